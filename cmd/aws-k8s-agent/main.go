@@ -15,11 +15,13 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/tracing"
 )
 
 var version string
@@ -38,6 +40,18 @@ func _main() int {
 
 	log.Infof("Starting L-IPAMD %s  ...", version)
 
+	// Tracing is a no-op unless ENABLE_TRACING is set
+	shutdownTracing, err := tracing.Init(context.Background(), "aws-node")
+	if err != nil {
+		log.Errorf("Failed to set up tracing: %v", err)
+		return 1
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Warnf("Failed to flush traces: %v", err)
+		}
+	}()
+
 	//Check API Server Connectivity
 	if k8sapi.CheckAPIServerConnectivity() != nil {
 		return 1
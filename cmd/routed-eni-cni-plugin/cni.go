@@ -22,7 +22,9 @@ import (
 	"net"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
@@ -39,10 +41,17 @@ import (
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/rpcwrapper"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/typeswrapper"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/tracing"
 	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
 )
 
-const ipamdAddress = "127.0.0.1:50051"
+const (
+	ipamdAddress = "127.0.0.1:50051"
+
+	tracingServiceName = "aws-cni"
+	// tracingShutdownTimeout bounds how long the plugin waits to flush its spans before exiting
+	tracingShutdownTimeout = time.Second
+)
 
 var version string
 
@@ -61,6 +70,16 @@ type NetConf struct {
 	PluginLogFile string `json:"pluginLogFile"`
 
 	PluginLogLevel string `json:"pluginLogLevel"`
+
+	// TeardownRetries is how many times a netlink operation of the DEL network teardown that fails with a transient
+	// error, e.g. a busy device, is retried. Defaults to 3.
+	TeardownRetries string `json:"teardownRetries"`
+
+	// EnableTracing makes the plugin export a span for each ADD and DEL to TracingExporterEndpoint, and send its
+	// context to ipamd as the parent of the ipamd spans
+	EnableTracing string `json:"enableTracing"`
+
+	TracingExporterEndpoint string `json:"tracingExporterEndpoint"`
 }
 
 // K8sArgs is the valid CNI_ARGS used for Kubernetes
@@ -107,6 +126,41 @@ func LoadNetConf(bytes []byte) (*NetConf, logger.Logger, error) {
 	return &conf, log, nil
 }
 
+// teardownRetries returns the validated TeardownRetries, or the default if it is not set or invalid
+func (conf *NetConf) teardownRetries(log logger.Logger) int {
+	if conf.TeardownRetries == "" {
+		return driver.DefaultTeardownRetries
+	}
+	retries, err := strconv.Atoi(conf.TeardownRetries)
+	if err != nil || retries < 0 || retries > driver.MaxTeardownRetries {
+		log.Warnf("Invalid teardownRetries %q, must be between 0 and %d, using %d", conf.TeardownRetries,
+			driver.MaxTeardownRetries, driver.DefaultTeardownRetries)
+		return driver.DefaultTeardownRetries
+	}
+	return retries
+}
+
+// initTracing sets up the span exporter if tracing is enabled and returns the function flushing it, which does nothing
+// otherwise. Failing to set it up only loses the spans.
+func (conf *NetConf) initTracing(log logger.Logger) func() {
+	noop := func() {}
+	if enabled, _ := strconv.ParseBool(conf.EnableTracing); !enabled {
+		return noop
+	}
+	shutdown, err := tracing.InitWithEndpoint(context.Background(), conf.TracingExporterEndpoint, tracingServiceName)
+	if err != nil {
+		log.Warnf("Failed to set up tracing: %v", err)
+		return noop
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			log.Warnf("Failed to flush the trace spans: %v", err)
+		}
+	}
+}
+
 func cmdAdd(args *skel.CmdArgs) error {
 	return add(args, typeswrapper.New(), grpcwrapper.New(), rpcwrapper.New(), driver.New())
 }
@@ -127,6 +181,13 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		log.Errorf("Failed to load k8s config from arg: %v", err)
 		return errors.Wrap(err, "add cmd: failed to load k8s config from arg")
 	}
+	defer conf.initTracing(log)()
+	ctx, span := tracing.StartSpan(context.Background(), "CNI ADD",
+		tracing.AttrPodName.String(string(k8sArgs.K8S_POD_NAME)),
+		tracing.AttrPodNamespace.String(string(k8sArgs.K8S_POD_NAMESPACE)),
+		tracing.AttrContainerID.String(args.ContainerID))
+	defer span.End()
+	ctx = tracing.InjectGRPC(ctx)
 
 	mtu := networkutils.GetEthernetMTU(conf.MTU)
 	log.Debugf("MTU value set is %d:", mtu)
@@ -142,7 +203,7 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 
 	c := rpcClient.NewCNIBackendClient(conn)
 
-	r, err := c.AddNetwork(context.Background(),
+	r, err := c.AddNetwork(ctx,
 		&pb.AddNetworkRequest{
 			ClientVersion:              version,
 			K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
@@ -174,18 +235,20 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		IP:   net.ParseIP(r.IPv4Addr),
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
+	podRoutes := parsePodRoutes(r.PodRoutes, log)
+	podMAC := parsePodMAC(r.PodInterfaceMAC, log)
 
 	if r.PodVlanId != 0 {
 		hostVethName := generateHostVethName("vlan", string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
 
 		err = driverClient.SetupPodENINetwork(hostVethName, args.IfName, args.Netns, addr, int(r.PodVlanId), r.PodENIMAC,
-			r.PodENISubnetGW, int(r.ParentIfIndex), mtu, log)
+			r.PodENISubnetGW, int(r.ParentIfIndex), mtu, int(r.NumQueues), podRoutes, podMAC, log)
 	} else {
 		// build hostVethName
 		// Note: the maximum length for linux interface name is 15
 		hostVethName := generateHostVethName(conf.VethPrefix, string(k8sArgs.K8S_POD_NAMESPACE), string(k8sArgs.K8S_POD_NAME))
 
-		err = driverClient.SetupNS(hostVethName, args.IfName, args.Netns, addr, int(r.DeviceNumber), r.VPCcidrs, r.UseExternalSNAT, mtu, log)
+		err = driverClient.SetupNS(hostVethName, args.IfName, args.Netns, addr, int(r.DeviceNumber), r.VPCcidrs, r.UseExternalSNAT, mtu, int(r.NumQueues), podRoutes, podMAC, r.PodSourceValidation, log)
 	}
 
 	if err != nil {
@@ -193,7 +256,7 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 			args.ContainerID, err)
 
 		// return allocated IP back to IP pool
-		r, delErr := c.DelNetwork(context.Background(), &pb.DelNetworkRequest{
+		r, delErr := c.DelNetwork(ctx, &pb.DelNetworkRequest{
 			ClientVersion:              version,
 			K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
 			K8S_POD_NAMESPACE:          string(k8sArgs.K8S_POD_NAMESPACE),
@@ -220,13 +283,43 @@ func add(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		},
 	}
 
+	// The nameservers and search domains of the "dns" key of the CNI config, if any, are passed on to the next plugins
+	// in the chain and to the runtime
 	result := &current.Result{
 		IPs: ips,
+		DNS: conf.DNS,
 	}
 
 	return cniTypes.PrintResult(result, conf.CNIVersion)
 }
 
+// parsePodRoutes converts the extra routes returned by ipamd, which has already validated them
+func parsePodRoutes(routes []string, log logger.Logger) []*net.IPNet {
+	var podRoutes []*net.IPNet
+	for _, route := range routes {
+		_, dst, err := net.ParseCIDR(route)
+		if err != nil {
+			log.Warnf("Ignoring invalid pod route %q: %v", route, err)
+			continue
+		}
+		podRoutes = append(podRoutes, dst)
+	}
+	return podRoutes
+}
+
+// parsePodMAC returns the hardware address requested for the pod interface, nil to keep the kernel assigned one
+func parsePodMAC(mac string, log logger.Logger) net.HardwareAddr {
+	if mac == "" {
+		return nil
+	}
+	podMAC, err := net.ParseMAC(mac)
+	if err != nil {
+		log.Warnf("Ignoring invalid pod interface MAC %q: %v", mac, err)
+		return nil
+	}
+	return podMAC
+}
+
 // generateHostVethName returns a name to be used on the host-side veth device.
 // The veth name is generated such that it aligns with the value expected
 // by Calico for NetworkPolicy enforcement.
@@ -256,6 +349,13 @@ func del(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		log.Errorf("Failed to load k8s config from args: %v", err)
 		return errors.Wrap(err, "del cmd: failed to load k8s config from args")
 	}
+	defer conf.initTracing(log)()
+	ctx, span := tracing.StartSpan(context.Background(), "CNI DEL",
+		tracing.AttrPodName.String(string(k8sArgs.K8S_POD_NAME)),
+		tracing.AttrPodNamespace.String(string(k8sArgs.K8S_POD_NAMESPACE)),
+		tracing.AttrContainerID.String(args.ContainerID))
+	defer span.End()
+	ctx = tracing.InjectGRPC(ctx)
 
 	// notify local IP address manager to free secondary IP
 	// Set up a connection to the server.
@@ -270,7 +370,7 @@ func del(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 
 	c := rpcClient.NewCNIBackendClient(conn)
 
-	r, err := c.DelNetwork(context.Background(), &pb.DelNetworkRequest{
+	r, err := c.DelNetwork(ctx, &pb.DelNetworkRequest{
 		ClientVersion:              version,
 		K8S_POD_NAME:               string(k8sArgs.K8S_POD_NAME),
 		K8S_POD_NAMESPACE:          string(k8sArgs.K8S_POD_NAMESPACE),
@@ -311,9 +411,9 @@ func del(args *skel.CmdArgs, cniTypes typeswrapper.CNITYPES, grpcClient grpcwrap
 		}
 
 		if r.PodVlanId != 0 {
-			err = driverClient.TeardownPodENINetwork(int(r.PodVlanId), log)
+			err = driverClient.TeardownPodENINetwork(int(r.PodVlanId), conf.teardownRetries(log), log)
 		} else {
-			err = driverClient.TeardownNS(addr, int(r.DeviceNumber), log)
+			err = driverClient.TeardownNS(addr, int(r.DeviceNumber), conf.teardownRetries(log), log)
 		}
 
 		if err != nil {
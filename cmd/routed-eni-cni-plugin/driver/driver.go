@@ -19,6 +19,7 @@ import (
 	"net"
 	"os"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
@@ -32,26 +33,38 @@ import (
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/nswrapper"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/procsyswrapper"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/retry"
 )
 
 const (
 	// vlan rule priority
 	vlanRulePriority = 10
 	// IP rules priority, leaving a 512 gap for the future
-	toContainerRulePriority = 512
+	toContainerRulePriority = networkutils.ToPodRulePriority
 	// 1024 is reserved for (IP rule not to <VPC's subnet> table main)
-	fromContainerRulePriority = 1536
+	fromContainerRulePriority = networkutils.FromPodRulePriority
 	// Main routing table number
 	mainRouteTable = unix.RT_TABLE_MAIN
+
+	// DefaultTeardownRetries is how many times a teardown netlink operation failing with a transient error is retried
+	DefaultTeardownRetries = 3
+	// MaxTeardownRetries bounds the retries, so a DEL does not outlive the runtime's CNI timeout
+	MaxTeardownRetries = 10
+)
+
+// Backoff between the retries of a teardown netlink operation, variables so tests can shorten them
+var (
+	teardownRetryMinBackoff = 50 * time.Millisecond
+	teardownRetryMaxBackoff = time.Second
 )
 
 // NetworkAPIs defines network API calls
 type NetworkAPIs interface {
-	SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, deviceNumber int, vpcCIDRs []string, useExternalSNAT bool, mtu int, log logger.Logger) error
-	TeardownNS(addr *net.IPNet, deviceNumber int, log logger.Logger) error
+	SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, deviceNumber int, vpcCIDRs []string, useExternalSNAT bool, mtu int, numQueues int, podRoutes []*net.IPNet, podMAC net.HardwareAddr, sourceValidation bool, log logger.Logger) error
+	TeardownNS(addr *net.IPNet, deviceNumber int, retries int, log logger.Logger) error
 	SetupPodENINetwork(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, vlanID int, eniMAC string,
-		subnetGW string, parentIfIndex int, mtu int, log logger.Logger) error
-	TeardownPodENINetwork(vlanID int, log logger.Logger) error
+		subnetGW string, parentIfIndex int, mtu int, numQueues int, podRoutes []*net.IPNet, podMAC net.HardwareAddr, log logger.Logger) error
+	TeardownPodENINetwork(vlanID int, retries int, log logger.Logger) error
 }
 
 type linuxNetwork struct {
@@ -78,9 +91,15 @@ type createVethPairContext struct {
 	netLink      netlinkwrapper.NetLink
 	ip           ipwrapper.IP
 	mtu          int
+	// numQueues is the number of tx/rx queues on both ends of the veth pair, 0 keeps the kernel default
+	numQueues int
+	// podRoutes are extra destinations routed via the default gateway inside the pod
+	podRoutes []*net.IPNet
+	// podMAC is the hardware address of the pod end of the veth pair, nil keeps the kernel assigned one
+	podMAC net.HardwareAddr
 }
 
-func newCreateVethPairContext(contVethName string, hostVethName string, addr *net.IPNet, mtu int) *createVethPairContext {
+func newCreateVethPairContext(contVethName string, hostVethName string, addr *net.IPNet, mtu int, numQueues int, podRoutes []*net.IPNet, podMAC net.HardwareAddr) *createVethPairContext {
 	return &createVethPairContext{
 		contVethName: contVethName,
 		hostVethName: hostVethName,
@@ -88,6 +107,9 @@ func newCreateVethPairContext(contVethName string, hostVethName string, addr *ne
 		netLink:      netlinkwrapper.NewNetLink(),
 		ip:           ipwrapper.NewIP(),
 		mtu:          mtu,
+		numQueues:    numQueues,
+		podRoutes:    podRoutes,
+		podMAC:       podMAC,
 	}
 }
 
@@ -95,12 +117,18 @@ func newCreateVethPairContext(contVethName string, hostVethName string, addr *ne
 func (createVethContext *createVethPairContext) run(hostNS ns.NetNS) error {
 	veth := &netlink.Veth{
 		LinkAttrs: netlink.LinkAttrs{
-			Name:  createVethContext.contVethName,
-			Flags: net.FlagUp,
-			MTU:   createVethContext.mtu,
+			Name:         createVethContext.contVethName,
+			Flags:        net.FlagUp,
+			MTU:          createVethContext.mtu,
+			HardwareAddr: createVethContext.podMAC,
 		},
 		PeerName: createVethContext.hostVethName,
 	}
+	if createVethContext.numQueues > 0 {
+		// netlink applies the queue counts to the peer as well
+		veth.NumTxQueues = createVethContext.numQueues
+		veth.NumRxQueues = createVethContext.numQueues
+	}
 
 	if err := createVethContext.netLink.LinkAdd(veth); err != nil {
 		return err
@@ -148,6 +176,15 @@ func (createVethContext *createVethPairContext) run(hostNS ns.NetNS) error {
 		return errors.Wrap(err, "setup NS network: failed to add default route")
 	}
 
+	for _, dst := range createVethContext.podRoutes {
+		if err = createVethContext.netLink.RouteReplace(&netlink.Route{
+			LinkIndex: contVeth.Attrs().Index,
+			Dst:       dst,
+			Gw:        gwNet.IP}); err != nil {
+			return errors.Wrapf(err, "setup NS network: failed to add pod route to %s", dst.String())
+		}
+	}
+
 	if err = createVethContext.netLink.AddrAdd(contVeth, &netlink.Addr{IPNet: createVethContext.addr}); err != nil {
 		return errors.Wrapf(err, "setup NS network: failed to add IP addr to %q", createVethContext.contVethName)
 	}
@@ -174,15 +211,15 @@ func (createVethContext *createVethPairContext) run(hostNS ns.NetNS) error {
 }
 
 // SetupNS wires up linux networking for a pod's network
-func (os *linuxNetwork) SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, deviceNumber int, vpcCIDRs []string, useExternalSNAT bool, mtu int, log logger.Logger) error {
-	log.Debugf("SetupNS: hostVethName=%s, contVethName=%s, netnsPath=%s, deviceNumber=%d, mtu=%d", hostVethName, contVethName, netnsPath, deviceNumber, mtu)
-	return setupNS(hostVethName, contVethName, netnsPath, addr, deviceNumber, vpcCIDRs, useExternalSNAT, os.netLink, os.ns, mtu, log, os.procSys)
+func (os *linuxNetwork) SetupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, deviceNumber int, vpcCIDRs []string, useExternalSNAT bool, mtu int, numQueues int, podRoutes []*net.IPNet, podMAC net.HardwareAddr, sourceValidation bool, log logger.Logger) error {
+	log.Debugf("SetupNS: hostVethName=%s, contVethName=%s, netnsPath=%s, deviceNumber=%d, mtu=%d, numQueues=%d, podRoutes=%v, podMAC=%s, sourceValidation=%v", hostVethName, contVethName, netnsPath, deviceNumber, mtu, numQueues, podRoutes, podMAC, sourceValidation)
+	return setupNS(hostVethName, contVethName, netnsPath, addr, deviceNumber, vpcCIDRs, useExternalSNAT, os.netLink, os.ns, mtu, numQueues, podRoutes, podMAC, sourceValidation, log, os.procSys)
 }
 
 func setupNS(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, deviceNumber int, vpcCIDRs []string, useExternalSNAT bool,
-	netLink netlinkwrapper.NetLink, ns nswrapper.NS, mtu int, log logger.Logger, procSys procsyswrapper.ProcSys) error {
+	netLink netlinkwrapper.NetLink, ns nswrapper.NS, mtu int, numQueues int, podRoutes []*net.IPNet, podMAC net.HardwareAddr, sourceValidation bool, log logger.Logger, procSys procsyswrapper.ProcSys) error {
 
-	hostVeth, err := setupVeth(hostVethName, contVethName, netnsPath, addr, netLink, ns, mtu, procSys, log)
+	hostVeth, err := setupVeth(hostVethName, contVethName, netnsPath, addr, netLink, ns, mtu, numQueues, podRoutes, podMAC, procSys, log)
 	if err != nil {
 		return errors.Wrapf(err, "setupNS network: failed to setup veth pair.")
 	}
@@ -204,6 +241,15 @@ func setupNS(hostVethName string, contVethName string, netnsPath string, addr *n
 	}
 	log.Debugf("Successfully set host route to be %s/0", route.Dst.IP.String())
 
+	if sourceValidation {
+		// With strict reverse path filtering the host drops packets from the pod whose source is not routed back via
+		// the pod's veth, so the pod can only send from the IPs that have a host route to it
+		if err := procSys.Set(fmt.Sprintf("net/ipv4/conf/%s/rp_filter", hostVethName), "1"); err != nil {
+			return errors.Wrapf(err, "setupNS network: failed to enable source validation on %q", hostVethName)
+		}
+		log.Infof("Enabled source validation for %s on %s", addr.String(), hostVethName)
+	}
+
 	err = addContainerRule(netLink, true, addr, mainRouteTable)
 
 	if err != nil {
@@ -230,7 +276,7 @@ func setupNS(hostVethName string, contVethName string, netnsPath string, addr *n
 
 // setupVeth sets up veth for the pod.
 func setupVeth(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet, netLink netlinkwrapper.NetLink,
-	ns nswrapper.NS, mtu int, procSys procsyswrapper.ProcSys, log logger.Logger) (netlink.Link, error) {
+	ns nswrapper.NS, mtu int, numQueues int, podRoutes []*net.IPNet, podMAC net.HardwareAddr, procSys procsyswrapper.ProcSys, log logger.Logger) (netlink.Link, error) {
 	// Clean up if hostVeth exists.
 	if oldHostVeth, err := netLink.LinkByName(hostVethName); err == nil {
 		if err = netLink.LinkDel(oldHostVeth); err != nil {
@@ -239,7 +285,7 @@ func setupVeth(hostVethName string, contVethName string, netnsPath string, addr
 		log.Debugf("Cleaned up old hostVeth: %v\n", hostVethName)
 	}
 
-	createVethContext := newCreateVethPairContext(contVethName, hostVethName, addr, mtu)
+	createVethContext := newCreateVethPairContext(contVethName, hostVethName, addr, mtu, numQueues, podRoutes, podMAC)
 	if err := ns.WithNetNSPath(netnsPath, createVethContext.run); err != nil {
 		log.Errorf("Failed to setup veth network %v", err)
 		return nil, errors.Wrap(err, "setupVeth network: failed to setup veth network")
@@ -276,9 +322,9 @@ func setupVeth(hostVethName string, contVethName string, netnsPath string, addr
 
 // SetupPodENINetwork sets up the network ns for pods requesting its own security group
 func (os *linuxNetwork) SetupPodENINetwork(hostVethName string, contVethName string, netnsPath string, addr *net.IPNet,
-	vlanID int, eniMAC string, subnetGW string, parentIfIndex int, mtu int, log logger.Logger) error {
+	vlanID int, eniMAC string, subnetGW string, parentIfIndex int, mtu int, numQueues int, podRoutes []*net.IPNet, podMAC net.HardwareAddr, log logger.Logger) error {
 
-	hostVeth, err := setupVeth(hostVethName, contVethName, netnsPath, addr, os.netLink, os.ns, mtu, os.procSys, log)
+	hostVeth, err := setupVeth(hostVethName, contVethName, netnsPath, addr, os.netLink, os.ns, mtu, numQueues, podRoutes, podMAC, os.procSys, log)
 	if err != nil {
 		return errors.Wrapf(err, "SetupPodENINetwork failed to setup veth pair.")
 	}
@@ -415,13 +461,14 @@ func addContainerRule(netLink netlinkwrapper.NetLink, isToContainer bool, addr *
 	return nil
 }
 
-// TeardownPodNetwork cleanup ip rules
-func (os *linuxNetwork) TeardownNS(addr *net.IPNet, deviceNumber int, log logger.Logger) error {
+// TeardownPodNetwork cleanup ip rules. Netlink operations failing with a transient error are retried up to retries
+// times.
+func (os *linuxNetwork) TeardownNS(addr *net.IPNet, deviceNumber int, retries int, log logger.Logger) error {
 	log.Debugf("TeardownNS: addr %s, deviceNumber %d", addr.String(), deviceNumber)
-	return tearDownNS(addr, deviceNumber, os.netLink, log)
+	return tearDownNS(addr, deviceNumber, retries, os.netLink, log)
 }
 
-func tearDownNS(addr *net.IPNet, deviceNumber int, netLink netlinkwrapper.NetLink, log logger.Logger) error {
+func tearDownNS(addr *net.IPNet, deviceNumber int, retries int, netLink netlinkwrapper.NetLink, log logger.Logger) error {
 	if addr == nil {
 		return errors.New("can't tear down network namespace with no IP address")
 	}
@@ -429,7 +476,9 @@ func tearDownNS(addr *net.IPNet, deviceNumber int, netLink netlinkwrapper.NetLin
 	toContainerRule := netLink.NewRule()
 	toContainerRule.Dst = addr
 	toContainerRule.Priority = toContainerRulePriority
-	err := netLink.RuleDel(toContainerRule)
+	err := retryTransient(retries, log, "delete toContainer rule", func() error {
+		return netLink.RuleDel(toContainerRule)
+	})
 
 	if err != nil {
 		log.Errorf("Failed to delete toContainer rule for %s err %v", addr.String(), err)
@@ -439,7 +488,9 @@ func tearDownNS(addr *net.IPNet, deviceNumber int, netLink netlinkwrapper.NetLin
 
 	if deviceNumber > 0 {
 		// remove from-pod rule only for non main table
-		err := deleteRuleListBySrc(*addr)
+		err := retryTransient(retries, log, "delete fromContainer rule", func() error {
+			return deleteRuleListBySrc(*addr)
+		})
 		if err != nil {
 			log.Errorf("Failed to delete fromContainer for %s %v", addr.String(), err)
 			return errors.Wrapf(err, "delete NS network: failed to delete fromContainer rule for %s", addr.String())
@@ -453,23 +504,28 @@ func tearDownNS(addr *net.IPNet, deviceNumber int, netLink netlinkwrapper.NetLin
 		Mask: net.CIDRMask(32, 32)}
 
 	// cleanup host route:
-	if err = netLink.RouteDel(&netlink.Route{
-		Scope: netlink.SCOPE_LINK,
-		Dst:   addrHostAddr}); err != nil {
+	if err = retryTransient(retries, log, "delete host route", func() error {
+		return netLink.RouteDel(&netlink.Route{
+			Scope: netlink.SCOPE_LINK,
+			Dst:   addrHostAddr})
+	}); err != nil {
 		log.Errorf("delete NS network: failed to delete host route for %s, %v", addr.String(), err)
 	}
 	log.Debug("Tear down of NS complete")
 	return nil
 }
 
-// TeardownPodENINetwork tears down the vlan and corresponding ip rules.
-func (os *linuxNetwork) TeardownPodENINetwork(vlanID int, log logger.Logger) error {
+// TeardownPodENINetwork tears down the vlan and corresponding ip rules. Netlink operations failing with a transient
+// error are retried up to retries times.
+func (os *linuxNetwork) TeardownPodENINetwork(vlanID int, retries int, log logger.Logger) error {
 	log.Infof("Tear down of pod ENI namespace")
 
 	// 1. delete vlan
 	if vlan, err := os.netLink.LinkByName(fmt.Sprintf("vlan.eth.%d",
 		vlanID)); err == nil {
-		err := os.netLink.LinkDel(vlan)
+		err := retryTransient(retries, log, "delete vlan link", func() error {
+			return os.netLink.LinkDel(vlan)
+		})
 		if err != nil {
 			return errors.Wrapf(err, "TeardownPodENINetwork: failed to delete vlan link for %d", vlanID)
 		}
@@ -483,7 +539,9 @@ func (os *linuxNetwork) TeardownPodENINetwork(vlanID int, log logger.Logger) err
 	for {
 		// Loop until both the rules are deleted.
 		// one of them handles vlan traffic and other is for pod host veth traffic.
-		if err := os.netLink.RuleDel(vlanRule); err != nil {
+		if err := retryTransient(retries, log, "delete vlan rule", func() error {
+			return os.netLink.RuleDel(vlanRule)
+		}); err != nil {
 			if !containsNoSuchRule(err) {
 				return errors.Wrapf(err, "TeardownPodENINetwork: failed to delete container rule for %d", vlanID)
 			}
@@ -493,6 +551,30 @@ func (os *linuxNetwork) TeardownPodENINetwork(vlanID int, log logger.Logger) err
 	return nil
 }
 
+// retryTransient runs op until it succeeds, fails with an error that is not transient, or has been retried retries
+// times, backing off between the attempts
+func retryTransient(retries int, log logger.Logger, name string, op func() error) error {
+	backoff := retry.NewSimpleBackoff(teardownRetryMinBackoff, teardownRetryMaxBackoff, 0.2, 2)
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || !isTransientNetlinkError(err) || attempt >= retries {
+			return err
+		}
+		delay := backoff.Duration()
+		log.Warnf("Failed to %s (retry %d/%d in %v): %v", name, attempt+1, retries, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// isTransientNetlinkError returns true for the errors of netlink operations racing with the kernel still releasing a
+// device or address, which go away when the operation is retried
+func isTransientNetlinkError(err error) bool {
+	if errno, ok := errors.Cause(err).(syscall.Errno); ok {
+		return errno == syscall.EBUSY || errno == syscall.EAGAIN
+	}
+	return false
+}
+
 func deleteRuleListBySrc(src net.IPNet) error {
 	networkClient := networkutils.New()
 	return networkClient.DeleteRuleListBySrc(src)
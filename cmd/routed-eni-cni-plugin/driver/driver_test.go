@@ -19,6 +19,7 @@ import (
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
 
@@ -39,6 +40,7 @@ import (
 const (
 	testMAC          = "01:23:45:67:89:ab"
 	testIP           = "10.0.10.10"
+	testPodRoute     = "192.168.100.0/24"
 	testContVethName = "eth0"
 	testHostVethName = "aws-eth0"
 	testVlanName     = "vlan.eth.1"
@@ -144,6 +146,20 @@ func (m *testMocks) mockWithFailureAt(t *testing.T, failAt string) *createVethPa
 	}
 	call = m.ip.EXPECT().AddDefaultRoute(gomock.Any(), mockContVeth).Return(nil).After(call)
 
+	// extra pod routes
+	if failAt == "pod-route-replace" {
+		_, dst, err := net.ParseCIDR(testPodRoute)
+		assert.NoError(t, err)
+		mockContext.podRoutes = []*net.IPNet{dst}
+		call = mockContVeth.EXPECT().Attrs().Return(mockLinkAttrs).After(call)
+		m.netlink.EXPECT().RouteReplace(&netlink.Route{
+			LinkIndex: mockLinkAttrs.Index,
+			Dst:       dst,
+			Gw:        net.IPv4(169, 254, 1, 1),
+		}).Return(errors.New("error on RouteReplace")).After(call)
+		return mockContext
+	}
+
 	// container addr
 	if failAt == "addr-add" {
 		m.netlink.EXPECT().AddrAdd(mockContVeth, gomock.Any()).Return(errors.New("error on AddrAdd")).After(call)
@@ -182,6 +198,58 @@ func TestRun(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRunNumQueues(t *testing.T) {
+	for _, numQueues := range []int{0, 4} {
+		m := setup(t)
+
+		mockContext := &createVethPairContext{
+			contVethName: testContVethName,
+			hostVethName: testHostVethName,
+			netLink:      m.netlink,
+			ip:           m.ip,
+			numQueues:    numQueues,
+		}
+		m.netlink.EXPECT().LinkAdd(gomock.Any()).DoAndReturn(func(link netlink.Link) error {
+			veth, ok := link.(*netlink.Veth)
+			assert.True(t, ok)
+			assert.Equal(t, numQueues, veth.NumTxQueues)
+			assert.Equal(t, numQueues, veth.NumRxQueues)
+			return errors.New("stop after LinkAdd")
+		})
+
+		err := mockContext.run(m.netns)
+		assert.Error(t, err)
+		m.ctrl.Finish()
+	}
+}
+
+func TestRunPodMAC(t *testing.T) {
+	podMAC, _ := net.ParseMAC("0a:58:0a:00:00:01")
+	for _, mac := range []net.HardwareAddr{nil, podMAC} {
+		m := setup(t)
+
+		mockContext := &createVethPairContext{
+			contVethName: testContVethName,
+			hostVethName: testHostVethName,
+			netLink:      m.netlink,
+			ip:           m.ip,
+			podMAC:       mac,
+		}
+		m.netlink.EXPECT().LinkAdd(gomock.Any()).DoAndReturn(func(link netlink.Link) error {
+			veth, ok := link.(*netlink.Veth)
+			assert.True(t, ok)
+			assert.Equal(t, mac, veth.HardwareAddr)
+			// Only the pod end of the pair gets the address
+			assert.Nil(t, veth.PeerHardwareAddr)
+			return errors.New("stop after LinkAdd")
+		})
+
+		err := mockContext.run(m.netns)
+		assert.Error(t, err)
+		m.ctrl.Finish()
+	}
+}
+
 func TestRunLinkAddErr(t *testing.T) {
 	m := setup(t)
 	defer m.ctrl.Finish()
@@ -242,6 +310,17 @@ func TestRunErrAddDefaultRoute(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestRunErrPodRouteReplace(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := m.mockWithFailureAt(t, "pod-route-replace")
+
+	err := mockContext.run(m.netns)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), testPodRoute)
+}
+
 func TestRunErrAddrAdd(t *testing.T) {
 	m := setup(t)
 	defer m.ctrl.Finish()
@@ -359,7 +438,7 @@ func TestSetupPodNetwork(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, true, m.netlink, m.ns, mtu, log, m.procsys)
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, true, m.netlink, m.ns, mtu, 0, nil, nil, false, log, m.procsys)
 	assert.NoError(t, err)
 }
 
@@ -374,7 +453,7 @@ func TestSetupPodNetworkErrNoIPv6(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, true, m.netlink, m.ns, mtu, log, m.procsys)
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, true, m.netlink, m.ns, mtu, 0, nil, nil, false, log, m.procsys)
 	assert.NoError(t, err)
 }
 
@@ -389,7 +468,7 @@ func TestSetupPodNetworkErrLinkByName(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, m.netlink, m.ns, mtu, log, m.procsys)
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, m.netlink, m.ns, mtu, 0, nil, nil, false, log, m.procsys)
 
 	assert.Error(t, err)
 }
@@ -405,7 +484,7 @@ func TestSetupPodNetworkErrLinkSetup(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, m.netlink, m.ns, mtu, log, m.procsys)
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, m.netlink, m.ns, mtu, 0, nil, nil, false, log, m.procsys)
 
 	assert.Error(t, err)
 }
@@ -421,7 +500,7 @@ func TestSetupPodNetworkErrProcSys(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, m.netlink, m.ns, mtu, log, m.procsys)
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, m.netlink, m.ns, mtu, 0, nil, nil, false, log, m.procsys)
 
 	assert.Error(t, err)
 }
@@ -437,11 +516,58 @@ func TestSetupPodNetworkErrRouteReplace(t *testing.T) {
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
 	var cidrs []string
-	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, m.netlink, m.ns, mtu, log, m.procsys)
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, m.netlink, m.ns, mtu, 0, nil, nil, false, log, m.procsys)
 
 	assert.Error(t, err)
 }
 
+func TestSetupPodNetworkSourceValidation(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	m.mockSetupPodNetworkWithFailureAt(t, "")
+	m.procsys.EXPECT().Set("net/ipv4/conf/"+testHostVethName+"/rp_filter", "1").Return(nil)
+
+	addr := &net.IPNet{
+		IP:   net.ParseIP(testIP),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+	var cidrs []string
+	err := setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, true, m.netlink, m.ns, mtu, 0, nil, nil, true, log, m.procsys)
+	assert.NoError(t, err)
+
+	// The host route to the pod IP is what the reverse path check accepts, teardown removes it
+	testRule := &netlink.Rule{}
+	m.netlink.EXPECT().NewRule().Return(testRule)
+	m.netlink.EXPECT().RuleDel(testRule).Return(nil)
+	m.netlink.EXPECT().RouteDel(&netlink.Route{
+		Scope: netlink.SCOPE_LINK,
+		Dst:   &net.IPNet{IP: addr.IP, Mask: net.CIDRMask(32, 32)},
+	}).Return(nil)
+	err = tearDownNS(addr, 0, DefaultTeardownRetries, m.netlink, log)
+	assert.NoError(t, err)
+}
+
+func TestSetupPodNetworkSourceValidationErr(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	hwAddr, err := net.ParseMAC(testMAC)
+	assert.NoError(t, err)
+	mockHostVeth := m.setupMockForVethCreation("")
+	mockHostVeth.EXPECT().Attrs().Return(&netlink.LinkAttrs{HardwareAddr: hwAddr}).Times(2)
+	m.netlink.EXPECT().RouteReplace(gomock.Any()).Return(nil)
+	m.procsys.EXPECT().Set("net/ipv4/conf/"+testHostVethName+"/rp_filter", "1").Return(errors.New("error writing to /proc/sys/"))
+
+	addr := &net.IPNet{
+		IP:   net.ParseIP(testIP),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+	var cidrs []string
+	err = setupNS(testHostVethName, testContVethName, testnetnsPath, addr, testTable, cidrs, false, m.netlink, m.ns, mtu, 0, nil, nil, true, log, m.procsys)
+	assert.Error(t, err)
+}
+
 func TestTearDownPodNetwork(t *testing.T) {
 	m := setup(t)
 	defer m.ctrl.Finish()
@@ -468,7 +594,7 @@ func TestTearDownPodNetwork(t *testing.T) {
 		IP:   net.ParseIP(testIP),
 		Mask: net.IPv4Mask(255, 255, 255, 255),
 	}
-	err := tearDownNS(addr, 0, m.netlink, log)
+	err := tearDownNS(addr, 0, DefaultTeardownRetries, m.netlink, log)
 	assert.NoError(t, err)
 }
 
@@ -498,10 +624,106 @@ func TestTeardownPodENINetworkHappyCase(t *testing.T) {
 		m.netlink.EXPECT().RuleDel(gomock.Eq(expectedRule)).Return(syscall.ENOENT),
 	)
 
-	err := linuxNetwork.TeardownPodENINetwork(1, log)
+	err := linuxNetwork.TeardownPodENINetwork(1, DefaultTeardownRetries, log)
 	assert.NoError(t, err)
 }
 
+// setShortTeardownBackoff makes the teardown retries fast and returns a func restoring the backoff
+func setShortTeardownBackoff() func() {
+	minBackoff, maxBackoff := teardownRetryMinBackoff, teardownRetryMaxBackoff
+	teardownRetryMinBackoff, teardownRetryMaxBackoff = time.Millisecond, time.Millisecond
+	return func() {
+		teardownRetryMinBackoff, teardownRetryMaxBackoff = minBackoff, maxBackoff
+	}
+}
+
+func TestTearDownPodNetworkTransientErr(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	defer setShortTeardownBackoff()()
+
+	gomock.InOrder(
+		m.netlink.EXPECT().NewRule().Return(&netlink.Rule{}),
+		m.netlink.EXPECT().RuleDel(gomock.Any()).Return(syscall.EBUSY),
+		m.netlink.EXPECT().RuleDel(gomock.Any()).Return(nil),
+		m.netlink.EXPECT().RouteDel(gomock.Any()).Return(syscall.EAGAIN),
+		m.netlink.EXPECT().RouteDel(gomock.Any()).Return(syscall.EBUSY),
+		m.netlink.EXPECT().RouteDel(gomock.Any()).Return(nil),
+	)
+
+	addr := &net.IPNet{
+		IP:   net.ParseIP(testIP),
+		Mask: net.IPv4Mask(255, 255, 255, 255),
+	}
+	err := tearDownNS(addr, 0, DefaultTeardownRetries, m.netlink, log)
+	assert.NoError(t, err)
+}
+
+func TestTeardownPodENINetworkTransientErr(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	defer setShortTeardownBackoff()()
+
+	mockVlan := mock_netlink.NewMockLink(m.ctrl)
+	linuxNetwork := &linuxNetwork{
+		netLink: m.netlink,
+		ns:      m.ns,
+		procSys: m.procsys,
+	}
+
+	m.netlink.EXPECT().NewRule().Return(&netlink.Rule{})
+	gomock.InOrder(
+		m.netlink.EXPECT().LinkByName(testVlanName).Return(mockVlan, nil),
+		m.netlink.EXPECT().LinkDel(mockVlan).Return(syscall.EBUSY),
+		m.netlink.EXPECT().LinkDel(mockVlan).Return(nil),
+		m.netlink.EXPECT().RuleDel(gomock.Any()).Return(syscall.EBUSY),
+		m.netlink.EXPECT().RuleDel(gomock.Any()).Return(nil),
+		m.netlink.EXPECT().RuleDel(gomock.Any()).Return(syscall.ENOENT),
+	)
+
+	err := linuxNetwork.TeardownPodENINetwork(1, DefaultTeardownRetries, log)
+	assert.NoError(t, err)
+}
+
+func TestTeardownPodENINetworkRetriesExhausted(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	defer setShortTeardownBackoff()()
+
+	mockVlan := mock_netlink.NewMockLink(m.ctrl)
+	linuxNetwork := &linuxNetwork{
+		netLink: m.netlink,
+		ns:      m.ns,
+		procSys: m.procsys,
+	}
+
+	m.netlink.EXPECT().LinkByName(testVlanName).Return(mockVlan, nil)
+	// The first attempt and the two retries
+	m.netlink.EXPECT().LinkDel(mockVlan).Return(syscall.EBUSY).Times(3)
+
+	err := linuxNetwork.TeardownPodENINetwork(1, 2, log)
+	assert.Error(t, err)
+}
+
+func TestTeardownPodENINetworkNoRetryOnPermanentErr(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	defer setShortTeardownBackoff()()
+
+	mockVlan := mock_netlink.NewMockLink(m.ctrl)
+	linuxNetwork := &linuxNetwork{
+		netLink: m.netlink,
+		ns:      m.ns,
+		procSys: m.procsys,
+	}
+
+	m.netlink.EXPECT().LinkByName(testVlanName).Return(mockVlan, nil)
+	m.netlink.EXPECT().LinkDel(mockVlan).Return(syscall.EPERM).Times(1)
+
+	err := linuxNetwork.TeardownPodENINetwork(1, DefaultTeardownRetries, log)
+	assert.Error(t, err)
+}
+
 func (m *testMocks) mockSetupPodENINetworkWithFailureAt(t *testing.T, addr *net.IPNet, failAt string) {
 	mockHostVeth := m.setupMockForVethCreation(failAt)
 
@@ -588,7 +810,7 @@ func TestSetupPodENINetworkHappyCase(t *testing.T) {
 	m.mockSetupPodENINetworkWithFailureAt(t, addr, "")
 
 	err := t1.SetupPodENINetwork(testHostVethName, testContVethName, testnetnsPath, addr, 1, "eniMacAddress",
-		"10.1.0.1", 2, mtu, log)
+		"10.1.0.1", 2, mtu, 0, nil, nil, log)
 
 	assert.NoError(t, err)
 }
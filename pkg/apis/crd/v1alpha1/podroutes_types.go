@@ -0,0 +1,52 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodRoutesSpec defines the extra routes to program in the network namespace of the selected pods
+type PodRoutesSpec struct {
+	// PodSelector selects the pods in the namespace of the PodRoutes; an empty selector selects all of them
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+	// Routes are IPv4 destination CIDRs routed through the pod's default gateway
+	Routes []string `json:"routes"`
+}
+
+//+kubebuilder:object:root=true
+
+// PodRoutes is the Schema for the podroutes API
+type PodRoutes struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PodRoutesSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PodRoutesList contains a list of PodRoutes
+type PodRoutesList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodRoutes `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodRoutes{}, &PodRoutesList{})
+}
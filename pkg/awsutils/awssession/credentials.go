@@ -0,0 +1,97 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awssession
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+const (
+	// credentialRefreshRetriesEnv is the number of times a failed refresh of the credentials, e.g. of the instance
+	// role through IMDS, is retried before the AWS call needing them fails
+	credentialRefreshRetriesEnv     = "CREDENTIAL_REFRESH_RETRIES"
+	defaultCredentialRefreshRetries = 3
+	maxCredentialRefreshRetries     = 10
+	// credentialRefreshMinBackoff doubles after each failed attempt, up to credentialRefreshMaxBackoff
+	credentialRefreshMinBackoff = 200 * time.Millisecond
+	credentialRefreshMaxBackoff = 5 * time.Second
+)
+
+func getCredentialRefreshRetries() int {
+	inputStr, found := os.LookupEnv(credentialRefreshRetriesEnv)
+	if !found {
+		return defaultCredentialRefreshRetries
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && input <= maxCredentialRefreshRetries {
+		log.Debugf("Using %s %v", credentialRefreshRetriesEnv, input)
+		return input
+	}
+	log.Warnf("%s env is set to %q, must be between 0 and %d, defaulting to %d retries", credentialRefreshRetriesEnv,
+		inputStr, maxCredentialRefreshRetries, defaultCredentialRefreshRetries)
+	return defaultCredentialRefreshRetries
+}
+
+// retryingProvider retrieves the credentials of creds, retrying with backoff when they fail to refresh. The instance
+// role credentials served by IMDS can briefly fail to refresh, and the AWS calls made meanwhile fail with errors that
+// look like missing IAM permissions.
+type retryingProvider struct {
+	creds      *credentials.Credentials
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+func newRetryingCredentials(creds *credentials.Credentials, retries int) *credentials.Credentials {
+	return credentials.NewCredentials(&retryingProvider{
+		creds:      creds,
+		retries:    retries,
+		minBackoff: credentialRefreshMinBackoff,
+		maxBackoff: credentialRefreshMaxBackoff,
+	})
+}
+
+// Retrieve implements credentials.Provider
+func (p *retryingProvider) Retrieve() (credentials.Value, error) {
+	backoff := p.minBackoff
+	for attempt := 0; ; attempt++ {
+		value, err := p.creds.Get()
+		if err == nil {
+			if attempt > 0 {
+				log.Infof("Refreshed the AWS credentials after %d failed attempts", attempt)
+			}
+			return value, nil
+		}
+		if attempt >= p.retries {
+			log.Errorf("Failed to refresh the AWS credentials after %d attempts, AWS calls fail until they are refreshed. "+
+				"This is a credential refresh failure, e.g. IMDS not serving the instance role credentials, not a missing IAM permission: %v",
+				attempt+1, err)
+			return value, err
+		}
+		log.Warnf("Failed to refresh the AWS credentials, retrying in %v: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+// IsExpired implements credentials.Provider
+func (p *retryingProvider) IsExpired() bool {
+	return p.creds.IsExpired()
+}
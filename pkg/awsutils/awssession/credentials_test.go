@@ -0,0 +1,87 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awssession
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyProvider fails to retrieve the credentials failures times, then succeeds
+type flakyProvider struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyProvider) Retrieve() (credentials.Value, error) {
+	p.calls++
+	if p.calls <= p.failures {
+		return credentials.Value{}, awserr.New("EC2RoleRequestError", "no EC2 instance role found", nil)
+	}
+	return credentials.Value{AccessKeyID: "AKID", SecretAccessKey: "SECRET", ProviderName: "flaky"}, nil
+}
+
+func (p *flakyProvider) IsExpired() bool {
+	return p.calls <= p.failures
+}
+
+func newTestRetryingCredentials(source credentials.Provider, retries int) *credentials.Credentials {
+	return credentials.NewCredentials(&retryingProvider{
+		creds:      credentials.NewCredentials(source),
+		retries:    retries,
+		minBackoff: time.Millisecond,
+		maxBackoff: 2 * time.Millisecond,
+	})
+}
+
+func TestRetryingCredentialsRecover(t *testing.T) {
+	source := &flakyProvider{failures: 2}
+	value, err := newTestRetryingCredentials(source, 3).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKID", value.AccessKeyID)
+	assert.Equal(t, 3, source.calls)
+}
+
+func TestRetryingCredentialsExhausted(t *testing.T) {
+	source := &flakyProvider{failures: 5}
+	_, err := newTestRetryingCredentials(source, 2).Get()
+	assert.Error(t, err)
+	assert.Equal(t, 3, source.calls)
+
+	// The next call tries again and succeeds once the source recovers
+	value, err := newTestRetryingCredentials(source, 2).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKID", value.AccessKeyID)
+}
+
+func TestCredentialRefreshRetries(t *testing.T) {
+	defer os.Unsetenv(credentialRefreshRetriesEnv)
+
+	os.Unsetenv(credentialRefreshRetriesEnv)
+	assert.Equal(t, defaultCredentialRefreshRetries, getCredentialRefreshRetries())
+
+	os.Setenv(credentialRefreshRetriesEnv, "0")
+	assert.Equal(t, 0, getCredentialRefreshRetries())
+
+	os.Setenv(credentialRefreshRetriesEnv, "5")
+	assert.Equal(t, 5, getCredentialRefreshRetries())
+
+	os.Setenv(credentialRefreshRetriesEnv, "50")
+	assert.Equal(t, defaultCredentialRefreshRetries, getCredentialRefreshRetries())
+}
@@ -32,6 +32,10 @@ import (
 const (
 	httpTimeoutEnv = "HTTP_TIMEOUT"
 	maxRetries     = 10
+	// maxRetriesEnv overrides the number of retries the AWS SDK does for throttled or failed API calls
+	maxRetriesEnv = "AWS_MAX_RETRIES"
+	// upper bound of AWS_MAX_RETRIES, the SDK backs off exponentially so higher values only stall callers
+	maxRetriesLimit = 50
 )
 
 var (
@@ -56,16 +60,33 @@ func getHTTPTimeout() time.Duration {
 	return httpTimeoutValue
 }
 
+func getMaxRetries() int {
+	maxRetriesEnvInput := os.Getenv(maxRetriesEnv)
+	if maxRetriesEnvInput != "" {
+		input, err := strconv.Atoi(maxRetriesEnvInput)
+		if err == nil && input >= 0 && input <= maxRetriesLimit {
+			log.Debugf("Using AWS_MAX_RETRIES %v", input)
+			return input
+		}
+		log.Warnf("AWS_MAX_RETRIES env is set to %q, must be between 0 and %d, defaulting to %d retries",
+			maxRetriesEnvInput, maxRetriesLimit, maxRetries)
+	}
+	return maxRetries
+}
+
 // New will return an session for service clients
 func New() *session.Session {
 	awsCfg := aws.Config{
-		MaxRetries: aws.Int(maxRetries),
+		MaxRetries: aws.Int(getMaxRetries()),
 		HTTPClient: &http.Client{
 			Timeout: getHTTPTimeout(),
 		},
 		STSRegionalEndpoint: endpoints.RegionalSTSEndpoint,
 	}
 	sess := session.Must(session.NewSession(&awsCfg))
+	if retries := getCredentialRefreshRetries(); retries > 0 {
+		sess.Config.Credentials = newRetryingCredentials(sess.Config.Credentials, retries)
+	}
 	//injecting session handler info
 	injectUserAgent(&sess.Handlers)
 
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,3 +22,35 @@ func TestHttpTimeoutWithValueAbove10(t *testing.T) {
 	expectedHTTPTimeOut := time.Duration(12) * time.Second
 	assert.Equal(t, expectedHTTPTimeOut, getHTTPTimeout())
 }
+
+func TestMaxRetries(t *testing.T) {
+	defer os.Unsetenv(maxRetriesEnv)
+
+	os.Unsetenv(maxRetriesEnv)
+	assert.Equal(t, maxRetries, getMaxRetries())
+
+	os.Setenv(maxRetriesEnv, "20")
+	assert.Equal(t, 20, getMaxRetries())
+
+	os.Setenv(maxRetriesEnv, "0")
+	assert.Equal(t, 0, getMaxRetries())
+
+	os.Setenv(maxRetriesEnv, "500")
+	assert.Equal(t, maxRetries, getMaxRetries())
+
+	os.Setenv(maxRetriesEnv, "-1")
+	assert.Equal(t, maxRetries, getMaxRetries())
+
+	os.Setenv(maxRetriesEnv, "many")
+	assert.Equal(t, maxRetries, getMaxRetries())
+}
+
+func TestNewAppliesMaxRetries(t *testing.T) {
+	defer os.Unsetenv(maxRetriesEnv)
+
+	os.Unsetenv(maxRetriesEnv)
+	assert.Equal(t, maxRetries, aws.IntValue(New().Config.MaxRetries))
+
+	os.Setenv(maxRetriesEnv, "25")
+	assert.Equal(t, 25, aws.IntValue(New().Config.MaxRetries))
+}
@@ -22,8 +22,11 @@ import (
 	"net"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils/awssession"
@@ -34,8 +37,10 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
@@ -51,6 +56,7 @@ const (
 	clusterNameEnvVar       = "CLUSTER_NAME"
 	eniNodeTagKey           = "node.k8s.amazonaws.com/instance_id"
 	eniCreatedAtTagKey      = "node.k8s.amazonaws.com/createdAt"
+	eniAvailableAtTagKey    = "node.k8s.amazonaws.com/availableAt"
 	eniClusterTagKey        = "cluster.k8s.amazonaws.com/name"
 	additionalEniTagsEnvVar = "ADDITIONAL_ENI_TAGS"
 	reservedTagKeyPrefix    = "k8s.amazonaws.com"
@@ -61,19 +67,90 @@ const (
 	eniCleanupStartupDelayMax = 300
 	eniDeleteCooldownTime     = 5 * time.Minute
 
+	// eniCleanupConcurrencyEnvVar is used to bound how many leaked ENIs are deleted in parallel
+	eniCleanupConcurrencyEnvVar = "ENI_CLEANUP_CONCURRENCY"
+	// Keep the default low, EC2 throttles DeleteNetworkInterface per account
+	defaultENICleanupConcurrency = 2
+	// eniCleanupDeletesPerSecondEnvVar is used to bound how many leaked ENI deletes are started per second
+	eniCleanupDeletesPerSecondEnvVar  = "ENI_CLEANUP_DELETES_PER_SECOND"
+	defaultENICleanupDeletesPerSecond = 2
+
+	// branchENICleanupEnvVar enables reaping of branch ENIs whose trunk ENI no longer exists
+	branchENICleanupEnvVar = "ENABLE_BRANCH_ENI_CLEANUP"
+	// trunkENIIDTagKey is set by the VPC resource controller on branch ENIs to the ID of their trunk ENI
+	trunkENIIDTagKey = "vpcresources.k8s.aws/trunk-eni-id"
+
+	// describeENIPageSizeEnvVar is used to override the page size of filtered DescribeNetworkInterfaces calls
+	describeENIPageSizeEnvVar = "DESCRIBE_ENI_PAGE_SIZE"
 	// the default page size when paginating the DescribeNetworkInterfaces call
-	describeENIPageSize = 1000
+	defaultDescribeENIPageSize = 1000
+	// EC2 rejects DescribeNetworkInterfaces page sizes outside of [5, 1000]
+	minDescribeENIPageSize = 5
+	maxDescribeENIPageSize = 1000
+	// EC2 rejects filters with more than 200 values
+	maxDescribeFilterValues = 200
+
+	// ec2APIRetriesEnvVar overrides the number of attempts of the CNI's own retry loops around EC2 calls, on top of
+	// the retries done by the AWS SDK. maxENIEC2APIRetries is the default.
+	ec2APIRetriesEnvVar = "EC2_API_RETRIES"
+	minEC2APIRetries    = 1
+	maxEC2APIRetries    = 50
+
+	// deviceIndexBaseEnvVar is the lowest device index used when attaching ENIs, so that the indices below it stay
+	// free for ENIs attached by the operator
+	deviceIndexBaseEnvVar = "ENI_DEVICE_INDEX_BASE"
+
+	// networkCardSpreadEnvVar attaches the ENIs across all the network cards of the instance type instead of only
+	// card 0, to use the bandwidth of each of them
+	networkCardSpreadEnvVar = "ENABLE_NETWORK_CARD_SPREAD"
+
+	// primaryIPReleaseTimeoutEnvVar is how long, in seconds, an ENI delete waits for a reference to the ENI's primary
+	// private IP to go away when EC2 rejects the delete because of it. 0, the default, fails the delete right away, as
+	// the wait blocks the caller.
+	primaryIPReleaseTimeoutEnvVar  = "ENI_PRIMARY_IP_RELEASE_TIMEOUT_SECONDS"
+	defaultPrimaryIPReleaseTimeout = time.Duration(0)
+	maxPrimaryIPReleaseTimeout     = 10 * time.Minute
+	// primaryIPReleasePollInterval is how often the ENI is described while waiting for its primary IP to be released
+	primaryIPReleasePollInterval = 2 * time.Second
+
+	// leakedENIGracePeriodEnvVar is how long, in seconds, an available ENI of another instance has to stay available
+	// before the leaked ENI cleanup deletes it, so the ENIs of a just terminated instance are left alone while EC2 is
+	// still detaching them. 0 disables the grace period.
+	leakedENIGracePeriodEnvVar = "LEAKED_ENI_GRACE_PERIOD_SECONDS"
+	maxLeakedENIGracePeriod    = 24 * time.Hour
+
+	// subnetDiscoveryTagKey marks the subnets of the VPC new ENIs can be created in, whatever its value
+	subnetDiscoveryTagKey = "kubernetes.io/role/cni"
+
+	// sgDriftCorrectionEnvVar makes the security group refresh put the primary ENI's security groups back on the
+	// managed ENIs whose security groups were changed outside of the CNI. The drift is only reported when it is false.
+	sgDriftCorrectionEnvVar = "ENABLE_SG_DRIFT_CORRECTION"
+
+	// auditModeEnvVar makes the CNI observe only: the calls that would create, attach, modify, tag, detach or delete
+	// ENIs, or assign or unassign their IPs, are logged and return ErrAuditMode instead of calling EC2
+	auditModeEnvVar = "AUDIT_MODE"
 )
 
 var (
 	// ErrENINotFound is an error when ENI is not found.
 	ErrENINotFound = errors.New("ENI is not found")
+	// ErrAuditMode is returned instead of changing AWS resources when the audit mode is on
+	ErrAuditMode = errors.New("audit mode is on, AWS resources are not changed")
 	// ErrAllSecondaryIPsNotFound is returned when not all secondary IPs on an ENI have been assigned
 	ErrAllSecondaryIPsNotFound = errors.New("All secondary IPs not found")
 	// ErrNoSecondaryIPsFound is returned when not all secondary IPs on an ENI have been assigned
 	ErrNoSecondaryIPsFound = errors.New("No secondary IPs have been assigned to this ENI")
 	// ErrNoNetworkInterfaces occurs when DescribeNetworkInterfaces(eniID) returns no network interfaces
 	ErrNoNetworkInterfaces = errors.New("No network interfaces found for ENI")
+	// ErrSubnetAZMismatch is returned when the subnet picked for a new ENI is not in the instance's availability zone
+	ErrSubnetAZMismatch = errors.New("subnet is not in the instance's availability zone")
+	// ErrSubnetVPCMismatch is returned when a subnet is not in the instance's VPC
+	ErrSubnetVPCMismatch = errors.New("subnet is not in the instance's VPC")
+	// ErrSubnetNotFound is returned when EC2 does not know the subnet
+	ErrSubnetNotFound = errors.New("subnet not found")
+	// ErrENIPrimaryInUse is returned when EC2 keeps rejecting the delete of an ENI because its primary private IP is
+	// still referenced, e.g. by an Elastic IP association
+	ErrENIPrimaryInUse = errors.New("ENI primary private IP is still in use")
 )
 
 var log = logger.Get()
@@ -100,9 +177,100 @@ var (
 		},
 		[]string{"fn", "error"},
 	)
+	eniRemovals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "awscni_eni_removed_count",
+			Help: "The number of ENIs detached, deleted or dropped from the datastore, by reason",
+		},
+		[]string{"reason"},
+	)
+	sgDriftedENIs = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_sg_drifted_eni_count",
+			Help: "The number of managed ENIs whose security groups differ from the primary ENI's, as of the last check",
+		},
+	)
+	ec2LastSuccessfulCall = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_ec2_last_successful_call_timestamp_seconds",
+			Help: "The Unix time of the last EC2 API call that succeeded",
+		},
+	)
+	sgDriftCorrections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "awscni_sg_drift_correction_count",
+			Help: "The number of attempts to put the expected security groups back on a drifted ENI",
+		},
+		[]string{"error"},
+	)
+	auditModeSkippedCalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "awscni_audit_mode_skipped_call_count",
+			Help: "The number of calls that would have changed AWS resources, skipped because of the audit mode",
+		},
+		[]string{"fn"},
+	)
+	leakedENIsFound = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "awscni_leaked_enis_found_total",
+			Help: "The number of distinct leaked ENIs found by the leaked ENI cleanup runs, including the ones too recent to delete",
+		},
+	)
+	leakedENIsDeleted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "awscni_leaked_enis_deleted_total",
+			Help: "The number of leaked ENIs deleted by the leaked ENI cleanup",
+		},
+	)
+	leakedENIsCurrent = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_leaked_enis_current",
+			Help: "The number of leaked ENIs found but not deleted by the last leaked ENI cleanup run",
+		},
+	)
+	ec2RetriesDenied = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "awscni_ec2_retries_denied_total",
+			Help: "The number of EC2 call retries not made because the shared retry budget was exhausted",
+		},
+		[]string{"api"},
+	)
+	ec2InflightCalls = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_ec2_inflight_calls",
+			Help: "The number of EC2 calls in flight, retries included",
+		},
+	)
+	ec2QueuedCalls = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_ec2_queued_calls",
+			Help: "The number of EC2 calls waiting for one of the EC2_MAX_INFLIGHT_CALLS slots",
+		},
+	)
 	prometheusRegistered = false
 )
 
+// ENIRemovalReason says why an ENI was detached, deleted or dropped from the datastore
+type ENIRemovalReason string
+
+const (
+	// ENIRemovalWarmShrink is an unused ENI freed because the pool is above the warm targets
+	ENIRemovalWarmShrink ENIRemovalReason = "warm_shrink"
+	// ENIRemovalErrorRecovery is an ENI cleaned up after a failed attach or set up
+	ENIRemovalErrorRecovery ENIRemovalReason = "error_recovery"
+	// ENIRemovalDrain is an ENI that is still attached but that ipamd stopped managing, e.g. after it got the
+	// no_manage tag
+	ENIRemovalDrain ENIRemovalReason = "drain"
+	// ENIRemovalDecommission is an ENI detached outside of ipamd, or left behind by a terminated instance
+	ENIRemovalDecommission ENIRemovalReason = "decommission"
+)
+
+// RecordENIRemoval logs and counts the removal of an ENI
+func RecordENIRemoval(eniID string, reason ENIRemovalReason) {
+	log.Infof("Removed ENI %s, reason: %s", eniID, reason)
+	eniRemovals.With(prometheus.Labels{"reason": string(reason)}).Inc()
+}
+
 // APIs defines interfaces calls for adding/getting/deleting ENIs/secondary IPs. The APIs are not thread-safe.
 type APIs interface {
 	// AllocENI creates an ENI and attaches it to the instance
@@ -150,7 +318,8 @@ type APIs interface {
 	// GetENIIPv4Limit return IP address limit per ENI based on EC2 instance type
 	GetENIIPv4Limit() (int, error)
 
-	// GetENILimit returns the number of ENIs that can be attached to an instance
+	// GetENILimit returns the number of ENIs that can be attached to an instance, the primary ENI included, leaving out
+	// the device indices below ENI_DEVICE_INDEX_BASE
 	GetENILimit() (int, error)
 
 	// GetPrimaryENImac returns the mac address of the primary ENI
@@ -171,6 +340,9 @@ type APIs interface {
 	//isCNIUnmanagedENI
 	IsCNIUnmanagedENI(eniID string) bool
 
+	// IsAuditMode returns true when the changes to AWS resources are only logged
+	IsAuditMode() bool
+
 	//RefreshSGIDs
 	RefreshSGIDs(mac string) error
 
@@ -180,6 +352,30 @@ type APIs interface {
 	//GetInstanceType returns the EC2 instance type
 	GetInstanceType() string
 
+	//GetNetworkPerformance returns the network performance tier of the EC2 instance type, e.g. "Up to 10 Gigabit"
+	GetNetworkPerformance() (string, error)
+
+	//GetExpectedENIs returns the IDs of the ENIs that EC2 reports as attached or attaching to the instance
+	GetExpectedENIs() ([]string, error)
+
+	//GetENICleanupHistory returns the most recent ENIs deleted by the leaked ENI cleanup, oldest first
+	GetENICleanupHistory() []ENICleanupRecord
+
+	//GetSubnetIPv4CIDR returns the IPv4 CIDR of a subnet ENIs can be created in for the instance
+	GetSubnetIPv4CIDR(subnetID string) (string, error)
+
+	//GetTaggedSubnets returns the subnets of the instance's VPC and availability zone tagged for ENIs, most free IPs first
+	GetTaggedSubnets() ([]SubnetAvailability, error)
+
+	//GetSubnetAvailability returns the free IPs of a subnet, of the primary ENI's subnet if subnetID is empty
+	GetSubnetAvailability(subnetID string) (SubnetAvailability, error)
+
+	//GetENIQuota returns the number of ENIs the account can have in the region
+	GetENIQuota() (int, error)
+
+	//GetEC2APIStatus returns when the last EC2 call succeeded and how many failed since
+	GetEC2APIStatus() EC2APIStatus
+
 	//Update cached prefix delegation flag
 	InitCachedPrefixDelegation(bool)
 }
@@ -196,14 +392,51 @@ type EC2InstanceMetadataCache struct {
 	primaryENImac    string
 	availabilityZone string
 	region           string
+	vpcID            string
 
 	unmanagedENIs              StringSet
 	useCustomNetworking        bool
 	cniunmanagedENIs           StringSet
 	enableIpv4PrefixDelegation bool
 
-	clusterName       string
-	additionalENITags map[string]string
+	clusterName            string
+	additionalENITags      map[string]string
+	eniCleanupConcurrency  int
+	enableBranchENICleanup bool
+	// eniCleanupDeleteInterval is the minimum time between the starts of two leaked ENI deletes, 0 for no limit
+	eniCleanupDeleteInterval time.Duration
+	// enableSGDriftCorrection fixes the security groups of the managed ENIs that drifted from the primary ENI's
+	enableSGDriftCorrection bool
+	// auditMode logs the changes to AWS resources instead of making them
+	auditMode           bool
+	describeENIPageSize int64
+	ec2APIRetries       int
+	deviceIndexBase     int
+	// enableNetworkCardSpread attaches each ENI to the network card with the fewest ENIs
+	enableNetworkCardSpread bool
+	// networkCardENILimits is the number of ENIs each network card of the instance type takes, by card index
+	networkCardENILimits []int
+	networkCardsLock     sync.Mutex
+	// primaryIPReleaseTimeout and primaryIPReleasePollInterval bound the wait for an ENI's primary IP to be released
+	primaryIPReleaseTimeout      time.Duration
+	primaryIPReleasePollInterval time.Duration
+	leakedENIGracePeriod         time.Duration
+	eniCleanupHistory            *eniCleanupHistory
+	// leakedENIsSeen holds the IDs of the leaked ENIs found by the last cleanup run, to count each one once
+	leakedENIsSeen map[string]bool
+	// leakedENIPartialTagPolicy decides whether the leaked ENI cleanup deletes partially tagged ENIs
+	leakedENIPartialTagPolicy leakedENIPartialTagPolicy
+	// leakedENIExcludeDescription matches the descriptions of the ENIs the cleanup never deletes, nil for none
+	leakedENIExcludeDescription *regexp.Regexp
+	// serviceQuotas looks up the account's ENI quota
+	serviceQuotas serviceQuotas
+	// ec2APIStatus tracks the outcome of the EC2 calls
+	ec2APIStatus *ec2APIStatusTracker
+	// ec2RetryBudget limits the retries of the EC2 calls, nil for no limit
+	ec2RetryBudget *retryBudget
+
+	networkPerformanceLock sync.Mutex
+	networkPerformance     string
 
 	imds   TypedIMDS
 	ec2SVC ec2wrapper.EC2
@@ -228,6 +461,9 @@ type ENIMetadata struct {
 
 	// IPv4 Prefixes allocated for the network interface
 	IPv4Prefixes []*ec2.Ipv4PrefixSpecification
+
+	// AttachTime is when the network interface was attached, only known from EC2 by DescribeAllENIs
+	AttachTime time.Time
 }
 
 // InstanceTypeLimits keeps track of limits for an instance type
@@ -269,6 +505,17 @@ func prometheusRegister() {
 		prometheus.MustRegister(awsAPILatency)
 		prometheus.MustRegister(awsAPIErr)
 		prometheus.MustRegister(awsUtilsErr)
+		prometheus.MustRegister(eniRemovals)
+		prometheus.MustRegister(sgDriftedENIs)
+		prometheus.MustRegister(sgDriftCorrections)
+		prometheus.MustRegister(ec2LastSuccessfulCall)
+		prometheus.MustRegister(auditModeSkippedCalls)
+		prometheus.MustRegister(leakedENIsFound)
+		prometheus.MustRegister(leakedENIsDeleted)
+		prometheus.MustRegister(leakedENIsCurrent)
+		prometheus.MustRegister(ec2RetriesDenied)
+		prometheus.MustRegister(ec2InflightCalls)
+		prometheus.MustRegister(ec2QueuedCalls)
 		prometheusRegistered = true
 	}
 }
@@ -356,6 +603,27 @@ func New(useCustomNetworking bool) (*EC2InstanceMetadataCache, error) {
 	cache.imds = TypedIMDS{instrumentedIMDS{ec2Metadata}}
 	cache.clusterName = os.Getenv(clusterNameEnvVar)
 	cache.additionalENITags = loadAdditionalENITags()
+	cache.eniCleanupConcurrency = loadENICleanupConcurrency()
+	cache.eniCleanupDeleteInterval = time.Second / time.Duration(loadENICleanupDeletesPerSecond())
+	cache.enableBranchENICleanup = loadEnableBranchENICleanup()
+	cache.enableSGDriftCorrection = loadEnableSGDriftCorrection()
+	cache.auditMode = loadAuditMode()
+	if cache.auditMode {
+		log.Warnf("%s is on, AWS resources will not be changed", auditModeEnvVar)
+	}
+	cache.describeENIPageSize = loadDescribeENIPageSize()
+	cache.ec2APIRetries = loadEC2APIRetries()
+	cache.deviceIndexBase = loadDeviceIndexBase()
+	cache.enableNetworkCardSpread = loadEnableNetworkCardSpread()
+	cache.primaryIPReleaseTimeout = loadPrimaryIPReleaseTimeout()
+	cache.leakedENIGracePeriod = loadLeakedENIGracePeriod()
+	cache.leakedENIPartialTagPolicy = loadLeakedENIPartialTagPolicy()
+	excludeDescription, err := loadLeakedENIExcludeDescription()
+	if err != nil {
+		return nil, err
+	}
+	cache.leakedENIExcludeDescription = excludeDescription
+	cache.eniCleanupHistory = newENICleanupHistory(os.Getenv(eniCleanupHistoryFileEnvVar), eniCleanupHistorySize)
 
 	region, err := ec2Metadata.Region()
 	if err != nil {
@@ -370,6 +638,15 @@ func New(useCustomNetworking bool) (*EC2InstanceMetadataCache, error) {
 
 	awsCfg := aws.NewConfig().WithRegion(region)
 	sess = sess.Copy(awsCfg)
+	// Created before the EC2 call tracking is installed, Service Quotas calls aren't EC2 ones
+	cache.serviceQuotas = servicequotas.New(sess)
+	cache.ec2APIStatus = newEC2APIStatusTracker(time.Now)
+	cache.ec2APIStatus.install(&sess.Handlers)
+	if budget := loadEC2RetryBudget(); budget > 0 {
+		cache.ec2RetryBudget = newRetryBudget(budget, time.Now)
+		cache.ec2RetryBudget.install(&sess.Handlers)
+	}
+	newInflightLimiter(loadEC2MaxInflight()).install(&sess.Handlers)
 
 	ec2SVC := ec2wrapper.New(sess)
 	cache.ec2SVC = ec2SVC
@@ -377,6 +654,9 @@ func New(useCustomNetworking bool) (*EC2InstanceMetadataCache, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = cache.validateDeviceIndexBase(); err != nil {
+		return nil, err
+	}
 
 	// Clean up leaked ENIs in the background
 	go wait.Forever(cache.cleanUpLeakedENIs, time.Hour)
@@ -389,65 +669,98 @@ func (cache *EC2InstanceMetadataCache) InitCachedPrefixDelegation(enableIpv4Pref
 	log.Infof("Prefix Delegation enabled %v", cache.enableIpv4PrefixDelegation)
 }
 
-// InitWithEC2metadata initializes the EC2InstanceMetadataCache with the data retrieved from EC2 metadata service
+// InitWithEC2metadata initializes the EC2InstanceMetadataCache with the data retrieved from EC2 metadata service.
+// The lookups that do not depend on each other run concurrently, only the primary ENI and its subnet wait for the MAC.
 func (cache *EC2InstanceMetadataCache) initWithEC2Metadata(ctx context.Context) error {
-	var err error
-	// retrieve availability-zone
-	cache.availabilityZone, err = cache.imds.GetAZ(ctx)
-	if err != nil {
-		return err
-	}
-	log.Debugf("Found availability zone: %s ", cache.availabilityZone)
+	g, gctx := errgroup.WithContext(ctx)
 
-	// retrieve eth0 local-ipv4
-	cache.localIPv4, err = cache.imds.GetLocalIPv4(ctx)
-	if err != nil {
-		return err
-	}
-	log.Debugf("Discovered the instance primary ip address: %s", cache.localIPv4)
+	g.Go(func() error {
+		// retrieve availability-zone
+		az, err := cache.imds.GetAZ(gctx)
+		if err != nil {
+			return err
+		}
+		cache.availabilityZone = az
+		log.Debugf("Found availability zone: %s ", cache.availabilityZone)
+		return nil
+	})
 
-	// retrieve instance-id
-	cache.instanceID, err = cache.imds.GetInstanceID(ctx)
-	if err != nil {
-		return err
-	}
-	log.Debugf("Found instance-id: %s ", cache.instanceID)
+	g.Go(func() error {
+		// retrieve eth0 local-ipv4
+		localIPv4, err := cache.imds.GetLocalIPv4(gctx)
+		if err != nil {
+			return err
+		}
+		cache.localIPv4 = localIPv4
+		log.Debugf("Discovered the instance primary ip address: %s", cache.localIPv4)
+		return nil
+	})
 
-	// retrieve instance-type
-	cache.instanceType, err = cache.imds.GetInstanceType(ctx)
-	if err != nil {
-		return err
-	}
-	log.Debugf("Found instance-type: %s ", cache.instanceType)
+	g.Go(func() error {
+		// retrieve instance-id
+		instanceID, err := cache.imds.GetInstanceID(gctx)
+		if err != nil {
+			return err
+		}
+		cache.instanceID = instanceID
+		log.Debugf("Found instance-id: %s ", cache.instanceID)
+		return nil
+	})
 
-	// retrieve primary interface's mac
-	mac, err := cache.imds.GetMAC(ctx)
-	if err != nil {
-		return err
-	}
-	cache.primaryENImac = mac
-	log.Debugf("Found primary interface's MAC address: %s", mac)
+	g.Go(func() error {
+		// retrieve instance-type
+		instanceType, err := cache.imds.GetInstanceType(gctx)
+		if err != nil {
+			return err
+		}
+		cache.instanceType = instanceType
+		log.Debugf("Found instance-type: %s ", cache.instanceType)
+		return nil
+	})
 
-	cache.primaryENI, err = cache.imds.GetInterfaceID(ctx, mac)
-	if err != nil {
-		return errors.Wrap(err, "get instance metadata: failed to find primary ENI")
-	}
-	log.Debugf("%s is the primary ENI of this instance", cache.primaryENI)
+	g.Go(func() error {
+		// retrieve primary interface's mac
+		mac, err := cache.imds.GetMAC(gctx)
+		if err != nil {
+			return err
+		}
+		cache.primaryENImac = mac
+		log.Debugf("Found primary interface's MAC address: %s", mac)
 
-	// retrieve sub-id
-	cache.subnetID, err = cache.imds.GetSubnetID(ctx, mac)
-	if err != nil {
-		return err
-	}
-	log.Debugf("Found subnet-id: %s ", cache.subnetID)
+		// The primary ENI and its subnet are looked up by MAC, fetch them concurrently once it is known
+		macGroup, macCtx := errgroup.WithContext(gctx)
+		macGroup.Go(func() error {
+			primaryENI, err := cache.imds.GetInterfaceID(macCtx, mac)
+			if err != nil {
+				return errors.Wrap(err, "get instance metadata: failed to find primary ENI")
+			}
+			cache.primaryENI = primaryENI
+			log.Debugf("%s is the primary ENI of this instance", cache.primaryENI)
+			return nil
+		})
+		macGroup.Go(func() error {
+			// retrieve sub-id
+			subnetID, err := cache.imds.GetSubnetID(macCtx, mac)
+			if err != nil {
+				return err
+			}
+			cache.subnetID = subnetID
+			log.Debugf("Found subnet-id: %s ", cache.subnetID)
+			return nil
+		})
+		macGroup.Go(func() error {
+			vpcID, err := cache.imds.GetVPCID(macCtx, mac)
+			if err != nil {
+				return err
+			}
+			cache.vpcID = vpcID
+			log.Debugf("Found vpc-id: %s ", cache.vpcID)
+			return nil
+		})
+		return macGroup.Wait()
+	})
 
-	// We use the ctx here for testing, since we spawn go-routines above which will run forever.
-	select {
-	case <-ctx.Done():
-		return nil
-	default:
-	}
-	return nil
+	return g.Wait()
 }
 
 // RefreshSGIDs retrieves security groups
@@ -476,52 +789,95 @@ func (cache *EC2InstanceMetadataCache) RefreshSGIDs(mac string) error {
 	}
 	cache.securityGroups.Set(sgIDs)
 
-	if !cache.useCustomNetworking && (addedSGsCount != 0 || deletedSGsCount != 0) {
-		allENIs, err := cache.GetAttachedENIs()
-		if err != nil {
-			return errors.Wrap(err, "DescribeAllENIs: failed to get local ENI metadata")
-		}
+	// With custom networking the security groups of the secondary ENIs come from their ENIConfig
+	if cache.useCustomNetworking {
+		return nil
+	}
 
-		var eniIDs []string
-		for _, eni := range allENIs {
-			eniIDs = append(eniIDs, eni.ENIID)
+	allENIs, err := cache.GetAttachedENIs()
+	if err != nil {
+		return errors.Wrap(err, "DescribeAllENIs: failed to get local ENI metadata")
+	}
+	sgsChanged := addedSGsCount != 0 || deletedSGsCount != 0
+	drifted := 0
+	// This will update SG for managed ENIs created by EKS.
+	for _, eni := range allENIs {
+		if cache.cniunmanagedENIs.Has(eni.ENIID) || cache.unmanagedENIs.Has(eni.ENIID) {
+			continue
 		}
-
-		newENIs := StringSet{}
-		newENIs.Set(eniIDs)
-
-		tempfilteredENIs := newENIs.Difference(&cache.cniunmanagedENIs)
-		filteredENIs := tempfilteredENIs.Difference(&cache.unmanagedENIs)
-
-		sgIDsPtrs := aws.StringSlice(sgIDs)
-		// This will update SG for managed ENIs created by EKS.
-		for _, eniID := range filteredENIs.SortedList() {
-			log.Debugf("Update ENI %s", eniID)
-
-			attributeInput := &ec2.ModifyNetworkInterfaceAttributeInput{
-				Groups:             sgIDsPtrs,
-				NetworkInterfaceId: aws.String(eniID),
+		if !sgsChanged {
+			if !cache.hasSGDrift(ctx, eni, &newSGs) {
+				continue
 			}
-			start := time.Now()
-			_, err = cache.ec2SVC.ModifyNetworkInterfaceAttributeWithContext(context.Background(), attributeInput)
-			awsAPILatency.WithLabelValues("ModifyNetworkInterfaceAttribute", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
-			if err != nil {
-				if aerr, ok := err.(awserr.Error); ok {
-					if aerr.Code() == "InvalidNetworkInterfaceID.NotFound" {
-						awsAPIErrInc("IMDSMetaDataOutOfSync", err)
-					}
-				}
-				awsAPIErrInc("ModifyNetworkInterfaceAttribute", err)
-				//No need to return error here since retry will happen in 30seconds and also
-				//If update failed due to stale ENI then returning error will prevent updating SG
-				//for following ENIs since the list is sorted
-				log.Debugf("refreshSGIDs: unable to update the ENI %s SG - %v", eniID, err)
+			drifted++
+			if !cache.enableSGDriftCorrection {
+				continue
+			}
+		}
+		log.Debugf("Update ENI %s", eni.ENIID)
+		err = cache.updateENISGs(eni.ENIID, sgIDs)
+		if err == ErrAuditMode {
+			continue
+		}
+		if !sgsChanged {
+			sgDriftCorrections.With(prometheus.Labels{"error": fmt.Sprint(err != nil)}).Inc()
+			if err == nil {
+				log.Infof("Restored security groups %v on ENI %s", sgIDs, eni.ENIID)
+				drifted--
 			}
 		}
+		if err != nil {
+			//No need to return error here since retry will happen in 30seconds and also
+			//If update failed due to stale ENI then returning error will prevent updating SG
+			//for following ENIs
+			log.Debugf("refreshSGIDs: unable to update the ENI %s SG - %v", eni.ENIID, err)
+		}
+	}
+	if !sgsChanged {
+		sgDriftedENIs.Set(float64(drifted))
 	}
 	return nil
 }
 
+// hasSGDrift returns true if the security groups of the ENI, as reported by IMDS, differ from expected
+func (cache *EC2InstanceMetadataCache) hasSGDrift(ctx context.Context, eni ENIMetadata, expected *StringSet) bool {
+	eniSGIDs, err := cache.imds.GetSecurityGroupIDs(ctx, eni.MAC)
+	if err != nil {
+		log.Debugf("refreshSGIDs: unable to get the security groups of ENI %s - %v", eni.ENIID, err)
+		return false
+	}
+	eniSGs := StringSet{}
+	eniSGs.Set(eniSGIDs)
+	if len(eniSGs.Difference(expected).SortedList()) == 0 && len(expected.Difference(&eniSGs).SortedList()) == 0 {
+		return false
+	}
+	log.Warnf("ENI %s has security groups %v instead of %v", eni.ENIID, eniSGs.SortedList(), expected.SortedList())
+	return true
+}
+
+// updateENISGs sets the security groups of the ENI
+func (cache *EC2InstanceMetadataCache) updateENISGs(eniID string, sgIDs []string) error {
+	if cache.skipInAuditMode("updateENISGs", "set security groups %v on ENI %s", sgIDs, eniID) {
+		return ErrAuditMode
+	}
+	attributeInput := &ec2.ModifyNetworkInterfaceAttributeInput{
+		Groups:             aws.StringSlice(sgIDs),
+		NetworkInterfaceId: aws.String(eniID),
+	}
+	start := time.Now()
+	_, err := cache.ec2SVC.ModifyNetworkInterfaceAttributeWithContext(context.Background(), attributeInput)
+	awsAPILatency.WithLabelValues("ModifyNetworkInterfaceAttribute", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == "InvalidNetworkInterfaceID.NotFound" {
+				awsAPIErrInc("IMDSMetaDataOutOfSync", err)
+			}
+		}
+		awsAPIErrInc("ModifyNetworkInterfaceAttribute", err)
+	}
+	return err
+}
+
 // GetAttachedENIs retrieves ENI information from meta data service
 func (cache *EC2InstanceMetadataCache) GetAttachedENIs() (eniList []ENIMetadata, err error) {
 	ctx := context.TODO()
@@ -571,6 +927,13 @@ func (cache *EC2InstanceMetadataCache) getENIMetadata(eniMAC string) (ENIMetadat
 		deviceNum = 0
 	}
 
+	if cache.enableNetworkCardSpread && eniMAC != primaryMAC {
+		deviceNum, err = cache.uniqueDeviceNumber(ctx, eniMAC, deviceNum)
+		if err != nil {
+			return ENIMetadata{}, err
+		}
+	}
+
 	log.Debugf("Found ENI: %s, MAC %s, device %d", eniID, eniMAC, deviceNum)
 
 	cidr, err := cache.imds.GetSubnetIPv4CIDRBlock(ctx, eniMAC)
@@ -619,8 +982,33 @@ func (cache *EC2InstanceMetadataCache) getENIMetadata(eniMAC string) (ENIMetadat
 	}, nil
 }
 
-// awsGetFreeDeviceNumber calls EC2 API DescribeInstances to get the next free device index
-func (cache *EC2InstanceMetadataCache) awsGetFreeDeviceNumber() (int, error) {
+// uniqueDeviceNumber turns the device index of an ENI, which is only unique on its network card, into a device number
+// unique on the instance: the ENIs of a card are numbered after the ones all the cards before it take. The device number
+// names the route table of the ENI, so two ENIs can't share it.
+func (cache *EC2InstanceMetadataCache) uniqueDeviceNumber(ctx context.Context, eniMAC string, deviceIndex int) (int, error) {
+	networkCard, err := cache.imds.GetNetworkCard(ctx, eniMAC)
+	if err != nil || networkCard == 0 {
+		return deviceIndex, err
+	}
+	limits, err := cache.getNetworkCardENILimits()
+	if err != nil {
+		return 0, err
+	}
+	deviceNum := deviceIndex
+	for card := 0; card < networkCard && card < len(limits); card++ {
+		deviceNum += limits[card]
+	}
+	return deviceNum, nil
+}
+
+// awsGetFreeAttachment calls EC2 API DescribeInstances to get the network card and the free device index on it to
+// attach the next ENI at. It is always network card 0 unless ENIs are spread across the cards.
+func (cache *EC2InstanceMetadataCache) awsGetFreeAttachment() (int, int, error) {
+	limits, err := cache.getNetworkCardENILimits()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "find a free device number for ENI: not able to get the network cards")
+	}
+
 	input := &ec2.DescribeInstancesInput{
 		InstanceIds: []*string{aws.String(cache.instanceID)},
 	}
@@ -630,18 +1018,27 @@ func (cache *EC2InstanceMetadataCache) awsGetFreeDeviceNumber() (int, error) {
 	awsAPILatency.WithLabelValues("DescribeInstances", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
 	if err != nil {
 		awsAPIErrInc("DescribeInstances", err)
-		log.Errorf("awsGetFreeDeviceNumber: Unable to retrieve instance data from EC2 control plane %v", err)
-		return 0, errors.Wrap(err,
+		log.Errorf("awsGetFreeAttachment: Unable to retrieve instance data from EC2 control plane %v", err)
+		return 0, 0, errors.Wrap(err,
 			"find a free device number for ENI: not able to retrieve instance data from EC2 control plane")
 	}
 
 	if len(result.Reservations) != 1 {
-		return 0, errors.Errorf("awsGetFreeDeviceNumber: invalid instance id %s", cache.instanceID)
+		return 0, 0, errors.Errorf("awsGetFreeAttachment: invalid instance id %s", cache.instanceID)
 	}
 
 	inst := result.Reservations[0].Instances[0]
+	networkCard := pickNetworkCard(inst.NetworkInterfaces, limits, cache.deviceIndexBase)
 	var device [maxENIs]bool
+	// Device indices below the base are left to the operator
+	for deviceIndex := 0; deviceIndex < cache.deviceIndexBase && deviceIndex < maxENIs; deviceIndex++ {
+		device[deviceIndex] = true
+	}
 	for _, eni := range inst.NetworkInterfaces {
+		// Each network card has its own device indices
+		if aws.Int64Value(eni.Attachment.NetworkCardIndex) != int64(networkCard) {
+			continue
+		}
 		if aws.Int64Value(eni.Attachment.DeviceIndex) > maxENIs {
 			log.Warnf("The Device Index %d of the attached ENI %s > instance max slot %d",
 				aws.Int64Value(eni.Attachment.DeviceIndex), aws.StringValue(eni.NetworkInterfaceId),
@@ -654,16 +1051,125 @@ func (cache *EC2InstanceMetadataCache) awsGetFreeDeviceNumber() (int, error) {
 
 	for freeDeviceIndex := 0; freeDeviceIndex < maxENIs; freeDeviceIndex++ {
 		if !device[freeDeviceIndex] {
-			log.Debugf("Found a free device number: %d", freeDeviceIndex)
-			return freeDeviceIndex, nil
+			log.Debugf("Found a free device number: %d on network card %d", freeDeviceIndex, networkCard)
+			return networkCard, freeDeviceIndex, nil
+		}
+	}
+	return 0, 0, errors.New("awsGetFreeAttachment: no available device number")
+}
+
+// pickNetworkCard returns the network card with the fewest attached ENIs out of the ones with room for another at or
+// above the device index base, the lowest index first. It is card 0 on instance types with a single card, or when every
+// card is full.
+func pickNetworkCard(enis []*ec2.InstanceNetworkInterface, limits []int, deviceIndexBase int) int {
+	if len(limits) < 2 {
+		return 0
+	}
+	attached := make([]int, len(limits))
+	free := make([]int, len(limits))
+	for card, limit := range limits {
+		free[card] = limit - deviceIndexBase
+	}
+	for _, eni := range enis {
+		if card := int(aws.Int64Value(eni.Attachment.NetworkCardIndex)); card < len(limits) {
+			attached[card]++
+			if int(aws.Int64Value(eni.Attachment.DeviceIndex)) >= deviceIndexBase {
+				free[card]--
+			}
 		}
 	}
-	return 0, errors.New("awsGetFreeDeviceNumber: no available device number")
+	best := 0
+	for card := range limits {
+		if free[card] <= 0 {
+			continue
+		}
+		if free[best] <= 0 || attached[card] < attached[best] {
+			best = card
+		}
+	}
+	return best
+}
+
+// getNetworkCardENILimits returns the number of ENIs each network card of the instance type takes, by card index, or
+// nil when ENIs aren't spread across the cards. The cards don't change for the life of the instance, so they are
+// fetched from the EC2 API once and cached.
+func (cache *EC2InstanceMetadataCache) getNetworkCardENILimits() ([]int, error) {
+	if !cache.enableNetworkCardSpread {
+		return nil, nil
+	}
+	cache.networkCardsLock.Lock()
+	defer cache.networkCardsLock.Unlock()
+
+	if cache.networkCardENILimits != nil {
+		return cache.networkCardENILimits, nil
+	}
+	input := &ec2.DescribeInstanceTypesInput{InstanceTypes: []*string{aws.String(cache.instanceType)}}
+	output, err := cache.ec2SVC.DescribeInstanceTypesWithContext(context.Background(), input)
+	if err != nil {
+		awsAPIErrInc("DescribeInstanceTypes", err)
+		return nil, errors.Wrapf(err, "failed to describe instance type %s", cache.instanceType)
+	}
+	if len(output.InstanceTypes) != 1 || output.InstanceTypes[0].NetworkInfo == nil {
+		return nil, errors.Errorf("no network info found for instance type %s", cache.instanceType)
+	}
+	info := output.InstanceTypes[0].NetworkInfo
+	limits := make([]int, 1)
+	if cards := int(aws.Int64Value(info.MaximumNetworkCards)); cards > 1 {
+		limits = make([]int, cards)
+	}
+	for _, card := range info.NetworkCards {
+		if index := int(aws.Int64Value(card.NetworkCardIndex)); index >= 0 && index < len(limits) {
+			limits[index] = int(aws.Int64Value(card.MaximumNetworkInterfaces))
+		}
+	}
+	if len(info.NetworkCards) == 0 {
+		// Only the ENI limit of the whole instance is known
+		limits[0] = int(aws.Int64Value(info.MaximumNetworkInterfaces))
+	}
+	log.Infof("Attaching ENIs across the %d network cards of instance type %s, taking %v ENIs",
+		len(limits), cache.instanceType, limits)
+	cache.networkCardENILimits = limits
+	return limits, nil
+}
+
+// GetExpectedENIs calls EC2 API DescribeInstances to get the ENIs the control plane has attached, or is attaching, to
+// the instance. Right after boot, ENIs from the launch template can be listed here before they show up in IMDS.
+func (cache *EC2InstanceMetadataCache) GetExpectedENIs() ([]string, error) {
+	input := &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(cache.instanceID)},
+	}
+
+	start := time.Now()
+	result, err := cache.ec2SVC.DescribeInstancesWithContext(context.Background(), input)
+	awsAPILatency.WithLabelValues("DescribeInstances", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
+	if err != nil {
+		awsAPIErrInc("DescribeInstances", err)
+		return nil, errors.Wrap(err, "get expected ENIs: not able to retrieve instance data from EC2 control plane")
+	}
+	if len(result.Reservations) != 1 || len(result.Reservations[0].Instances) != 1 {
+		return nil, errors.Errorf("get expected ENIs: invalid instance id %s", cache.instanceID)
+	}
+
+	var eniIDs []string
+	for _, eni := range result.Reservations[0].Instances[0].NetworkInterfaces {
+		if eni.Attachment != nil {
+			status := aws.StringValue(eni.Attachment.Status)
+			if status == ec2.AttachmentStatusDetaching || status == ec2.AttachmentStatusDetached {
+				continue
+			}
+		}
+		eniIDs = append(eniIDs, aws.StringValue(eni.NetworkInterfaceId))
+	}
+	return eniIDs, nil
 }
 
 // AllocENI creates an ENI and attaches it to the instance
 // returns: newly created ENI ID
 func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string, subnet string) (string, error) {
+	if cache.skipInAuditMode("AllocENI", "create and attach an ENI in subnet %q with security groups %v",
+		subnet, aws.StringValueSlice(sg)) {
+		return "", ErrAuditMode
+	}
 	eniID, err := cache.createENI(useCustomCfg, sg, subnet)
 	if err != nil {
 		return "", errors.Wrap(err, "AllocENI: failed to create ENI")
@@ -675,6 +1181,8 @@ func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string,
 		if derr != nil {
 			awsUtilsErrInc("AllocENIDeleteErr", err)
 			log.Errorf("Failed to delete newly created untagged ENI! %v", err)
+		} else {
+			RecordENIRemoval(eniID, ENIRemovalErrorRecovery)
 		}
 		return "", errors.Wrap(err, "AllocENI: error attaching ENI")
 	}
@@ -696,6 +1204,8 @@ func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string,
 		err := cache.FreeENI(eniID)
 		if err != nil {
 			awsUtilsErrInc("ENICleanupUponModifyNetworkErr", err)
+		} else {
+			RecordENIRemoval(eniID, ENIRemovalErrorRecovery)
 		}
 		return "", errors.Wrap(err, "AllocENI: unable to change the ENI's attribute")
 	}
@@ -707,7 +1217,7 @@ func (cache *EC2InstanceMetadataCache) AllocENI(useCustomCfg bool, sg []*string,
 //  attachENI calls EC2 API to attach the ENI and returns the attachment id
 func (cache *EC2InstanceMetadataCache) attachENI(eniID string) (string, error) {
 	// attach to instance
-	freeDevice, err := cache.awsGetFreeDeviceNumber()
+	networkCard, freeDevice, err := cache.awsGetFreeAttachment()
 	if err != nil {
 		return "", errors.Wrap(err, "attachENI: failed to get a free device number")
 	}
@@ -717,6 +1227,9 @@ func (cache *EC2InstanceMetadataCache) attachENI(eniID string) (string, error) {
 		InstanceId:         aws.String(cache.instanceID),
 		NetworkInterfaceId: aws.String(eniID),
 	}
+	if cache.enableNetworkCardSpread {
+		attachInput.NetworkCardIndex = aws.Int64(int64(networkCard))
+	}
 	start := time.Now()
 	attachOutput, err := cache.ec2SVC.AttachNetworkInterfaceWithContext(context.Background(), attachInput)
 	awsAPILatency.WithLabelValues("AttachNetworkInterface", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
@@ -759,6 +1272,10 @@ func (cache *EC2InstanceMetadataCache) createENI(useCustomCfg bool, sg []*string
 			log.Warnf("No custom networking security group found, will use the node's primary ENI's SG: %s", input.Groups)
 		}
 		input.SubnetId = aws.String(subnet)
+		// The primary ENI's subnet is always in the instance's AZ, but an ENIConfig can point anywhere
+		if err := cache.verifySubnetAZ(subnet); err != nil {
+			return "", err
+		}
 	} else {
 		log.Info("Using same config as the primary interface for the new ENI")
 	}
@@ -777,6 +1294,153 @@ func (cache *EC2InstanceMetadataCache) createENI(useCustomCfg bool, sg []*string
 	return aws.StringValue(result.NetworkInterface.NetworkInterfaceId), nil
 }
 
+// verifySubnetAZ makes sure the subnet is in the same availability zone as the instance, since EC2 refuses to attach an
+// ENI from another AZ. The check is only skipped when the node role is not allowed to describe subnets.
+func (cache *EC2InstanceMetadataCache) verifySubnetAZ(subnet string) error {
+	if cache.availabilityZone == "" {
+		return nil
+	}
+	input := &ec2.DescribeSubnetsInput{SubnetIds: []*string{aws.String(subnet)}}
+
+	start := time.Now()
+	result, err := cache.ec2SVC.DescribeSubnetsWithContext(context.Background(), input)
+	awsAPILatency.WithLabelValues("DescribeSubnets", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
+	if err != nil {
+		awsAPIErrInc("DescribeSubnets", err)
+		if containsUnauthorizedOperationError(err) {
+			log.Warnf("Not allowed to describe subnet %s, skipping the availability zone check: %v", subnet, err)
+			return nil
+		}
+		return errors.Wrapf(err, "failed to verify the availability zone of subnet %s", subnet)
+	}
+	if len(result.Subnets) == 0 {
+		return errors.Wrapf(ErrSubnetNotFound, "subnet %s was not returned by DescribeSubnets", subnet)
+	}
+	subnetAZ := aws.StringValue(result.Subnets[0].AvailabilityZone)
+	if subnetAZ != cache.availabilityZone {
+		awsUtilsErrInc("SubnetAZMismatch", ErrSubnetAZMismatch)
+		return errors.Wrapf(ErrSubnetAZMismatch, "subnet %s is in %s, instance %s is in %s",
+			subnet, subnetAZ, cache.instanceID, cache.availabilityZone)
+	}
+	return nil
+}
+
+// GetSubnetIPv4CIDR returns the IPv4 CIDR of the subnet after making sure ENIs of the instance can be created in it,
+// that is it is in the same VPC and availability zone as the instance's primary ENI subnet
+func (cache *EC2InstanceMetadataCache) GetSubnetIPv4CIDR(subnetID string) (string, error) {
+	subnetIDs := []string{subnetID}
+	if subnetID != cache.subnetID {
+		subnetIDs = append(subnetIDs, cache.subnetID)
+	}
+	input := &ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice(subnetIDs)}
+
+	start := time.Now()
+	result, err := cache.ec2SVC.DescribeSubnetsWithContext(context.Background(), input)
+	awsAPILatency.WithLabelValues("DescribeSubnets", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
+	if err != nil {
+		awsAPIErrInc("DescribeSubnets", err)
+		if aerr, ok := err.(awserr.Error); ok && strings.HasPrefix(aerr.Code(), "InvalidSubnetID.") {
+			return "", errors.Wrapf(ErrSubnetNotFound, "subnet %s: %v", subnetID, err)
+		}
+		return "", errors.Wrapf(err, "failed to describe subnet %s", subnetID)
+	}
+
+	var subnet, primarySubnet *ec2.Subnet
+	for _, s := range result.Subnets {
+		if aws.StringValue(s.SubnetId) == subnetID {
+			subnet = s
+		}
+		if aws.StringValue(s.SubnetId) == cache.subnetID {
+			primarySubnet = s
+		}
+	}
+	if subnet == nil {
+		return "", errors.Wrapf(ErrSubnetNotFound, "subnet %s", subnetID)
+	}
+	if primarySubnet != nil && aws.StringValue(subnet.VpcId) != aws.StringValue(primarySubnet.VpcId) {
+		return "", errors.Wrapf(ErrSubnetVPCMismatch, "subnet %s is in %s, instance %s is in %s",
+			subnetID, aws.StringValue(subnet.VpcId), cache.instanceID, aws.StringValue(primarySubnet.VpcId))
+	}
+	if cache.availabilityZone != "" && aws.StringValue(subnet.AvailabilityZone) != cache.availabilityZone {
+		return "", errors.Wrapf(ErrSubnetAZMismatch, "subnet %s is in %s, instance %s is in %s",
+			subnetID, aws.StringValue(subnet.AvailabilityZone), cache.instanceID, cache.availabilityZone)
+	}
+	return aws.StringValue(subnet.CidrBlock), nil
+}
+
+// SubnetAvailability is a subnet new ENIs can be created in, with its number of free IPs
+type SubnetAvailability struct {
+	SubnetID     string
+	AvailableIPs int64
+}
+
+func newSubnetAvailability(subnet *ec2.Subnet) SubnetAvailability {
+	return SubnetAvailability{
+		SubnetID:     aws.StringValue(subnet.SubnetId),
+		AvailableIPs: aws.Int64Value(subnet.AvailableIpAddressCount),
+	}
+}
+
+// GetTaggedSubnets returns the subnets with the kubernetes.io/role/cni tag in the VPC and availability zone of the
+// instance, most free IPs first
+func (cache *EC2InstanceMetadataCache) GetTaggedSubnets() ([]SubnetAvailability, error) {
+	vpcID, err := cache.imds.GetVPCID(context.TODO(), cache.primaryENImac)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the VPC of the instance")
+	}
+	input := &ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+			{Name: aws.String("availability-zone"), Values: []*string{aws.String(cache.availabilityZone)}},
+			{Name: aws.String("tag-key"), Values: []*string{aws.String(subnetDiscoveryTagKey)}},
+		},
+	}
+
+	start := time.Now()
+	result, err := cache.ec2SVC.DescribeSubnetsWithContext(context.Background(), input)
+	awsAPILatency.WithLabelValues("DescribeSubnets", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
+	if err != nil {
+		awsAPIErrInc("DescribeSubnets", err)
+		return nil, errors.Wrap(err, "failed to describe the tagged subnets")
+	}
+	subnets := make([]SubnetAvailability, 0, len(result.Subnets))
+	for _, subnet := range result.Subnets {
+		subnets = append(subnets, newSubnetAvailability(subnet))
+	}
+	sort.SliceStable(subnets, func(i, j int) bool {
+		if subnets[i].AvailableIPs != subnets[j].AvailableIPs {
+			return subnets[i].AvailableIPs > subnets[j].AvailableIPs
+		}
+		return subnets[i].SubnetID < subnets[j].SubnetID
+	})
+	return subnets, nil
+}
+
+// GetSubnetAvailability returns the number of free IPs of the subnet, of the primary ENI's subnet if subnetID is empty
+func (cache *EC2InstanceMetadataCache) GetSubnetAvailability(subnetID string) (SubnetAvailability, error) {
+	if subnetID == "" {
+		subnetID = cache.subnetID
+	}
+	input := &ec2.DescribeSubnetsInput{SubnetIds: []*string{aws.String(subnetID)}}
+
+	start := time.Now()
+	result, err := cache.ec2SVC.DescribeSubnetsWithContext(context.Background(), input)
+	awsAPILatency.WithLabelValues("DescribeSubnets", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
+	if err != nil {
+		awsAPIErrInc("DescribeSubnets", err)
+		if aerr, ok := err.(awserr.Error); ok && strings.HasPrefix(aerr.Code(), "InvalidSubnetID.") {
+			return SubnetAvailability{}, errors.Wrapf(ErrSubnetNotFound, "subnet %s: %v", subnetID, err)
+		}
+		return SubnetAvailability{}, errors.Wrapf(err, "failed to describe subnet %s", subnetID)
+	}
+	for _, subnet := range result.Subnets {
+		if aws.StringValue(subnet.SubnetId) == subnetID {
+			return newSubnetAvailability(subnet), nil
+		}
+	}
+	return SubnetAvailability{}, errors.Wrapf(ErrSubnetNotFound, "subnet %s", subnetID)
+}
+
 // buildENITags computes the desired AWS Tags for eni
 func (cache *EC2InstanceMetadataCache) buildENITags() map[string]string {
 	tags := map[string]string{
@@ -795,15 +1459,28 @@ func (cache *EC2InstanceMetadataCache) buildENITags() map[string]string {
 }
 
 func (cache *EC2InstanceMetadataCache) TagENI(eniID string, currentTags map[string]string) error {
+	desiredTags := cache.buildENITags()
 	tagChanges := make(map[string]string)
-	for tagKey, tagValue := range cache.buildENITags() {
+	for tagKey, tagValue := range desiredTags {
 		if currentTagValue, ok := currentTags[tagKey]; !ok || currentTagValue != tagValue {
 			tagChanges[tagKey] = tagValue
 		}
 	}
+	// An ENI of a node recycled from another cluster still carries that cluster's name, which would have the other
+	// cluster's leaked ENI cleanup reap it and ours skip it
+	if staleCluster, ok := currentTags[eniClusterTagKey]; ok && staleCluster != desiredTags[eniClusterTagKey] {
+		if cluster, ok := desiredTags[eniClusterTagKey]; ok {
+			log.Infof("ENI %s is tagged for cluster %q, re-tagging it for cluster %q", eniID, staleCluster, cluster)
+		} else {
+			cache.untagENICluster(eniID, staleCluster)
+		}
+	}
 	if len(tagChanges) == 0 {
 		return nil
 	}
+	if cache.skipInAuditMode("TagENI", "tag ENI %s with %v", eniID, tagChanges) {
+		return ErrAuditMode
+	}
 
 	input := &ec2.CreateTagsInput{
 		Resources: []*string{
@@ -813,7 +1490,7 @@ func (cache *EC2InstanceMetadataCache) TagENI(eniID string, currentTags map[stri
 	}
 
 	log.Debugf("Tagging ENI %s with missing tags: %v", eniID, tagChanges)
-	return retry.NWithBackoff(retry.NewSimpleBackoff(500*time.Millisecond, maxENIBackoffDelay, 0.3, 2), 5, func() error {
+	return retry.NWithBackoff(retry.NewSimpleBackoff(500*time.Millisecond, maxENIBackoffDelay, 0.3, 2), 5, cache.ec2RetryBudget.gateRetries("CreateTags", func() error {
 		start := time.Now()
 		_, err := cache.ec2SVC.CreateTagsWithContext(context.Background(), input)
 		awsAPILatency.WithLabelValues("CreateTags", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
@@ -824,7 +1501,44 @@ func (cache *EC2InstanceMetadataCache) TagENI(eniID string, currentTags map[stri
 		}
 		log.Debugf("Successfully tagged ENI: %s", eniID)
 		return nil
-	})
+	}))
+}
+
+// untagENICluster removes the cluster tag of an ENI when no cluster name is set. Failures, e.g. without the
+// ec2:DeleteTags permission, are only logged: the ENI works the same, only its cleanup once leaked is affected.
+func (cache *EC2InstanceMetadataCache) untagENICluster(eniID string, staleCluster string) {
+	if cache.skipInAuditMode("DeleteTags", "remove the tag of cluster %q from ENI %s", staleCluster, eniID) {
+		return
+	}
+	log.Infof("ENI %s is tagged for cluster %q but %s is not set, removing the tag", eniID, staleCluster, clusterNameEnvVar)
+	input := &ec2.DeleteTagsInput{
+		Resources: []*string{aws.String(eniID)},
+		Tags:      convertTagsToSDKTags(map[string]string{eniClusterTagKey: staleCluster}),
+	}
+	start := time.Now()
+	_, err := cache.ec2SVC.DeleteTagsWithContext(context.Background(), input)
+	awsAPILatency.WithLabelValues("DeleteTags", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
+	if err != nil {
+		awsAPIErrInc("DeleteTags", err)
+		log.Warnf("Failed to remove the tag of cluster %q from ENI %s: %v", staleCluster, eniID, err)
+	}
+}
+
+// containsPrimaryIPInUseError returns whether EC2 rejected an ENI delete because the ENI's primary private IP is still
+// referenced, which EC2 reports as a DependencyViolation
+func containsPrimaryIPInUseError(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == "DependencyViolation"
+	}
+	return false
+}
+
+// containsUnauthorizedOperationError returns whether the call was denied by the IAM policy of the node
+func containsUnauthorizedOperationError(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == "UnauthorizedOperation"
+	}
+	return false
 }
 
 // containsPrivateIPAddressLimitExceededError returns whether exceeds ENI's IP address limit
@@ -838,7 +1552,21 @@ func containsPrivateIPAddressLimitExceededError(err error) bool {
 func awsAPIErrInc(api string, err error) {
 	if aerr, ok := err.(awserr.Error); ok {
 		awsAPIErr.With(prometheus.Labels{"api": api, "error": aerr.Code()}).Inc()
+		if isCredentialRefreshError(aerr) {
+			log.Errorf("%s failed because the AWS credentials could not be refreshed or expired, e.g. IMDS did not serve "+
+				"the instance role credentials in time. This is not a missing IAM permission: %v", api, err)
+		}
+	}
+}
+
+// isCredentialRefreshError returns true if the AWS call failed because its credentials could not be retrieved or had
+// expired, rather than because they lack a permission
+func isCredentialRefreshError(aerr awserr.Error) bool {
+	switch aerr.Code() {
+	case "NoCredentialProviders", "EC2RoleRequestError", "ExpiredToken", "ExpiredTokenException":
+		return true
 	}
+	return false
 }
 
 func awsUtilsErrInc(fn string, err error) {
@@ -851,6 +1579,9 @@ func (cache *EC2InstanceMetadataCache) FreeENI(eniName string) error {
 }
 
 func (cache *EC2InstanceMetadataCache) freeENI(eniName string, sleepDelayAfterDetach time.Duration, maxBackoffDelay time.Duration) error {
+	if cache.skipInAuditMode("FreeENI", "detach and delete ENI %s", eniName) {
+		return ErrAuditMode
+	}
 	log.Infof("Trying to free ENI: %s", eniName)
 
 	// Find out attachment
@@ -871,7 +1602,7 @@ func (cache *EC2InstanceMetadataCache) freeENI(eniName string, sleepDelayAfterDe
 	}
 
 	// Retry detaching the ENI from the instance
-	err = retry.NWithBackoff(retry.NewSimpleBackoff(time.Millisecond*200, maxBackoffDelay, 0.15, 2.0), maxENIEC2APIRetries, func() error {
+	err = retry.NWithBackoff(retry.NewSimpleBackoff(time.Millisecond*200, maxBackoffDelay, 0.15, 2.0), cache.getEC2APIRetries(), cache.ec2RetryBudget.gateRetries("DetachNetworkInterface", func() error {
 		start := time.Now()
 		_, ec2Err := cache.ec2SVC.DetachNetworkInterfaceWithContext(context.Background(), detachInput)
 		awsAPILatency.WithLabelValues("DetachNetworkInterface", fmt.Sprint(ec2Err != nil), awsReqStatus(ec2Err)).Observe(msSince(start))
@@ -882,7 +1613,7 @@ func (cache *EC2InstanceMetadataCache) freeENI(eniName string, sleepDelayAfterDe
 		}
 		log.Infof("Successfully detached ENI: %s", eniName)
 		return nil
-	})
+	}))
 
 	if err != nil {
 		log.Errorf("Failed to detach ENI %s %v", eniName, err)
@@ -937,11 +1668,15 @@ func (cache *EC2InstanceMetadataCache) getENIAttachmentID(eniID string) (*string
 }
 
 func (cache *EC2InstanceMetadataCache) deleteENI(eniName string, maxBackoffDelay time.Duration) error {
+	if cache.skipInAuditMode("deleteENI", "delete ENI %s", eniName) {
+		return ErrAuditMode
+	}
 	log.Debugf("Trying to delete ENI: %s", eniName)
 	deleteInput := &ec2.DeleteNetworkInterfaceInput{
 		NetworkInterfaceId: aws.String(eniName),
 	}
-	err := retry.NWithBackoff(retry.NewSimpleBackoff(time.Millisecond*500, maxBackoffDelay, 0.15, 2.0), maxENIEC2APIRetries, func() error {
+	var errPrimaryInUse error
+	err := retry.NWithBackoff(retry.NewSimpleBackoff(time.Millisecond*500, maxBackoffDelay, 0.15, 2.0), cache.getEC2APIRetries(), cache.ec2RetryBudget.gateRetries("DeleteNetworkInterface", func() error {
 		start := time.Now()
 		_, ec2Err := cache.ec2SVC.DeleteNetworkInterfaceWithContext(context.Background(), deleteInput)
 		awsAPILatency.WithLabelValues("DeleteNetworkInterface", fmt.Sprint(ec2Err != nil), awsReqStatus(ec2Err)).Observe(msSince(start))
@@ -955,14 +1690,77 @@ func (cache *EC2InstanceMetadataCache) deleteENI(eniName string, maxBackoffDelay
 			}
 			awsAPIErrInc("DeleteNetworkInterface", ec2Err)
 			log.Debugf("Not able to delete ENI: %v ", ec2Err)
+			if containsPrimaryIPInUseError(ec2Err) {
+				if cache.primaryIPReleaseTimeout <= 0 {
+					errPrimaryInUse = errors.Wrapf(ErrENIPrimaryInUse, "unable to delete ENI %s: %v", eniName, ec2Err)
+					return retry.NewRetriableError(retry.NewRetriable(false), errPrimaryInUse)
+				}
+				if waitErr := cache.waitForPrimaryIPRelease(eniName); waitErr != nil {
+					errPrimaryInUse = errors.Wrapf(ErrENIPrimaryInUse, "unable to delete ENI %s: %v", eniName, waitErr)
+					// Deleting again would fail the same way, stop retrying
+					return retry.NewRetriableError(retry.NewRetriable(false), errPrimaryInUse)
+				}
+				log.Infof("The primary IP of ENI %s has been released, retrying the delete", eniName)
+			}
 			return errors.Wrapf(ec2Err, "unable to delete ENI")
 		}
 		log.Infof("Successfully deleted ENI: %s", eniName)
 		return nil
-	})
+	}))
+	if errPrimaryInUse != nil {
+		return errPrimaryInUse
+	}
 	return err
 }
 
+// waitForPrimaryIPRelease polls the ENI until its primary private IP has no association left, for at most the
+// configured release timeout
+func (cache *EC2InstanceMetadataCache) waitForPrimaryIPRelease(eniID string) error {
+	pollInterval := cache.primaryIPReleasePollInterval
+	if pollInterval <= 0 {
+		pollInterval = primaryIPReleasePollInterval
+	}
+	deadline := time.Now().Add(cache.primaryIPReleaseTimeout)
+	for {
+		released, err := cache.isPrimaryIPReleased(eniID)
+		if err != nil {
+			log.Warnf("Failed to check the primary IP of ENI %s: %v", eniID, err)
+		} else if released {
+			return nil
+		}
+		if !time.Now().Add(pollInterval).Before(deadline) {
+			return errors.Errorf("primary IP still in use after %v", cache.primaryIPReleaseTimeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// isPrimaryIPReleased returns true once the primary private IP of the ENI is not associated anymore
+func (cache *EC2InstanceMetadataCache) isPrimaryIPReleased(eniID string) (bool, error) {
+	input := &ec2.DescribeNetworkInterfacesInput{NetworkInterfaceIds: []*string{aws.String(eniID)}}
+
+	start := time.Now()
+	result, err := cache.ec2SVC.DescribeNetworkInterfacesWithContext(context.Background(), input)
+	awsAPILatency.WithLabelValues("DescribeNetworkInterfaces", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidNetworkInterfaceID.NotFound" {
+			return true, nil
+		}
+		awsAPIErrInc("DescribeNetworkInterfaces", err)
+		return false, errors.Wrap(err, "failed to describe network interface")
+	}
+	for _, eni := range result.NetworkInterfaces {
+		for _, addr := range eni.PrivateIpAddresses {
+			if aws.BoolValue(addr.Primary) && addr.Association != nil {
+				log.Debugf("Primary IP %s of ENI %s is still associated with %s", aws.StringValue(addr.PrivateIpAddress),
+					eniID, aws.StringValue(addr.Association.PublicIp))
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
 // GetIPv4sFromEC2 calls EC2 and returns a list of all addresses on the ENI
 func (cache *EC2InstanceMetadataCache) GetIPv4sFromEC2(eniID string) (addrList []*ec2.NetworkInterfacePrivateIpAddress, err error) {
 	eniIds := make([]*string, 0)
@@ -1038,10 +1836,12 @@ func (cache *EC2InstanceMetadataCache) DescribeAllENIs() (DescribeAllENIsResult,
 
 	var ec2Response *ec2.DescribeNetworkInterfacesOutput
 	// Try calling EC2 to describe the interfaces.
-	for retryCount := 0; retryCount < maxENIEC2APIRetries && len(eniIDs) > 0; retryCount++ {
+	for retryCount := 0; retryCount < cache.getEC2APIRetries() && len(eniIDs) > 0; retryCount++ {
+		// MaxResults can't be combined with NetworkInterfaceIds, but EC2 may still split the response,
+		// so follow every NextToken to avoid dropping ENIs.
 		input := &ec2.DescribeNetworkInterfacesInput{NetworkInterfaceIds: aws.StringSlice(eniIDs)}
 		start := time.Now()
-		ec2Response, err = cache.ec2SVC.DescribeNetworkInterfacesWithContext(context.Background(), input)
+		ec2Response, err = cache.describeAllNetworkInterfacePages(input)
 		awsAPILatency.WithLabelValues("DescribeNetworkInterfaces", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
 		if err == nil {
 			// No error, exit the loop
@@ -1075,12 +1875,6 @@ func (cache *EC2InstanceMetadataCache) DescribeAllENIs() (DescribeAllENIsResult,
 		return DescribeAllENIsResult{}, err
 	}
 
-	// Collect the verified ENIs
-	var verifiedENIs []ENIMetadata
-	for _, eniMetadata := range eniMap {
-		verifiedENIs = append(verifiedENIs, eniMetadata)
-	}
-
 	// Collect ENI response into ENI metadata and tags.
 	var trunkENI string
 	var multiCardENIIDs []string
@@ -1092,11 +1886,17 @@ func (cache *EC2InstanceMetadataCache) DescribeAllENIs() (DescribeAllENIsResult,
 			log.Warn("Primary ENI will not get deleted when node terminates because 'delete_on_termination' is set to false")
 		}
 		eniID := aws.StringValue(ec2res.NetworkInterfaceId)
-		if aws.Int64Value(ec2res.Attachment.NetworkCardIndex) > 0 {
+		// The ENIs the CNI spread across the network cards are managed wherever they are, other ones only on card 0
+		spreadENI := cache.enableNetworkCardSpread && strings.HasPrefix(aws.StringValue(ec2res.Description), eniDescriptionPrefix)
+		if aws.Int64Value(ec2res.Attachment.NetworkCardIndex) > 0 && !spreadENI {
 			multiCardENIIDs = append(multiCardENIIDs, eniID)
 		}
 
 		eniMetadata := eniMap[eniID]
+		if ec2res.Attachment != nil {
+			eniMetadata.AttachTime = aws.TimeValue(ec2res.Attachment.AttachTime)
+			eniMap[eniID] = eniMetadata
+		}
 		interfaceType := aws.StringValue(ec2res.InterfaceType)
 
 		log.Infof("%s is of type: %s", eniID, interfaceType)
@@ -1112,6 +1912,12 @@ func (cache *EC2InstanceMetadataCache) DescribeAllENIs() (DescribeAllENIsResult,
 		logOutOfSyncState(eniID, eniMetadata.IPv4Addresses, ec2res.PrivateIpAddresses)
 		tagMap[eniMetadata.ENIID] = convertSDKTagsToTags(ec2res.TagSet)
 	}
+
+	// Collect the verified ENIs
+	var verifiedENIs []ENIMetadata
+	for _, eniMetadata := range eniMap {
+		verifiedENIs = append(verifiedENIs, eniMetadata)
+	}
 	return DescribeAllENIsResult{
 		ENIMetadata:     verifiedENIs,
 		TagMap:          tagMap,
@@ -1176,6 +1982,209 @@ func loadAdditionalENITags() map[string]string {
 	return additionalENITags
 }
 
+// loadENICleanupConcurrency returns the number of leaked ENIs that may be deleted in parallel
+func loadENICleanupConcurrency() int {
+	inputStr, found := os.LookupEnv(eniCleanupConcurrencyEnvVar)
+	if !found {
+		return defaultENICleanupConcurrency
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		log.Debugf("Using ENI_CLEANUP_CONCURRENCY %v", input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, using default %d", eniCleanupConcurrencyEnvVar, inputStr, defaultENICleanupConcurrency)
+	return defaultENICleanupConcurrency
+}
+
+// loadENICleanupDeletesPerSecond returns the number of leaked ENI deletes that may be started per second
+func loadENICleanupDeletesPerSecond() int {
+	inputStr, found := os.LookupEnv(eniCleanupDeletesPerSecondEnvVar)
+	if !found {
+		return defaultENICleanupDeletesPerSecond
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		log.Debugf("Using %s %v", eniCleanupDeletesPerSecondEnvVar, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, using default %d", eniCleanupDeletesPerSecondEnvVar, inputStr,
+		defaultENICleanupDeletesPerSecond)
+	return defaultENICleanupDeletesPerSecond
+}
+
+// loadEnableBranchENICleanup returns true if leaked branch ENIs should be cleaned up as well
+func loadEnableBranchENICleanup() bool {
+	if strValue := os.Getenv(branchENICleanupEnvVar); strValue != "" {
+		enabled, err := strconv.ParseBool(strValue)
+		if err == nil {
+			return enabled
+		}
+		log.Warnf("Failed to parse %s; using default: false, err: %v", branchENICleanupEnvVar, err)
+	}
+	return false
+}
+
+// loadEnableSGDriftCorrection returns whether the security groups of drifted ENIs are corrected
+func loadEnableSGDriftCorrection() bool {
+	if strValue := os.Getenv(sgDriftCorrectionEnvVar); strValue != "" {
+		enabled, err := strconv.ParseBool(strValue)
+		if err == nil {
+			return enabled
+		}
+		log.Warnf("Failed to parse %s; using default: false, err: %v", sgDriftCorrectionEnvVar, err)
+	}
+	return false
+}
+
+// loadEnableNetworkCardSpread returns whether ENIs are attached across all the network cards
+func loadEnableNetworkCardSpread() bool {
+	if strValue := os.Getenv(networkCardSpreadEnvVar); strValue != "" {
+		enabled, err := strconv.ParseBool(strValue)
+		if err == nil {
+			return enabled
+		}
+		log.Warnf("Failed to parse %s; using default: false, err: %v", networkCardSpreadEnvVar, err)
+	}
+	return false
+}
+
+// loadAuditMode returns whether the changes to AWS resources are only logged
+func loadAuditMode() bool {
+	if strValue := os.Getenv(auditModeEnvVar); strValue != "" {
+		enabled, err := strconv.ParseBool(strValue)
+		if err == nil {
+			return enabled
+		}
+		log.Warnf("Failed to parse %s; using default: false, err: %v", auditModeEnvVar, err)
+	}
+	return false
+}
+
+// IsAuditMode returns true when the changes to AWS resources are only logged
+func (cache *EC2InstanceMetadataCache) IsAuditMode() bool {
+	return cache.auditMode
+}
+
+// skipInAuditMode returns true when the audit mode is on, after logging what fn would have done
+func (cache *EC2InstanceMetadataCache) skipInAuditMode(fn string, format string, args ...interface{}) bool {
+	if !cache.auditMode {
+		return false
+	}
+	RecordAuditModeSkip(fn, format, args...)
+	return true
+}
+
+// RecordAuditModeSkip logs and counts a change to AWS resources that fn skipped because of the audit mode. ipamd
+// uses it for the changes it skips before reaching the API calls.
+func RecordAuditModeSkip(fn string, format string, args ...interface{}) {
+	log.Infof("Audit mode, skipping %s: would %s", fn, fmt.Sprintf(format, args...))
+	auditModeSkippedCalls.WithLabelValues(fn).Inc()
+}
+
+// loadDescribeENIPageSize returns the page size to use for filtered DescribeNetworkInterfaces calls
+func loadDescribeENIPageSize() int64 {
+	inputStr, found := os.LookupEnv(describeENIPageSizeEnvVar)
+	if !found {
+		return defaultDescribeENIPageSize
+	}
+	if input, err := strconv.ParseInt(inputStr, 10, 64); err == nil && input >= minDescribeENIPageSize && input <= maxDescribeENIPageSize {
+		log.Debugf("Using DESCRIBE_ENI_PAGE_SIZE %v", input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, must be between %d and %d, using default %d", describeENIPageSizeEnvVar, inputStr,
+		minDescribeENIPageSize, maxDescribeENIPageSize, defaultDescribeENIPageSize)
+	return defaultDescribeENIPageSize
+}
+
+// getDescribeENIPageSize returns the configured DescribeNetworkInterfaces page size, or the default if none was loaded
+func (cache *EC2InstanceMetadataCache) getDescribeENIPageSize() int64 {
+	if cache.describeENIPageSize <= 0 {
+		return defaultDescribeENIPageSize
+	}
+	return cache.describeENIPageSize
+}
+
+func loadDeviceIndexBase() int {
+	inputStr, found := os.LookupEnv(deviceIndexBaseEnvVar)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && input < maxENIs {
+		log.Debugf("Using ENI_DEVICE_INDEX_BASE %v", input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, must be between 0 and %d, attaching ENIs at any free device index",
+		deviceIndexBaseEnvVar, inputStr, maxENIs-1)
+	return 0
+}
+
+// validateDeviceIndexBase checks that the instance type has device indices at or above the configured base, otherwise
+// no ENI could ever be attached
+func (cache *EC2InstanceMetadataCache) validateDeviceIndexBase() error {
+	if cache.deviceIndexBase == 0 {
+		return nil
+	}
+	eniLimit, err := cache.getInstanceENILimit()
+	if err != nil {
+		return err
+	}
+	if cache.deviceIndexBase >= eniLimit {
+		return errors.Errorf("%s %d is above the highest device index %d of instance type %s",
+			deviceIndexBaseEnvVar, cache.deviceIndexBase, eniLimit-1, cache.instanceType)
+	}
+	log.Infof("Attaching ENIs at device index %d or above", cache.deviceIndexBase)
+	return nil
+}
+
+func loadLeakedENIGracePeriod() time.Duration {
+	inputStr, found := os.LookupEnv(leakedENIGracePeriodEnvVar)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && time.Duration(input)*time.Second <= maxLeakedENIGracePeriod {
+		log.Debugf("Using LEAKED_ENI_GRACE_PERIOD_SECONDS %v", input)
+		return time.Duration(input) * time.Second
+	}
+	log.Warnf("Invalid %s value %q, must be between 0 and %d, not using a grace period", leakedENIGracePeriodEnvVar,
+		inputStr, int(maxLeakedENIGracePeriod.Seconds()))
+	return 0
+}
+
+func loadPrimaryIPReleaseTimeout() time.Duration {
+	inputStr, found := os.LookupEnv(primaryIPReleaseTimeoutEnvVar)
+	if !found {
+		return defaultPrimaryIPReleaseTimeout
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && time.Duration(input)*time.Second <= maxPrimaryIPReleaseTimeout {
+		log.Debugf("Using ENI_PRIMARY_IP_RELEASE_TIMEOUT_SECONDS %v", input)
+		return time.Duration(input) * time.Second
+	}
+	log.Warnf("Invalid %s value %q, must be between 0 and %d, using default %v", primaryIPReleaseTimeoutEnvVar, inputStr,
+		int(maxPrimaryIPReleaseTimeout.Seconds()), defaultPrimaryIPReleaseTimeout)
+	return defaultPrimaryIPReleaseTimeout
+}
+
+func loadEC2APIRetries() int {
+	inputStr, found := os.LookupEnv(ec2APIRetriesEnvVar)
+	if !found {
+		return maxENIEC2APIRetries
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= minEC2APIRetries && input <= maxEC2APIRetries {
+		log.Debugf("Using EC2_API_RETRIES %v", input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, must be between %d and %d, using default %d", ec2APIRetriesEnvVar, inputStr,
+		minEC2APIRetries, maxEC2APIRetries, maxENIEC2APIRetries)
+	return maxENIEC2APIRetries
+}
+
+// getEC2APIRetries returns the configured number of attempts of the EC2 retry loops, or the default if none was loaded
+func (cache *EC2InstanceMetadataCache) getEC2APIRetries() int {
+	if cache.ec2APIRetries <= 0 {
+		return maxENIEC2APIRetries
+	}
+	return cache.ec2APIRetries
+}
+
 var eniErrorMessageRegex = regexp.MustCompile("'([a-zA-Z0-9-]+)'")
 
 func badENIID(errMsg string) string {
@@ -1224,6 +2233,9 @@ func logOutOfSyncState(eniID string, imdsIPv4s, ec2IPv4s []*ec2.NetworkInterface
 
 // AllocIPAddress allocates an IP address for an ENI
 func (cache *EC2InstanceMetadataCache) AllocIPAddress(eniID string) error {
+	if cache.skipInAuditMode("AllocIPAddress", "assign an IP address to ENI %s", eniID) {
+		return ErrAuditMode
+	}
 	log.Infof("Trying to allocate an IP address on ENI: %s", eniID)
 
 	input := &ec2.AssignPrivateIpAddressesInput{
@@ -1255,8 +2267,32 @@ func (cache *EC2InstanceMetadataCache) GetENIIPv4Limit() (int, error) {
 	return eniLimits.IPv4Limit - 1, nil
 }
 
-// GetENILimit returns the number of ENIs can be attached to an instance
+// GetENILimit returns the number of ENIs the CNI can have attached to the instance: its primary ENI and the ENIs at
+// device indices at or above the base, which applies to every network card ENIs are spread across
 func (cache *EC2InstanceMetadataCache) GetENILimit() (int, error) {
+	eniLimit, err := cache.getInstanceENILimit()
+	if err != nil || cache.deviceIndexBase == 0 {
+		return eniLimit, err
+	}
+	limits, err := cache.getNetworkCardENILimits()
+	if err != nil {
+		return 0, err
+	}
+	if len(limits) < 2 {
+		limits = []int{eniLimit}
+	}
+	// The primary ENI is at device index 0 of card 0, below the base
+	usable := 1
+	for _, limit := range limits {
+		if limit > cache.deviceIndexBase {
+			usable += limit - cache.deviceIndexBase
+		}
+	}
+	return usable, nil
+}
+
+// getInstanceENILimit returns the number of ENIs the instance type takes, whatever the device index base
+func (cache *EC2InstanceMetadataCache) getInstanceENILimit() (int, error) {
 	eniLimits, ok := InstanceNetworkingLimits[cache.instanceType]
 	if !ok {
 		// Fetch from EC2 API
@@ -1300,6 +2336,28 @@ func (cache *EC2InstanceMetadataCache) GetInstanceType() string {
 	return cache.instanceType
 }
 
+// GetNetworkPerformance returns the network performance of the EC2 instance type. It does not change for the life of the
+// instance, so it is fetched from the EC2 API once and cached.
+func (cache *EC2InstanceMetadataCache) GetNetworkPerformance() (string, error) {
+	cache.networkPerformanceLock.Lock()
+	defer cache.networkPerformanceLock.Unlock()
+
+	if cache.networkPerformance != "" {
+		return cache.networkPerformance, nil
+	}
+	input := &ec2.DescribeInstanceTypesInput{InstanceTypes: []*string{aws.String(cache.instanceType)}}
+	output, err := cache.ec2SVC.DescribeInstanceTypesWithContext(context.Background(), input)
+	if err != nil {
+		awsAPIErrInc("DescribeInstanceTypes", err)
+		return "", errors.Wrapf(err, "failed to describe instance type %s", cache.instanceType)
+	}
+	if len(output.InstanceTypes) != 1 || output.InstanceTypes[0].NetworkInfo == nil {
+		return "", errors.Errorf("no network info found for instance type %s", cache.instanceType)
+	}
+	cache.networkPerformance = aws.StringValue(output.InstanceTypes[0].NetworkInfo.NetworkPerformance)
+	return cache.networkPerformance, nil
+}
+
 // AllocIPAddresses allocates numIPs of IP address on an ENI
 func (cache *EC2InstanceMetadataCache) AllocIPAddresses(eniID string, numIPs int) error {
 	var needIPs = numIPs
@@ -1319,6 +2377,13 @@ func (cache *EC2InstanceMetadataCache) AllocIPAddresses(eniID string, numIPs int
 		return nil
 	}
 
+	kind := "IP addresses"
+	if cache.enableIpv4PrefixDelegation {
+		kind = "prefixes"
+	}
+	if cache.skipInAuditMode("AllocIPAddresses", "assign %d %s to ENI %s", needIPs, kind, eniID) {
+		return ErrAuditMode
+	}
 	log.Infof("Trying to allocate %d IP addresses on ENI %s", needIPs, eniID)
 	log.Debugf("PD enabled - %t", cache.enableIpv4PrefixDelegation)
 	input := &ec2.AssignPrivateIpAddressesInput{}
@@ -1369,11 +2434,11 @@ func (cache *EC2InstanceMetadataCache) waitForENIAndIPsAttached(eni string, want
 	start := time.Now()
 	attempt := 0
 	// Wait until the ENI shows up in the instance metadata service and has at least some secondary IPs
-	err = retry.NWithBackoff(retry.NewSimpleBackoff(time.Millisecond*100, maxBackoffDelay, 0.15, 2.0), maxENIEC2APIRetries, func() error {
+	err = retry.NWithBackoff(retry.NewSimpleBackoff(time.Millisecond*100, maxBackoffDelay, 0.15, 2.0), cache.getEC2APIRetries(), func() error {
 		attempt++
 		enis, err := cache.GetAttachedENIs()
 		if err != nil {
-			log.Warnf("Failed to increase pool, error trying to discover attached ENIs on attempt %d/%d: %v ", attempt, maxENIEC2APIRetries, err)
+			log.Warnf("Failed to increase pool, error trying to discover attached ENIs on attempt %d/%d: %v ", attempt, cache.getEC2APIRetries(), err)
 			return ErrNoNetworkInterfaces
 		}
 		// Verify that the ENI we are waiting for is in the returned list
@@ -1403,7 +2468,7 @@ func (cache *EC2InstanceMetadataCache) waitForENIAndIPsAttached(eni string, want
 				return ErrAllSecondaryIPsNotFound
 			}
 		}
-		log.Debugf("Not able to find the right ENI yet (attempt %d/%d)", attempt, maxENIEC2APIRetries)
+		log.Debugf("Not able to find the right ENI yet (attempt %d/%d)", attempt, cache.getEC2APIRetries())
 		return ErrENINotFound
 	})
 	awsAPILatency.WithLabelValues("waitForENIAndIPsAttached", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
@@ -1431,6 +2496,9 @@ func (cache *EC2InstanceMetadataCache) DeallocIPAddresses(eniID string, ips []st
 	if len(ips) == 0 {
 		return nil
 	}
+	if cache.skipInAuditMode("DeallocIPAddresses", "unassign IP addresses %v from ENI %s", ips, eniID) {
+		return ErrAuditMode
+	}
 	log.Infof("Trying to unassign the following IPs %v from ENI %s", ips, eniID)
 	ipsInput := aws.StringSlice(ips)
 
@@ -1456,6 +2524,9 @@ func (cache *EC2InstanceMetadataCache) DeallocPrefixAddresses(eniID string, pref
 	if len(prefixes) == 0 {
 		return nil
 	}
+	if cache.skipInAuditMode("DeallocPrefixAddresses", "unassign prefixes %v from ENI %s", prefixes, eniID) {
+		return ErrAuditMode
+	}
 	log.Infof("Trying to unassign the following Prefixes %v from ENI %s", prefixes, eniID)
 	prefixesInput := aws.StringSlice(prefixes)
 
@@ -1486,34 +2557,101 @@ func (cache *EC2InstanceMetadataCache) cleanUpLeakedENIsInternal(startupDelay ti
 	time.Sleep(startupDelay)
 
 	log.Debug("Checking for leaked AWS CNI ENIs.")
-	networkInterfaces, err := cache.getLeakedENIs()
+	networkInterfaces, pending, err := cache.findLeakedENIs()
 	if err != nil {
 		log.Warnf("Unable to get leaked ENIs: %v", err)
-	} else {
-		// Clean up all the leaked ones we found
-		for _, networkInterface := range networkInterfaces {
+		return
+	}
+	if cache.enableBranchENICleanup {
+		branchENIs, err := cache.getLeakedBranchENIs()
+		if err != nil {
+			log.Warnf("Unable to get leaked branch ENIs: %v", err)
+		}
+		networkInterfaces = append(networkInterfaces, branchENIs...)
+	}
+	found := len(networkInterfaces) + len(pending)
+	// The ENIs still pending or failing to delete are found again by the next runs
+	seen := make(map[string]bool, found)
+	for _, enis := range [][]*ec2.NetworkInterface{networkInterfaces, pending} {
+		for _, networkInterface := range enis {
 			eniID := aws.StringValue(networkInterface.NetworkInterfaceId)
-			err = cache.deleteENI(eniID, maxENIBackoffDelay)
+			if !cache.leakedENIsSeen[eniID] {
+				leakedENIsFound.Inc()
+			}
+			seen[eniID] = true
+		}
+	}
+	cache.leakedENIsSeen = seen
+	var deleted int32
+
+	concurrency := cache.eniCleanupConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// Leaked ENIs are already detached (status "available"), e.g. when ipamd died between the detach and the
+	// delete in freeENI, so they are deleted directly without going through the detach step.
+	// Clean up all the leaked ones we found, at most concurrency at a time and at most one start per
+	// eniCleanupDeleteInterval, so a large backlog does not exhaust the account's DeleteNetworkInterface rate
+	sem := make(chan struct{}, concurrency)
+	var pace <-chan time.Time
+	if cache.eniCleanupDeleteInterval > 0 && len(networkInterfaces) > 1 {
+		ticker := time.NewTicker(cache.eniCleanupDeleteInterval)
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+	var wg sync.WaitGroup
+	for i, networkInterface := range networkInterfaces {
+		networkInterface := networkInterface
+		eniID := aws.StringValue(networkInterface.NetworkInterfaceId)
+		if pace != nil && i > 0 {
+			<-pace
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := cache.deleteENI(eniID, maxENIBackoffDelay)
+			if err == ErrAuditMode {
+				return
+			}
 			if err != nil {
 				awsUtilsErrInc("cleanUpLeakedENIDeleteErr", err)
 				log.Warnf("Failed to clean up leaked ENI %s: %v", eniID, err)
 			} else {
 				log.Debugf("Cleaned up leaked CNI ENI %s", eniID)
+				atomic.AddInt32(&deleted, 1)
+				leakedENIsDeleted.Inc()
+				RecordENIRemoval(eniID, ENIRemovalDecommission)
+				cache.eniCleanupHistory.add(newENICleanupRecord(networkInterface, time.Now()))
 			}
-		}
+		}()
 	}
+	wg.Wait()
+	leakedENIsCurrent.Set(float64(found - int(deleted)))
 }
 
 func (cache *EC2InstanceMetadataCache) tagENIcreateTS(eniID string, maxBackoffDelay time.Duration) {
 	// Tag the ENI with "node.k8s.amazonaws.com/createdAt=currentTime"
+	cache.tagENIWithCurrentTime(eniID, eniCreatedAtTagKey, maxBackoffDelay)
+}
+
+// tagENIWithCurrentTime tags the ENI with the current time under the given key
+func (cache *EC2InstanceMetadataCache) tagENIWithCurrentTime(eniID, tagKey string, maxBackoffDelay time.Duration) {
 	tags := []*ec2.Tag{
 		{
-			Key:   aws.String(eniCreatedAtTagKey),
+			Key:   aws.String(tagKey),
 			Value: aws.String(time.Now().Format(time.RFC3339)),
 		},
 	}
 
 	log.Debugf("Tag untagged ENI %s: key=%s, value=%s", eniID, aws.StringValue(tags[0].Key), aws.StringValue(tags[0].Value))
+	if cache.skipInAuditMode("tagENIWithCurrentTime", "tag ENI %s with %s=%s", eniID, aws.StringValue(tags[0].Key),
+		aws.StringValue(tags[0].Value)) {
+		return
+	}
 
 	input := &ec2.CreateTagsInput{
 		Resources: []*string{
@@ -1522,7 +2660,7 @@ func (cache *EC2InstanceMetadataCache) tagENIcreateTS(eniID string, maxBackoffDe
 		Tags: tags,
 	}
 
-	_ = retry.NWithBackoff(retry.NewSimpleBackoff(500*time.Millisecond, maxBackoffDelay, 0.3, 2), 5, func() error {
+	_ = retry.NWithBackoff(retry.NewSimpleBackoff(500*time.Millisecond, maxBackoffDelay, 0.3, 2), 5, cache.ec2RetryBudget.gateRetries("CreateTags", func() error {
 		start := time.Now()
 		_, err := cache.ec2SVC.CreateTagsWithContext(context.Background(), input)
 		awsAPILatency.WithLabelValues("CreateTags", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
@@ -1533,12 +2671,19 @@ func (cache *EC2InstanceMetadataCache) tagENIcreateTS(eniID string, maxBackoffDe
 		}
 		log.Debugf("Successfully tagged ENI: %s", eniID)
 		return nil
-	})
+	}))
 }
 
 // getLeakedENIs calls DescribeNetworkInterfaces to get all available ENIs that were allocated by
 // the AWS CNI plugin, but were not deleted.
 func (cache *EC2InstanceMetadataCache) getLeakedENIs() ([]*ec2.NetworkInterface, error) {
+	networkInterfaces, _, err := cache.findLeakedENIs()
+	return networkInterfaces, err
+}
+
+// findLeakedENIs returns the leaked ENIs that can be deleted, and the ones that are still too recent to be, within the
+// delete cooldown or the leaked ENI grace period.
+func (cache *EC2InstanceMetadataCache) findLeakedENIs() (networkInterfaces, pending []*ec2.NetworkInterface, err error) {
 	leakedENIFilters := []*ec2.Filter{
 		{
 			Name: aws.String("tag-key"),
@@ -1553,63 +2698,203 @@ func (cache *EC2InstanceMetadataCache) getLeakedENIs() ([]*ec2.NetworkInterface,
 			},
 		},
 	}
-	if cache.clusterName != "" {
-		leakedENIFilters = append(leakedENIFilters, &ec2.Filter{
-			Name: aws.String(fmt.Sprintf("tag:%s", eniClusterTagKey)),
-			Values: []*string{
-				aws.String(cache.clusterName),
-			},
-		})
-	}
+	// The ENIs of other clusters and the ones without the cluster tag are left to isLeakedENICandidate and the partial
+	// tag policy, a filter on the cluster tag would hide the partially tagged ones
+	leakedENIFilters = append(leakedENIFilters, cache.vpcFilters()...)
 
 	input := &ec2.DescribeNetworkInterfacesInput{
 		Filters:    leakedENIFilters,
-		MaxResults: aws.Int64(describeENIPageSize),
+		MaxResults: aws.Int64(cache.getDescribeENIPageSize()),
 	}
 
-	var networkInterfaces []*ec2.NetworkInterface
 	filterFn := func(networkInterface *ec2.NetworkInterface) error {
-		// Verify the description starts with "aws-K8S-"
-		if !strings.HasPrefix(aws.StringValue(networkInterface.Description), eniDescriptionPrefix) {
+		// Verify the description starts with "aws-K8S-" and the CNI tags are set
+		if !cache.isLeakedENICandidate(networkInterface) {
 			return nil
 		}
-		// Check that it's not a newly created ENI
-		tags := convertSDKTagsToTags(networkInterface.TagSet)
-
-		if value, ok := tags[eniCreatedAtTagKey]; ok {
-			parsedTime, err := time.Parse(time.RFC3339, value)
-			if err != nil {
-				log.Warnf("ParsedTime format %s is wrong so retagging with current TS", parsedTime)
-				cache.tagENIcreateTS(aws.StringValue(networkInterface.NetworkInterfaceId), maxENIBackoffDelay)
-			}
-			if time.Since(parsedTime) < eniDeleteCooldownTime {
-				log.Infof("Found an ENI created less than 5 minutes ago, so not cleaning it up")
-				return nil
-			}
-			log.Debugf("%v", value)
-		} else {
-			/* Set a time if we didn't find one. This is to prevent accidentally deleting ENIs that are in the
-			 * process of being attached by CNI versions v1.5.x or earlier.
-			 */
-			cache.tagENIcreateTS(aws.StringValue(networkInterface.NetworkInterfaceId), maxENIBackoffDelay)
+		// Check that it's not a newly created ENI, nor the ENI of an instance that was just terminated
+		if !cache.isENIPastDeleteCooldown(networkInterface) || !cache.isENIPastLeakGracePeriod(networkInterface) {
+			pending = append(pending, networkInterface)
 			return nil
 		}
 		networkInterfaces = append(networkInterfaces, networkInterface)
 		return nil
 	}
 
-	err := cache.getENIsFromPaginatedDescribeNetworkInterfaces(input, filterFn)
+	err = cache.getENIsFromPaginatedDescribeNetworkInterfaces(input, filterFn)
 
 	if err != nil {
-		return nil, errors.Wrap(err, "awsutils: unable to obtain filtered list of network interfaces")
+		return nil, nil, errors.Wrap(err, "awsutils: unable to obtain filtered list of network interfaces")
 	}
 
 	if len(networkInterfaces) < 1 {
 		log.Debug("No AWS CNI leaked ENIs found.")
-		return nil, nil
+		return nil, pending, nil
 	}
 
 	log.Debugf("Found %d leaked ENIs with the AWS CNI tag.", len(networkInterfaces))
+	return networkInterfaces, pending, nil
+}
+
+// isENIPastLeakGracePeriod returns true if the available ENI of another instance was first seen available more than the
+// leaked ENI grace period ago. The first time such an ENI is seen it gets tagged with the current time and is not
+// considered for deletion yet. ENIs of this instance are not subject to the grace period.
+func (cache *EC2InstanceMetadataCache) isENIPastLeakGracePeriod(networkInterface *ec2.NetworkInterface) bool {
+	if cache.leakedENIGracePeriod <= 0 {
+		return true
+	}
+	tags := convertSDKTagsToTags(networkInterface.TagSet)
+	if tags[eniNodeTagKey] == cache.instanceID {
+		return true
+	}
+	eniID := aws.StringValue(networkInterface.NetworkInterfaceId)
+	if value, ok := tags[eniAvailableAtTagKey]; ok {
+		availableAt, err := time.Parse(time.RFC3339, value)
+		if err == nil {
+			if time.Since(availableAt) < cache.leakedENIGracePeriod {
+				log.Infof("ENI %s of instance %s has been available for less than %v, not cleaning it up yet",
+					eniID, tags[eniNodeTagKey], cache.leakedENIGracePeriod)
+				return false
+			}
+			return true
+		}
+		log.Warnf("Invalid %s tag %q on ENI %s, retagging it with the current time", eniAvailableAtTagKey, value, eniID)
+	}
+	cache.tagENIWithCurrentTime(eniID, eniAvailableAtTagKey, maxENIBackoffDelay)
+	return false
+}
+
+// isENIPastDeleteCooldown returns true if the ENI was tagged as created more than eniDeleteCooldownTime ago.
+// ENIs without a valid creation time tag get tagged with the current time and are not considered for deletion yet.
+func (cache *EC2InstanceMetadataCache) isENIPastDeleteCooldown(networkInterface *ec2.NetworkInterface) bool {
+	tags := convertSDKTagsToTags(networkInterface.TagSet)
+
+	if value, ok := tags[eniCreatedAtTagKey]; ok {
+		parsedTime, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Warnf("ParsedTime format %s is wrong so retagging with current TS", parsedTime)
+			cache.tagENIcreateTS(aws.StringValue(networkInterface.NetworkInterfaceId), maxENIBackoffDelay)
+		}
+		if time.Since(parsedTime) < eniDeleteCooldownTime {
+			log.Infof("Found an ENI created less than 5 minutes ago, so not cleaning it up")
+			return false
+		}
+		log.Debugf("%v", value)
+		return true
+	}
+	/* Set a time if we didn't find one. This is to prevent accidentally deleting ENIs that are in the
+	 * process of being attached by CNI versions v1.5.x or earlier.
+	 */
+	cache.tagENIcreateTS(aws.StringValue(networkInterface.NetworkInterfaceId), maxENIBackoffDelay)
+	return false
+}
+
+// vpcFilters returns the filter keeping the ENIs of the instance's VPC, none if it is unknown
+func (cache *EC2InstanceMetadataCache) vpcFilters() []*ec2.Filter {
+	if cache.vpcID == "" {
+		return nil
+	}
+	return []*ec2.Filter{{Name: aws.String("vpc-id"), Values: []*string{aws.String(cache.vpcID)}}}
+}
+
+// isOfClusterVPC returns true if the ENI is tagged with the cluster's name and in the instance's VPC, if known
+func (cache *EC2InstanceMetadataCache) isOfClusterVPC(networkInterface *ec2.NetworkInterface) bool {
+	if convertSDKTagsToTags(networkInterface.TagSet)[eniClusterTagKey] != cache.clusterName {
+		return false
+	}
+	return cache.vpcID == "" || aws.StringValue(networkInterface.VpcId) == cache.vpcID
+}
+
+// getLeakedBranchENIs returns the available branch ENIs whose trunk ENI no longer exists. These leak when a node
+// with security groups for pods is terminated before the VPC resource controller could delete its branch ENIs.
+func (cache *EC2InstanceMetadataCache) getLeakedBranchENIs() ([]*ec2.NetworkInterface, error) {
+	// Branch ENIs carry no instance tag, only the cluster tag tells the ones of this cluster from the others
+	if cache.clusterName == "" {
+		log.Debugf("Not looking for leaked branch ENIs, the cluster name is unknown")
+		return nil, nil
+	}
+	filters := []*ec2.Filter{
+		{
+			Name:   aws.String("interface-type"),
+			Values: []*string{aws.String("branch")},
+		},
+		{
+			Name:   aws.String("tag-key"),
+			Values: []*string{aws.String(trunkENIIDTagKey)},
+		},
+		{
+			Name: aws.String("status"),
+			Values: []*string{
+				aws.String(ec2.NetworkInterfaceStatusAvailable),
+			},
+		},
+		{
+			Name:   aws.String(fmt.Sprintf("tag:%s", eniClusterTagKey)),
+			Values: []*string{aws.String(cache.clusterName)},
+		},
+	}
+	input := &ec2.DescribeNetworkInterfacesInput{
+		Filters:    append(filters, cache.vpcFilters()...),
+		MaxResults: aws.Int64(cache.getDescribeENIPageSize()),
+	}
+
+	var candidates []*ec2.NetworkInterface
+	trunkENIIDs := make(map[string]bool)
+	filterFn := func(networkInterface *ec2.NetworkInterface) error {
+		trunkENIID := convertSDKTagsToTags(networkInterface.TagSet)[trunkENIIDTagKey]
+		if trunkENIID == "" || !cache.isOfClusterVPC(networkInterface) || cache.isExcludedByDescription(networkInterface) {
+			return nil
+		}
+		if !cache.isENIPastDeleteCooldown(networkInterface) {
+			return nil
+		}
+		trunkENIIDs[trunkENIID] = true
+		candidates = append(candidates, networkInterface)
+		return nil
+	}
+	if err := cache.getENIsFromPaginatedDescribeNetworkInterfaces(input, filterFn); err != nil {
+		return nil, errors.Wrap(err, "awsutils: unable to obtain filtered list of branch network interfaces")
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	// Only branch ENIs whose trunk is gone are leaked. The trunks are looked up in batches, a filter takes at most
+	// maxDescribeFilterValues IDs.
+	existingTrunks := make(map[string]bool)
+	trunkIDs := sets.StringKeySet(trunkENIIDs).List()
+	for len(trunkIDs) > 0 {
+		batch := trunkIDs
+		if len(batch) > maxDescribeFilterValues {
+			batch = batch[:maxDescribeFilterValues]
+		}
+		trunkIDs = trunkIDs[len(batch):]
+		trunkInput := &ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("network-interface-id"),
+					Values: aws.StringSlice(batch),
+				},
+			},
+			MaxResults: aws.Int64(cache.getDescribeENIPageSize()),
+		}
+		err := cache.getENIsFromPaginatedDescribeNetworkInterfaces(trunkInput, func(networkInterface *ec2.NetworkInterface) error {
+			existingTrunks[aws.StringValue(networkInterface.NetworkInterfaceId)] = true
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "awsutils: unable to look up trunk network interfaces")
+		}
+	}
+
+	var networkInterfaces []*ec2.NetworkInterface
+	for _, networkInterface := range candidates {
+		if existingTrunks[convertSDKTagsToTags(networkInterface.TagSet)[trunkENIIDTagKey]] {
+			continue
+		}
+		networkInterfaces = append(networkInterfaces, networkInterface)
+	}
+	log.Debugf("Found %d leaked branch ENIs", len(networkInterfaces))
 	return networkInterfaces, nil
 }
 
@@ -1659,6 +2944,20 @@ func (cache *EC2InstanceMetadataCache) IsUnmanagedENI(eniID string) bool {
 	return false
 }
 
+// describeAllNetworkInterfacePages calls DescribeNetworkInterfaces and merges all returned pages into one output
+func (cache *EC2InstanceMetadataCache) describeAllNetworkInterfacePages(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	result := &ec2.DescribeNetworkInterfacesOutput{}
+	err := cache.ec2SVC.DescribeNetworkInterfacesPagesWithContext(context.Background(), input,
+		func(output *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+			result.NetworkInterfaces = append(result.NetworkInterfaces, output.NetworkInterfaces...)
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 func (cache *EC2InstanceMetadataCache) getENIsFromPaginatedDescribeNetworkInterfaces(
 	input *ec2.DescribeNetworkInterfacesInput, filterFn func(networkInterface *ec2.NetworkInterface) error) error {
 	pageNum := 0
@@ -20,12 +20,17 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -42,8 +47,10 @@ const (
 	metadataInstanceType = "instance-type"
 	metadataSGs          = "/security-group-ids"
 	metadataSubnetID     = "/subnet-id"
+	metadataVPCID        = "/vpc-id"
 	metadataVPCcidrs     = "/vpc-ipv4-cidr-blocks"
 	metadataDeviceNum    = "/device-number"
+	metadataNetworkCard  = "/network-card"
 	metadataInterface    = "/interface-id"
 	metadataSubnetCIDR   = "/subnet-ipv4-cidr-block"
 	metadataIPv4s        = "/local-ipv4s"
@@ -59,6 +66,7 @@ const (
 	sg2                  = "sg-2e080f51"
 	sgs                  = sg1 + " " + sg2
 	subnetID             = "subnet-6b245523"
+	vpcID                = "vpc-3c133421"
 	subnetCIDR           = "10.0.1.0/24"
 	primaryeniID         = "eni-00000000"
 	eniID                = primaryeniID
@@ -86,6 +94,7 @@ func testMetadata(overrides map[string]interface{}) FakeIMDS {
 		metadataMACPath + primaryMAC + metadataSGs:        sgs,
 		metadataMACPath + primaryMAC + metadataIPv4s:      eni1PrivateIP,
 		metadataMACPath + primaryMAC + metadataSubnetID:   subnetID,
+		metadataMACPath + primaryMAC + metadataVPCID:      vpcID,
 		metadataMACPath + primaryMAC + metadataSubnetCIDR: subnetCIDR,
 		metadataMACPath + primaryMAC + metadataVPCcidrs:   metadataVPCIPv4CIDRs,
 	}
@@ -111,6 +120,7 @@ func testMetadataWithPrefixes(overrides map[string]interface{}) FakeIMDS {
 		metadataMACPath + primaryMAC + metadataIPv4s:        eni1PrivateIP,
 		metadataMACPath + primaryMAC + metadataIPv4Prefixes: eni1Prefix,
 		metadataMACPath + primaryMAC + metadataSubnetID:     subnetID,
+		metadataMACPath + primaryMAC + metadataVPCID:        vpcID,
 		metadataMACPath + primaryMAC + metadataSubnetCIDR:   subnetCIDR,
 		metadataMACPath + primaryMAC + metadataVPCcidrs:     metadataVPCIPv4CIDRs,
 	}
@@ -146,9 +156,48 @@ func TestInitWithEC2metadata(t *testing.T) {
 		assert.Equal(t, ins.primaryENImac, primaryMAC)
 		assert.Equal(t, ins.primaryENI, primaryeniID)
 		assert.Equal(t, subnetID, ins.subnetID)
+		assert.Equal(t, vpcID, ins.vpcID)
 	}
 }
 
+// slowIMDS answers from FakeIMDS after a delay and records how many requests were in flight at once
+type slowIMDS struct {
+	FakeIMDS
+	delay       time.Duration
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (s *slowIMDS) GetMetadataWithContext(ctx context.Context, p string) (string, error) {
+	n := atomic.AddInt32(&s.inFlight, 1)
+	defer atomic.AddInt32(&s.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&s.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&s.maxInFlight, max, n) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+	return s.FakeIMDS.GetMetadataWithContext(ctx, p)
+}
+
+func TestInitWithEC2metadataConcurrent(t *testing.T) {
+	mockMetadata := &slowIMDS{FakeIMDS: testMetadata(nil), delay: 100 * time.Millisecond}
+
+	ins := &EC2InstanceMetadataCache{imds: TypedIMDS{mockMetadata}}
+	err := ins.initWithEC2Metadata(context.Background())
+	if assert.NoError(t, err) {
+		assert.Equal(t, az, ins.availabilityZone)
+		assert.Equal(t, localIP, ins.localIPv4.String())
+		assert.Equal(t, instanceID, ins.instanceID)
+		assert.Equal(t, instanceType, ins.instanceType)
+		assert.Equal(t, primaryeniID, ins.primaryENI)
+		assert.Equal(t, subnetID, ins.subnetID)
+	}
+	// The AZ, local IP, instance ID, instance type and MAC lookups do not depend on each other
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&mockMetadata.maxInFlight), int32(5))
+}
+
 func TestInitWithEC2metadataErr(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Millisecond)
 	defer cancel()
@@ -208,7 +257,7 @@ func TestGetAttachedENIsWithPrefixes(t *testing.T) {
 	}
 }
 
-func TestAWSGetFreeDeviceNumberOnErr(t *testing.T) {
+func TestAWSGetFreeAttachmentOnErr(t *testing.T) {
 	ctrl, mockEC2 := setup(t)
 	defer ctrl.Finish()
 
@@ -216,11 +265,107 @@ func TestAWSGetFreeDeviceNumberOnErr(t *testing.T) {
 	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("error on DescribeInstancesWithContext"))
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.awsGetFreeDeviceNumber()
+	_, _, err := ins.awsGetFreeAttachment()
 	assert.Error(t, err)
 }
 
-func TestAWSGetFreeDeviceNumberNoDevice(t *testing.T) {
+func TestAWSGetFreeAttachmentWithBase(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	var ec2ENIs []*ec2.InstanceNetworkInterface
+	for _, deviceIndex := range []int64{0, 1, 4} {
+		ec2ENIs = append(ec2ENIs, &ec2.InstanceNetworkInterface{Attachment: &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(deviceIndex)}})
+	}
+	result := &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{NetworkInterfaces: ec2ENIs}}}}}
+	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(result, nil).Times(2)
+
+	// Without a base, the first free index is used
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
+	_, device, err := ins.awsGetFreeAttachment()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, device)
+
+	// Indices below the base are skipped even when free
+	ins.deviceIndexBase = 3
+	_, device, err = ins.awsGetFreeAttachment()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, device)
+}
+
+func Test_loadDeviceIndexBase(t *testing.T) {
+	defer os.Unsetenv(deviceIndexBaseEnvVar)
+
+	os.Unsetenv(deviceIndexBaseEnvVar)
+	assert.Equal(t, 0, loadDeviceIndexBase())
+
+	os.Setenv(deviceIndexBaseEnvVar, "4")
+	assert.Equal(t, 4, loadDeviceIndexBase())
+
+	os.Setenv(deviceIndexBaseEnvVar, "-1")
+	assert.Equal(t, 0, loadDeviceIndexBase())
+
+	os.Setenv(deviceIndexBaseEnvVar, "100")
+	assert.Equal(t, 0, loadDeviceIndexBase())
+
+	os.Setenv(deviceIndexBaseEnvVar, "eth2")
+	assert.Equal(t, 0, loadDeviceIndexBase())
+}
+
+func TestValidateDeviceIndexBase(t *testing.T) {
+	// m5.large supports 3 ENIs, at device indices 0 to 2
+	ins := &EC2InstanceMetadataCache{instanceType: "m5.large"}
+	assert.NoError(t, ins.validateDeviceIndexBase())
+
+	ins.deviceIndexBase = 2
+	assert.NoError(t, ins.validateDeviceIndexBase())
+
+	ins.deviceIndexBase = 3
+	assert.Error(t, ins.validateDeviceIndexBase())
+}
+
+func TestGetENILimitDeviceIndexBase(t *testing.T) {
+	// m5.large supports 3 ENIs, the primary ENI and the one at device index 2 are left above a base of 2
+	ins := &EC2InstanceMetadataCache{instanceType: "m5.large"}
+	limit, err := ins.GetENILimit()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, limit)
+	ins.deviceIndexBase = 2
+	limit, err = ins.GetENILimit()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, limit)
+
+	// The base applies to every network card ENIs are spread across
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+	mockEC2.EXPECT().DescribeInstanceTypesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(&ec2.DescribeInstanceTypesOutput{
+		InstanceTypes: []*ec2.InstanceTypeInfo{{NetworkInfo: &ec2.NetworkInfo{
+			MaximumNetworkCards: aws.Int64(2),
+			NetworkCards: []*ec2.NetworkCardInfo{
+				{NetworkCardIndex: aws.Int64(0), MaximumNetworkInterfaces: aws.Int64(15)},
+				{NetworkCardIndex: aws.Int64(1), MaximumNetworkInterfaces: aws.Int64(15)},
+			},
+		}}},
+	}, nil)
+	ins = &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceType: "p4d.24xlarge", enableNetworkCardSpread: true, deviceIndexBase: 2}
+	limit, err = ins.GetENILimit()
+	assert.NoError(t, err)
+	assert.Equal(t, 1+13+13, limit)
+}
+
+func TestPickNetworkCardDeviceIndexBase(t *testing.T) {
+	eni := func(card, device int64) *ec2.InstanceNetworkInterface {
+		return &ec2.InstanceNetworkInterface{Attachment: &ec2.InstanceNetworkInterfaceAttachment{
+			NetworkCardIndex: aws.Int64(card), DeviceIndex: aws.Int64(device)}}
+	}
+	// Both cards have 3 ENIs, but card 0 has no free index at or above the base left
+	enis := []*ec2.InstanceNetworkInterface{eni(0, 0), eni(0, 2), eni(0, 3), eni(1, 0), eni(1, 1), eni(1, 2)}
+	assert.Equal(t, 0, pickNetworkCard(enis, []int{4, 4}, 0))
+	assert.Equal(t, 1, pickNetworkCard(enis, []int{4, 4}, 2))
+}
+
+func TestAWSGetFreeAttachmentNoDevice(t *testing.T) {
 	ctrl, mockEC2 := setup(t)
 	defer ctrl.Finish()
 
@@ -239,10 +384,130 @@ func TestAWSGetFreeDeviceNumberNoDevice(t *testing.T) {
 	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(result, nil)
 
 	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
-	_, err := ins.awsGetFreeDeviceNumber()
+	_, _, err := ins.awsGetFreeAttachment()
 	assert.Error(t, err)
 }
 
+// attachENIsOnCards attaches count ENIs to an instance whose primary ENI is on network card 0, with the given number of
+// ENIs per card, and returns the network card and device index of each attachment
+func attachENIsOnCards(t *testing.T, cardENILimits []int64, count int) ([]int64, []int64) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	var cardInfos []*ec2.NetworkCardInfo
+	for i, limit := range cardENILimits {
+		cardInfos = append(cardInfos, &ec2.NetworkCardInfo{NetworkCardIndex: aws.Int64(int64(i)), MaximumNetworkInterfaces: aws.Int64(limit)})
+	}
+	mockEC2.EXPECT().DescribeInstanceTypesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(&ec2.DescribeInstanceTypesOutput{
+		InstanceTypes: []*ec2.InstanceTypeInfo{{NetworkInfo: &ec2.NetworkInfo{
+			MaximumNetworkCards: aws.Int64(int64(len(cardENILimits))),
+			NetworkCards:        cardInfos,
+		}}},
+	}, nil)
+
+	attached := []*ec2.InstanceNetworkInterface{{Attachment: &ec2.InstanceNetworkInterfaceAttachment{
+		DeviceIndex: aws.Int64(0), NetworkCardIndex: aws.Int64(0)}}}
+	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(context.Context, *ec2.DescribeInstancesInput, ...interface{}) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{NetworkInterfaces: attached}}}}}, nil
+		}).Times(count)
+	var cards, devices []int64
+	mockEC2.EXPECT().AttachNetworkInterfaceWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, input *ec2.AttachNetworkInterfaceInput, _ ...interface{}) (*ec2.AttachNetworkInterfaceOutput, error) {
+			cards = append(cards, aws.Int64Value(input.NetworkCardIndex))
+			devices = append(devices, aws.Int64Value(input.DeviceIndex))
+			attached = append(attached, &ec2.InstanceNetworkInterface{Attachment: &ec2.InstanceNetworkInterfaceAttachment{
+				DeviceIndex: input.DeviceIndex, NetworkCardIndex: input.NetworkCardIndex}})
+			return &ec2.AttachNetworkInterfaceOutput{AttachmentId: aws.String(eniAttachID)}, nil
+		}).Times(count)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID, enableNetworkCardSpread: true}
+	for i := 0; i < count; i++ {
+		_, err := ins.attachENI(eni2ID)
+		assert.NoError(t, err)
+	}
+	return cards, devices
+}
+
+func TestAttachENISpreadAcrossNetworkCards(t *testing.T) {
+	// Each ENI goes to the card with the fewest, card 0 already has the primary ENI
+	cards, devices := attachENIsOnCards(t, []int64{15, 15, 15, 15}, 7)
+	assert.Equal(t, []int64{1, 2, 3, 0, 1, 2, 3}, cards)
+	assert.Equal(t, []int64{0, 0, 0, 1, 1, 1, 1}, devices)
+
+	// A full card is skipped
+	cards, _ = attachENIsOnCards(t, []int64{4, 1}, 4)
+	assert.Equal(t, []int64{1, 0, 0, 0}, cards)
+}
+
+func TestAttachENISingleNetworkCard(t *testing.T) {
+	cards, devices := attachENIsOnCards(t, []int64{3}, 2)
+	assert.Equal(t, []int64{0, 0}, cards)
+	assert.Equal(t, []int64{1, 2}, devices)
+}
+
+func TestGetENIMetadataUniqueDeviceNumber(t *testing.T) {
+	// The second ENI is at device index 1 of network card 1, after the 15 ENIs of card 0
+	mockMetadata := testMetadata(map[string]interface{}{
+		metadataMACPath: primaryMAC + " " + eni2MAC,
+		metadataMACPath + eni2MAC + metadataDeviceNum:   eni2Device,
+		metadataMACPath + eni2MAC + metadataNetworkCard: "1",
+		metadataMACPath + eni2MAC + metadataInterface:   eni2ID,
+		metadataMACPath + eni2MAC + metadataSubnetCIDR:  subnetCIDR,
+		metadataMACPath + eni2MAC + metadataIPv4s:       eni2PrivateIP,
+	})
+
+	ins := &EC2InstanceMetadataCache{imds: TypedIMDS{mockMetadata}, enableNetworkCardSpread: true,
+		networkCardENILimits: []int{15, 15}}
+	eni, err := ins.getENIMetadata(eni2MAC)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, eni.DeviceNumber)
+
+	// Without spreading, it keeps its device index
+	ins.enableNetworkCardSpread = false
+	eni, err = ins.getENIMetadata(eni2MAC)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, eni.DeviceNumber)
+
+	// The primary ENI reports no network card
+	ins.enableNetworkCardSpread = true
+	eni, err = ins.getENIMetadata(primaryMAC)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, eni.DeviceNumber)
+}
+
+func TestGetExpectedENIs(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID}
+
+	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("error on DescribeInstancesWithContext"))
+	_, err := ins.GetExpectedENIs()
+	assert.Error(t, err)
+
+	attachment := func(status string) *ec2.InstanceNetworkInterfaceAttachment {
+		return &ec2.InstanceNetworkInterfaceAttachment{Status: aws.String(status)}
+	}
+	result := &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			{NetworkInterfaceId: aws.String(primaryeniID), Attachment: attachment(ec2.AttachmentStatusAttached)},
+			{NetworkInterfaceId: aws.String(eniID), Attachment: attachment(ec2.AttachmentStatusAttaching)},
+			{NetworkInterfaceId: aws.String("eni-detaching"), Attachment: attachment(ec2.AttachmentStatusDetaching)},
+		}}}}}}
+	mockEC2.EXPECT().DescribeInstancesWithContext(gomock.Any(), &ec2.DescribeInstancesInput{InstanceIds: []*string{aws.String(instanceID)}}, gomock.Any()).Return(result, nil)
+	eniIDs, err := ins.GetExpectedENIs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{primaryeniID, eniID}, eniIDs)
+}
+
+func TestRecordENIRemoval(t *testing.T) {
+	before := testutil.ToFloat64(eniRemovals.With(prometheus.Labels{"reason": string(ENIRemovalDrain)}))
+	RecordENIRemoval(eniID, ENIRemovalDrain)
+	assert.Equal(t, before+1, testutil.ToFloat64(eniRemovals.With(prometheus.Labels{"reason": string(ENIRemovalDrain)})))
+}
+
 func TestGetENIAttachmentID(t *testing.T) {
 	ctrl, mockEC2 := setup(t)
 	defer ctrl.Finish()
@@ -340,7 +605,16 @@ func TestDescribeAllENIs(t *testing.T) {
 	mockMetadata := testMetadata(nil)
 
 	for _, tc := range testCases {
-		mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Times(tc.n).Return(result, tc.awsErr)
+		awsErr := tc.awsErr
+		mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Times(tc.n).
+			DoAndReturn(func(_ context.Context, _ *ec2.DescribeNetworkInterfacesInput,
+				fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+				if awsErr != nil {
+					return awsErr
+				}
+				fn(result, true)
+				return nil
+			})
 		ins := &EC2InstanceMetadataCache{imds: TypedIMDS{mockMetadata}, ec2SVC: mockEC2}
 		metaData, err := ins.DescribeAllENIs()
 		assert.Equal(t, tc.expErr, err, tc.name)
@@ -348,6 +622,63 @@ func TestDescribeAllENIs(t *testing.T) {
 	}
 }
 
+func TestDescribeAllENIsMultiplePages(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	mockMetadata := testMetadata(map[string]interface{}{
+		metadataMACPath: primaryMAC + " " + eni2MAC,
+		metadataMACPath + eni2MAC + metadataDeviceNum:  eni2Device,
+		metadataMACPath + eni2MAC + metadataInterface:  eni2ID,
+		metadataMACPath + eni2MAC + metadataSubnetCIDR: subnetCIDR,
+		metadataMACPath + eni2MAC + metadataIPv4s:      eni2PrivateIP,
+	})
+	attachTime := time.Unix(1600000000, 0)
+	pages := []*ec2.DescribeNetworkInterfacesOutput{
+		{
+			NetworkInterfaces: []*ec2.NetworkInterface{{
+				NetworkInterfaceId: aws.String(primaryeniID),
+				TagSet:             []*ec2.Tag{{Key: aws.String("foo"), Value: aws.String("foo-value")}},
+				Attachment:         &ec2.NetworkInterfaceAttachment{NetworkCardIndex: aws.Int64(0)},
+			}},
+			NextToken: aws.String("page-2"),
+		},
+		{
+			NetworkInterfaces: []*ec2.NetworkInterface{{
+				NetworkInterfaceId: aws.String(eni2ID),
+				TagSet:             []*ec2.Tag{{Key: aws.String("bar"), Value: aws.String("bar-value")}},
+				Attachment:         &ec2.NetworkInterfaceAttachment{NetworkCardIndex: aws.Int64(0), AttachTime: aws.Time(attachTime)},
+			}},
+		},
+	}
+	mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ *ec2.DescribeNetworkInterfacesInput,
+			fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+			for i, page := range pages {
+				if !fn(page, i == len(pages)-1) {
+					break
+				}
+			}
+			return nil
+		})
+
+	ins := &EC2InstanceMetadataCache{imds: TypedIMDS{mockMetadata}, ec2SVC: mockEC2}
+	metaData, err := ins.DescribeAllENIs()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]TagMap{
+		primaryeniID: {"foo": "foo-value"},
+		eni2ID:       {"bar": "bar-value"},
+	}, metaData.TagMap)
+	// The attach time is taken from EC2
+	for _, eni := range metaData.ENIMetadata {
+		if eni.ENIID == eni2ID {
+			assert.Equal(t, attachTime, eni.AttachTime)
+		} else {
+			assert.True(t, eni.AttachTime.IsZero())
+		}
+	}
+}
+
 func TestAllocENI(t *testing.T) {
 	ctrl, mockEC2 := setup(t)
 	defer ctrl.Finish()
@@ -386,6 +717,137 @@ func TestAllocENI(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCreateENISubnetAZ(t *testing.T) {
+	subnets := map[string]string{
+		"subnet-in-az":    az,
+		"subnet-other-az": "us-east-1b",
+	}
+	throttled := awserr.New("RequestLimitExceeded", "throttled", nil)
+	tests := []struct {
+		name         string
+		useCustomCfg bool
+		subnet       string
+		describeErr  error
+		wantSubnet   string
+		wantErr      error
+	}{
+		{"default config uses the primary ENI subnet", false, "subnet-other-az", nil, subnetID, nil},
+		{"ENIConfig subnet in the instance AZ", true, "subnet-in-az", nil, "subnet-in-az", nil},
+		{"ENIConfig subnet in another AZ", true, "subnet-other-az", nil, "", ErrSubnetAZMismatch},
+		{"DescribeSubnets not authorized skips the check", true, "subnet-other-az",
+			awserr.New("UnauthorizedOperation", "not authorized to perform ec2:DescribeSubnets", nil), "subnet-other-az", nil},
+		{"DescribeSubnets failure fails the create", true, "subnet-in-az", throttled, "", throttled},
+		{"ENIConfig subnet missing from the reply", true, "subnet-unknown", nil, "", ErrSubnetNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl, mockEC2 := setup(t)
+			defer ctrl.Finish()
+
+			if tt.useCustomCfg {
+				mockEC2.EXPECT().DescribeSubnetsWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, input *ec2.DescribeSubnetsInput, _ ...interface{}) (*ec2.DescribeSubnetsOutput, error) {
+						if tt.describeErr != nil {
+							return nil, tt.describeErr
+						}
+						id := aws.StringValue(input.SubnetIds[0])
+						subnetAZ, ok := subnets[id]
+						if !ok {
+							return &ec2.DescribeSubnetsOutput{}, nil
+						}
+						return &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+							{SubnetId: aws.String(id), AvailabilityZone: aws.String(subnetAZ)},
+						}}, nil
+					})
+			}
+			if tt.wantErr == nil {
+				mockEC2.EXPECT().CreateNetworkInterfaceWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, input *ec2.CreateNetworkInterfaceInput, _ ...interface{}) (*ec2.CreateNetworkInterfaceOutput, error) {
+						assert.Equal(t, tt.wantSubnet, aws.StringValue(input.SubnetId))
+						return &ec2.CreateNetworkInterfaceOutput{NetworkInterface: &ec2.NetworkInterface{NetworkInterfaceId: aws.String(eni2ID)}}, nil
+					})
+			}
+
+			ins := &EC2InstanceMetadataCache{
+				ec2SVC:           mockEC2,
+				availabilityZone: az,
+				subnetID:         subnetID,
+				securityGroups:   StringSet{data: sets.NewString(sg1)},
+			}
+			eni, err := ins.createENI(tt.useCustomCfg, nil, tt.subnet)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "unexpected error %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, eni2ID, eni)
+		})
+	}
+}
+
+func TestGetSubnetIPv4CIDR(t *testing.T) {
+	subnets := map[string]*ec2.Subnet{
+		subnetID:          {SubnetId: aws.String(subnetID), VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String(az), CidrBlock: aws.String("10.0.0.0/24")},
+		"subnet-in-az":    {SubnetId: aws.String("subnet-in-az"), VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String(az), CidrBlock: aws.String("10.1.0.0/24")},
+		"subnet-other-az": {SubnetId: aws.String("subnet-other-az"), VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String("us-east-1b"), CidrBlock: aws.String("10.2.0.0/24")},
+		"subnet-peer-vpc": {SubnetId: aws.String("subnet-peer-vpc"), VpcId: aws.String("vpc-2"), AvailabilityZone: aws.String(az), CidrBlock: aws.String("10.3.0.0/24")},
+	}
+	tests := []struct {
+		name        string
+		subnet      string
+		describeErr error
+		wantCIDR    string
+		wantErr     error
+	}{
+		{"subnet in the instance VPC and AZ", "subnet-in-az", nil, "10.1.0.0/24", nil},
+		{"primary ENI subnet", subnetID, nil, "10.0.0.0/24", nil},
+		{"subnet in another AZ", "subnet-other-az", nil, "", ErrSubnetAZMismatch},
+		{"subnet in another VPC", "subnet-peer-vpc", nil, "", ErrSubnetVPCMismatch},
+		{"unknown subnet", "subnet-unknown", awserr.New("InvalidSubnetID.NotFound", "no subnet-unknown", nil), "", ErrSubnetNotFound},
+		{"subnet missing from the reply", "subnet-unknown", nil, "", ErrSubnetNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl, mockEC2 := setup(t)
+			defer ctrl.Finish()
+
+			mockEC2.EXPECT().DescribeSubnetsWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, input *ec2.DescribeSubnetsInput, _ ...interface{}) (*ec2.DescribeSubnetsOutput, error) {
+					if tt.describeErr != nil {
+						return nil, tt.describeErr
+					}
+					output := &ec2.DescribeSubnetsOutput{}
+					for _, id := range input.SubnetIds {
+						if subnet, ok := subnets[aws.StringValue(id)]; ok {
+							output.Subnets = append(output.Subnets, subnet)
+						}
+					}
+					return output, nil
+				})
+
+			ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, availabilityZone: az, subnetID: subnetID}
+			cidr, err := ins.GetSubnetIPv4CIDR(tt.subnet)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "unexpected error %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantCIDR, cidr)
+		})
+	}
+}
+
+func TestGetSubnetIPv4CIDRDescribeErr(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	mockEC2.EXPECT().DescribeSubnetsWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("throttled"))
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, availabilityZone: az, subnetID: subnetID}
+	_, err := ins.GetSubnetIPv4CIDR("subnet-in-az")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrSubnetNotFound))
+}
+
 func TestAllocENINoFreeDevice(t *testing.T) {
 	ctrl, mockEC2 := setup(t)
 	defer ctrl.Finish()
@@ -415,8 +877,12 @@ func TestAllocENINoFreeDevice(t *testing.T) {
 		ec2SVC: mockEC2,
 		imds:   TypedIMDS{mockMetadata},
 	}
+	recovered := eniRemovals.With(prometheus.Labels{"reason": string(ENIRemovalErrorRecovery)})
+	before := testutil.ToFloat64(recovered)
 	_, err := ins.AllocENI(false, nil, "")
 	assert.Error(t, err)
+	// The ENI that could not be attached is cleaned up
+	assert.Equal(t, before+1, testutil.ToFloat64(recovered))
 }
 
 func TestAllocENIMaxReached(t *testing.T) {
@@ -522,6 +988,72 @@ func TestFreeENIDescribeErr(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func Test_containsPrimaryIPInUseError(t *testing.T) {
+	assert.True(t, containsPrimaryIPInUseError(awserr.New("DependencyViolation", "Primary IP is associated with an Elastic IP", nil)))
+	assert.True(t, containsPrimaryIPInUseError(awserr.New("DependencyViolation", "The network interface has dependencies", nil)))
+	// Only the code counts, not the wording of the message
+	assert.False(t, containsPrimaryIPInUseError(awserr.New("InvalidNetworkInterface.InUse", "The primary private IP address is in use", nil)))
+	assert.False(t, containsPrimaryIPInUseError(awserr.New("InvalidNetworkInterface.InUse", "Network interface is currently in use", nil)))
+	assert.False(t, containsPrimaryIPInUseError(awserr.New("UnauthorizedOperation", "primary", nil)))
+	assert.False(t, containsPrimaryIPInUseError(errors.New("primary IP in use")))
+}
+
+func primaryIPDescribeOutput(associated bool) *ec2.DescribeNetworkInterfacesOutput {
+	addr := &ec2.NetworkInterfacePrivateIpAddress{PrivateIpAddress: aws.String(eni2PrivateIP), Primary: aws.Bool(true)}
+	if associated {
+		addr.Association = &ec2.NetworkInterfaceAssociation{PublicIp: aws.String("3.3.3.3")}
+	}
+	return &ec2.DescribeNetworkInterfacesOutput{
+		NetworkInterfaces: []*ec2.NetworkInterface{{PrivateIpAddresses: []*ec2.NetworkInterfacePrivateIpAddress{addr}}}}
+}
+
+func TestDeleteENIPrimaryIPInUse(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	inUseErr := awserr.New("DependencyViolation", "The primary private IP address of the interface is in use", nil)
+	gomock.InOrder(
+		mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, inUseErr),
+		// Still associated on the first poll, released on the second
+		mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(primaryIPDescribeOutput(true), nil),
+		mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(primaryIPDescribeOutput(false), nil),
+		mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil),
+	)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, primaryIPReleaseTimeout: time.Second, primaryIPReleasePollInterval: time.Millisecond}
+	err := ins.deleteENI(eni2ID, time.Millisecond)
+	assert.NoError(t, err)
+}
+
+func TestDeleteENIPrimaryIPInUseNoWait(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	inUseErr := awserr.New("DependencyViolation", "The primary private IP address of the interface is in use", nil)
+	// Without a release timeout the ENI is neither polled nor deleted again
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, inUseErr).Times(1)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
+	err := ins.deleteENI(eni2ID, time.Millisecond)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrENIPrimaryInUse))
+}
+
+func TestDeleteENIPrimaryIPInUseTimeout(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	inUseErr := awserr.New("DependencyViolation", "The primary private IP address of the interface is in use", nil)
+	// The delete is not retried once the wait for the primary IP has timed out
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, inUseErr).Times(1)
+	mockEC2.EXPECT().DescribeNetworkInterfacesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(primaryIPDescribeOutput(true), nil).MinTimes(1)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, primaryIPReleaseTimeout: 10 * time.Millisecond, primaryIPReleasePollInterval: time.Millisecond}
+	err := ins.deleteENI(eni2ID, time.Millisecond)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrENIPrimaryInUse))
+}
+
 func TestDescribeInstanceTypes(t *testing.T) {
 	ctrl, mockEC2 := setup(t)
 	defer ctrl.Finish()
@@ -545,6 +1077,29 @@ func TestDescribeInstanceTypes(t *testing.T) {
 	assert.Equal(t, 98, pv4Limit)
 }
 
+func TestGetNetworkPerformance(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceType: "m5.large"}
+
+	mockEC2.EXPECT().DescribeInstanceTypesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("AccessDenied"))
+	_, err := ins.GetNetworkPerformance()
+	assert.Error(t, err)
+
+	// Only looked up once
+	mockEC2.EXPECT().DescribeInstanceTypesWithContext(gomock.Any(), &ec2.DescribeInstanceTypesInput{InstanceTypes: []*string{aws.String("m5.large")}}, gomock.Any()).Return(&ec2.DescribeInstanceTypesOutput{
+		InstanceTypes: []*ec2.InstanceTypeInfo{
+			{InstanceType: aws.String("m5.large"), NetworkInfo: &ec2.NetworkInfo{NetworkPerformance: aws.String("Up to 10 Gigabit")}},
+		},
+	}, nil)
+	for i := 0; i < 2; i++ {
+		value, err := ins.GetNetworkPerformance()
+		assert.NoError(t, err)
+		assert.Equal(t, "Up to 10 Gigabit", value)
+	}
+}
+
 func TestAllocIPAddress(t *testing.T) {
 	ctrl, mockEC2 := setup(t)
 	defer ctrl.Finish()
@@ -860,6 +1415,409 @@ func TestEC2InstanceMetadataCache_cleanUpLeakedENIsInternal(t *testing.T) {
 	ins.cleanUpLeakedENIsInternal(time.Millisecond)
 }
 
+func TestEC2InstanceMetadataCache_cleanUpLeakedENIsInternalDetachedENI(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	description := eniDescriptionPrefix + "test"
+	interfaces := []*ec2.NetworkInterface{{
+		NetworkInterfaceId: aws.String(eni2ID),
+		Description:        &description,
+		Status:             aws.String(ec2.NetworkInterfaceStatusAvailable),
+		TagSet: []*ec2.Tag{
+			{Key: aws.String(eniNodeTagKey), Value: aws.String(instanceID)},
+			{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(time.Now().Add(-time.Hour).Format(time.RFC3339))},
+		},
+	}}
+
+	setupDescribeNetworkInterfacesPagesWithContextMock(t, mockEC2, interfaces, nil, 1)
+	// No DetachNetworkInterfaceWithContext expectation: the ENI is already detached and must only be deleted
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), &ec2.DeleteNetworkInterfaceInput{
+		NetworkInterfaceId: aws.String(eni2ID),
+	}).Return(&ec2.DeleteNetworkInterfaceOutput{}, nil)
+
+	decommissioned := eniRemovals.With(prometheus.Labels{"reason": string(ENIRemovalDecommission)})
+	before := testutil.ToFloat64(decommissioned)
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
+	ins.cleanUpLeakedENIsInternal(time.Millisecond)
+	assert.Equal(t, before+1, testutil.ToFloat64(decommissioned))
+}
+
+func TestEC2InstanceMetadataCache_cleanUpLeakedENIsInternalConcurrency(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	const leakedENIs = 20
+	const concurrency = 3
+	description := eniDescriptionPrefix + "test"
+	createdAt := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	var interfaces []*ec2.NetworkInterface
+	for i := 0; i < leakedENIs; i++ {
+		interfaces = append(interfaces, &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(fmt.Sprintf("eni-%08d", i)),
+			Description:        &description,
+			TagSet: []*ec2.Tag{
+				{Key: aws.String(eniNodeTagKey), Value: aws.String("test-value")},
+				{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(createdAt)},
+			},
+		})
+	}
+	setupDescribeNetworkInterfacesPagesWithContextMock(t, mockEC2, interfaces, nil, 1)
+
+	// The deletes block until concurrency of them are in flight, so the bound is reached whatever the scheduling
+	var inFlight, maxInFlight, deleted int32
+	full := make(chan struct{})
+	var fullOnce sync.Once
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Times(leakedENIs).
+		DoAndReturn(func(_ context.Context, _ *ec2.DeleteNetworkInterfaceInput, _ ...interface{}) (*ec2.DeleteNetworkInterfaceOutput, error) {
+			current := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				seen := atomic.LoadInt32(&maxInFlight)
+				if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+					break
+				}
+			}
+			if current == concurrency {
+				fullOnce.Do(func() { close(full) })
+			}
+			select {
+			case <-full:
+			case <-time.After(5 * time.Second):
+				t.Error("timed out waiting for the concurrent deletes")
+			}
+			atomic.AddInt32(&deleted, 1)
+			return &ec2.DeleteNetworkInterfaceOutput{}, nil
+		})
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, eniCleanupConcurrency: concurrency}
+	ins.cleanUpLeakedENIsInternal(time.Millisecond)
+
+	assert.Equal(t, int32(leakedENIs), atomic.LoadInt32(&deleted))
+	assert.Equal(t, int32(concurrency), atomic.LoadInt32(&maxInFlight))
+}
+
+func TestEC2InstanceMetadataCache_cleanUpLeakedENIsInternalRateLimit(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	const leakedENIs = 4
+	const interval = 50 * time.Millisecond
+	description := eniDescriptionPrefix + "test"
+	createdAt := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	var interfaces []*ec2.NetworkInterface
+	for i := 0; i < leakedENIs; i++ {
+		interfaces = append(interfaces, &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(fmt.Sprintf("eni-%08d", i)),
+			Description:        &description,
+			TagSet: []*ec2.Tag{
+				{Key: aws.String(eniNodeTagKey), Value: aws.String("test-value")},
+				{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(createdAt)},
+			},
+		})
+	}
+	setupDescribeNetworkInterfacesPagesWithContextMock(t, mockEC2, interfaces, nil, 1)
+
+	var lock sync.Mutex
+	var starts []time.Time
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Times(leakedENIs).
+		DoAndReturn(func(_ context.Context, _ *ec2.DeleteNetworkInterfaceInput, _ ...interface{}) (*ec2.DeleteNetworkInterfaceOutput, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			starts = append(starts, time.Now())
+			return &ec2.DeleteNetworkInterfaceOutput{}, nil
+		})
+
+	// The concurrency alone would let all the deletes start at once
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, eniCleanupConcurrency: leakedENIs, eniCleanupDeleteInterval: interval}
+	ins.cleanUpLeakedENIsInternal(time.Millisecond)
+
+	if assert.Len(t, starts, leakedENIs) {
+		elapsed := starts[leakedENIs-1].Sub(starts[0])
+		assert.True(t, elapsed >= (leakedENIs-1)*interval-10*time.Millisecond, "deletes started within %v", elapsed)
+	}
+}
+
+func TestEC2InstanceMetadataCache_cleanUpLeakedENIsInternalMetrics(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	description := eniDescriptionPrefix + "test"
+	leakedENI := func(id, instance string, createdAt, availableAt time.Time) *ec2.NetworkInterface {
+		eni := &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(id),
+			Description:        &description,
+			TagSet: []*ec2.Tag{
+				{Key: aws.String(eniNodeTagKey), Value: aws.String(instance)},
+				{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(createdAt.Format(time.RFC3339))},
+			},
+		}
+		if !availableAt.IsZero() {
+			eni.TagSet = append(eni.TagSet, &ec2.Tag{Key: aws.String(eniAvailableAtTagKey), Value: aws.String(availableAt.Format(time.RFC3339))})
+		}
+		return eni
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	interfaces := []*ec2.NetworkInterface{
+		// Past every threshold
+		leakedENI("eni-old-1", instanceID, hourAgo, time.Time{}),
+		leakedENI("eni-old-2", "i-terminated", hourAgo, hourAgo),
+		// Past every threshold, but its delete fails
+		leakedENI("eni-delete-fails", instanceID, hourAgo, time.Time{}),
+		// Within the delete cooldown
+		leakedENI("eni-new", instanceID, time.Now(), time.Time{}),
+		// Within the leaked ENI grace period of a just terminated instance
+		leakedENI("eni-grace", "i-terminated", hourAgo, time.Now().Add(-time.Minute)),
+	}
+	setupDescribeNetworkInterfacesPagesWithContextMock(t, mockEC2, interfaces, nil, 1)
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).AnyTimes().
+		DoAndReturn(func(_ context.Context, input *ec2.DeleteNetworkInterfaceInput, _ ...interface{}) (*ec2.DeleteNetworkInterfaceOutput, error) {
+			if aws.StringValue(input.NetworkInterfaceId) == "eni-delete-fails" {
+				return nil, errors.New("boom")
+			}
+			return &ec2.DeleteNetworkInterfaceOutput{}, nil
+		})
+
+	foundBefore := testutil.ToFloat64(leakedENIsFound)
+	deletedBefore := testutil.ToFloat64(leakedENIsDeleted)
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID, ec2APIRetries: 1,
+		leakedENIGracePeriod: 10 * time.Minute}
+	ins.cleanUpLeakedENIsInternal(time.Millisecond)
+
+	assert.Equal(t, float64(5), testutil.ToFloat64(leakedENIsFound)-foundBefore)
+	assert.Equal(t, float64(2), testutil.ToFloat64(leakedENIsDeleted)-deletedBefore)
+	assert.Equal(t, float64(3), testutil.ToFloat64(leakedENIsCurrent))
+
+	// The next run finds the ones left again, they are not counted twice
+	setupDescribeNetworkInterfacesPagesWithContextMock(t, mockEC2, interfaces[2:], nil, 1)
+	ins.cleanUpLeakedENIsInternal(time.Millisecond)
+	assert.Equal(t, float64(5), testutil.ToFloat64(leakedENIsFound)-foundBefore)
+	assert.Equal(t, float64(2), testutil.ToFloat64(leakedENIsDeleted)-deletedBefore)
+	assert.Equal(t, float64(3), testutil.ToFloat64(leakedENIsCurrent))
+}
+
+func Test_loadENICleanupConcurrency(t *testing.T) {
+	defer os.Unsetenv(eniCleanupConcurrencyEnvVar)
+
+	os.Unsetenv(eniCleanupConcurrencyEnvVar)
+	assert.Equal(t, defaultENICleanupConcurrency, loadENICleanupConcurrency())
+
+	os.Setenv(eniCleanupConcurrencyEnvVar, "8")
+	assert.Equal(t, 8, loadENICleanupConcurrency())
+
+	os.Setenv(eniCleanupConcurrencyEnvVar, "0")
+	assert.Equal(t, defaultENICleanupConcurrency, loadENICleanupConcurrency())
+
+	os.Setenv(eniCleanupConcurrencyEnvVar, "many")
+	assert.Equal(t, defaultENICleanupConcurrency, loadENICleanupConcurrency())
+}
+
+func Test_loadENICleanupDeletesPerSecond(t *testing.T) {
+	defer os.Unsetenv(eniCleanupDeletesPerSecondEnvVar)
+
+	os.Unsetenv(eniCleanupDeletesPerSecondEnvVar)
+	assert.Equal(t, defaultENICleanupDeletesPerSecond, loadENICleanupDeletesPerSecond())
+
+	os.Setenv(eniCleanupDeletesPerSecondEnvVar, "10")
+	assert.Equal(t, 10, loadENICleanupDeletesPerSecond())
+
+	os.Setenv(eniCleanupDeletesPerSecondEnvVar, "0")
+	assert.Equal(t, defaultENICleanupDeletesPerSecond, loadENICleanupDeletesPerSecond())
+
+	os.Setenv(eniCleanupDeletesPerSecondEnvVar, "fast")
+	assert.Equal(t, defaultENICleanupDeletesPerSecond, loadENICleanupDeletesPerSecond())
+}
+
+func Test_loadDescribeENIPageSize(t *testing.T) {
+	defer os.Unsetenv(describeENIPageSizeEnvVar)
+
+	os.Unsetenv(describeENIPageSizeEnvVar)
+	assert.Equal(t, int64(defaultDescribeENIPageSize), loadDescribeENIPageSize())
+
+	os.Setenv(describeENIPageSizeEnvVar, "50")
+	assert.Equal(t, int64(50), loadDescribeENIPageSize())
+
+	os.Setenv(describeENIPageSizeEnvVar, "1")
+	assert.Equal(t, int64(defaultDescribeENIPageSize), loadDescribeENIPageSize())
+
+	os.Setenv(describeENIPageSizeEnvVar, "5000")
+	assert.Equal(t, int64(defaultDescribeENIPageSize), loadDescribeENIPageSize())
+
+	os.Setenv(describeENIPageSizeEnvVar, "lots")
+	assert.Equal(t, int64(defaultDescribeENIPageSize), loadDescribeENIPageSize())
+}
+
+func Test_loadEC2APIRetries(t *testing.T) {
+	defer os.Unsetenv(ec2APIRetriesEnvVar)
+
+	os.Unsetenv(ec2APIRetriesEnvVar)
+	assert.Equal(t, maxENIEC2APIRetries, loadEC2APIRetries())
+
+	os.Setenv(ec2APIRetriesEnvVar, "30")
+	assert.Equal(t, 30, loadEC2APIRetries())
+
+	os.Setenv(ec2APIRetriesEnvVar, "0")
+	assert.Equal(t, maxENIEC2APIRetries, loadEC2APIRetries())
+
+	os.Setenv(ec2APIRetriesEnvVar, "100")
+	assert.Equal(t, maxENIEC2APIRetries, loadEC2APIRetries())
+
+	os.Setenv(ec2APIRetriesEnvVar, "forever")
+	assert.Equal(t, maxENIEC2APIRetries, loadEC2APIRetries())
+
+	cache := &EC2InstanceMetadataCache{}
+	assert.Equal(t, maxENIEC2APIRetries, cache.getEC2APIRetries())
+	cache.ec2APIRetries = 3
+	assert.Equal(t, 3, cache.getEC2APIRetries())
+}
+
+func Test_loadPrimaryIPReleaseTimeout(t *testing.T) {
+	defer os.Unsetenv(primaryIPReleaseTimeoutEnvVar)
+
+	os.Unsetenv(primaryIPReleaseTimeoutEnvVar)
+	assert.Equal(t, defaultPrimaryIPReleaseTimeout, loadPrimaryIPReleaseTimeout())
+
+	os.Setenv(primaryIPReleaseTimeoutEnvVar, "90")
+	assert.Equal(t, 90*time.Second, loadPrimaryIPReleaseTimeout())
+
+	os.Setenv(primaryIPReleaseTimeoutEnvVar, "0")
+	assert.Equal(t, time.Duration(0), loadPrimaryIPReleaseTimeout())
+
+	os.Setenv(primaryIPReleaseTimeoutEnvVar, "601")
+	assert.Equal(t, defaultPrimaryIPReleaseTimeout, loadPrimaryIPReleaseTimeout())
+
+	os.Setenv(primaryIPReleaseTimeoutEnvVar, "-1")
+	assert.Equal(t, defaultPrimaryIPReleaseTimeout, loadPrimaryIPReleaseTimeout())
+
+	os.Setenv(primaryIPReleaseTimeoutEnvVar, "soon")
+	assert.Equal(t, defaultPrimaryIPReleaseTimeout, loadPrimaryIPReleaseTimeout())
+}
+
+func TestEC2InstanceMetadataCache_getLeakedENIsMultiplePages(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	tenMinuteAgo := time.Now().Add(-10 * time.Minute).Format(time.RFC3339)
+	leakedENI := func(id string) *ec2.NetworkInterface {
+		return &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(id),
+			Description:        aws.String("aws-K8S-i-xxxxx"),
+			Status:             aws.String("available"),
+			TagSet: []*ec2.Tag{
+				{Key: aws.String(eniNodeTagKey), Value: aws.String("i-xxxxx")},
+				{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(tenMinuteAgo)},
+			},
+		}
+	}
+	// Four pages of the smallest valid page size, the last one partial
+	const pageSize = minDescribeENIPageSize
+	const leakedENIs = 3*pageSize + 2
+	var wantIDs []string
+	var pages []*ec2.DescribeNetworkInterfacesOutput
+	for i := 0; i < leakedENIs; i++ {
+		id := fmt.Sprintf("eni-%d", i+1)
+		wantIDs = append(wantIDs, id)
+		if i%pageSize == 0 {
+			if len(pages) > 0 {
+				pages[len(pages)-1].NextToken = aws.String(strconv.Itoa(len(pages) + 1))
+			}
+			pages = append(pages, &ec2.DescribeNetworkInterfacesOutput{})
+		}
+		page := pages[len(pages)-1]
+		page.NetworkInterfaces = append(page.NetworkInterfaces, leakedENI(id))
+	}
+	assert.Len(t, pages, 4)
+	mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *ec2.DescribeNetworkInterfacesInput,
+			fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+			assert.Equal(t, int64(pageSize), aws.Int64Value(input.MaxResults))
+			for i, page := range pages {
+				if !fn(page, i == len(pages)-1) {
+					break
+				}
+			}
+			return nil
+		})
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, describeENIPageSize: pageSize}
+	got, err := ins.getLeakedENIs()
+	assert.NoError(t, err)
+	var gotIDs []string
+	for _, eni := range got {
+		gotIDs = append(gotIDs, aws.StringValue(eni.NetworkInterfaceId))
+	}
+	assert.Equal(t, wantIDs, gotIDs)
+}
+
+func TestEC2InstanceMetadataCache_getLeakedENIsGracePeriod(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	tenMinuteAgo := time.Now().Add(-10 * time.Minute).Format(time.RFC3339)
+	leakedENI := func(id, instance string, availableAt time.Time) *ec2.NetworkInterface {
+		eni := &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(id),
+			Description:        aws.String("aws-K8S-" + instance),
+			Status:             aws.String("available"),
+			TagSet: []*ec2.Tag{
+				{Key: aws.String(eniNodeTagKey), Value: aws.String(instance)},
+				{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(tenMinuteAgo)},
+			},
+		}
+		if !availableAt.IsZero() {
+			eni.TagSet = append(eni.TagSet, &ec2.Tag{Key: aws.String(eniAvailableAtTagKey), Value: aws.String(availableAt.Format(time.RFC3339))})
+		}
+		return eni
+	}
+	page := &ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: []*ec2.NetworkInterface{
+		// The instance was just terminated, its ENI only went available a minute ago
+		leakedENI("eni-1", "i-terminated", time.Now().Add(-time.Minute)),
+		// Past the grace period
+		leakedENI("eni-2", "i-terminated", time.Now().Add(-time.Hour)),
+		// Seen available for the first time, it gets tagged
+		leakedENI("eni-3", "i-terminated", time.Time{}),
+		// ENIs of this instance are not subject to the grace period
+		leakedENI("eni-4", instanceID, time.Time{}),
+	}}
+	mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ *ec2.DescribeNetworkInterfacesInput,
+			fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+			fn(page, true)
+			return nil
+		})
+	mockEC2.EXPECT().CreateTagsWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *ec2.CreateTagsInput, _ ...interface{}) (*ec2.CreateTagsOutput, error) {
+			assert.Equal(t, "eni-3", aws.StringValue(input.Resources[0]))
+			assert.Equal(t, eniAvailableAtTagKey, aws.StringValue(input.Tags[0].Key))
+			return &ec2.CreateTagsOutput{}, nil
+		})
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID, leakedENIGracePeriod: 10 * time.Minute}
+	got, err := ins.getLeakedENIs()
+	assert.NoError(t, err)
+	var gotIDs []string
+	for _, eni := range got {
+		gotIDs = append(gotIDs, aws.StringValue(eni.NetworkInterfaceId))
+	}
+	assert.Equal(t, []string{"eni-2", "eni-4"}, gotIDs)
+}
+
+func Test_loadLeakedENIGracePeriod(t *testing.T) {
+	defer os.Unsetenv(leakedENIGracePeriodEnvVar)
+
+	os.Unsetenv(leakedENIGracePeriodEnvVar)
+	assert.Equal(t, time.Duration(0), loadLeakedENIGracePeriod())
+
+	os.Setenv(leakedENIGracePeriodEnvVar, "900")
+	assert.Equal(t, 15*time.Minute, loadLeakedENIGracePeriod())
+
+	os.Setenv(leakedENIGracePeriodEnvVar, "86401")
+	assert.Equal(t, time.Duration(0), loadLeakedENIGracePeriod())
+
+	os.Setenv(leakedENIGracePeriodEnvVar, "a while")
+	assert.Equal(t, time.Duration(0), loadLeakedENIGracePeriod())
+}
+
 func setupDescribeNetworkInterfacesPagesWithContextMock(
 	t *testing.T, mockEC2 *mock_ec2wrapper.MockEC2, interfaces []*ec2.NetworkInterface, err error, times int) {
 	mockEC2.EXPECT().
@@ -1176,10 +2134,6 @@ func TestEC2InstanceMetadataCache_getLeakedENIs(t *testing.T) {
 									Name:   aws.String("status"),
 									Values: []*string{aws.String("available")},
 								},
-								{
-									Name:   aws.String("tag:cluster.k8s.amazonaws.com/name"),
-									Values: []*string{aws.String("awesome-cluster")},
-								},
 							},
 							MaxResults: aws.Int64(1000),
 						},
@@ -1249,10 +2203,6 @@ func TestEC2InstanceMetadataCache_getLeakedENIs(t *testing.T) {
 									Name:   aws.String("status"),
 									Values: []*string{aws.String("available")},
 								},
-								{
-									Name:   aws.String("tag:cluster.k8s.amazonaws.com/name"),
-									Values: []*string{aws.String("awesome-cluster")},
-								},
 							},
 							MaxResults: aws.Int64(1000),
 						},
@@ -1302,10 +2252,6 @@ func TestEC2InstanceMetadataCache_getLeakedENIs(t *testing.T) {
 									Name:   aws.String("status"),
 									Values: []*string{aws.String("available")},
 								},
-								{
-									Name:   aws.String("tag:cluster.k8s.amazonaws.com/name"),
-									Values: []*string{aws.String("awesome-cluster")},
-								},
 							},
 							MaxResults: aws.Int64(1000),
 						},
@@ -1371,6 +2317,201 @@ func TestEC2InstanceMetadataCache_getLeakedENIs(t *testing.T) {
 	}
 }
 
+func TestEC2InstanceMetadataCache_getLeakedBranchENIs(t *testing.T) {
+	oldCreatedAt := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	newCreatedAt := time.Now().Format(time.RFC3339)
+	branchENIOf := func(eniID, trunkID, createdAt, cluster, vpc string) *ec2.NetworkInterface {
+		return &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(eniID),
+			InterfaceType:      aws.String("branch"),
+			Status:             aws.String(ec2.NetworkInterfaceStatusAvailable),
+			VpcId:              aws.String(vpc),
+			TagSet: []*ec2.Tag{
+				{Key: aws.String(trunkENIIDTagKey), Value: aws.String(trunkID)},
+				{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(createdAt)},
+				{Key: aws.String(eniClusterTagKey), Value: aws.String(cluster)},
+			},
+		}
+	}
+	branchENI := func(eniID, trunkID, createdAt string) *ec2.NetworkInterface {
+		return branchENIOf(eniID, trunkID, createdAt, "my-cluster", vpcID)
+	}
+	tests := []struct {
+		name           string
+		branchENIs     []*ec2.NetworkInterface
+		existingTrunks []*ec2.NetworkInterface
+		want           []string
+	}{
+		{
+			name:       "branch ENI of a deleted trunk is leaked",
+			branchENIs: []*ec2.NetworkInterface{branchENI("eni-branch1", "eni-trunk1", oldCreatedAt)},
+			want:       []string{"eni-branch1"},
+		},
+		{
+			name:           "branch ENI of an existing trunk is kept",
+			branchENIs:     []*ec2.NetworkInterface{branchENI("eni-branch1", "eni-trunk1", oldCreatedAt)},
+			existingTrunks: []*ec2.NetworkInterface{{NetworkInterfaceId: aws.String("eni-trunk1")}},
+		},
+		{
+			name: "only branch ENIs of deleted trunks are leaked",
+			branchENIs: []*ec2.NetworkInterface{
+				branchENI("eni-branch1", "eni-trunk1", oldCreatedAt),
+				branchENI("eni-branch2", "eni-trunk2", oldCreatedAt),
+			},
+			existingTrunks: []*ec2.NetworkInterface{{NetworkInterfaceId: aws.String("eni-trunk2")}},
+			want:           []string{"eni-branch1"},
+		},
+		{
+			name:       "recently created branch ENI is kept",
+			branchENIs: []*ec2.NetworkInterface{branchENI("eni-branch1", "eni-trunk1", newCreatedAt)},
+		},
+		{
+			name: "branch ENIs of other clusters and VPCs are kept",
+			branchENIs: []*ec2.NetworkInterface{
+				branchENIOf("eni-branch1", "eni-trunk1", oldCreatedAt, "other-cluster", vpcID),
+				branchENIOf("eni-branch2", "eni-trunk2", oldCreatedAt, "my-cluster", "vpc-other"),
+				branchENI("eni-branch3", "eni-trunk3", oldCreatedAt),
+			},
+			want: []string{"eni-branch3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl, mockEC2 := setup(t)
+			defer ctrl.Finish()
+
+			describeBranches := mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, input *ec2.DescribeNetworkInterfacesInput,
+					fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+					assert.Equal(t, "interface-type", aws.StringValue(input.Filters[0].Name))
+					assert.Contains(t, input.Filters, &ec2.Filter{Name: aws.String("tag:" + eniClusterTagKey), Values: aws.StringSlice([]string{"my-cluster"})})
+					assert.Contains(t, input.Filters, &ec2.Filter{Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{vpcID})})
+					fn(&ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: tt.branchENIs}, true)
+					return nil
+				})
+			mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, input *ec2.DescribeNetworkInterfacesInput,
+					fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+					assert.Equal(t, "network-interface-id", aws.StringValue(input.Filters[0].Name))
+					fn(&ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: tt.existingTrunks}, true)
+					return nil
+				}).After(describeBranches).MaxTimes(1)
+
+			cache := &EC2InstanceMetadataCache{ec2SVC: mockEC2, clusterName: "my-cluster", vpcID: vpcID}
+			got, err := cache.getLeakedBranchENIs()
+			assert.NoError(t, err)
+			var gotIDs []string
+			for _, eni := range got {
+				gotIDs = append(gotIDs, aws.StringValue(eni.NetworkInterfaceId))
+			}
+			assert.Equal(t, tt.want, gotIDs)
+		})
+	}
+}
+
+func TestEC2InstanceMetadataCache_getLeakedBranchENIsTrunkBatches(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	// One branch ENI per trunk, enough trunks for two full batches and a partial one
+	const trunks = 2*maxDescribeFilterValues + 1
+	oldCreatedAt := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	var branchENIs []*ec2.NetworkInterface
+	for i := 0; i < trunks; i++ {
+		branchENIs = append(branchENIs, &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(fmt.Sprintf("eni-branch%04d", i)),
+			InterfaceType:      aws.String("branch"),
+			Status:             aws.String(ec2.NetworkInterfaceStatusAvailable),
+			VpcId:              aws.String(vpcID),
+			TagSet: []*ec2.Tag{
+				{Key: aws.String(trunkENIIDTagKey), Value: aws.String(fmt.Sprintf("eni-trunk%04d", i))},
+				{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(oldCreatedAt)},
+				{Key: aws.String(eniClusterTagKey), Value: aws.String("my-cluster")},
+			},
+		})
+	}
+	describeBranches := mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ *ec2.DescribeNetworkInterfacesInput,
+			fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+			fn(&ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: branchENIs}, true)
+			return nil
+		})
+	// Every trunk but the last one still exists
+	var batchSizes []int
+	lookedUp := sets.NewString()
+	mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *ec2.DescribeNetworkInterfacesInput,
+			fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+			assert.Len(t, input.Filters, 1)
+			assert.Equal(t, "network-interface-id", aws.StringValue(input.Filters[0].Name))
+			batchSizes = append(batchSizes, len(input.Filters[0].Values))
+			var existing []*ec2.NetworkInterface
+			for _, id := range aws.StringValueSlice(input.Filters[0].Values) {
+				lookedUp.Insert(id)
+				if id != fmt.Sprintf("eni-trunk%04d", trunks-1) {
+					existing = append(existing, &ec2.NetworkInterface{NetworkInterfaceId: aws.String(id)})
+				}
+			}
+			fn(&ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: existing}, true)
+			return nil
+		}).After(describeBranches).Times(3)
+
+	cache := &EC2InstanceMetadataCache{ec2SVC: mockEC2, clusterName: "my-cluster", vpcID: vpcID}
+	got, err := cache.getLeakedBranchENIs()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{maxDescribeFilterValues, maxDescribeFilterValues, 1}, batchSizes)
+	assert.Equal(t, trunks, lookedUp.Len())
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, fmt.Sprintf("eni-branch%04d", trunks-1), aws.StringValue(got[0].NetworkInterfaceId))
+	}
+}
+
+func TestEC2InstanceMetadataCache_getLeakedBranchENIsNoClusterName(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	// Without the cluster name the branch ENIs of every cluster of the account would be candidates
+	cache := &EC2InstanceMetadataCache{ec2SVC: mockEC2}
+	got, err := cache.getLeakedBranchENIs()
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestEC2InstanceMetadataCache_cleanUpLeakedENIsInternalBranchENI(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	orphanedBranch := &ec2.NetworkInterface{
+		NetworkInterfaceId: aws.String("eni-branch1"),
+		InterfaceType:      aws.String("branch"),
+		Status:             aws.String(ec2.NetworkInterfaceStatusAvailable),
+		TagSet: []*ec2.Tag{
+			{Key: aws.String(trunkENIIDTagKey), Value: aws.String("eni-trunk1")},
+			{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(time.Now().Add(-time.Hour).Format(time.RFC3339))},
+			{Key: aws.String(eniClusterTagKey), Value: aws.String("my-cluster")},
+		},
+	}
+	pages := [][]*ec2.NetworkInterface{
+		nil,              // regular leaked ENIs
+		{orphanedBranch}, // branch ENIs
+		nil,              // trunk ENIs
+	}
+	call := 0
+	mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Times(len(pages)).
+		DoAndReturn(func(_ context.Context, _ *ec2.DescribeNetworkInterfacesInput,
+			fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+			fn(&ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: pages[call]}, true)
+			call++
+			return nil
+		})
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), &ec2.DeleteNetworkInterfaceInput{
+		NetworkInterfaceId: aws.String("eni-branch1"),
+	}).Return(&ec2.DeleteNetworkInterfaceOutput{}, nil)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, enableBranchENICleanup: true, clusterName: "my-cluster"}
+	ins.cleanUpLeakedENIsInternal(time.Millisecond)
+}
+
 func TestEC2InstanceMetadataCache_TagENI(t *testing.T) {
 	type createTagsCall struct {
 		input *ec2.CreateTagsInput
@@ -1466,6 +2607,34 @@ func TestEC2InstanceMetadataCache_TagENI(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "eni tagged for another cluster",
+			fields: fields{
+				instanceID:  "i-xxxx",
+				clusterName: "awesome-cluster",
+				createTagsCalls: []createTagsCall{
+					{
+						input: &ec2.CreateTagsInput{
+							Resources: []*string{aws.String("eni-xxxx")},
+							Tags: []*ec2.Tag{
+								{
+									Key:   aws.String("cluster.k8s.amazonaws.com/name"),
+									Value: aws.String("awesome-cluster"),
+								},
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				eniID: "eni-xxxx",
+				currentTags: map[string]string{
+					"node.k8s.amazonaws.com/instance_id": "i-xxxx",
+					"cluster.k8s.amazonaws.com/name":     "previous-cluster",
+				},
+			},
+			wantErr: nil,
+		},
 		{
 			name: "create tags fails",
 			fields: fields{
@@ -1522,6 +2691,28 @@ func TestEC2InstanceMetadataCache_TagENI(t *testing.T) {
 	}
 }
 
+func TestEC2InstanceMetadataCache_TagENIStaleClusterWithoutClusterName(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+	cache := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID}
+	currentTags := map[string]string{eniNodeTagKey: instanceID, eniClusterTagKey: "previous-cluster"}
+
+	// Without a cluster name the tag of the previous cluster is removed
+	mockEC2.EXPECT().DeleteTagsWithContext(gomock.Any(), &ec2.DeleteTagsInput{
+		Resources: []*string{aws.String(eniID)},
+		Tags:      []*ec2.Tag{{Key: aws.String(eniClusterTagKey), Value: aws.String("previous-cluster")}},
+	}).Return(&ec2.DeleteTagsOutput{}, nil)
+	assert.NoError(t, cache.TagENI(eniID, currentTags))
+
+	// Failing to remove it does not fail the tagging
+	mockEC2.EXPECT().DeleteTagsWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("UnauthorizedOperation"))
+	assert.NoError(t, cache.TagENI(eniID, currentTags))
+
+	// A cluster tag set in ADDITIONAL_ENI_TAGS is the desired one
+	cache.additionalENITags = map[string]string{eniClusterTagKey: "previous-cluster"}
+	assert.NoError(t, cache.TagENI(eniID, currentTags))
+}
+
 func Test_convertTagsToSDKTags(t *testing.T) {
 	type args struct {
 		tags map[string]string
@@ -1694,3 +2885,171 @@ func Test_loadAdditionalENITags(t *testing.T) {
 		})
 	}
 }
+
+func sgDriftTestMetadata() FakeIMDS {
+	return testMetadata(map[string]interface{}{
+		metadataMACPath: primaryMAC + " " + eni2MAC,
+		metadataMACPath + eni2MAC + metadataDeviceNum:  eni2Device,
+		metadataMACPath + eni2MAC + metadataInterface:  eni2ID,
+		metadataMACPath + eni2MAC + metadataSubnetCIDR: subnetCIDR,
+		metadataMACPath + eni2MAC + metadataIPv4s:      eni2PrivateIP,
+		// sg2 was removed from the secondary ENI outside of the CNI
+		metadataMACPath + eni2MAC + metadataSGs: sg1,
+	})
+}
+
+func TestRefreshSGIDsDetectsDrift(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	ins := &EC2InstanceMetadataCache{imds: TypedIMDS{sgDriftTestMetadata()}, ec2SVC: mockEC2}
+	ins.securityGroups.Set([]string{sg1, sg2})
+
+	// Without correction the drift is only reported
+	assert.NoError(t, ins.RefreshSGIDs(primaryMAC))
+	assert.Equal(t, float64(1), testutil.ToFloat64(sgDriftedENIs))
+}
+
+func TestRefreshSGIDsCorrectsDrift(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	ins := &EC2InstanceMetadataCache{imds: TypedIMDS{sgDriftTestMetadata()}, ec2SVC: mockEC2, enableSGDriftCorrection: true}
+	ins.securityGroups.Set([]string{sg1, sg2})
+
+	mockEC2.EXPECT().ModifyNetworkInterfaceAttributeWithContext(gomock.Any(), &ec2.ModifyNetworkInterfaceAttributeInput{
+		Groups:             aws.StringSlice([]string{sg1, sg2}),
+		NetworkInterfaceId: aws.String(eni2ID),
+	}).Return(&ec2.ModifyNetworkInterfaceAttributeOutput{}, nil)
+	assert.NoError(t, ins.RefreshSGIDs(primaryMAC))
+	assert.Equal(t, float64(0), testutil.ToFloat64(sgDriftedENIs))
+
+	// A failed correction leaves the ENI drifted
+	mockEC2.EXPECT().ModifyNetworkInterfaceAttributeWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("error on ModifyNetworkInterfaceAttributeWithContext"))
+	assert.NoError(t, ins.RefreshSGIDs(primaryMAC))
+	assert.Equal(t, float64(1), testutil.ToFloat64(sgDriftedENIs))
+}
+
+func TestRefreshSGIDsSkipsUnmanagedAndCustomNetworking(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	// The drifted ENI is not managed by the CNI, it is left alone
+	ins := &EC2InstanceMetadataCache{imds: TypedIMDS{sgDriftTestMetadata()}, ec2SVC: mockEC2, enableSGDriftCorrection: true}
+	ins.securityGroups.Set([]string{sg1, sg2})
+	ins.unmanagedENIs.Set([]string{eni2ID})
+	assert.NoError(t, ins.RefreshSGIDs(primaryMAC))
+	assert.Equal(t, float64(0), testutil.ToFloat64(sgDriftedENIs))
+
+	// With custom networking the secondary ENIs have the security groups of their ENIConfig
+	ins = &EC2InstanceMetadataCache{imds: TypedIMDS{sgDriftTestMetadata()}, ec2SVC: mockEC2, enableSGDriftCorrection: true,
+		useCustomNetworking: true}
+	ins.securityGroups.Set([]string{sg1, sg2})
+	assert.NoError(t, ins.RefreshSGIDs(primaryMAC))
+}
+
+func Test_isCredentialRefreshError(t *testing.T) {
+	for code, want := range map[string]bool{
+		"NoCredentialProviders": true,
+		"EC2RoleRequestError":   true,
+		"ExpiredToken":          true,
+		"RequestExpired":        false,
+		"UnauthorizedOperation": false,
+		"InvalidParameterValue": false,
+		"RequestLimitExceeded":  false,
+	} {
+		assert.Equal(t, want, isCredentialRefreshError(awserr.New(code, "", nil)), code)
+	}
+}
+
+func TestGetTaggedSubnets(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	mockMetadata := testMetadata(map[string]interface{}{
+		metadataMACPath + primaryMAC + "/vpc-id": "vpc-1",
+	})
+	mockEC2.EXPECT().DescribeSubnetsWithContext(gomock.Any(), &ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{"vpc-1"})},
+			{Name: aws.String("availability-zone"), Values: aws.StringSlice([]string{az})},
+			{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{subnetDiscoveryTagKey})},
+		},
+	}, gomock.Any()).Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-small"), AvailableIpAddressCount: aws.Int64(10)},
+		{SubnetId: aws.String("subnet-large"), AvailableIpAddressCount: aws.Int64(200)},
+	}}, nil)
+
+	ins := &EC2InstanceMetadataCache{imds: TypedIMDS{mockMetadata}, ec2SVC: mockEC2, availabilityZone: az, primaryENImac: primaryMAC}
+	subnets, err := ins.GetTaggedSubnets()
+	assert.NoError(t, err)
+	assert.Equal(t, []SubnetAvailability{{SubnetID: "subnet-large", AvailableIPs: 200}, {SubnetID: "subnet-small", AvailableIPs: 10}}, subnets)
+}
+
+func TestGetSubnetAvailability(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, subnetID: subnetID}
+
+	// The node's subnet by default
+	mockEC2.EXPECT().DescribeSubnetsWithContext(gomock.Any(), &ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice([]string{subnetID})}, gomock.Any()).
+		Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{{SubnetId: aws.String(subnetID), AvailableIpAddressCount: aws.Int64(42)}}}, nil)
+	availability, err := ins.GetSubnetAvailability("")
+	assert.NoError(t, err)
+	assert.Equal(t, SubnetAvailability{SubnetID: subnetID, AvailableIPs: 42}, availability)
+
+	mockEC2.EXPECT().DescribeSubnetsWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil, awserr.New("InvalidSubnetID.NotFound", "no subnet-unknown", nil))
+	_, err = ins.GetSubnetAvailability("subnet-unknown")
+	assert.True(t, errors.Is(err, ErrSubnetNotFound), "unexpected error %v", err)
+}
+
+func TestAuditMode(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	// No EC2 call is expected, the mock fails the test on any of them
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceType: "m5.large", instanceID: instanceID, auditMode: true}
+	assert.True(t, ins.IsAuditMode())
+	skipped := func(fn string) float64 {
+		return testutil.ToFloat64(auditModeSkippedCalls.WithLabelValues(fn))
+	}
+	before := map[string]float64{}
+	for _, fn := range []string{"AllocENI", "FreeENI", "deleteENI", "TagENI", "AllocIPAddress", "AllocIPAddresses",
+		"DeallocIPAddresses", "DeallocPrefixAddresses", "updateENISGs", "tagENIWithCurrentTime"} {
+		before[fn] = skipped(fn)
+	}
+
+	_, err := ins.AllocENI(false, aws.StringSlice([]string{sg1}), subnetID)
+	assert.Equal(t, ErrAuditMode, err)
+	assert.Equal(t, ErrAuditMode, ins.FreeENI(eniID))
+	assert.Equal(t, ErrAuditMode, ins.deleteENI(eniID, time.Millisecond))
+	assert.Equal(t, ErrAuditMode, ins.TagENI(eniID, map[string]string{}))
+	assert.Equal(t, ErrAuditMode, ins.AllocIPAddress(eniID))
+	assert.Equal(t, ErrAuditMode, ins.AllocIPAddresses(eniID, 5))
+	assert.Equal(t, ErrAuditMode, ins.DeallocIPAddresses(eniID, []string{"10.0.0.1"}))
+	assert.Equal(t, ErrAuditMode, ins.DeallocPrefixAddresses(eniID, []string{"10.0.0.16/28"}))
+	assert.Equal(t, ErrAuditMode, ins.updateENISGs(eniID, []string{sg1}))
+	ins.tagENIWithCurrentTime(eniID, eniCreatedAtTagKey, time.Millisecond)
+
+	// Every skipped call is counted
+	for fn, count := range before {
+		assert.Equal(t, count+1, skipped(fn), fn)
+	}
+
+	// Calls that change nothing are not reported
+	assert.NoError(t, ins.DeallocIPAddresses(eniID, nil))
+	assert.NoError(t, ins.AllocIPAddresses(eniID, 0))
+	assert.Equal(t, before["DeallocIPAddresses"]+1, skipped("DeallocIPAddresses"))
+}
+
+func TestLoadAuditMode(t *testing.T) {
+	defer os.Unsetenv(auditModeEnvVar)
+
+	assert.False(t, loadAuditMode())
+	_ = os.Setenv(auditModeEnvVar, "true")
+	assert.True(t, loadAuditMode())
+	_ = os.Setenv(auditModeEnvVar, "not-a-bool")
+	assert.False(t, loadAuditMode())
+}
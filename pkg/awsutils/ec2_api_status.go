@@ -0,0 +1,88 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// EC2APIStatus tells how long EC2 has been unreachable, to tell an outage from a transient blip
+type EC2APIStatus struct {
+	// LastSuccess is when the last successful EC2 call completed, zero if none did yet
+	LastSuccess time.Time `json:"lastSuccess"`
+	// LastSuccessAPI is the name of the last successful EC2 call
+	LastSuccessAPI string `json:"lastSuccessAPI,omitempty"`
+	// SecondsSinceLastSuccess is the time elapsed since LastSuccess, or since ipamd started if no call succeeded yet
+	SecondsSinceLastSuccess float64 `json:"secondsSinceLastSuccess"`
+	// FailuresSinceLastSuccess is the number of EC2 calls that failed, after the SDK retries, since LastSuccess
+	FailuresSinceLastSuccess int `json:"failuresSinceLastSuccess"`
+}
+
+// ec2APIStatusTracker records the outcome of every EC2 call made through the session it is installed on
+type ec2APIStatusTracker struct {
+	lock    sync.Mutex
+	now     func() time.Time
+	started time.Time
+	status  EC2APIStatus
+}
+
+func newEC2APIStatusTracker(now func() time.Time) *ec2APIStatusTracker {
+	return &ec2APIStatusTracker{now: now, started: now()}
+}
+
+// install records the EC2 calls of the handlers, once they completed with all their retries
+func (t *ec2APIStatusTracker) install(handlers *request.Handlers) {
+	handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "amazon-vpc-cni-k8s/ec2-api-status",
+		Fn:   t.record,
+	})
+}
+
+func (t *ec2APIStatusTracker) record(r *request.Request) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if r.Error != nil {
+		t.status.FailuresSinceLastSuccess++
+		return
+	}
+	t.status.LastSuccess = t.now()
+	if r.Operation != nil {
+		t.status.LastSuccessAPI = r.Operation.Name
+	}
+	t.status.FailuresSinceLastSuccess = 0
+	ec2LastSuccessfulCall.Set(float64(t.status.LastSuccess.Unix()))
+}
+
+func (t *ec2APIStatusTracker) get() EC2APIStatus {
+	if t == nil {
+		return EC2APIStatus{}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	status := t.status
+	since := t.started
+	if !status.LastSuccess.IsZero() {
+		since = status.LastSuccess
+	}
+	status.SecondsSinceLastSuccess = t.now().Sub(since).Seconds()
+	return status
+}
+
+// GetEC2APIStatus returns when the last EC2 call succeeded and how many failed since
+func (cache *EC2InstanceMetadataCache) GetEC2APIStatus() EC2APIStatus {
+	return cache.ec2APIStatus.get()
+}
@@ -0,0 +1,74 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEC2APIStatusTracker(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newEC2APIStatusTracker(func() time.Time { return now })
+	ins := &EC2InstanceMetadataCache{ec2APIStatus: tracker}
+
+	// No call succeeded yet, the time counts from the start
+	now = now.Add(time.Minute)
+	assert.Equal(t, EC2APIStatus{SecondsSinceLastSuccess: 60}, ins.GetEC2APIStatus())
+
+	succeededAt := now
+	tracker.record(&request.Request{Operation: &request.Operation{Name: "DescribeNetworkInterfaces"}})
+	assert.Equal(t, EC2APIStatus{LastSuccess: succeededAt, LastSuccessAPI: "DescribeNetworkInterfaces"}, ins.GetEC2APIStatus())
+	assert.Equal(t, float64(succeededAt.Unix()), testutil.ToFloat64(ec2LastSuccessfulCall))
+
+	// The timestamp stalls during a failure streak
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Minute)
+		tracker.record(&request.Request{Operation: &request.Operation{Name: "AssignPrivateIpAddresses"}, Error: errors.New("timeout")})
+	}
+	assert.Equal(t, EC2APIStatus{
+		LastSuccess:              succeededAt,
+		LastSuccessAPI:           "DescribeNetworkInterfaces",
+		SecondsSinceLastSuccess:  180,
+		FailuresSinceLastSuccess: 3,
+	}, ins.GetEC2APIStatus())
+	assert.Equal(t, float64(succeededAt.Unix()), testutil.ToFloat64(ec2LastSuccessfulCall))
+
+	// It moves again once EC2 recovers
+	now = now.Add(time.Minute)
+	tracker.record(&request.Request{Operation: &request.Operation{Name: "AssignPrivateIpAddresses"}})
+	assert.Equal(t, EC2APIStatus{LastSuccess: now, LastSuccessAPI: "AssignPrivateIpAddresses"}, ins.GetEC2APIStatus())
+	assert.Equal(t, float64(now.Unix()), testutil.ToFloat64(ec2LastSuccessfulCall))
+}
+
+func TestEC2APIStatusTrackerInstall(t *testing.T) {
+	tracker := newEC2APIStatusTracker(time.Now)
+	var handlers request.Handlers
+	tracker.install(&handlers)
+
+	handlers.Complete.Run(&request.Request{Error: errors.New("timeout")})
+	assert.Equal(t, 1, tracker.get().FailuresSinceLastSuccess)
+	handlers.Complete.Run(&request.Request{})
+	assert.False(t, tracker.get().LastSuccess.IsZero())
+}
+
+func TestGetEC2APIStatusNoTracker(t *testing.T) {
+	ins := &EC2InstanceMetadataCache{}
+	assert.Equal(t, EC2APIStatus{}, ins.GetEC2APIStatus())
+}
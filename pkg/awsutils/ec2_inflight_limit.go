@@ -0,0 +1,112 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	// ec2MaxInflightEnvVar caps the EC2 calls in flight at once across ipamd, with their retries. The calls above it
+	// wait for one to complete, so a burst of allocations and reconciles doesn't get the node throttled. 0 doesn't cap.
+	ec2MaxInflightEnvVar = "EC2_MAX_INFLIGHT_CALLS"
+	maxEC2MaxInflight    = 1000
+)
+
+func loadEC2MaxInflight() int {
+	inputStr, found := os.LookupEnv(ec2MaxInflightEnvVar)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && input <= maxEC2MaxInflight {
+		log.Debugf("Using %s %v", ec2MaxInflightEnvVar, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, must be between 0 and %d, not capping the EC2 calls in flight", ec2MaxInflightEnvVar,
+		inputStr, maxEC2MaxInflight)
+	return 0
+}
+
+// inflightLimiter counts the EC2 calls of the session it is installed on from their validation to their completion,
+// retries included, and holds the calls above its cap until a slot frees up
+type inflightLimiter struct {
+	// slots holds a token per call in flight, nil without a cap
+	slots chan struct{}
+
+	lock sync.Mutex
+	// inflight are the calls holding a slot, the ones that failed before being validated never took one
+	inflight map[*request.Request]struct{}
+}
+
+// newInflightLimiter returns a limiter letting maxInflight calls through at once, any number if it is 0
+func newInflightLimiter(maxInflight int) *inflightLimiter {
+	l := &inflightLimiter{inflight: make(map[*request.Request]struct{})}
+	if maxInflight > 0 {
+		l.slots = make(chan struct{}, maxInflight)
+	}
+	return l
+}
+
+// install makes the handlers' calls take a slot before anything else, and give it back once completed
+func (l *inflightLimiter) install(handlers *request.Handlers) {
+	handlers.Validate.PushFrontNamed(request.NamedHandler{
+		Name: "amazon-vpc-cni-k8s/ec2-inflight-acquire",
+		Fn:   l.acquire,
+	})
+	handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "amazon-vpc-cni-k8s/ec2-inflight-release",
+		Fn:   l.release,
+	})
+}
+
+func (l *inflightLimiter) acquire(r *request.Request) {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			ec2QueuedCalls.Inc()
+			select {
+			case l.slots <- struct{}{}:
+				ec2QueuedCalls.Dec()
+			case <-r.Context().Done():
+				ec2QueuedCalls.Dec()
+				r.Error = awserr.New(request.CanceledErrorCode,
+					"request context canceled while waiting for an EC2 call slot", r.Context().Err())
+				return
+			}
+		}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.inflight[r] = struct{}{}
+	ec2InflightCalls.Set(float64(len(l.inflight)))
+}
+
+func (l *inflightLimiter) release(r *request.Request) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.inflight[r]; !ok {
+		return
+	}
+	delete(l.inflight, r)
+	ec2InflightCalls.Set(float64(len(l.inflight)))
+	if l.slots != nil {
+		<-l.slots
+	}
+}
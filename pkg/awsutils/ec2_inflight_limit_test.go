@@ -0,0 +1,161 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_loadEC2MaxInflight(t *testing.T) {
+	defer os.Unsetenv(ec2MaxInflightEnvVar)
+
+	os.Unsetenv(ec2MaxInflightEnvVar)
+	assert.Equal(t, 0, loadEC2MaxInflight())
+
+	os.Setenv(ec2MaxInflightEnvVar, "8")
+	assert.Equal(t, 8, loadEC2MaxInflight())
+
+	os.Setenv(ec2MaxInflightEnvVar, "-1")
+	assert.Equal(t, 0, loadEC2MaxInflight())
+}
+
+// inflightTestHandlers returns handlers limited by a limiter of maxInflight calls, whose calls run send instead of
+// going to EC2
+func inflightTestHandlers(maxInflight int, send func()) request.Handlers {
+	handlers := defaults.Handlers()
+	newInflightLimiter(maxInflight).install(&handlers)
+	handlers.Send.Clear()
+	handlers.Send.PushBack(func(r *request.Request) {
+		send()
+		r.HTTPResponse = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}
+	})
+	handlers.Unmarshal.Clear()
+	handlers.UnmarshalMeta.Clear()
+	handlers.ValidateResponse.Clear()
+	return handlers
+}
+
+func newInflightTestRequest(handlers request.Handlers) *request.Request {
+	return request.New(aws.Config{Region: aws.String("us-west-2")},
+		metadata.ClientInfo{ServiceName: "ec2", Endpoint: "https://ec2.us-west-2.amazonaws.com"},
+		handlers, client.DefaultRetryer{}, &request.Operation{Name: "AssignPrivateIpAddresses", HTTPMethod: "POST", HTTPPath: "/"},
+		nil, nil)
+}
+
+func TestInflightLimiterCapsCalls(t *testing.T) {
+	const (
+		calls       = 12
+		maxInflight = 3
+	)
+	var inflight, peak int64
+	handlers := inflightTestHandlers(maxInflight, func() {
+		n := atomic.AddInt64(&inflight, 1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		assert.LessOrEqual(t, testutil.ToFloat64(ec2InflightCalls), float64(maxInflight))
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt64(&inflight, -1)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, newInflightTestRequest(handlers).Send())
+		}()
+	}
+	wg.Wait()
+
+	// The calls above the cap waited their turn, none failed
+	assert.Equal(t, int64(maxInflight), peak)
+	assert.Equal(t, float64(0), testutil.ToFloat64(ec2InflightCalls))
+	assert.Equal(t, float64(0), testutil.ToFloat64(ec2QueuedCalls))
+}
+
+func TestInflightLimiterReportsConcurrentCalls(t *testing.T) {
+	const calls = 5
+	var started sync.WaitGroup
+	started.Add(calls)
+	unblock := make(chan struct{})
+	handlers := inflightTestHandlers(0, func() {
+		started.Done()
+		<-unblock
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, newInflightTestRequest(handlers).Send())
+		}()
+	}
+	// Without a cap every call is in flight at once
+	started.Wait()
+	assert.Equal(t, float64(calls), testutil.ToFloat64(ec2InflightCalls))
+
+	close(unblock)
+	wg.Wait()
+	assert.Equal(t, float64(0), testutil.ToFloat64(ec2InflightCalls))
+}
+
+func TestInflightLimiterQueuedCallCanceled(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	handlers := inflightTestHandlers(1, func() {
+		close(started)
+		<-unblock
+	})
+	done := make(chan error)
+	go func() {
+		done <- newInflightTestRequest(handlers).Send()
+	}()
+	<-started
+
+	// The slot is taken, the second call waits until its context is done and is never sent
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r := newInflightTestRequest(handlers)
+	r.SetContext(ctx)
+	err := r.Send()
+	if aerr, ok := err.(awserr.Error); assert.True(t, ok) {
+		assert.Equal(t, request.CanceledErrorCode, aerr.Code())
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(ec2InflightCalls))
+	assert.Equal(t, float64(0), testutil.ToFloat64(ec2QueuedCalls))
+
+	close(unblock)
+	assert.NoError(t, <-done)
+	assert.Equal(t, float64(0), testutil.ToFloat64(ec2InflightCalls))
+}
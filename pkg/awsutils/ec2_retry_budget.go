@@ -0,0 +1,151 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/retry"
+)
+
+const (
+	// ec2RetryBudgetEnvVar is the number of retries the AWS SDK and ipamd's own retry loops may make per minute across
+	// all the EC2 calls, so a broad EC2 degradation doesn't turn into a retry storm. Once it is spent, failing calls are
+	// not retried and return their error right away. 0 leaves every call its own retries.
+	ec2RetryBudgetEnvVar = "EC2_RETRY_BUDGET_PER_MINUTE"
+	maxEC2RetryBudget    = 100000
+)
+
+func loadEC2RetryBudget() int {
+	inputStr, found := os.LookupEnv(ec2RetryBudgetEnvVar)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && input <= maxEC2RetryBudget {
+		log.Debugf("Using %s %v", ec2RetryBudgetEnvVar, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, must be between 0 and %d, not limiting the EC2 retries", ec2RetryBudgetEnvVar,
+		inputStr, maxEC2RetryBudget)
+	return 0
+}
+
+// retryBudget is a token bucket shared by the EC2 calls of the session it is installed on, every retry takes a token
+type retryBudget struct {
+	lock            sync.Mutex
+	now             func() time.Time
+	capacity        float64
+	refillPerSecond float64
+	tokens          float64
+	last            time.Time
+}
+
+// newRetryBudget returns a full budget of perMinute retries, refilled at perMinute retries per minute
+func newRetryBudget(perMinute int, now func() time.Time) *retryBudget {
+	return &retryBudget{
+		now:             now,
+		capacity:        float64(perMinute),
+		refillPerSecond: float64(perMinute) / 60,
+		tokens:          float64(perMinute),
+		last:            now(),
+	}
+}
+
+// take returns true and takes a token if one is left
+func (b *retryBudget) take() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.refillPerSecond
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// install gates the retries of the handlers' calls on the budget. It runs before the SDK's retry handler, which then
+// keeps the decision.
+func (b *retryBudget) install(handlers *request.Handlers) {
+	handlers.AfterRetry.PushFrontNamed(request.NamedHandler{
+		Name: "amazon-vpc-cni-k8s/ec2-retry-budget",
+		Fn:   b.gate,
+	})
+}
+
+func (b *retryBudget) gate(r *request.Request) {
+	if r.Error == nil {
+		return
+	}
+	if r.Retryable == nil {
+		r.Retryable = aws.Bool(r.ShouldRetry(r))
+	}
+	if !aws.BoolValue(r.Retryable) || r.RetryCount >= r.MaxRetries() || b.take() {
+		return
+	}
+	api := ""
+	if r.Operation != nil {
+		api = r.Operation.Name
+	}
+	log.Warnf("Not retrying %s, the EC2 retry budget of %s is exhausted: %v", api, ec2RetryBudgetEnvVar, r.Error)
+	ec2RetriesDenied.WithLabelValues(api).Inc()
+	r.Retryable = aws.Bool(false)
+}
+
+// retryBudgetExhaustedError stops a retry.NWithBackoff loop once the budget is spent, with the error of the last try
+type retryBudgetExhaustedError struct {
+	error
+}
+
+var _ retry.RetriableError = retryBudgetExhaustedError{}
+
+func (e retryBudgetExhaustedError) Retry() bool {
+	return false
+}
+
+func (e retryBudgetExhaustedError) Cause() error {
+	return e.error
+}
+
+func (e retryBudgetExhaustedError) Unwrap() error {
+	return e.error
+}
+
+// gateRetries wraps fn, an EC2 call retried by a retry.NWithBackoff loop, so that each of its retries takes a token
+// too. The first try is free, like the SDK's. A nil budget returns fn.
+func (b *retryBudget) gateRetries(api string, fn func() error) func() error {
+	if b == nil {
+		return fn
+	}
+	var lastErr error
+	return func() error {
+		if lastErr != nil && !b.take() {
+			log.Warnf("Not retrying %s, the EC2 retry budget of %s is exhausted: %v", api, ec2RetryBudgetEnvVar, lastErr)
+			ec2RetriesDenied.WithLabelValues(api).Inc()
+			return retryBudgetExhaustedError{lastErr}
+		}
+		lastErr = fn()
+		return lastErr
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_loadEC2RetryBudget(t *testing.T) {
+	defer os.Unsetenv(ec2RetryBudgetEnvVar)
+
+	os.Unsetenv(ec2RetryBudgetEnvVar)
+	assert.Equal(t, 0, loadEC2RetryBudget())
+
+	os.Setenv(ec2RetryBudgetEnvVar, "120")
+	assert.Equal(t, 120, loadEC2RetryBudget())
+
+	os.Setenv(ec2RetryBudgetEnvVar, "-1")
+	assert.Equal(t, 0, loadEC2RetryBudget())
+}
+
+func TestRetryBudgetRefill(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	budget := newRetryBudget(60, func() time.Time { return now })
+	for i := 0; i < 60; i++ {
+		assert.True(t, budget.take())
+	}
+	assert.False(t, budget.take())
+
+	// One retry a second comes back, never more than the budget
+	now = now.Add(2 * time.Second)
+	assert.True(t, budget.take())
+	assert.True(t, budget.take())
+	assert.False(t, budget.take())
+	now = now.Add(time.Hour)
+	for i := 0; i < 60; i++ {
+		assert.True(t, budget.take())
+	}
+	assert.False(t, budget.take())
+}
+
+func TestRetryBudgetLimitsRetriesAcrossCalls(t *testing.T) {
+	const (
+		calls      = 10
+		maxRetries = 3
+		budget     = 5
+	)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	handlers := defaults.Handlers()
+	newRetryBudget(budget, func() time.Time { return now }).install(&handlers)
+	var attempts int64
+	handlers.Send.Clear()
+	handlers.Send.PushBack(func(r *request.Request) {
+		atomic.AddInt64(&attempts, 1)
+		r.HTTPResponse = &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Body: http.NoBody}
+		r.Error = awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+	})
+	denied := testutil.ToFloat64(ec2RetriesDenied.WithLabelValues("AssignPrivateIpAddresses"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg := aws.Config{Region: aws.String("us-west-2"), SleepDelay: func(time.Duration) {}}
+			r := request.New(cfg, metadata.ClientInfo{ServiceName: "ec2", Endpoint: "https://ec2.us-west-2.amazonaws.com"},
+				handlers, client.DefaultRetryer{NumMaxRetries: maxRetries},
+				&request.Operation{Name: "AssignPrivateIpAddresses", HTTPMethod: "POST", HTTPPath: "/"}, nil, nil)
+			err := r.Send()
+			// The calls out of budget fail fast with the throttling error
+			if aerr, ok := err.(awserr.Error); assert.True(t, ok) {
+				assert.Equal(t, "RequestLimitExceeded", aerr.Code())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Without the budget every call would be retried maxRetries times
+	assert.Equal(t, int64(calls+budget), attempts)
+	// Every call is denied a retry, but the ones that got all of theirs from the budget
+	denied = testutil.ToFloat64(ec2RetriesDenied.WithLabelValues("AssignPrivateIpAddresses")) - denied
+	assert.GreaterOrEqual(t, denied, float64(calls-budget/maxRetries))
+	assert.LessOrEqual(t, denied, float64(calls))
+}
+
+func TestRetryBudgetGatesRetryLoops(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, ec2RetryBudget: newRetryBudget(1, func() time.Time { return now })}
+	denied := testutil.ToFloat64(ec2RetriesDenied.WithLabelValues("DeleteNetworkInterface"))
+
+	// The first try and one retry, then the budget is spent
+	deleteErr := errors.New("RequestLimitExceeded")
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, deleteErr).Times(2)
+	err := ins.deleteENI("test-eni", time.Millisecond)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, deleteErr))
+	assert.Equal(t, float64(1), testutil.ToFloat64(ec2RetriesDenied.WithLabelValues("DeleteNetworkInterface"))-denied)
+
+	// Without a budget the loop keeps its own retries
+	ins.ec2RetryBudget = nil
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, deleteErr).Times(maxENIEC2APIRetries)
+	assert.Error(t, ins.deleteENI("test-eni", time.Millisecond))
+}
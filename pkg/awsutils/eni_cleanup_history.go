@@ -0,0 +1,163 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+const (
+	// eniCleanupHistoryFileEnvVar is the file the leaked ENI cleanup history is persisted to, so it survives ipamd
+	// restarts. The history is only kept in memory when it is not set.
+	eniCleanupHistoryFileEnvVar = "ENI_CLEANUP_HISTORY_FILE"
+	// eniCleanupHistorySize is the number of most recent deletions kept in the history
+	eniCleanupHistorySize = 100
+
+	// ENICleanupReasonLeaked is an available ENI created by the CNI that was left behind, e.g. by a terminated instance
+	ENICleanupReasonLeaked = "leaked"
+	// ENICleanupReasonLeakedBranch is a branch ENI whose trunk ENI no longer exists
+	ENICleanupReasonLeakedBranch = "leaked_branch"
+)
+
+// ENICleanupRecord describes an ENI deleted by the leaked ENI cleanup
+type ENICleanupRecord struct {
+	ENIID    string            `json:"eniID"`
+	SubnetID string            `json:"subnetID"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Time     time.Time         `json:"time"`
+	Reason   string            `json:"reason"`
+}
+
+// newENICleanupRecord builds the history record of a deleted leaked ENI
+func newENICleanupRecord(networkInterface *ec2.NetworkInterface, now time.Time) ENICleanupRecord {
+	reason := ENICleanupReasonLeaked
+	if aws.StringValue(networkInterface.InterfaceType) == "branch" {
+		reason = ENICleanupReasonLeakedBranch
+	}
+	var tags map[string]string
+	if len(networkInterface.TagSet) > 0 {
+		tags = make(map[string]string, len(networkInterface.TagSet))
+		for _, tag := range networkInterface.TagSet {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return ENICleanupRecord{
+		ENIID:    aws.StringValue(networkInterface.NetworkInterfaceId),
+		SubnetID: aws.StringValue(networkInterface.SubnetId),
+		Tags:     tags,
+		Time:     now,
+		Reason:   reason,
+	}
+}
+
+// eniCleanupHistory keeps the most recent leaked ENI deletions, oldest first, and optionally persists them to a file
+type eniCleanupHistory struct {
+	lock    sync.Mutex
+	size    int
+	path    string
+	records []ENICleanupRecord
+}
+
+// newENICleanupHistory returns a history of at most size records. When path is set, the records persisted by a
+// previous run are loaded from it; a missing or unreadable file starts an empty history.
+func newENICleanupHistory(path string, size int) *eniCleanupHistory {
+	history := &eniCleanupHistory{size: size, path: path}
+	if path == "" {
+		return history
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Failed to read the ENI cleanup history from %s: %v", path, err)
+		}
+		return history
+	}
+	if err := json.Unmarshal(data, &history.records); err != nil {
+		log.Warnf("Ignoring the invalid ENI cleanup history in %s: %v", path, err)
+		history.records = nil
+		return history
+	}
+	history.trimUnsafe()
+	return history
+}
+
+// add appends a record, evicting the oldest one when the history is full
+func (h *eniCleanupHistory) add(record ENICleanupRecord) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.records = append(h.records, record)
+	h.trimUnsafe()
+	if h.path != "" {
+		if err := h.persistUnsafe(); err != nil {
+			log.Warnf("Failed to persist the ENI cleanup history: %v", err)
+		}
+	}
+}
+
+// list returns a copy of the records, oldest first
+func (h *eniCleanupHistory) list() []ENICleanupRecord {
+	if h == nil {
+		return nil
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	records := make([]ENICleanupRecord, len(h.records))
+	copy(records, h.records)
+	return records
+}
+
+func (h *eniCleanupHistory) trimUnsafe() {
+	if len(h.records) > h.size {
+		h.records = append([]ENICleanupRecord(nil), h.records[len(h.records)-h.size:]...)
+	}
+}
+
+// persistUnsafe writes the history to a temporary file next to path and renames it, so a crash never leaves a
+// truncated history behind
+func (h *eniCleanupHistory) persistUnsafe() error {
+	data, err := json.Marshal(h.records)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the ENI cleanup history")
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(h.path), filepath.Base(h.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create a temporary ENI cleanup history file")
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "failed to write the ENI cleanup history")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "failed to write the ENI cleanup history")
+	}
+	return errors.Wrapf(os.Rename(tmpFile.Name(), h.path), "failed to move the ENI cleanup history to %s", h.path)
+}
+
+// GetENICleanupHistory returns the most recent ENIs deleted by the leaked ENI cleanup, oldest first
+func (cache *EC2InstanceMetadataCache) GetENICleanupHistory() []ENICleanupRecord {
+	return cache.eniCleanupHistory.list()
+}
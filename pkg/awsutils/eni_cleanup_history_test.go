@@ -0,0 +1,165 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEC2InstanceMetadataCache_cleanUpLeakedENIsInternalHistory(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	description := eniDescriptionPrefix + "test"
+	createdAt := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	interfaces := []*ec2.NetworkInterface{{
+		NetworkInterfaceId: aws.String(eni2ID),
+		SubnetId:           aws.String(subnetID),
+		Description:        &description,
+		Status:             aws.String(ec2.NetworkInterfaceStatusAvailable),
+		TagSet: []*ec2.Tag{
+			{Key: aws.String(eniNodeTagKey), Value: aws.String(instanceID)},
+			{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(createdAt)},
+		},
+	}}
+	setupDescribeNetworkInterfacesPagesWithContextMock(t, mockEC2, interfaces, nil, 1)
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(&ec2.DeleteNetworkInterfaceOutput{}, nil)
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, eniCleanupHistory: newENICleanupHistory("", eniCleanupHistorySize)}
+	before := time.Now()
+	ins.cleanUpLeakedENIsInternal(time.Millisecond)
+
+	history := ins.GetENICleanupHistory()
+	assert.Len(t, history, 1)
+	assert.Equal(t, eni2ID, history[0].ENIID)
+	assert.Equal(t, subnetID, history[0].SubnetID)
+	assert.Equal(t, map[string]string{eniNodeTagKey: instanceID, eniCreatedAtTagKey: createdAt}, history[0].Tags)
+	assert.Equal(t, ENICleanupReasonLeaked, history[0].Reason)
+	assert.False(t, history[0].Time.Before(before))
+}
+
+func TestEC2InstanceMetadataCache_cleanUpLeakedENIsInternalHistoryDeleteErr(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	description := eniDescriptionPrefix + "test"
+	interfaces := []*ec2.NetworkInterface{{
+		NetworkInterfaceId: aws.String(eni2ID),
+		Description:        &description,
+		TagSet: []*ec2.Tag{
+			{Key: aws.String(eniNodeTagKey), Value: aws.String(instanceID)},
+			{Key: aws.String(eniCreatedAtTagKey), Value: aws.String(time.Now().Add(-time.Hour).Format(time.RFC3339))},
+		},
+	}}
+	setupDescribeNetworkInterfacesPagesWithContextMock(t, mockEC2, interfaces, nil, 1)
+	mockEC2.EXPECT().DeleteNetworkInterfaceWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("boom")).AnyTimes()
+
+	ins := &EC2InstanceMetadataCache{ec2SVC: mockEC2, ec2APIRetries: 1,
+		eniCleanupHistory: newENICleanupHistory("", eniCleanupHistorySize)}
+	ins.cleanUpLeakedENIsInternal(time.Millisecond)
+	// Failed deletes are not recorded
+	assert.Empty(t, ins.GetENICleanupHistory())
+}
+
+func Test_newENICleanupRecordBranch(t *testing.T) {
+	now := time.Now()
+	record := newENICleanupRecord(&ec2.NetworkInterface{
+		NetworkInterfaceId: aws.String("eni-branch"),
+		SubnetId:           aws.String(subnetID),
+		InterfaceType:      aws.String("branch"),
+		TagSet:             []*ec2.Tag{{Key: aws.String(trunkENIIDTagKey), Value: aws.String(eniID)}},
+	}, now)
+	assert.Equal(t, ENICleanupRecord{
+		ENIID:    "eni-branch",
+		SubnetID: subnetID,
+		Tags:     map[string]string{trunkENIIDTagKey: eniID},
+		Time:     now,
+		Reason:   ENICleanupReasonLeakedBranch,
+	}, record)
+}
+
+func Test_eniCleanupHistoryEviction(t *testing.T) {
+	history := newENICleanupHistory("", 3)
+	for i := 0; i < 5; i++ {
+		history.add(ENICleanupRecord{ENIID: fmt.Sprintf("eni-%d", i)})
+	}
+	records := history.list()
+	assert.Len(t, records, 3)
+	assert.Equal(t, "eni-2", records[0].ENIID)
+	assert.Equal(t, "eni-4", records[2].ENIID)
+
+	// The returned slice is a copy
+	records[0].ENIID = "changed"
+	assert.Equal(t, "eni-2", history.list()[0].ENIID)
+}
+
+func Test_eniCleanupHistoryNil(t *testing.T) {
+	var history *eniCleanupHistory
+	history.add(ENICleanupRecord{ENIID: eniID})
+	assert.Empty(t, history.list())
+}
+
+func Test_eniCleanupHistoryPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eni-cleanup-history")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "eni-cleanup-history.json")
+
+	history := newENICleanupHistory(path, 2)
+	assert.Empty(t, history.list())
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		history.add(ENICleanupRecord{ENIID: fmt.Sprintf("eni-%d", i), SubnetID: subnetID, Time: now,
+			Reason: ENICleanupReasonLeaked})
+	}
+
+	// A new history, e.g. after an ipamd restart, picks up the persisted records
+	reloaded := newENICleanupHistory(path, 2)
+	assert.Equal(t, history.list(), reloaded.list())
+	assert.Equal(t, "eni-1", reloaded.list()[0].ENIID)
+
+	// A smaller history keeps the most recent records
+	smaller := newENICleanupHistory(path, 1)
+	assert.Len(t, smaller.list(), 1)
+	assert.Equal(t, "eni-2", smaller.list()[0].ENIID)
+
+	// No temporary files are left behind
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func Test_eniCleanupHistoryInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eni-cleanup-history")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "eni-cleanup-history.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("not json"), 0600))
+
+	history := newENICleanupHistory(path, eniCleanupHistorySize)
+	assert.Empty(t, history.list())
+	history.add(ENICleanupRecord{ENIID: eniID})
+	assert.Len(t, newENICleanupHistory(path, eniCleanupHistorySize).list(), 1)
+}
@@ -0,0 +1,55 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/pkg/errors"
+)
+
+const (
+	// eniQuotaServiceCode and eniQuotaCode name the "Network interfaces per Region" quota in Service Quotas
+	eniQuotaServiceCode = "vpc"
+	eniQuotaCode        = "L-DF5E4CA3"
+)
+
+// serviceQuotas is the part of the Service Quotas API used to look up the account's ENI quota
+type serviceQuotas interface {
+	GetServiceQuotaWithContext(ctx aws.Context, input *servicequotas.GetServiceQuotaInput, opts ...request.Option) (*servicequotas.GetServiceQuotaOutput, error)
+}
+
+// GetENIQuota returns the number of ENIs the account can have in the region
+func (cache *EC2InstanceMetadataCache) GetENIQuota() (int, error) {
+	input := &servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String(eniQuotaServiceCode),
+		QuotaCode:   aws.String(eniQuotaCode),
+	}
+	start := time.Now()
+	output, err := cache.serviceQuotas.GetServiceQuotaWithContext(context.Background(), input)
+	awsAPILatency.WithLabelValues("GetServiceQuota", fmt.Sprint(err != nil), awsReqStatus(err)).Observe(msSince(start))
+	if err != nil {
+		awsAPIErrInc("GetServiceQuota", err)
+		return 0, errors.Wrap(err, "failed to get the ENI quota of the account")
+	}
+	if output.Quota == nil || output.Quota.Value == nil {
+		return 0, errors.New("the ENI quota of the account has no value")
+	}
+	return int(aws.Float64Value(output.Quota.Value)), nil
+}
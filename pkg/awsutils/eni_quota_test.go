@@ -0,0 +1,57 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServiceQuotas answers with a fixed quota, or err
+type fakeServiceQuotas struct {
+	quota *servicequotas.ServiceQuota
+	err   error
+	input *servicequotas.GetServiceQuotaInput
+}
+
+func (f *fakeServiceQuotas) GetServiceQuotaWithContext(_ aws.Context, input *servicequotas.GetServiceQuotaInput, _ ...request.Option) (*servicequotas.GetServiceQuotaOutput, error) {
+	f.input = input
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &servicequotas.GetServiceQuotaOutput{Quota: f.quota}, nil
+}
+
+func TestGetENIQuota(t *testing.T) {
+	quotas := &fakeServiceQuotas{quota: &servicequotas.ServiceQuota{Value: aws.Float64(5000)}}
+	ins := &EC2InstanceMetadataCache{serviceQuotas: quotas}
+	quota, err := ins.GetENIQuota()
+	assert.NoError(t, err)
+	assert.Equal(t, 5000, quota)
+	assert.Equal(t, eniQuotaServiceCode, aws.StringValue(quotas.input.ServiceCode))
+	assert.Equal(t, eniQuotaCode, aws.StringValue(quotas.input.QuotaCode))
+
+	quotas.quota = &servicequotas.ServiceQuota{}
+	_, err = ins.GetENIQuota()
+	assert.Error(t, err)
+
+	quotas.err = errors.New("AccessDeniedException")
+	_, err = ins.GetENIQuota()
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+const (
+	// leakedENIPartialTagPolicyEnvVar decides whether the leaked ENI cleanup deletes the available ENIs that have only
+	// some of the markers of a CNI ENI of this cluster, e.g. left by a failed migration
+	leakedENIPartialTagPolicyEnvVar = "LEAKED_ENI_PARTIAL_TAG_POLICY"
+	// leakedENIExcludeDescriptionEnvVar is a regular expression on the ENI description, the ENIs matching it are never
+	// cleaned up, whatever their tags
+	leakedENIExcludeDescriptionEnvVar = "LEAKED_ENI_EXCLUDE_DESCRIPTION_REGEX"
+)
+
+// leakedENIPartialTagPolicy is what the leaked ENI cleanup does with a partially tagged ENI
+type leakedENIPartialTagPolicy string
+
+const (
+	// leakedENIPolicyConservative skips the partially tagged ENIs
+	leakedENIPolicyConservative leakedENIPartialTagPolicy = "conservative"
+	// leakedENIPolicyAggressive deletes them like the fully tagged ones
+	leakedENIPolicyAggressive leakedENIPartialTagPolicy = "aggressive"
+)
+
+func loadLeakedENIPartialTagPolicy() leakedENIPartialTagPolicy {
+	inputStr, found := os.LookupEnv(leakedENIPartialTagPolicyEnvVar)
+	if !found {
+		return leakedENIPolicyConservative
+	}
+	switch policy := leakedENIPartialTagPolicy(strings.ToLower(inputStr)); policy {
+	case leakedENIPolicyConservative, leakedENIPolicyAggressive:
+		log.Debugf("Using %s %v", leakedENIPartialTagPolicyEnvVar, policy)
+		return policy
+	}
+	log.Warnf("Invalid %s value %q, must be %s or %s, skipping partially tagged ENIs", leakedENIPartialTagPolicyEnvVar,
+		inputStr, leakedENIPolicyConservative, leakedENIPolicyAggressive)
+	return leakedENIPolicyConservative
+}
+
+// loadLeakedENIExcludeDescription compiles the description exclusion of the ENI cleanup, nil if there is none. An
+// invalid expression is an error, rather than a cleanup running without the exclusion it was given.
+func loadLeakedENIExcludeDescription() (*regexp.Regexp, error) {
+	inputStr := os.Getenv(leakedENIExcludeDescriptionEnvVar)
+	if inputStr == "" {
+		return nil, nil
+	}
+	exclude, err := regexp.Compile(inputStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s value %q", leakedENIExcludeDescriptionEnvVar, inputStr)
+	}
+	log.Debugf("Using %s %v", leakedENIExcludeDescriptionEnvVar, inputStr)
+	return exclude, nil
+}
+
+// isExcludedByDescription returns true if the ENI's description matches LEAKED_ENI_EXCLUDE_DESCRIPTION_REGEX, the
+// ENI is then never cleaned up
+func (cache *EC2InstanceMetadataCache) isExcludedByDescription(networkInterface *ec2.NetworkInterface) bool {
+	if cache.leakedENIExcludeDescription == nil {
+		return false
+	}
+	description := aws.StringValue(networkInterface.Description)
+	if !cache.leakedENIExcludeDescription.MatchString(description) {
+		return false
+	}
+	log.Infof("Not cleaning up available ENI %s, its description %q matches %s",
+		aws.StringValue(networkInterface.NetworkInterfaceId), description, leakedENIExcludeDescriptionEnvVar)
+	return true
+}
+
+// isLeakedENICandidate returns true if the available ENI is to be cleaned up as leaked. An ENI with every marker of a
+// CNI ENI of this cluster is, one with none of them or tagged for another cluster is not, and one with only some of
+// them is up to the partial tag policy, the decision being logged. An ENI whose description is excluded never is.
+func (cache *EC2InstanceMetadataCache) isLeakedENICandidate(networkInterface *ec2.NetworkInterface) bool {
+	if cache.isExcludedByDescription(networkInterface) {
+		return false
+	}
+	tags := convertSDKTagsToTags(networkInterface.TagSet)
+	expected := 2
+	var missing []string
+	if !strings.HasPrefix(aws.StringValue(networkInterface.Description), eniDescriptionPrefix) {
+		missing = append(missing, "description "+eniDescriptionPrefix)
+	}
+	if tags[eniNodeTagKey] == "" {
+		missing = append(missing, "tag "+eniNodeTagKey)
+	}
+	if cache.clusterName != "" {
+		expected++
+		cluster, ok := tags[eniClusterTagKey]
+		if ok && cluster != cache.clusterName {
+			return false
+		}
+		if !ok {
+			missing = append(missing, "tag "+eniClusterTagKey)
+		}
+	}
+	if len(missing) == 0 {
+		return true
+	}
+	if len(missing) == expected {
+		return false
+	}
+
+	policy := cache.leakedENIPartialTagPolicy
+	if policy == "" {
+		policy = leakedENIPolicyConservative
+	}
+	candidate := policy == leakedENIPolicyAggressive
+	decision := "skipping it"
+	if candidate {
+		decision = "cleaning it up"
+	}
+	log.Infof("Available ENI %s has only some of the CNI markers, missing %s, %s partial tag policy: %s",
+		aws.StringValue(networkInterface.NetworkInterfaceId), strings.Join(missing, ", "), policy, decision)
+	return candidate
+}
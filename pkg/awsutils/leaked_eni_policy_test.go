@@ -0,0 +1,190 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package awsutils
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_loadLeakedENIPartialTagPolicy(t *testing.T) {
+	defer os.Unsetenv(leakedENIPartialTagPolicyEnvVar)
+
+	os.Unsetenv(leakedENIPartialTagPolicyEnvVar)
+	assert.Equal(t, leakedENIPolicyConservative, loadLeakedENIPartialTagPolicy())
+
+	os.Setenv(leakedENIPartialTagPolicyEnvVar, "Aggressive")
+	assert.Equal(t, leakedENIPolicyAggressive, loadLeakedENIPartialTagPolicy())
+
+	os.Setenv(leakedENIPartialTagPolicyEnvVar, "delete-all")
+	assert.Equal(t, leakedENIPolicyConservative, loadLeakedENIPartialTagPolicy())
+}
+
+func TestEC2InstanceMetadataCache_isLeakedENICandidate(t *testing.T) {
+	const cluster = "cluster-1"
+	eni := func(description string, tags map[string]string) *ec2.NetworkInterface {
+		return &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String("eni-1"),
+			Description:        aws.String(description),
+			TagSet:             convertTagsToSDKTags(tags),
+		}
+	}
+	fullyTagged := map[string]string{eniNodeTagKey: instanceID, eniClusterTagKey: cluster}
+	for _, tc := range []struct {
+		name             string
+		eni              *ec2.NetworkInterface
+		wantConservative bool
+		wantAggressive   bool
+	}{
+		{"fully tagged", eni(eniDescriptionPrefix+instanceID, fullyTagged), true, true},
+		{"untagged", eni("created by hand", nil), false, false},
+		{"another description", eni("migrated", fullyTagged), false, true},
+		{"without the cluster tag", eni(eniDescriptionPrefix+instanceID, map[string]string{eniNodeTagKey: instanceID}), false, true},
+		{"another cluster", eni(eniDescriptionPrefix+instanceID,
+			map[string]string{eniNodeTagKey: instanceID, eniClusterTagKey: "cluster-2"}), false, false},
+		{"only the description", eni(eniDescriptionPrefix+instanceID, nil), false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := &EC2InstanceMetadataCache{clusterName: cluster}
+			assert.Equal(t, tc.wantConservative, cache.isLeakedENICandidate(tc.eni))
+
+			cache.leakedENIPartialTagPolicy = leakedENIPolicyAggressive
+			assert.Equal(t, tc.wantAggressive, cache.isLeakedENICandidate(tc.eni))
+		})
+	}
+
+	// Without a cluster name, the cluster tag is not expected
+	cache := &EC2InstanceMetadataCache{}
+	assert.True(t, cache.isLeakedENICandidate(eni(eniDescriptionPrefix+instanceID, map[string]string{eniNodeTagKey: instanceID})))
+}
+
+func TestEC2InstanceMetadataCache_getLeakedENIsPartialTagPolicy(t *testing.T) {
+	hourAgo := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	page := &ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: []*ec2.NetworkInterface{
+		// Left without the cluster tag
+		{
+			NetworkInterfaceId: aws.String("eni-1"),
+			Description:        aws.String(eniDescriptionPrefix + instanceID),
+			TagSet:             convertTagsToSDKTags(map[string]string{eniNodeTagKey: instanceID, eniCreatedAtTagKey: hourAgo}),
+		},
+		// Of another cluster
+		{
+			NetworkInterfaceId: aws.String("eni-2"),
+			Description:        aws.String(eniDescriptionPrefix + instanceID),
+			TagSet: convertTagsToSDKTags(map[string]string{eniNodeTagKey: instanceID, eniCreatedAtTagKey: hourAgo,
+				eniClusterTagKey: "cluster-2"}),
+		},
+		// Of this cluster
+		{
+			NetworkInterfaceId: aws.String("eni-3"),
+			Description:        aws.String(eniDescriptionPrefix + instanceID),
+			TagSet: convertTagsToSDKTags(map[string]string{eniNodeTagKey: instanceID, eniCreatedAtTagKey: hourAgo,
+				eniClusterTagKey: "cluster-1"}),
+		},
+	}}
+	for policy, want := range map[leakedENIPartialTagPolicy][]string{
+		leakedENIPolicyConservative: {"eni-3"},
+		leakedENIPolicyAggressive:   {"eni-1", "eni-3"},
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			ctrl, mockEC2 := setup(t)
+			defer ctrl.Finish()
+
+			mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, input *ec2.DescribeNetworkInterfacesInput,
+					fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+					// The ENIs without the cluster tag are listed for the policy to decide
+					for _, filter := range input.Filters {
+						assert.NotEqual(t, "tag:"+eniClusterTagKey, aws.StringValue(filter.Name))
+					}
+					fn(page, true)
+					return nil
+				})
+
+			cache := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID, clusterName: "cluster-1",
+				leakedENIPartialTagPolicy: policy}
+			got, err := cache.getLeakedENIs()
+			assert.NoError(t, err)
+			var gotIDs []string
+			for _, eni := range got {
+				gotIDs = append(gotIDs, aws.StringValue(eni.NetworkInterfaceId))
+			}
+			assert.Equal(t, want, gotIDs)
+		})
+	}
+}
+
+func Test_loadLeakedENIExcludeDescription(t *testing.T) {
+	defer os.Unsetenv(leakedENIExcludeDescriptionEnvVar)
+
+	os.Unsetenv(leakedENIExcludeDescriptionEnvVar)
+	exclude, err := loadLeakedENIExcludeDescription()
+	assert.NoError(t, err)
+	assert.Nil(t, exclude)
+
+	os.Setenv(leakedENIExcludeDescriptionEnvVar, "^(ELB|EFS) ")
+	exclude, err = loadLeakedENIExcludeDescription()
+	assert.NoError(t, err)
+	assert.NotNil(t, exclude)
+
+	os.Setenv(leakedENIExcludeDescriptionEnvVar, "aws-K8S-(")
+	_, err = loadLeakedENIExcludeDescription()
+	assert.Error(t, err)
+}
+
+func TestEC2InstanceMetadataCache_getLeakedENIsExcludedDescription(t *testing.T) {
+	ctrl, mockEC2 := setup(t)
+	defer ctrl.Finish()
+
+	hourAgo := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	eni := func(id, description string) *ec2.NetworkInterface {
+		return &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(id),
+			Description:        aws.String(description),
+			TagSet:             convertTagsToSDKTags(map[string]string{eniNodeTagKey: instanceID, eniCreatedAtTagKey: hourAgo}),
+		}
+	}
+	page := &ec2.DescribeNetworkInterfacesOutput{NetworkInterfaces: []*ec2.NetworkInterface{
+		eni("eni-1", eniDescriptionPrefix+instanceID),
+		// Fully tagged, but its description marks it as off-limits
+		eni("eni-2", eniDescriptionPrefix+"keep-"+instanceID),
+	}}
+	mockEC2.EXPECT().DescribeNetworkInterfacesPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ *ec2.DescribeNetworkInterfacesInput,
+			fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...interface{}) error {
+			fn(page, true)
+			return nil
+		})
+
+	cache := &EC2InstanceMetadataCache{ec2SVC: mockEC2, instanceID: instanceID,
+		leakedENIExcludeDescription: regexp.MustCompile("^aws-K8S-keep-")}
+	got, err := cache.getLeakedENIs()
+	assert.NoError(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "eni-1", aws.StringValue(got[0].NetworkInterfaceId))
+	}
+
+	// Excluded ENIs are left alone whatever the partial tag policy
+	cache.leakedENIPartialTagPolicy = leakedENIPolicyAggressive
+	assert.False(t, cache.isLeakedENICandidate(eni("eni-2", eniDescriptionPrefix+"keep-"+instanceID)))
+	assert.True(t, cache.isLeakedENICandidate(eni("eni-1", eniDescriptionPrefix+instanceID)))
+}
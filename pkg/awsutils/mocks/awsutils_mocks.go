@@ -165,6 +165,34 @@ func (mr *MockAPIsMockRecorder) GetAttachedENIs() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAttachedENIs", reflect.TypeOf((*MockAPIs)(nil).GetAttachedENIs))
 }
 
+// GetEC2APIStatus mocks base method
+func (m *MockAPIs) GetEC2APIStatus() awsutils.EC2APIStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEC2APIStatus")
+	ret0, _ := ret[0].(awsutils.EC2APIStatus)
+	return ret0
+}
+
+// GetEC2APIStatus indicates an expected call of GetEC2APIStatus
+func (mr *MockAPIsMockRecorder) GetEC2APIStatus() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEC2APIStatus", reflect.TypeOf((*MockAPIs)(nil).GetEC2APIStatus))
+}
+
+// GetENICleanupHistory mocks base method
+func (m *MockAPIs) GetENICleanupHistory() []awsutils.ENICleanupRecord {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetENICleanupHistory")
+	ret0, _ := ret[0].([]awsutils.ENICleanupRecord)
+	return ret0
+}
+
+// GetENICleanupHistory indicates an expected call of GetENICleanupHistory
+func (mr *MockAPIsMockRecorder) GetENICleanupHistory() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetENICleanupHistory", reflect.TypeOf((*MockAPIs)(nil).GetENICleanupHistory))
+}
+
 // GetENIIPv4Limit mocks base method
 func (m *MockAPIs) GetENIIPv4Limit() (int, error) {
 	m.ctrl.T.Helper()
@@ -195,6 +223,36 @@ func (mr *MockAPIsMockRecorder) GetENILimit() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetENILimit", reflect.TypeOf((*MockAPIs)(nil).GetENILimit))
 }
 
+// GetENIQuota mocks base method
+func (m *MockAPIs) GetENIQuota() (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetENIQuota")
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetENIQuota indicates an expected call of GetENIQuota
+func (mr *MockAPIsMockRecorder) GetENIQuota() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetENIQuota", reflect.TypeOf((*MockAPIs)(nil).GetENIQuota))
+}
+
+// GetExpectedENIs mocks base method
+func (m *MockAPIs) GetExpectedENIs() ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExpectedENIs")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExpectedENIs indicates an expected call of GetExpectedENIs
+func (mr *MockAPIsMockRecorder) GetExpectedENIs() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExpectedENIs", reflect.TypeOf((*MockAPIs)(nil).GetExpectedENIs))
+}
+
 // GetIPv4PrefixesFromEC2 mocks base method
 func (m *MockAPIs) GetIPv4PrefixesFromEC2(arg0 string) ([]*ec2.Ipv4PrefixSpecification, error) {
 	m.ctrl.T.Helper()
@@ -268,6 +326,21 @@ func (mr *MockAPIsMockRecorder) GetLocalIPv4() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLocalIPv4", reflect.TypeOf((*MockAPIs)(nil).GetLocalIPv4))
 }
 
+// GetNetworkPerformance mocks base method
+func (m *MockAPIs) GetNetworkPerformance() (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNetworkPerformance")
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNetworkPerformance indicates an expected call of GetNetworkPerformance
+func (mr *MockAPIsMockRecorder) GetNetworkPerformance() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNetworkPerformance", reflect.TypeOf((*MockAPIs)(nil).GetNetworkPerformance))
+}
+
 // GetPrimaryENI mocks base method
 func (m *MockAPIs) GetPrimaryENI() string {
 	m.ctrl.T.Helper()
@@ -296,6 +369,51 @@ func (mr *MockAPIsMockRecorder) GetPrimaryENImac() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPrimaryENImac", reflect.TypeOf((*MockAPIs)(nil).GetPrimaryENImac))
 }
 
+// GetSubnetAvailability mocks base method
+func (m *MockAPIs) GetSubnetAvailability(arg0 string) (awsutils.SubnetAvailability, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSubnetAvailability", arg0)
+	ret0, _ := ret[0].(awsutils.SubnetAvailability)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSubnetAvailability indicates an expected call of GetSubnetAvailability
+func (mr *MockAPIsMockRecorder) GetSubnetAvailability(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubnetAvailability", reflect.TypeOf((*MockAPIs)(nil).GetSubnetAvailability), arg0)
+}
+
+// GetSubnetIPv4CIDR mocks base method
+func (m *MockAPIs) GetSubnetIPv4CIDR(arg0 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSubnetIPv4CIDR", arg0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSubnetIPv4CIDR indicates an expected call of GetSubnetIPv4CIDR
+func (mr *MockAPIsMockRecorder) GetSubnetIPv4CIDR(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSubnetIPv4CIDR", reflect.TypeOf((*MockAPIs)(nil).GetSubnetIPv4CIDR), arg0)
+}
+
+// GetTaggedSubnets mocks base method
+func (m *MockAPIs) GetTaggedSubnets() ([]awsutils.SubnetAvailability, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTaggedSubnets")
+	ret0, _ := ret[0].([]awsutils.SubnetAvailability)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTaggedSubnets indicates an expected call of GetTaggedSubnets
+func (mr *MockAPIsMockRecorder) GetTaggedSubnets() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTaggedSubnets", reflect.TypeOf((*MockAPIs)(nil).GetTaggedSubnets))
+}
+
 // GetVPCIPv4CIDRs mocks base method
 func (m *MockAPIs) GetVPCIPv4CIDRs() ([]string, error) {
 	m.ctrl.T.Helper()
@@ -323,6 +441,20 @@ func (mr *MockAPIsMockRecorder) InitCachedPrefixDelegation(arg0 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InitCachedPrefixDelegation", reflect.TypeOf((*MockAPIs)(nil).InitCachedPrefixDelegation), arg0)
 }
 
+// IsAuditMode mocks base method
+func (m *MockAPIs) IsAuditMode() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsAuditMode")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsAuditMode indicates an expected call of IsAuditMode
+func (mr *MockAPIsMockRecorder) IsAuditMode() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsAuditMode", reflect.TypeOf((*MockAPIs)(nil).IsAuditMode))
+}
+
 // IsCNIUnmanagedENI mocks base method
 func (m *MockAPIs) IsCNIUnmanagedENI(arg0 string) bool {
 	m.ctrl.T.Helper()
@@ -17,15 +17,42 @@ package cri
 import (
 	"context"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
 )
 
-const (
+var (
+	// criSocketPath and dockerSocketPath are the candidate sockets, the CRI one first
 	criSocketPath    = "unix:///var/run/cri.sock"
 	dockerSocketPath = "unix:///var/run/dockershim.sock"
+
+	// sandboxStatesTimeout bounds GetPodSandboxStates, called from the reconciler that must not hang on a stuck runtime
+	sandboxStatesTimeout = 10 * time.Second
+
+	criSocketSelected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_cri_socket_selected",
+			Help: "1 for the container runtime socket the pod sandboxes are listed from, 0 for the other candidate",
+		},
+		[]string{"socket"},
+	)
+	prometheusRegistered = false
+)
+
+const (
+	// envRuntimeHandlerAllowList is a comma separated list of the runtime handlers whose sandboxes are the only ones
+	// listed, all of them when empty
+	envRuntimeHandlerAllowList = "CRI_RUNTIME_HANDLER_ALLOW_LIST"
+	// envRuntimeHandlerDenyList is a comma separated list of the runtime handlers whose sandboxes are never listed
+	envRuntimeHandlerDenyList = "CRI_RUNTIME_HANDLER_DENY_LIST"
+	// defaultRuntimeHandler is the name in the lists of the handler of the sandboxes that report none
+	defaultRuntimeHandler = "default"
 )
 
 // SandboxInfo provides container information
@@ -34,29 +61,133 @@ type SandboxInfo struct {
 	IP string
 }
 
+// SandboxState is whether a sandbox is running, or still around while it is stopped or being created
+type SandboxState int
+
+const (
+	// SandboxReady is a running sandbox
+	SandboxReady SandboxState = iota
+	// SandboxNotReady is a sandbox the runtime still knows of but that is not running
+	SandboxNotReady
+)
+
 // APIs is the CRI interface
 type APIs interface {
 	GetRunningPodSandboxes(log logger.Logger) ([]*SandboxInfo, error)
+	GetPodSandboxStates(log logger.Logger) (map[string]SandboxState, error)
 }
 
-// Client is an empty struct
-type Client struct{}
+// Client lists the sandboxes of the runtime handlers it is scoped to
+type Client struct {
+	runtimeHandlers runtimeHandlerFilter
+
+	socketLock sync.Mutex
+	// socketPath is the socket last selected, to log when it changes
+	socketPath string
+}
+
+func prometheusRegister() {
+	if !prometheusRegistered {
+		prometheus.MustRegister(criSocketSelected)
+		prometheusRegistered = true
+	}
+}
 
 // New creates a new CRI client
 func New() *Client {
-	return &Client{}
+	prometheusRegister()
+	return &Client{
+		runtimeHandlers: newRuntimeHandlerFilter(os.Getenv(envRuntimeHandlerAllowList), os.Getenv(envRuntimeHandlerDenyList)),
+	}
 }
 
-//GetRunningPodSandboxes get running sandboxIDs
-func (c *Client) GetRunningPodSandboxes(log logger.Logger) ([]*SandboxInfo, error) {
-	ctx := context.TODO()
+// runtimeHandlerFilter tells which runtime handlers the sandboxes are listed of
+type runtimeHandlerFilter struct {
+	allowed map[string]bool
+	denied  map[string]bool
+}
+
+func newRuntimeHandlerFilter(allowList, denyList string) runtimeHandlerFilter {
+	return runtimeHandlerFilter{allowed: parseRuntimeHandlers(allowList), denied: parseRuntimeHandlers(denyList)}
+}
+
+func parseRuntimeHandlers(list string) map[string]bool {
+	handlers := make(map[string]bool)
+	for _, handler := range strings.Split(list, ",") {
+		if handler = strings.TrimSpace(handler); handler != "" {
+			handlers[handler] = true
+		}
+	}
+	return handlers
+}
+
+// includes returns whether the sandboxes of the runtime handler are listed. The deny list wins over the allow list.
+func (f runtimeHandlerFilter) includes(handler string) bool {
+	if handler == "" {
+		handler = defaultRuntimeHandler
+	}
+	if f.denied[handler] {
+		return false
+	}
+	return len(f.allowed) == 0 || f.allowed[handler]
+}
+
+// socketFile returns the file of a unix socket path
+func socketFile(socketPath string) string {
+	return strings.TrimPrefix(socketPath, "unix://")
+}
 
+func socketExists(socketPath string) bool {
+	info, err := os.Stat(socketFile(socketPath))
+	return err == nil && !info.IsDir()
+}
+
+// selectSocket returns the CRI socket, or the dockershim one when there is no CRI socket, and sets the
+// awscni_cri_socket_selected metric. The first selection and any later change are logged, the fallback to the
+// dockershim socket as a warning since on a containerd node it usually means the CRI socket isn't mounted.
+func (c *Client) selectSocket(log logger.Logger) string {
 	socketPath := dockerSocketPath
-	if info, err := os.Stat("/var/run/cri.sock"); err == nil && !info.IsDir() {
+	if socketExists(criSocketPath) {
 		socketPath = criSocketPath
 	}
-	log.Debugf("Getting running pod sandboxes from %q", socketPath)
-	conn, err := grpc.Dial(socketPath, grpc.WithInsecure(), grpc.WithNoProxy(), grpc.WithBlock())
+	for _, candidate := range []string{criSocketPath, dockerSocketPath} {
+		if candidate == socketPath {
+			criSocketSelected.WithLabelValues(candidate).Set(1)
+		} else {
+			criSocketSelected.WithLabelValues(candidate).Set(0)
+		}
+	}
+
+	c.socketLock.Lock()
+	defer c.socketLock.Unlock()
+	if socketPath == c.socketPath {
+		return socketPath
+	}
+	c.socketPath = socketPath
+	switch {
+	case socketPath == criSocketPath:
+		log.Infof("Listing the pod sandboxes from the CRI socket %s", socketPath)
+	case socketExists(dockerSocketPath):
+		log.Warnf("No CRI socket at %s, falling back to the dockershim socket %s", socketFile(criSocketPath), socketPath)
+	default:
+		log.Warnf("Neither the CRI socket %s nor the dockershim socket %s exist, the pod sandboxes can't be listed",
+			socketFile(criSocketPath), socketFile(dockerSocketPath))
+	}
+	return socketPath
+}
+
+// dial connects to the CRI socket, or to the dockershim one when there is no CRI socket
+func (c *Client) dial(ctx context.Context, log logger.Logger) (*grpc.ClientConn, error) {
+	socketPath := c.selectSocket(log)
+	log.Debugf("Getting pod sandboxes from %q", socketPath)
+	return grpc.DialContext(ctx, socketPath, grpc.WithInsecure(), grpc.WithNoProxy(), grpc.WithBlock())
+}
+
+//GetRunningPodSandboxes get running sandboxIDs
+func (c *Client) GetRunningPodSandboxes(log logger.Logger) ([]*SandboxInfo, error) {
+	ctx := context.TODO()
+
+	conn, err := c.dial(ctx, log)
 	if err != nil {
 		return nil, err
 	}
@@ -89,24 +220,82 @@ func (c *Client) GetRunningPodSandboxes(log logger.Logger) ([]*SandboxInfo, erro
 			log.Debugf("Ignoring sandbox %s in unready state %s", sandbox.Id, state)
 			continue
 		}
-
-		if netmode := status.GetStatus().GetLinux().GetNamespaces().GetOptions().GetNetwork(); netmode != runtimeapi.NamespaceMode_POD {
-			log.Debugf("Ignoring sandbox %s with non-pod netns mode %s", sandbox.Id, netmode)
+		if handler := status.GetStatus().GetRuntimeHandler(); !c.runtimeHandlers.includes(handler) {
+			log.Infof("Ignoring sandbox %s of excluded runtime handler %q", sandbox.Id, handler)
 			continue
 		}
 
-		ips := []string{status.GetStatus().GetNetwork().GetIp()}
-		for _, ip := range status.GetStatus().GetNetwork().GetAdditionalIps() {
-			ips = append(ips, ip.GetIp())
+		sandboxInfos = append(sandboxInfos, sandboxInfosOf(log, sandbox.GetId(), status.GetStatus())...)
+	}
+	return sandboxInfos, nil
+}
+
+// GetPodSandboxStates returns the state of every sandbox the runtime knows of, by sandbox ID. Unlike
+// GetRunningPodSandboxes, the sandboxes that are not ready and the ones of excluded runtime handlers are listed too: a
+// sandbox missing from it is gone from the node, not merely restarting. It gives up after sandboxStatesTimeout.
+func (c *Client) GetPodSandboxStates(log logger.Logger) (map[string]SandboxState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sandboxStatesTimeout)
+	defer cancel()
+
+	conn, err := c.dial(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	sandboxes, err := runtimeapi.NewRuntimeServiceClient(conn).ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return sandboxStatesOf(sandboxes.GetItems()), nil
+}
+
+func sandboxStatesOf(sandboxes []*runtimeapi.PodSandbox) map[string]SandboxState {
+	states := make(map[string]SandboxState, len(sandboxes))
+	for _, sandbox := range sandboxes {
+		if sandbox.GetState() == runtimeapi.PodSandboxState_SANDBOX_READY {
+			states[sandbox.GetId()] = SandboxReady
+		} else {
+			states[sandbox.GetId()] = SandboxNotReady
 		}
+	}
+	return states
+}
 
-		for _, ip := range ips {
-			info := SandboxInfo{
-				ID: sandbox.GetId(),
-				IP: ip,
-			}
-			sandboxInfos = append(sandboxInfos, &info)
+// sandboxInfosOf returns the IPs of a ready sandbox. Only the sandboxes in the host network namespace are left out,
+// by their netns mode or their lack of a pod IP. Runtime handlers such as gVisor or Kata may report no netns mode, or
+// another mode than POD, for sandboxes that do have their own network namespace, so those are kept rather than missed
+// by the IP reconciliation.
+func sandboxInfosOf(log logger.Logger, sandboxID string, status *runtimeapi.PodSandboxStatus) []*SandboxInfo {
+	options := status.GetLinux().GetNamespaces().GetOptions()
+	switch netmode := options.GetNetwork(); {
+	case options == nil:
+		log.Infof("Sandbox %s of runtime handler %q reports no netns mode, keeping it", sandboxID, status.GetRuntimeHandler())
+	case netmode == runtimeapi.NamespaceMode_NODE:
+		log.Debugf("Ignoring sandbox %s with host netns mode %s", sandboxID, netmode)
+		return nil
+	case netmode != runtimeapi.NamespaceMode_POD:
+		log.Infof("Sandbox %s of runtime handler %q reports netns mode %s, keeping it", sandboxID,
+			status.GetRuntimeHandler(), netmode)
+	}
+
+	ips := []string{status.GetNetwork().GetIp()}
+	for _, ip := range status.GetNetwork().GetAdditionalIps() {
+		ips = append(ips, ip.GetIp())
+	}
+
+	sandboxInfos := make([]*SandboxInfo, 0, len(ips))
+	for _, ip := range ips {
+		if ip == "" {
+			// Sandboxes in the host network namespace report no IP of their own
+			log.Debugf("Ignoring sandbox %s without a pod IP, it is in the host network namespace", sandboxID)
+			continue
 		}
+		info := SandboxInfo{
+			ID: sandboxID,
+			IP: ip,
+		}
+		sandboxInfos = append(sandboxInfos, &info)
 	}
-	return sandboxInfos, nil
+	return sandboxInfos
 }
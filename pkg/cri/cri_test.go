@@ -0,0 +1,174 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cri
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+)
+
+var testLog = logger.New(&logger.Configuration{LogLevel: "Debug", LogLocation: "stdout"})
+
+func sandboxStatus(handler string, linux *runtimeapi.LinuxPodSandboxStatus) *runtimeapi.PodSandboxStatus {
+	return &runtimeapi.PodSandboxStatus{
+		RuntimeHandler: handler,
+		Linux:          linux,
+		Network: &runtimeapi.PodSandboxNetworkStatus{
+			Ip:            "10.0.0.1",
+			AdditionalIps: []*runtimeapi.PodIP{{Ip: "10.0.0.2"}},
+		},
+	}
+}
+
+func withNetworkMode(mode runtimeapi.NamespaceMode) *runtimeapi.LinuxPodSandboxStatus {
+	return &runtimeapi.LinuxPodSandboxStatus{
+		Namespaces: &runtimeapi.Namespace{Options: &runtimeapi.NamespaceOption{Network: mode}},
+	}
+}
+
+func TestSandboxInfosOf(t *testing.T) {
+	both := []*SandboxInfo{{ID: "sandbox", IP: "10.0.0.1"}, {ID: "sandbox", IP: "10.0.0.2"}}
+	for _, tc := range []struct {
+		name   string
+		status *runtimeapi.PodSandboxStatus
+		want   []*SandboxInfo
+	}{
+		{"pod netns", sandboxStatus("runc", withNetworkMode(runtimeapi.NamespaceMode_POD)), both},
+		{"host netns", sandboxStatus("runc", withNetworkMode(runtimeapi.NamespaceMode_NODE)), nil},
+		{"container netns", sandboxStatus("kata", withNetworkMode(runtimeapi.NamespaceMode_CONTAINER)), both},
+		{"unknown netns mode", sandboxStatus("runsc", withNetworkMode(runtimeapi.NamespaceMode(42))), both},
+		{"no linux status", sandboxStatus("runsc", nil), both},
+		{"no namespace options", sandboxStatus("kata", &runtimeapi.LinuxPodSandboxStatus{Namespaces: &runtimeapi.Namespace{}}), both},
+		{"no netns mode nor pod IP", &runtimeapi.PodSandboxStatus{RuntimeHandler: "runsc"}, []*SandboxInfo{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, sandboxInfosOf(testLog, "sandbox", tc.status))
+		})
+	}
+}
+
+func TestRuntimeHandlerFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		allowList string
+		denyList  string
+		want      map[string]bool
+	}{
+		{"no lists", "", "", map[string]bool{"runc": true, "runsc": true, "": true}},
+		{"deny list", "", "runsc, kata", map[string]bool{"runc": true, "runsc": false, "kata": false, "": true}},
+		{"allow list", "runc,default", "", map[string]bool{"runc": true, "runsc": false, "": true}},
+		{"deny wins", "runc,runsc", "runsc", map[string]bool{"runc": true, "runsc": false, "": false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filter := newRuntimeHandlerFilter(tc.allowList, tc.denyList)
+			for handler, want := range tc.want {
+				assert.Equal(t, want, filter.includes(handler), "runtime handler %q", handler)
+			}
+		})
+	}
+}
+
+func TestSandboxStatesOf(t *testing.T) {
+	states := sandboxStatesOf([]*runtimeapi.PodSandbox{
+		{Id: "running", State: runtimeapi.PodSandboxState_SANDBOX_READY},
+		{Id: "stopped", State: runtimeapi.PodSandboxState_SANDBOX_NOTREADY, RuntimeHandler: "runsc"},
+	})
+	assert.Equal(t, map[string]SandboxState{"running": SandboxReady, "stopped": SandboxNotReady}, states)
+}
+
+// recordingLogger keeps the info and warning messages
+type recordingLogger struct {
+	logger.Logger
+	infos    []string
+	warnings []string
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+func TestSelectSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cri")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	criSocket, dockerSocket := filepath.Join(dir, "cri.sock"), filepath.Join(dir, "dockershim.sock")
+	defer func(cri, docker string) { criSocketPath, dockerSocketPath = cri, docker }(criSocketPath, dockerSocketPath)
+	criSocketPath, dockerSocketPath = "unix://"+criSocket, "unix://"+dockerSocket
+	selected := func(socketPath string) float64 {
+		return testutil.ToFloat64(criSocketSelected.WithLabelValues(socketPath))
+	}
+	c := New()
+
+	// Neither socket exists
+	log := &recordingLogger{Logger: testLog}
+	assert.Equal(t, dockerSocketPath, c.selectSocket(log))
+	assert.Equal(t, float64(0), selected(criSocketPath))
+	assert.Equal(t, float64(1), selected(dockerSocketPath))
+	if assert.Len(t, log.warnings, 1) {
+		assert.Contains(t, log.warnings[0], "Neither the CRI socket")
+	}
+
+	// Only the dockershim socket exists, the fallback is a warning
+	assert.NoError(t, ioutil.WriteFile(dockerSocket, nil, 0600))
+	c = New()
+	log = &recordingLogger{Logger: testLog}
+	assert.Equal(t, dockerSocketPath, c.selectSocket(log))
+	assert.Equal(t, float64(1), selected(dockerSocketPath))
+	if assert.Len(t, log.warnings, 1) {
+		assert.Equal(t, fmt.Sprintf("No CRI socket at %s, falling back to the dockershim socket %s", criSocket, dockerSocketPath),
+			log.warnings[0])
+	}
+	// The same selection is only logged once
+	c.selectSocket(log)
+	assert.Len(t, log.warnings, 1)
+
+	// The CRI socket shows up, it wins
+	assert.NoError(t, ioutil.WriteFile(criSocket, nil, 0600))
+	assert.Equal(t, criSocketPath, c.selectSocket(log))
+	assert.Equal(t, float64(1), selected(criSocketPath))
+	assert.Equal(t, float64(0), selected(dockerSocketPath))
+	assert.Equal(t, []string{"Listing the pod sandboxes from the CRI socket " + criSocketPath}, log.infos)
+	assert.Len(t, log.warnings, 1)
+}
+
+func TestGetPodSandboxStatesTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cri")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// A file nothing listens on, the dial never completes
+	criSocket := filepath.Join(dir, "cri.sock")
+	assert.NoError(t, ioutil.WriteFile(criSocket, nil, 0600))
+	defer func(cri string, timeout time.Duration) { criSocketPath, sandboxStatesTimeout = cri, timeout }(criSocketPath, sandboxStatesTimeout)
+	criSocketPath, sandboxStatesTimeout = "unix://"+criSocket, 100*time.Millisecond
+
+	start := time.Now()
+	states, err := (&Client{}).GetPodSandboxStates(testLog)
+	assert.Error(t, err)
+	assert.Nil(t, states)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
@@ -49,6 +49,21 @@ func (m *MockAPIs) EXPECT() *MockAPIsMockRecorder {
 	return m.recorder
 }
 
+// GetPodSandboxStates mocks base method
+func (m *MockAPIs) GetPodSandboxStates(arg0 logger.Logger) (map[string]cri.SandboxState, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPodSandboxStates", arg0)
+	ret0, _ := ret[0].(map[string]cri.SandboxState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPodSandboxStates indicates an expected call of GetPodSandboxStates
+func (mr *MockAPIsMockRecorder) GetPodSandboxStates(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPodSandboxStates", reflect.TypeOf((*MockAPIs)(nil).GetPodSandboxStates), arg0)
+}
+
 // GetRunningPodSandboxes mocks base method
 func (m *MockAPIs) GetRunningPodSandboxes(arg0 logger.Logger) ([]*cri.SandboxInfo, error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,122 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
+)
+
+const (
+	// envEnableENIConfigAnnotation annotates the node with the name of the ENIConfig ipamd resolved for it
+	envEnableENIConfigAnnotation = "ENABLE_ENI_CONFIG_ANNOTATION"
+	// activeENIConfigAnnotation is the node annotation with the name of the ENIConfig ipamd uses for new ENIs
+	activeENIConfigAnnotation = "vpc.amazonaws.com/active-eni-config"
+	// activeENIConfigCheckInterval is how often ipamd resolves the node's ENIConfig again, to pick up a relabeled node
+	activeENIConfigCheckInterval = time.Minute
+)
+
+func enableENIConfigAnnotation() bool {
+	return getEnvBoolWithDefault(envEnableENIConfigAnnotation, false)
+}
+
+// ActiveENIConfig is the ENIConfig the node resolves to, which new ENIs are created from
+type ActiveENIConfig struct {
+	Name string
+	// Since is when ipamd first resolved the node to this ENIConfig
+	Since time.Time
+}
+
+// activeENIConfigTracker keeps the ENIConfig the node last resolved to. The zero value is ready to use.
+type activeENIConfigTracker struct {
+	lock sync.Mutex
+	// annotate also records the name on the node, it is set by ENABLE_ENI_CONFIG_ANNOTATION
+	annotate  bool
+	lastCheck time.Time
+	active    *ActiveENIConfig
+	// annotated is the name last written to the node annotation
+	annotated string
+}
+
+// refreshActiveENIConfig resolves the node's ENIConfig again, every activeENIConfigCheckInterval, and records it when
+// the selection changed. It only applies with custom networking.
+func (c *IPAMContext) refreshActiveENIConfig(ctx context.Context, now time.Time) {
+	t := &c.activeENIConfig
+	if !c.useCustomNetworking || now.Sub(t.lastCheck) < activeENIConfigCheckInterval {
+		return
+	}
+	t.lastCheck = now
+
+	name, err := eniconfig.GetNodeSpecificENIConfigName(ctx, c.cachedK8SClient)
+	if err != nil {
+		log.Warnf("Failed to resolve the ENIConfig of the node: %v", err)
+		return
+	}
+	t.lock.Lock()
+	if t.active == nil || t.active.Name != name {
+		if t.active == nil {
+			log.Infof("The node uses ENIConfig %s", name)
+		} else {
+			log.Infof("The node switched from ENIConfig %s to %s, new ENIs are created from it", t.active.Name, name)
+		}
+		t.active = &ActiveENIConfig{Name: name, Since: now}
+	}
+	annotate := t.annotate && t.annotated != name
+	t.lock.Unlock()
+
+	// A failed update is retried on the next check
+	if annotate && c.setNodeAnnotation(ctx, activeENIConfigAnnotation, name) == nil {
+		t.annotated = name
+	}
+}
+
+func (t *activeENIConfigTracker) status() *ActiveENIConfig {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.active == nil {
+		return nil
+	}
+	active := *t.active
+	return &active
+}
+
+// setNodeAnnotation sets the annotation of the node to value
+func (c *IPAMContext) setNodeAnnotation(ctx context.Context, key, value string) error {
+	var node corev1.Node
+	if err := c.cachedK8SClient.Get(ctx, types.NamespacedName{Name: c.myNodeName}, &node); err != nil {
+		log.Errorf("Failed to get node: %v", err)
+		return err
+	}
+	if node.Annotations[key] == value {
+		return nil
+	}
+
+	updateNode := node.DeepCopy()
+	if updateNode.Annotations == nil {
+		updateNode.Annotations = make(map[string]string)
+	}
+	updateNode.Annotations[key] = value
+	if err := c.cachedK8SClient.Update(ctx, updateNode); err != nil {
+		log.Errorf("Failed to update node %s with annotation %q: %q, error: %v", c.myNodeName, key, value, err)
+		return err
+	}
+	log.Debugf("Updated node %s with annotation %q: %q", c.myNodeName, key, value)
+	return nil
+}
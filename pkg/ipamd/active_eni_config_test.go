@@ -0,0 +1,95 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRefreshActiveENIConfig(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	_ = os.Setenv("MY_NODE_NAME", myNodeName)
+	fakeNode := v1.Node{
+		TypeMeta:   metav1.TypeMeta{Kind: "Node"},
+		ObjectMeta: metav1.ObjectMeta{Name: myNodeName, Labels: map[string]string{"k8s.amazonaws.com/eniConfig": "az1"}},
+	}
+	assert.NoError(t, m.cachedK8SClient.Create(ctx, &fakeNode))
+	annotation := func() string {
+		var node v1.Node
+		assert.NoError(t, m.cachedK8SClient.Get(ctx, types.NamespacedName{Name: myNodeName}, &node))
+		return node.Annotations[activeENIConfigAnnotation]
+	}
+
+	c := &IPAMContext{
+		cachedK8SClient:     m.cachedK8SClient,
+		myNodeName:          myNodeName,
+		useCustomNetworking: true,
+		activeENIConfig:     activeENIConfigTracker{annotate: true},
+	}
+	now := time.Now()
+	c.refreshActiveENIConfig(ctx, now)
+	assert.Equal(t, &ActiveENIConfig{Name: "az1", Since: now}, c.activeENIConfig.status())
+	assert.Equal(t, "az1", annotation())
+
+	// The node is relabeled, it is picked up on the next check
+	var node v1.Node
+	assert.NoError(t, m.cachedK8SClient.Get(ctx, types.NamespacedName{Name: myNodeName}, &node))
+	node.Labels["k8s.amazonaws.com/eniConfig"] = "az2"
+	assert.NoError(t, m.cachedK8SClient.Update(ctx, &node))
+	c.refreshActiveENIConfig(ctx, now.Add(time.Second))
+	assert.Equal(t, "az1", c.activeENIConfig.status().Name)
+
+	switched := now.Add(activeENIConfigCheckInterval)
+	c.refreshActiveENIConfig(ctx, switched)
+	assert.Equal(t, &ActiveENIConfig{Name: "az2", Since: switched}, c.activeENIConfig.status())
+	assert.Equal(t, "az2", annotation())
+
+	// The same ENIConfig keeps the time it was first resolved
+	c.refreshActiveENIConfig(ctx, switched.Add(activeENIConfigCheckInterval))
+	assert.Equal(t, switched, c.activeENIConfig.status().Since)
+}
+
+func TestRefreshActiveENIConfigWithoutAnnotation(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	_ = os.Setenv("MY_NODE_NAME", myNodeName)
+	fakeNode := v1.Node{TypeMeta: metav1.TypeMeta{Kind: "Node"}, ObjectMeta: metav1.ObjectMeta{Name: myNodeName}}
+	assert.NoError(t, m.cachedK8SClient.Create(ctx, &fakeNode))
+
+	c := &IPAMContext{cachedK8SClient: m.cachedK8SClient, myNodeName: myNodeName, useCustomNetworking: true}
+	c.refreshActiveENIConfig(ctx, time.Now())
+	// Without a label nor annotation the node uses the default ENIConfig
+	assert.Equal(t, "default", c.activeENIConfig.status().Name)
+	var node v1.Node
+	assert.NoError(t, m.cachedK8SClient.Get(ctx, types.NamespacedName{Name: myNodeName}, &node))
+	assert.NotContains(t, node.Annotations, activeENIConfigAnnotation)
+
+	// Nothing is resolved without custom networking
+	c = &IPAMContext{cachedK8SClient: m.cachedK8SClient, myNodeName: myNodeName}
+	c.refreshActiveENIConfig(ctx, time.Now())
+	assert.Nil(t, c.activeENIConfig.status())
+}
@@ -0,0 +1,36 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"time"
+)
+
+const (
+	// addIPSourceWarmPool is an IP the datastore had free when the ADD came in
+	addIPSourceWarmPool = "warm_pool"
+	// addIPSourceQueued is an IP the ADD waited for under IP_EXHAUSTION_POLICY=queue, freed by another pod or added
+	// by a pool increase
+	addIPSourceQueued = "queued"
+	// addIPSourceBranchENI is the IP of the branch ENI the VPC resource controller attached for the pod
+	addIPSourceBranchENI = "branch_eni"
+	// addIPSourceFailed is an ADD that returned no IP
+	addIPSourceFailed = "failed"
+)
+
+// observeAddIPLatency records the time from an ADD starting at start to its IP being returned in addIPLatency. A
+// retry of a failed ADD is measured on its own, with the source of the IP it got.
+func observeAddIPLatency(start time.Time, source string) {
+	addIPLatency.WithLabelValues(source).Observe(time.Since(start).Seconds())
+}
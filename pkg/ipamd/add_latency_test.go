@@ -0,0 +1,177 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+// addIPLatencyCount returns the number of ADDs recorded for the source
+func addIPLatencyCount(t *testing.T, source string) uint64 {
+	return collectHistogram(t, addIPLatency.WithLabelValues(source).(prometheus.Histogram)).GetSampleCount()
+}
+
+// addIPLatencyCounts returns the number of ADDs recorded for each source
+func addIPLatencyCounts(t *testing.T) map[string]uint64 {
+	counts := make(map[string]uint64)
+	for _, source := range []string{addIPSourceWarmPool, addIPSourceQueued, addIPSourceBranchENI, addIPSourceFailed} {
+		counts[source] = addIPLatencyCount(t, source)
+	}
+	return counts
+}
+
+// assertAddIPLatencyRecorded checks that only the source got one more ADD recorded since before
+func assertAddIPLatencyRecorded(t *testing.T, before map[string]uint64, source string) {
+	expected := make(map[string]uint64)
+	for s, count := range before {
+		expected[s] = count
+	}
+	expected[source]++
+	assert.Equal(t, expected, addIPLatencyCounts(t))
+}
+
+func TestServer_AddNetworkRecordsWarmPoolLatency(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 1, false, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.100"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		networkClient: m.network,
+		dataStore:     ds,
+	}
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil).AnyTimes()
+	m.network.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	rpcServer := server{version: "1.2.3", ipamContext: mockContext}
+	addReq := exhaustedTestAddRequest()
+
+	// The IP comes from the warm pool
+	before := addIPLatencyCounts(t)
+	resp, err := rpcServer.AddNetwork(context.TODO(), addReq)
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assertAddIPLatencyRecorded(t, before, addIPSourceWarmPool)
+
+	// The pool is exhausted
+	addReq.K8S_POD_NAME = "pod-3"
+	addReq.ContainerID = "cid-3"
+	before = addIPLatencyCounts(t)
+	resp, err = rpcServer.AddNetwork(context.TODO(), addReq)
+	assert.NoError(t, err)
+	assert.False(t, resp.Success)
+	assertAddIPLatencyRecorded(t, before, addIPSourceFailed)
+
+	// The retry that succeeds once the pool was increased is measured on its own, and found a free IP
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.101"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	before = addIPLatencyCounts(t)
+	resp, err = rpcServer.AddNetwork(context.TODO(), addReq)
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assertAddIPLatencyRecorded(t, before, addIPSourceWarmPool)
+}
+
+func TestServer_AddNetworkRecordsQueuedLatency(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	rpcServer := exhaustedTestServer(t, m, ipExhaustionPolicyQueue, 10*time.Second)
+
+	// An IP is added while the ADD is waiting
+	go func() {
+		time.Sleep(3 * ipExhaustionQueuePollInterval)
+		assert.NoError(t, rpcServer.ipamContext.dataStore.AddIPv4CidrToStore("eni-1",
+			net.IPNet{IP: net.ParseIP("192.168.1.101"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}()
+	before := addIPLatencyCounts(t)
+	resp, err := rpcServer.AddNetwork(context.TODO(), exhaustedTestAddRequest())
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assertAddIPLatencyRecorded(t, before, addIPSourceQueued)
+	assert.GreaterOrEqual(t, collectHistogram(t, addIPLatency.WithLabelValues(addIPSourceQueued).(prometheus.Histogram)).GetSampleSum(),
+		(3 * ipExhaustionQueuePollInterval).Seconds())
+}
+
+func TestServer_AddNetworkRecordsBranchENILatency(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-trunk", 1, false, true, false))
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		networkClient: m.network,
+		rawK8SClient:  m.rawK8SClient,
+		dataStore:     ds,
+		enablePodENI:  true,
+	}
+	assert.NoError(t, m.rawK8SClient.Create(context.TODO(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-2",
+			Namespace: "default",
+			Annotations: map[string]string{"vpc.amazonaws.com/pod-eni": `[{"eniId":"eni-branch","ifAddress":"0a:00:00:00:00:01",` +
+				`"privateIp":"192.168.1.50","vlanID":1,"subnetCidr":"192.168.1.0/24"}]`},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{"vpc.amazonaws.com/pod-eni": resource.MustParse("1")}}}}},
+	}))
+	m.awsutils.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{{ENIID: "eni-trunk", MAC: "0a:00:00:00:00:00"}}, nil)
+	m.network.EXPECT().GetLinkByMac("0a:00:00:00:00:00", gomock.Any()).Return(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 3}}, nil)
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil).AnyTimes()
+	m.network.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	rpcServer := server{version: "1.2.3", ipamContext: mockContext}
+	before := addIPLatencyCounts(t)
+	resp, err := rpcServer.AddNetwork(context.TODO(), exhaustedTestAddRequest())
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, int32(1), resp.PodVlanId)
+	assertAddIPLatencyRecorded(t, before, addIPSourceBranchENI)
+}
+
+func TestServer_AddNetworkLatencyStartsOnceSandboxIsLocked(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	rpcServer := exhaustedTestServer(t, m, ipExhaustionPolicyReject, time.Minute)
+	assert.NoError(t, rpcServer.ipamContext.dataStore.AddIPv4CidrToStore("eni-1",
+		net.IPNet{IP: net.ParseIP("192.168.1.101"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+
+	// Another request of the sandbox holds its lock for a while
+	unlock := rpcServer.ipamContext.sandboxLocks.lockSandbox("cid-2")
+	go func() {
+		time.Sleep(time.Second)
+		unlock()
+	}()
+	sum := collectHistogram(t, addIPLatency.WithLabelValues(addIPSourceWarmPool).(prometheus.Histogram)).GetSampleSum()
+	resp, err := rpcServer.AddNetwork(context.TODO(), exhaustedTestAddRequest())
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Less(t, collectHistogram(t, addIPLatency.WithLabelValues(addIPSourceWarmPool).(prometheus.Histogram)).GetSampleSum()-sum,
+		0.5)
+}
@@ -0,0 +1,29 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+// skipInAuditMode returns true when AUDIT_MODE is on, after logging what fn would have done. The pool management
+// checks it before changing the datastore for a change to AWS resources, since the awsutils calls would only return
+// awsutils.ErrAuditMode and send it down its failure paths.
+func (c *IPAMContext) skipInAuditMode(fn string, format string, args ...interface{}) bool {
+	if !c.auditMode {
+		return false
+	}
+	awsutils.RecordAuditModeSkip(fn, format, args...)
+	return true
+}
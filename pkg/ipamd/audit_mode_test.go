@@ -0,0 +1,86 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// ipamdErrCount returns the number of errors counted for all the functions of ipamd
+func ipamdErrCount(t *testing.T) float64 {
+	ch := make(chan prometheus.Metric, 100)
+	ipamdErr.Collect(ch)
+	close(ch)
+	count := 0.0
+	for m := range ch {
+		metric := &dto.Metric{}
+		assert.NoError(t, m.Write(metric))
+		count += metric.GetCounter().GetValue()
+	}
+	return count
+}
+
+func TestAuditModeLeavesDatastoreUnchanged(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	assert.NoError(t, ds.AddENI(secENIid, secDevice, false, false, false))
+	for _, ip := range []string{ipaddr11, ipaddr12} {
+		assert.NoError(t, ds.AddIPv4CidrToStore(secENIid, net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+	// No call to EC2 is expected on the AWS client mock
+	mockContext := &IPAMContext{
+		awsClient:              m.awsutils,
+		networkClient:          m.network,
+		dataStore:              ds,
+		maxENI:                 4,
+		maxIPsPerENI:           14,
+		warmIPTarget:           1,
+		auditMode:              true,
+		reconcileCooldownCache: ReconcileCooldownCache{cache: make(map[string]time.Time)},
+	}
+	mockContext.pendingPodSubnets.Store(secSubnet, struct{}{})
+	errCount := ipamdErrCount(t)
+
+	// The pool is over its warm target
+	assert.True(t, mockContext.isDatastorePoolTooHigh())
+	mockContext.decreaseDatastorePool(0)
+	mockContext.tryFreeENI()
+	mockContext.tryUnassignIPFromENI(secENIid)
+	mockContext.allocatePodSubnetENIs(context.Background())
+
+	// Then short of it
+	mockContext.warmIPTarget = 10
+	assert.True(t, mockContext.isDatastorePoolTooLow())
+	mockContext.increaseDatastorePool(context.Background())
+
+	total, assigned, _ := ds.GetStats()
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 0, assigned)
+	assert.Equal(t, 2, ds.GetENIs())
+	assert.Len(t, ds.FreeableIPs(secENIid), 2)
+	_, pending := mockContext.pendingPodSubnets.Load(secSubnet)
+	assert.True(t, pending)
+	assert.Equal(t, errCount, ipamdErrCount(t))
+	assert.Nil(t, mockContext.warmTargetMiss.status())
+}
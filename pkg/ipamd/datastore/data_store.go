@@ -15,8 +15,10 @@ package datastore
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -30,10 +32,18 @@ const (
 	// minENILifeTime is the shortest time before we consider deleting a newly created ENI
 	minENILifeTime = 1 * time.Minute
 
+	// pendingENIGracePeriod is how long a secondary ENI without any IP or prefix is kept as pending, so IP assignment
+	// can be retried, before it can be deleted like any other unused ENI
+	pendingENIGracePeriod = 5 * time.Minute
+
 	// addressCoolingPeriod is used to ensure an IP not get assigned to a Pod if this IP is used by a different Pod
 	// in addressCoolingPeriod
 	addressCoolingPeriod = 30 * time.Second
 
+	// minReclaimPasses is the number of consecutive reclaim passes that must see a CIDR unassigned before it can be
+	// freed, when a reclaim minimum age is set
+	minReclaimPasses = 2
+
 	// DuplicatedENIError is an error when caller tries to add an duplicate ENI to data store
 	DuplicatedENIError = "data store: duplicate ENI"
 
@@ -51,6 +61,9 @@ const (
 
 	// UnknownENIError is an error when caller tries to access an ENI which is unknown to datastore
 	UnknownENIError = "datastore: unknown ENI"
+
+	// IPReservedForHostError is an error when caller tries to add an IP address that is reserved for the host
+	IPReservedForHostError = "datastore: IP is reserved for the host"
 )
 
 // We need to know which IPs are already allocated across
@@ -81,6 +94,16 @@ const backfillNetworkIface = "unknown"
 // ErrUnknownPod is an error when there is no pod in data store matching pod name, namespace, sandbox id
 var ErrUnknownPod = errors.New("datastore: unknown pod")
 
+// ErrNoAvailableIPs is an error when there is no free IP in data store for a pod
+var ErrNoAvailableIPs = errors.New("assignPodIPv4AddressUnsafe: no available IP/Prefix addresses")
+
+const (
+	// IPAllocationModeSecondaryIP is the mode where pods get secondary IPs of the ENIs
+	IPAllocationModeSecondaryIP = "secondary-ip"
+	// IPAllocationModeIPv4Prefix is the prefix delegation mode, where pods get IPs from /28 prefixes of the ENIs
+	IPAllocationModeIPv4Prefix = "ipv4-prefix"
+)
+
 var (
 	enis = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -112,6 +135,27 @@ var (
 			Help: "The number of IPs force removed while they had assigned pods",
 		},
 	)
+	totalIPsPerENIConfig = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_eniconfig_total_ip_addresses",
+			Help: "The total number of IP addresses per ENIConfig and subnet the ENIs were created under",
+		},
+		[]string{"eniconfig", "subnet"},
+	)
+	assignedIPsPerENIConfig = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_eniconfig_assigned_ip_addresses",
+			Help: "The number of IP addresses assigned to pods per ENIConfig and subnet the ENIs were created under",
+		},
+		[]string{"eniconfig", "subnet"},
+	)
+	ipAllocationMode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_ip_allocation_mode",
+			Help: "The active IP allocation mode, 1 for the mode in use and 0 for the others",
+		},
+		[]string{"mode"},
+	)
 	prometheusRegistered = false
 )
 
@@ -139,14 +183,25 @@ type ENI struct {
 	// AWS ENI ID
 	ID         string
 	createTime time.Time
+	// idleSince is when the ENI was added or last had a pod IP unassigned, for the warm ENI reclaim dwell
+	idleSince time.Time
+	// AttachedTime is when the ENI was attached, or added to the datastore when EC2 didn't tell
+	AttachedTime time.Time
 	// IsPrimary indicates whether ENI is a primary ENI
 	IsPrimary bool
 	// IsTrunk indicates whether this ENI is used to provide pods with dedicated ENIs
 	IsTrunk bool
 	// IsEFA indicates whether this ENI is tagged as an EFA
 	IsEFA bool
+	// NoNewPods keeps new pods off the ENI, its addresses only serve the pods already using them
+	NoNewPods bool
 	// DeviceNumber is the device number of ENI (0 means the primary ENI)
 	DeviceNumber int
+	// ENIConfig is the name of the ENIConfig the ENI was created from, empty if it does not come from one or was found
+	// attached at startup
+	ENIConfig string
+	// Subnet is the IPv4 CIDR of the subnet the ENI is in
+	Subnet string
 	// IPv4Addresses shows whether each address is assigned, the key is IP address, which must
 	// be in dot-decimal notation with no leading zeros and no whitespace(eg: "10.1.0.253")
 	// Key is the IP address - PD: "IP/28" and SIP: "IP/32"
@@ -157,6 +212,7 @@ type ENI struct {
 type AddressInfo struct {
 	IPAMKey        IPAMKey
 	Address        string
+	AssignedTime   time.Time
 	UnassignedTime time.Time
 }
 
@@ -168,6 +224,41 @@ type CidrInfo struct {
 	IPv4Addresses map[string]*AddressInfo
 	//This block of addresses was allocated through PrefixDelegation
 	IsPrefix bool
+
+	// unassignedSince and unassignedPasses track since when, and by how many reclaim passes, the CIDR has been seen
+	// without any assigned address
+	unassignedSince  time.Time
+	unassignedPasses int
+
+	// releasedFromTotal is set once the address of a secondary IP left over from before prefix delegation was enabled
+	// has been unassigned and taken out of the total, since it is never handed out again
+	releasedFromTotal bool
+}
+
+// countedSize returns how many addresses of the CIDR are counted in the total of the datastore
+func (cidr *CidrInfo) countedSize() int {
+	if cidr.releasedFromTotal {
+		return cidr.Size() - 1
+	}
+	return cidr.Size()
+}
+
+// observeUnassigned records one more reclaim pass seeing the CIDR unassigned, and returns true once it has been
+// unassigned for at least minAge over minReclaimPasses passes. A zero minAge always returns true.
+func (cidr *CidrInfo) observeUnassigned(minAge time.Duration) bool {
+	if minAge == 0 {
+		return true
+	}
+	if cidr.unassignedPasses == 0 {
+		cidr.unassignedSince = time.Now()
+	}
+	cidr.unassignedPasses++
+	return cidr.unassignedPasses >= minReclaimPasses && time.Since(cidr.unassignedSince) >= minAge
+}
+
+func (cidr *CidrInfo) resetUnassignedObservations() {
+	cidr.unassignedSince = time.Time{}
+	cidr.unassignedPasses = 0
 }
 
 func (cidr *CidrInfo) Size() int {
@@ -247,6 +338,8 @@ type PodIPInfo struct {
 	IP string
 	// DeviceNumber is the device number of the ENI
 	DeviceNumber int
+	// AssignedTime is when the IP was assigned to the sandbox
+	AssignedTime time.Time
 }
 
 // DataStore contains node level ENI/IP
@@ -261,6 +354,19 @@ type DataStore struct {
 	backingStore             Checkpointer
 	cri                      cri.APIs
 	isPDEnabled              bool
+	reclaimMinAge            time.Duration
+	// reservedIPv4Addrs are addresses used by the host, such as the node's primary IP, that must never go to a pod
+	reservedIPv4Addrs map[string]bool
+	// now is the clock used to timestamp IP assignments
+	now func() time.Time
+	// maxPodsPerENI caps the number of pods given an IP from the same ENI, 0 means no cap
+	maxPodsPerENI int
+	// ipAssignmentStrategy is how a free address is picked for a pod, see SetIPAssignmentStrategy
+	ipAssignmentStrategy string
+	// random returns a random number in [0, n) for IPAssignmentStrategyRandom
+	random func(n int) int
+	// warmENIReclaimDwell is how long an ENI must be idle before it can be deleted, see SetWarmENIReclaimDwell
+	warmENIReclaimDwell time.Duration
 }
 
 // ENIInfos contains ENI IP information
@@ -269,6 +375,8 @@ type ENIInfos struct {
 	TotalIPs int
 	// assigned is the number of IP addresses that has been assigned
 	AssignedIPs int
+	// IPAllocationMode is the active IP allocation mode, IPAllocationModeSecondaryIP or IPAllocationModeIPv4Prefix
+	IPAllocationMode string
 	// ENIs contains ENI IP pool information
 	ENIs map[string]ENI
 }
@@ -280,6 +388,9 @@ func prometheusRegister() {
 		prometheus.MustRegister(assignedIPs)
 		prometheus.MustRegister(forceRemovedENIs)
 		prometheus.MustRegister(forceRemovedIPs)
+		prometheus.MustRegister(totalIPsPerENIConfig)
+		prometheus.MustRegister(assignedIPsPerENIConfig)
+		prometheus.MustRegister(ipAllocationMode)
 		prometheusRegistered = true
 	}
 }
@@ -287,14 +398,98 @@ func prometheusRegister() {
 // NewDataStore returns DataStore structure
 func NewDataStore(log logger.Logger, backingStore Checkpointer, isPDEnabled bool) *DataStore {
 	prometheusRegister()
-	return &DataStore{
+	ds := &DataStore{
 		eniPool:                  make(ENIPool),
 		log:                      log,
 		backingStore:             backingStore,
 		cri:                      cri.New(),
 		CheckpointMigrationPhase: checkpointMigrationPhase,
 		isPDEnabled:              isPDEnabled,
+		now:                      time.Now,
+		random:                   rand.Intn,
 	}
+	for _, mode := range []string{IPAllocationModeSecondaryIP, IPAllocationModeIPv4Prefix} {
+		active := 0.0
+		if mode == ds.IPAllocationMode() {
+			active = 1
+		}
+		ipAllocationMode.WithLabelValues(mode).Set(active)
+	}
+	return ds
+}
+
+// IPAllocationMode returns the active IP allocation mode
+func (ds *DataStore) IPAllocationMode() string {
+	if ds.isPDEnabled {
+		return IPAllocationModeIPv4Prefix
+	}
+	return IPAllocationModeSecondaryIP
+}
+
+// SetMaxPodsPerENI caps the number of pods given an IP from the same ENI. The free IPs of an ENI at the cap are not
+// assigned and not counted as available by GetStats, so the pool grows with a new ENI instead. Zero disables the cap.
+func (ds *DataStore) SetMaxPodsPerENI(maxPods int) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.maxPodsPerENI = maxPods
+}
+
+// atMaxPodsUnsafe returns true if the ENI already has as many pods as the per-ENI cap allows
+func (ds *DataStore) atMaxPodsUnsafe(eni *ENI) bool {
+	return ds.maxPodsPerENI > 0 && eni.AssignedIPv4Addresses() >= ds.maxPodsPerENI
+}
+
+// SetClock replaces the clock used to timestamp IP assignments
+func (ds *DataStore) SetClock(now func() time.Time) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.now = now
+}
+
+// SetReclaimMinAge sets how long a CIDR must be seen unassigned, over at least minReclaimPasses reclaim passes, before
+// FindFreeableCidrs returns it. Zero disables the guard.
+func (ds *DataStore) SetReclaimMinAge(minAge time.Duration) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.reclaimMinAge = minAge
+}
+
+// SetWarmENIReclaimDwell sets how long an ENI without pods must stay idle, since it was added or since the IP of its
+// last pod was unassigned, before RemoveUnusedENIFromStore can delete it. Zero disables the dwell.
+func (ds *DataStore) SetWarmENIReclaimDwell(dwell time.Duration) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.warmENIReclaimDwell = dwell
+}
+
+// SetReservedIPv4Addresses replaces the set of host addresses that are kept out of the pod pool. A reserved /32 is
+// refused by AddIPv4CidrToStore and reserved addresses inside a prefix are skipped on assignment.
+func (ds *DataStore) SetReservedIPv4Addresses(addrs []string) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.reservedIPv4Addrs = make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		ds.reservedIPv4Addrs[addr] = true
+	}
+}
+
+// GetReservedIPv4Conflicts returns the pods holding an address that is reserved for the host, keyed by the address.
+// Such a pod was assigned the address before it got reserved, for example from a checkpoint, and its routing is broken.
+func (ds *DataStore) GetReservedIPv4Conflicts() map[string]IPAMKey {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	conflicts := make(map[string]IPAMKey)
+	for _, eni := range ds.eniPool {
+		for _, availableCidr := range eni.AvailableIPv4Cidrs {
+			for _, addr := range availableCidr.IPv4Addresses {
+				if addr.Assigned() && ds.reservedIPv4Addrs[addr.Address] {
+					conflicts[addr.Address] = addr.IPAMKey
+				}
+			}
+		}
+	}
+	return conflicts
 }
 
 // CheckpointFormatVersion is the version stamp used on stored checkpoints.
@@ -313,6 +508,9 @@ type CheckpointData struct {
 type CheckpointEntry struct {
 	IPAMKey
 	IPv4 string `json:"ipv4"`
+	// AllocationTimestamp is when the IP was assigned to the sandbox, in Unix seconds. It is missing from the
+	// checkpoints written by older versions.
+	AllocationTimestamp int64 `json:"allocationTimestamp,omitempty"`
 }
 
 // ReadBackingStore initialises the IP allocation state from the
@@ -331,8 +529,17 @@ func (ds *DataStore) ReadBackingStore() error {
 			return err
 		}
 
+		ds.lock.Lock()
+		hostIPs := ds.reservedIPv4Addrs
+		ds.lock.Unlock()
 		entries := make([]CheckpointEntry, 0, len(sandboxes))
 		for _, s := range sandboxes {
+			// A sandbox reporting an address of the host shares the host network namespace, its IP is not from
+			// the pool and must not be counted as in use, even when a prefix covers it
+			if hostIPs[s.IP] {
+				ds.log.Infof("Ignoring sandbox %s using host IPv4 %s, it is in the host network namespace", s.ID, s.IP)
+				continue
+			}
 			entries = append(entries, CheckpointEntry{
 				// NB: These Backfill values are also assumed in UnassignPodIPv4Address
 				IPAMKey: IPAMKey{
@@ -390,6 +597,9 @@ func (ds *DataStore) ReadBackingStore() error {
 					addr := &AddressInfo{Address: ipv4Addr.String()}
 					cidr.IPv4Addresses[allocation.IPv4] = addr
 					ds.assignPodIPv4AddressUnsafe(allocation.IPAMKey, eni, addr)
+					if allocation.AllocationTimestamp != 0 {
+						addr.AssignedTime = time.Unix(allocation.AllocationTimestamp, 0)
+					}
 					ds.log.Debugf("Recovered %s => %s/%s", allocation.IPAMKey, eni.ID, addr.Address)
 					break eniloop
 				}
@@ -422,8 +632,9 @@ func (ds *DataStore) writeBackingStoreUnsafe() error {
 			for _, addr := range assignedAddr.IPv4Addresses {
 				if addr.Assigned() {
 					entry := CheckpointEntry{
-						IPAMKey: addr.IPAMKey,
-						IPv4:    addr.Address,
+						IPAMKey:             addr.IPAMKey,
+						IPv4:                addr.Address,
+						AllocationTimestamp: addr.AssignedTime.Unix(),
 					}
 					allocations = append(allocations, entry)
 				}
@@ -452,6 +663,8 @@ func (ds *DataStore) AddENI(eniID string, deviceNumber int, isPrimary, isTrunk,
 	}
 	ds.eniPool[eniID] = &ENI{
 		createTime:         time.Now(),
+		idleSince:          ds.now(),
+		AttachedTime:       ds.now(),
 		IsPrimary:          isPrimary,
 		IsTrunk:            isTrunk,
 		IsEFA:              isEFA,
@@ -463,6 +676,76 @@ func (ds *DataStore) AddENI(eniID string, deviceNumber int, isPrimary, isTrunk,
 	return nil
 }
 
+// SetENINoNewPods keeps the addresses of the ENI for the pods already using them, no new pod gets one
+func (ds *DataStore) SetENINoNewPods(eniID string) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	eni, ok := ds.eniPool[eniID]
+	if !ok {
+		return errors.New(UnknownENIError)
+	}
+	eni.NoNewPods = true
+	return nil
+}
+
+// SetENIAttachedTime sets when the ENI was attached, for the ENIs that were attached before ipamd started
+func (ds *DataStore) SetENIAttachedTime(eniID string, attachedTime time.Time) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	eni, ok := ds.eniPool[eniID]
+	if !ok {
+		return errors.New(UnknownENIError)
+	}
+	eni.AttachedTime = attachedTime
+	return nil
+}
+
+// SetENISubnet records the IPv4 CIDR of the subnet the ENI is in
+func (ds *DataStore) SetENISubnet(eniID, subnet string) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	eni, ok := ds.eniPool[eniID]
+	if !ok {
+		return errors.New(UnknownENIError)
+	}
+	ds.setENIOriginUnsafe(eni, eni.ENIConfig, subnet)
+	return nil
+}
+
+// SetENIConfig records the name of the ENIConfig the ENI was created from, for the per-ENIConfig IP metrics
+func (ds *DataStore) SetENIConfig(eniID, eniConfig string) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	eni, ok := ds.eniPool[eniID]
+	if !ok {
+		return errors.New(UnknownENIError)
+	}
+	ds.setENIOriginUnsafe(eni, eniConfig, eni.Subnet)
+	return nil
+}
+
+// setENIOriginUnsafe moves the ENI's IPs in the per-ENIConfig IP gauges to its new ENIConfig and subnet
+func (ds *DataStore) setENIOriginUnsafe(eni *ENI, eniConfig, subnet string) {
+	if eni.ENIConfig == eniConfig && eni.Subnet == subnet {
+		return
+	}
+	total := 0
+	for _, cidr := range eni.AvailableIPv4Cidrs {
+		total += cidr.Size()
+	}
+	assigned := eni.AssignedIPv4Addresses()
+	totalIPsPerENIConfig.WithLabelValues(eni.ENIConfig, eni.Subnet).Sub(float64(total))
+	assignedIPsPerENIConfig.WithLabelValues(eni.ENIConfig, eni.Subnet).Sub(float64(assigned))
+	eni.ENIConfig = eniConfig
+	eni.Subnet = subnet
+	totalIPsPerENIConfig.WithLabelValues(eni.ENIConfig, eni.Subnet).Add(float64(total))
+	assignedIPsPerENIConfig.WithLabelValues(eni.ENIConfig, eni.Subnet).Add(float64(assigned))
+}
+
 // AddIPv4AddressToStore add CIDR of an ENI to data store
 func (ds *DataStore) AddIPv4CidrToStore(eniID string, ipv4Cidr net.IPNet, isPrefix bool) error {
 	ds.lock.Lock()
@@ -481,6 +764,10 @@ func (ds *DataStore) AddIPv4CidrToStore(eniID string, ipv4Cidr net.IPNet, isPref
 		ds.log.Infof("IP already in DS")
 		return errors.New(IPAlreadyInStoreError)
 	}
+	if !isPrefix && ds.reservedIPv4Addrs[ipv4Cidr.IP.String()] {
+		ds.log.Warnf("Not adding %s to DS, the address is reserved for the host", strIPv4Cidr)
+		return errors.New(IPReservedForHostError)
+	}
 
 	newCidrInfo := &CidrInfo{
 		Cidr:          ipv4Cidr,
@@ -495,6 +782,7 @@ func (ds *DataStore) AddIPv4CidrToStore(eniID string, ipv4Cidr net.IPNet, isPref
 		ds.allocatedPrefix++
 	}
 	totalIPs.Set(float64(ds.total))
+	totalIPsPerENIConfig.WithLabelValues(curENI.ENIConfig, curENI.Subnet).Add(float64(newCidrInfo.Size()))
 
 	ds.log.Infof("Added ENI(%s)'s IP/Prefix %s to datastore", eniID, strIPv4Cidr)
 	return nil
@@ -528,7 +816,7 @@ func (ds *DataStore) DelIPv4CidrFromStore(eniID string, cidr net.IPNet, force bo
 				return errors.New(IPInUseError)
 			}
 			forceRemovedIPs.Inc()
-			ds.unassignPodIPv4AddressUnsafe(addr)
+			ds.unassignPodIPv4AddressUnsafe(curENI, addr)
 			updateBackingStore = true
 		}
 	}
@@ -538,12 +826,13 @@ func (ds *DataStore) DelIPv4CidrFromStore(eniID string, cidr net.IPNet, force bo
 			// Continuing because 'force'
 		}
 	}
-	ds.total -= deletableCidr.Size()
+	ds.total -= deletableCidr.countedSize()
 	if deletableCidr.IsPrefix {
 		ds.allocatedPrefix--
 	}
 	totalIPs.Set(float64(ds.total))
 	delete(curENI.AvailableIPv4Cidrs, strIPv4Cidr)
+	totalIPsPerENIConfig.WithLabelValues(curENI.ENIConfig, curENI.Subnet).Sub(float64(deletableCidr.Size()))
 	ds.log.Infof("Deleted ENI(%s)'s IP/Prefix %s from datastore", eniID, strIPv4Cidr)
 
 	return nil
@@ -552,6 +841,13 @@ func (ds *DataStore) DelIPv4CidrFromStore(eniID string, cidr net.IPNet, force bo
 // AssignPodIPv4Address assigns an IPv4 address to pod
 // It returns the assigned IPv4 address, device number, error
 func (ds *DataStore) AssignPodIPv4Address(ipamKey IPAMKey) (ipv4address string, deviceNumber int, err error) {
+	return ds.AssignPodIPv4AddressFromSubnet(ipamKey, "")
+}
+
+// AssignPodIPv4AddressFromSubnet assigns an IPv4 address to pod from an ENI in the subnet with the given IPv4 CIDR, or
+// from any ENI if subnet is empty
+// It returns the assigned IPv4 address, device number, error
+func (ds *DataStore) AssignPodIPv4AddressFromSubnet(ipamKey IPAMKey, subnet string) (ipv4address string, deviceNumber int, err error) {
 	ds.lock.Lock()
 	defer ds.lock.Unlock()
 
@@ -562,54 +858,88 @@ func (ds *DataStore) AssignPodIPv4Address(ipamKey IPAMKey) (ipv4address string,
 		return addr.Address, eni.DeviceNumber, nil
 	}
 
+	var eni *ENI
+	var availableCidr *CidrInfo
+	var strPrivateIPv4 string
+	if ds.ipAssignmentStrategy == IPAssignmentStrategyDefault {
+		eni, availableCidr, strPrivateIPv4 = ds.findFreeIPv4AddrUnsafe(subnet)
+	} else {
+		eni, availableCidr, strPrivateIPv4 = ds.selectFreeIPv4AddrUnsafe(subnet)
+	}
+	if eni != nil {
+		if availableCidr.IPv4Addresses == nil {
+			availableCidr.IPv4Addresses = make(map[string]*AddressInfo)
+		}
+		addr := availableCidr.IPv4Addresses[strPrivateIPv4]
+		if addr == nil {
+			// addr is nil when we are using a new IP from prefix or SIP pool
+			// if addr is out of cooldown or not assigned, we can reuse addr
+			addr = &AddressInfo{Address: strPrivateIPv4}
+		}
+
+		availableCidr.IPv4Addresses[strPrivateIPv4] = addr
+		ds.assignPodIPv4AddressUnsafe(ipamKey, eni, addr)
+		availableCidr.resetUnassignedObservations()
+
+		if err := ds.writeBackingStoreUnsafe(); err != nil {
+			ds.log.Warnf("Failed to update backing store: %v", err)
+			// Important! Unwind assignment
+			ds.unassignPodIPv4AddressUnsafe(eni, addr)
+			//Remove the IP from eni DB
+			delete(availableCidr.IPv4Addresses, addr.Address)
+			return "", -1, err
+		}
+		return addr.Address, eni.DeviceNumber, nil
+	}
+
+	if subnet != "" {
+		ds.log.Errorf("DataStore has no available IP/Prefix addresses in subnet %s", subnet)
+		return "", -1, errors.Wrapf(ErrNoAvailableIPs, "subnet %s", subnet)
+	}
+	ds.log.Errorf("DataStore has no available IP/Prefix addresses")
+	return "", -1, ErrNoAvailableIPs
+}
+
+// findFreeIPv4AddrUnsafe returns the first free address of the first ENI that has one, in the order of the ENI and
+// CIDR maps, along with its ENI and CIDR. The ENI is nil if there is no free address.
+func (ds *DataStore) findFreeIPv4AddrUnsafe(subnet string) (*ENI, *CidrInfo, string) {
 	for _, eni := range ds.eniPool {
+		if !ds.eniAssignableUnsafe(eni, subnet) {
+			continue
+		}
 		for _, availableCidr := range eni.AvailableIPv4Cidrs {
-			var addr *AddressInfo
-			var strPrivateIPv4 string
-			var err error
-
 			if (ds.isPDEnabled && availableCidr.IsPrefix) || (!ds.isPDEnabled && !availableCidr.IsPrefix) {
-				strPrivateIPv4, err = ds.getFreeIPv4AddrfromCidr(availableCidr)
+				strPrivateIPv4, err := ds.getFreeIPv4AddrfromCidr(availableCidr)
 				if err != nil {
 					ds.log.Debugf("Unable to get IP address from CIDR: %v", err)
 					//Check in next CIDR
 					continue
 				}
 				ds.log.Debugf("New IP from CIDR pool- %s", strPrivateIPv4)
-				if availableCidr.IPv4Addresses == nil {
-					availableCidr.IPv4Addresses = make(map[string]*AddressInfo)
-				}
-			} else {
-				//This can happen during upgrade or PD enable/disable knob toggle
-				//ENI can have prefixes attached and no space for SIPs or vice versa
-				continue
-			}
-
-			addr = availableCidr.IPv4Addresses[strPrivateIPv4]
-			if addr == nil {
-				// addr is nil when we are using a new IP from prefix or SIP pool
-				// if addr is out of cooldown or not assigned, we can reuse addr
-				addr = &AddressInfo{Address: strPrivateIPv4}
+				return eni, availableCidr, strPrivateIPv4
 			}
-
-			availableCidr.IPv4Addresses[strPrivateIPv4] = addr
-			ds.assignPodIPv4AddressUnsafe(ipamKey, eni, addr)
-
-			if err := ds.writeBackingStoreUnsafe(); err != nil {
-				ds.log.Warnf("Failed to update backing store: %v", err)
-				// Important! Unwind assignment
-				ds.unassignPodIPv4AddressUnsafe(addr)
-				//Remove the IP from eni DB
-				delete(availableCidr.IPv4Addresses, addr.Address)
-				return "", -1, err
-			}
-			return addr.Address, eni.DeviceNumber, nil
+			//This can happen during upgrade or PD enable/disable knob toggle
+			//ENI can have prefixes attached and no space for SIPs or vice versa
 		}
 		ds.log.Debugf("AssignPodIPv4Address: ENI %s does not have available addresses", eni.ID)
 	}
+	return nil, nil, ""
+}
 
-	ds.log.Errorf("DataStore has no available IP/Prefix addresses")
-	return "", -1, errors.New("assignPodIPv4AddressUnsafe: no available IP/Prefix addresses")
+// eniAssignableUnsafe returns true if pods can get an address of the ENI: it takes new pods, is in subnet, when set,
+// and not at the per-ENI pod cap
+func (ds *DataStore) eniAssignableUnsafe(eni *ENI, subnet string) bool {
+	if eni.NoNewPods {
+		return false
+	}
+	if subnet != "" && eni.Subnet != subnet {
+		return false
+	}
+	if ds.atMaxPodsUnsafe(eni) {
+		ds.log.Debugf("AssignPodIPv4Address: ENI %s already has the maximum of %d pods", eni.ID, ds.maxPodsPerENI)
+		return false
+	}
+	return true
 }
 
 // It returns the assigned IPv4 address, device number
@@ -621,15 +951,17 @@ func (ds *DataStore) assignPodIPv4AddressUnsafe(ipamKey IPAMKey, eni *ENI, addr
 		panic("addr already assigned")
 	}
 	addr.IPAMKey = ipamKey // This marks the addr as assigned
+	addr.AssignedTime = ds.now()
 
 	ds.assigned++
 	// Prometheus gauge
 	assignedIPs.Set(float64(ds.assigned))
+	assignedIPsPerENIConfig.WithLabelValues(eni.ENIConfig, eni.Subnet).Inc()
 
 	return addr.Address, eni.DeviceNumber
 }
 
-func (ds *DataStore) unassignPodIPv4AddressUnsafe(addr *AddressInfo) {
+func (ds *DataStore) unassignPodIPv4AddressUnsafe(eni *ENI, addr *AddressInfo) {
 	if !addr.Assigned() {
 		// Already unassigned
 		return
@@ -640,6 +972,39 @@ func (ds *DataStore) unassignPodIPv4AddressUnsafe(addr *AddressInfo) {
 	ds.assigned--
 	// Prometheus gauge
 	assignedIPs.Set(float64(ds.assigned))
+	assignedIPsPerENIConfig.WithLabelValues(eni.ENIConfig, eni.Subnet).Dec()
+}
+
+// GetIPAllocationAges returns how long each assigned IP has been held by its current sandbox
+func (ds *DataStore) GetIPAllocationAges() []time.Duration {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	now := ds.now()
+	ages := make([]time.Duration, 0, ds.assigned)
+	for _, eni := range ds.eniPool {
+		for _, cidr := range eni.AvailableIPv4Cidrs {
+			for _, addr := range cidr.IPv4Addresses {
+				if addr.Assigned() {
+					ages = append(ages, now.Sub(addr.AssignedTime))
+				}
+			}
+		}
+	}
+	return ages
+}
+
+// GetENIAttachmentAges returns the time since each ENI of the datastore was attached, by ENI ID
+func (ds *DataStore) GetENIAttachmentAges() map[string]time.Duration {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	now := ds.now()
+	ages := make(map[string]time.Duration, len(ds.eniPool))
+	for id, eni := range ds.eniPool {
+		ages[id] = now.Sub(eni.AttachedTime)
+	}
+	return ages
 }
 
 // GetStats returns total number of IP addresses, number of assigned IP addresses and total prefixes
@@ -650,13 +1015,26 @@ func (ds *DataStore) GetStats() (int, int, int) {
 	totalIPs := 0
 	assignedIPs := 0
 	for _, eni := range ds.eniPool {
+		eniTotalIPs := 0
+		eniAssignedIPs := 0
 		for _, cidr := range eni.AvailableIPv4Cidrs {
 			if (ds.isPDEnabled && cidr.IsPrefix) || (!ds.isPDEnabled && !cidr.IsPrefix) {
-				assignedIPs += cidr.AssignedIPv4AddressesInCidr()
-				totalIPs += cidr.Size()
+				eniAssignedIPs += cidr.AssignedIPv4AddressesInCidr()
+				eniTotalIPs += cidr.Size()
 			}
 		}
-
+		// Nor can the free IPs of an ENI taking no new pods, or of one at the pod cap
+		if eni.NoNewPods {
+			eniTotalIPs = eniAssignedIPs
+		}
+		if ds.maxPodsPerENI > 0 && eniTotalIPs > ds.maxPodsPerENI {
+			eniTotalIPs = ds.maxPodsPerENI
+			if eniAssignedIPs > eniTotalIPs {
+				eniTotalIPs = eniAssignedIPs
+			}
+		}
+		totalIPs += eniTotalIPs
+		assignedIPs += eniAssignedIPs
 	}
 	return totalIPs, assignedIPs, ds.allocatedPrefix
 }
@@ -758,6 +1136,11 @@ func (ds *DataStore) getDeletableENI(warmIPTarget, minimumIPTarget, warmPrefixTa
 			continue
 		}
 
+		if eni.isPending() {
+			ds.log.Debugf("ENI %s cannot be deleted because it is pending IP assignment", eni.ID)
+			continue
+		}
+
 		if eni.hasIPInCooling() {
 			ds.log.Debugf("ENI %s cannot be deleted because has IPs in cooling", eni.ID)
 			continue
@@ -768,6 +1151,12 @@ func (ds *DataStore) getDeletableENI(warmIPTarget, minimumIPTarget, warmPrefixTa
 			continue
 		}
 
+		if idle := ds.now().Sub(eni.idleSince); idle < ds.warmENIReclaimDwell {
+			ds.log.Debugf("ENI %s cannot be deleted because it has only been idle for %v, the reclaim dwell is %v",
+				eni.ID, idle, ds.warmENIReclaimDwell)
+			continue
+		}
+
 		if warmIPTarget != 0 && ds.isRequiredForWarmIPTarget(warmIPTarget, eni) {
 			ds.log.Debugf("ENI %s cannot be deleted because it is required for WARM_IP_TARGET: %d", eni.ID, warmIPTarget)
 			continue
@@ -804,6 +1193,14 @@ func (e *ENI) isTooYoung() bool {
 	return time.Since(e.createTime) < minENILifeTime
 }
 
+// isPending returns true for a recently attached secondary ENI that does not have any IP or prefix yet
+func (e *ENI) isPending() bool {
+	if e.IsPrimary || e.IsTrunk || e.IsEFA || len(e.AvailableIPv4Cidrs) > 0 {
+		return false
+	}
+	return time.Since(e.createTime) < pendingENIGracePeriod
+}
+
 // HasIPInCooling returns true if an IP address was unassigned recently.
 func (e *ENI) hasIPInCooling() bool {
 	for _, assignedaddr := range e.AvailableIPv4Cidrs {
@@ -839,6 +1236,39 @@ func (ds *DataStore) GetENINeedsIP(maxIPperENI int, skipPrimary bool) *ENI {
 	return nil
 }
 
+// GetENIsNeedIP returns the number of IPs or prefixes of every ENI that has fewer than maxIPperENI, by ENI ID
+func (ds *DataStore) GetENIsNeedIP(maxIPperENI int, skipPrimary bool) map[string]int {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	enis := make(map[string]int)
+	for _, eni := range ds.eniPool {
+		if skipPrimary && eni.IsPrimary {
+			continue
+		}
+		if len(eni.AvailableIPv4Cidrs) < maxIPperENI {
+			enis[eni.ID] = len(eni.AvailableIPv4Cidrs)
+		}
+	}
+	return enis
+}
+
+// GetPendingENIs returns the sorted IDs of the recently attached secondary ENIs that don't have any IP or prefix yet.
+// They don't add anything to the pool, and should get their IPs assigned before another ENI is allocated.
+func (ds *DataStore) GetPendingENIs() []string {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	var pending []string
+	for _, eni := range ds.eniPool {
+		if eni.isPending() {
+			pending = append(pending, eni.ID)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
 // RemoveUnusedENIFromStore removes a deletable ENI from the data store.
 // It returns the name of the ENI which has been removed from the data store and needs to be deleted,
 // or empty string if no ENI could be removed.
@@ -854,10 +1284,11 @@ func (ds *DataStore) RemoveUnusedENIFromStore(warmIPTarget, minimumIPTarget, war
 	removableENI := deletableENI.ID
 
 	for _, availableCidr := range ds.eniPool[removableENI].AvailableIPv4Cidrs {
-		ds.total -= availableCidr.Size()
+		ds.total -= availableCidr.countedSize()
 		if availableCidr.IsPrefix {
 			ds.allocatedPrefix--
 		}
+		totalIPsPerENIConfig.WithLabelValues(deletableENI.ENIConfig, deletableENI.Subnet).Sub(float64(availableCidr.Size()))
 	}
 	ds.log.Infof("RemoveUnusedENIFromStore %s: IP/Prefix address pool stats: free %d addresses, total: %d, assigned: %d, total prefixes: %d",
 		removableENI, len(ds.eniPool[removableENI].AvailableIPv4Cidrs), ds.total, ds.assigned, ds.allocatedPrefix)
@@ -893,13 +1324,9 @@ func (ds *DataStore) RemoveENIFromDataStore(eniID string, force bool) error {
 		for _, assignedaddr := range eni.AvailableIPv4Cidrs {
 			for _, addr := range assignedaddr.IPv4Addresses {
 				if addr.Assigned() {
-					ds.unassignPodIPv4AddressUnsafe(addr)
+					ds.unassignPodIPv4AddressUnsafe(eni, addr)
 				}
 			}
-			ds.total -= assignedaddr.Size()
-			if assignedaddr.IsPrefix {
-				ds.allocatedPrefix--
-			}
 		}
 		if err := ds.writeBackingStoreUnsafe(); err != nil {
 			ds.log.Warnf("Unable to update backing store: %v", err)
@@ -908,10 +1335,11 @@ func (ds *DataStore) RemoveENIFromDataStore(eniID string, force bool) error {
 	}
 
 	for _, assignedaddr := range eni.AvailableIPv4Cidrs {
-		ds.total -= assignedaddr.Size()
+		ds.total -= assignedaddr.countedSize()
 		if assignedaddr.IsPrefix {
 			ds.allocatedPrefix--
 		}
+		totalIPsPerENIConfig.WithLabelValues(eni.ENIConfig, eni.Subnet).Sub(float64(assignedaddr.Size()))
 	}
 
 	ds.log.Infof("RemoveENIFromDataStore %s: IP/Prefix address pool stats: free %d addresses, total: %d, assigned: %d, total prefixes: %d",
@@ -953,16 +1381,18 @@ func (ds *DataStore) UnassignPodIPv4Address(ipamKey IPAMKey) (e *ENI, ip string,
 		return nil, "", 0, ErrUnknownPod
 	}
 
-	ds.unassignPodIPv4AddressUnsafe(addr)
+	ds.unassignPodIPv4AddressUnsafe(eni, addr)
 	if err := ds.writeBackingStoreUnsafe(); err != nil {
 		// Unwind un-assignment
 		ds.assignPodIPv4AddressUnsafe(ipamKey, eni, addr)
 		return nil, "", 0, err
 	}
 	addr.UnassignedTime = time.Now()
+	eni.idleSince = ds.now()
 	if ds.isPDEnabled && !availableCidr.IsPrefix {
 		ds.log.Infof("Prefix delegation is enabled and the IP is from secondary pool hence no need to update prefix pool")
 		ds.total--
+		availableCidr.releasedFromTotal = true
 	}
 
 	ds.log.Infof("UnassignPodIPv4Address: sandbox %s's ipAddr %s, DeviceNumber %d",
@@ -985,6 +1415,7 @@ func (ds *DataStore) AllocatedIPs() []PodIPInfo {
 						IPAMKey:      addr.IPAMKey,
 						IP:           addr.Address,
 						DeviceNumber: eni.DeviceNumber,
+						AssignedTime: addr.AssignedTime,
 					}
 					ret = append(ret, info)
 				}
@@ -1037,15 +1468,51 @@ func (ds *DataStore) FreeablePrefixes(eniID string) []net.IPNet {
 	return freeable
 }
 
+// ModeMismatchedCidrs counts the CIDRs of an ENI that do not match the IP allocation mode of the datastore, i.e.
+// secondary IPs when prefix delegation is enabled and prefixes when it is disabled
+type ModeMismatchedCidrs struct {
+	// Total is the number of mismatched CIDRs
+	Total int
+	// InUse is the number of mismatched CIDRs that still have addresses assigned to pods
+	InUse int
+}
+
+// GetModeMismatchedCidrs returns the ENIs that carry CIDRs left over from the other IP allocation mode, e.g. after
+// prefix delegation was turned on or off. Those CIDRs keep serving the pods already using them but are never used
+// for new pods.
+func (ds *DataStore) GetModeMismatchedCidrs() map[string]ModeMismatchedCidrs {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	mismatched := make(map[string]ModeMismatchedCidrs)
+	for eniID, eni := range ds.eniPool {
+		var stats ModeMismatchedCidrs
+		for _, cidr := range eni.AvailableIPv4Cidrs {
+			if cidr.IsPrefix == ds.isPDEnabled {
+				continue
+			}
+			stats.Total++
+			if cidr.AssignedIPv4AddressesInCidr() > 0 {
+				stats.InUse++
+			}
+		}
+		if stats.Total > 0 {
+			mismatched[eniID] = stats
+		}
+	}
+	return mismatched
+}
+
 // GetENIInfos provides ENI and IP information about the datastore
 func (ds *DataStore) GetENIInfos() *ENIInfos {
 	ds.lock.Lock()
 	defer ds.lock.Unlock()
 
 	var eniInfos = ENIInfos{
-		TotalIPs:    ds.total,
-		AssignedIPs: ds.assigned,
-		ENIs:        make(map[string]ENI, len(ds.eniPool)),
+		TotalIPs:         ds.total,
+		AssignedIPs:      ds.assigned,
+		IPAllocationMode: ds.IPAllocationMode(),
+		ENIs:             make(map[string]ENI, len(ds.eniPool)),
 	}
 
 	for eni, eniInfo := range ds.eniPool {
@@ -1140,7 +1607,7 @@ func (ds *DataStore) getUnusedIP(availableCidr *CidrInfo) (string, error) {
 	//Check if there is any IP out of cooldown
 	var cachedIP string
 	for _, addr := range availableCidr.IPv4Addresses {
-		if !addr.Assigned() && !addr.inCoolingPeriod() {
+		if !addr.Assigned() && !addr.inCoolingPeriod() && !ds.reservedIPv4Addrs[addr.Address] {
 			//if the IP is out of cooldown and not assigned then cache the first available IP
 			//continue cleaning up the DB, this is to avoid stale entries and a new thread :)
 			if cachedIP == "" {
@@ -1163,6 +1630,10 @@ func (ds *DataStore) getUnusedIP(availableCidr *CidrInfo) (string, error) {
 		if _, ok := availableCidr.IPv4Addresses[strPrivateIPv4]; ok {
 			continue
 		}
+		if ds.reservedIPv4Addrs[strPrivateIPv4] {
+			ds.log.Debugf("Skipping %s, the address is reserved for the host", strPrivateIPv4)
+			continue
+		}
 		ds.log.Debugf("Found a free IP not in DB - %s", strPrivateIPv4)
 		return strPrivateIPv4, nil
 	}
@@ -1203,12 +1674,18 @@ func (ds *DataStore) FindFreeableCidrs(eniID string) []CidrInfo {
 	var freeable []CidrInfo
 	for _, assignedaddr := range eni.AvailableIPv4Cidrs {
 		if assignedaddr.AssignedIPv4AddressesInCidr() == 0 {
+			if !assignedaddr.observeUnassigned(ds.reclaimMinAge) {
+				ds.log.Debugf("CIDR %s has not been unassigned for long enough to be freed", assignedaddr.Cidr.String())
+				continue
+			}
 			tempFreeable := CidrInfo{
 				Cidr:          assignedaddr.Cidr,
 				IPv4Addresses: nil,
 				IsPrefix:      assignedaddr.IsPrefix,
 			}
 			freeable = append(freeable, tempFreeable)
+		} else {
+			assignedaddr.resetUnassignedObservations()
 		}
 	}
 	return freeable
@@ -15,12 +15,17 @@ package datastore
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/cri"
+	mock_cri "github.com/aws/amazon-vpc-cni-k8s/pkg/cri/mocks"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	"github.com/golang/mock/gomock"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -233,6 +238,8 @@ func TestDelENIIPv4Address(t *testing.T) {
 func TestPodIPv4Address(t *testing.T) {
 	checkpoint := NewTestCheckpoint(struct{}{})
 	ds := NewDataStore(Testlog, checkpoint, false)
+	assignTime := time.Unix(1600000000, 0)
+	ds.SetClock(func() time.Time { return assignTime })
 
 	err := ds.AddENI("eni-1", 1, true, false, false)
 	assert.NoError(t, err)
@@ -255,7 +262,8 @@ func TestPodIPv4Address(t *testing.T) {
 	assert.Equal(t, checkpoint.Data, &CheckpointData{
 		Version: CheckpointFormatVersion,
 		Allocations: []CheckpointEntry{
-			{IPAMKey: IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"}, IPv4: "1.1.1.1"},
+			{IPAMKey: IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"}, IPv4: "1.1.1.1",
+				AllocationTimestamp: assignTime.Unix()},
 		},
 	})
 
@@ -285,7 +293,8 @@ func TestPodIPv4Address(t *testing.T) {
 	assert.Equal(t, checkpoint.Data, &CheckpointData{
 		Version: CheckpointFormatVersion,
 		Allocations: []CheckpointEntry{
-			{IPAMKey: IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"}, IPv4: "1.1.1.1"},
+			{IPAMKey: IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"}, IPv4: "1.1.1.1",
+				AllocationTimestamp: assignTime.Unix()},
 		},
 	})
 	checkpoint.Error = nil
@@ -416,3 +425,409 @@ func TestWarmENIInteractions(t *testing.T) {
 	assert.Equal(t, "", thirdRemovedEni)
 	assert.Equal(t, 3, ds.GetENIs())
 }
+
+func TestReservedIPv4AddressesExcludedFromPool(t *testing.T) {
+	ds := NewDataStore(Testlog, NullCheckpoint{}, true)
+	ds.SetReservedIPv4Addresses([]string{"10.1.1.1", "10.1.1.2"})
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+
+	// A reserved /32 never enters the pool
+	err := ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("10.1.1.2"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false)
+	assert.EqualError(t, err, IPReservedForHostError)
+
+	// Reserved addresses inside a prefix are skipped on assignment
+	_, prefix, _ := net.ParseCIDR("10.1.1.0/28")
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", *prefix, true))
+	assigned := make(map[string]bool)
+	for i := 0; i < 14; i++ {
+		ip, _, err := ds.AssignPodIPv4Address(IPAMKey{"net0", fmt.Sprintf("sandbox-%d", i), "eth0"})
+		assert.NoError(t, err)
+		assigned[ip] = true
+	}
+	assert.Equal(t, 14, len(assigned))
+	assert.False(t, assigned["10.1.1.1"])
+	assert.False(t, assigned["10.1.1.2"])
+	_, _, err = ds.AssignPodIPv4Address(IPAMKey{"net0", "sandbox-14", "eth0"})
+	assert.Error(t, err)
+	assert.Empty(t, ds.GetReservedIPv4Conflicts())
+
+	// An address reserved after it was assigned is reported as a conflict
+	ds.SetReservedIPv4Addresses([]string{"10.1.1.1", "10.1.1.2", "10.1.1.3"})
+	conflicts := ds.GetReservedIPv4Conflicts()
+	assert.Equal(t, 1, len(conflicts))
+	assert.Contains(t, conflicts, "10.1.1.3")
+}
+
+func TestENIOriginMetrics(t *testing.T) {
+	totalIPsPerENIConfig.Reset()
+	assignedIPsPerENIConfig.Reset()
+	ds := NewDataStore(Testlog, NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 0, true, false, false))
+	assert.NoError(t, ds.AddENI("eni-2", 1, false, false, false))
+	assert.NoError(t, ds.AddENI("eni-3", 2, false, false, false))
+	assert.NoError(t, ds.SetENISubnet("eni-1", "10.0.0.0/24"))
+	assert.NoError(t, ds.SetENISubnet("eni-2", "100.64.0.0/24"))
+	assert.NoError(t, ds.SetENISubnet("eni-3", "100.64.0.0/24"))
+	assert.EqualError(t, ds.SetENISubnet("eni-4", "100.64.0.0/24"), UnknownENIError)
+
+	for eni, ips := range map[string][]string{
+		"eni-1": {"10.0.0.2"},
+		"eni-2": {"100.64.0.2", "100.64.0.3"},
+		"eni-3": {"100.64.0.4"},
+	} {
+		for _, ip := range ips {
+			assert.NoError(t, ds.AddIPv4CidrToStore(eni, net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+		}
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(totalIPsPerENIConfig.WithLabelValues("", "10.0.0.0/24")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(totalIPsPerENIConfig.WithLabelValues("", "100.64.0.0/24")))
+
+	// The ENIConfig of a new ENI is recorded once its IPs are in the datastore, they move over to it
+	assert.NoError(t, ds.SetENIConfig("eni-2", "us-west-2a"))
+	assert.NoError(t, ds.SetENIConfig("eni-3", "us-west-2a"))
+	assert.EqualError(t, ds.SetENIConfig("eni-4", "us-west-2a"), UnknownENIError)
+	assert.Equal(t, float64(0), testutil.ToFloat64(totalIPsPerENIConfig.WithLabelValues("", "100.64.0.0/24")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(totalIPsPerENIConfig.WithLabelValues("us-west-2a", "100.64.0.0/24")))
+
+	_, device, err := ds.AssignPodIPv4Address(IPAMKey{"net0", "sandbox-1", "eth0"})
+	assert.NoError(t, err)
+	eniConfig, subnet := "", "10.0.0.0/24"
+	if device != 0 {
+		eniConfig, subnet = "us-west-2a", "100.64.0.0/24"
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(assignedIPsPerENIConfig.WithLabelValues(eniConfig, subnet)))
+
+	_, _, _, err = ds.UnassignPodIPv4Address(IPAMKey{"net0", "sandbox-1", "eth0"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0), testutil.ToFloat64(assignedIPsPerENIConfig.WithLabelValues(eniConfig, subnet)))
+
+	// Removing an ENI drops its addresses from its ENIConfig
+	assert.NoError(t, ds.RemoveENIFromDataStore("eni-3", false))
+	assert.Equal(t, float64(2), testutil.ToFloat64(totalIPsPerENIConfig.WithLabelValues("us-west-2a", "100.64.0.0/24")))
+	assert.NoError(t, ds.DelIPv4CidrFromStore("eni-2", net.IPNet{IP: net.ParseIP("100.64.0.2"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	assert.Equal(t, float64(1), testutil.ToFloat64(totalIPsPerENIConfig.WithLabelValues("us-west-2a", "100.64.0.0/24")))
+}
+
+func TestFindFreeableCidrsReclaimMinAge(t *testing.T) {
+	ds := NewDataStore(Testlog, NullCheckpoint{}, false)
+	ds.SetReclaimMinAge(time.Minute)
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+	ipv4Addr := net.IPNet{IP: net.ParseIP("1.1.1.1"), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", ipv4Addr, false))
+	cidr := ds.eniPool["eni-1"].AvailableIPv4Cidrs[ipv4Addr.String()]
+
+	// Seen free on multiple passes, but for less than the guard
+	assert.Empty(t, ds.FindFreeableCidrs("eni-1"))
+	assert.Empty(t, ds.FindFreeableCidrs("eni-1"))
+	assert.Equal(t, 2, cidr.unassignedPasses)
+
+	// Seen free for longer than the guard
+	cidr.unassignedSince = time.Now().Add(-2 * time.Minute)
+	freeable := ds.FindFreeableCidrs("eni-1")
+	assert.Equal(t, 1, len(freeable))
+	assert.Equal(t, ipv4Addr, freeable[0].Cidr)
+
+	// An assignment restarts the observation
+	key := IPAMKey{"net0", "sandbox-1", "eth0"}
+	_, _, err := ds.AssignPodIPv4Address(key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cidr.unassignedPasses)
+	_, _, _, err = ds.UnassignPodIPv4Address(key)
+	assert.NoError(t, err)
+
+	// A single pass is not enough, even when the CIDR was free for longer than the guard
+	assert.Empty(t, ds.FindFreeableCidrs("eni-1"))
+	cidr.unassignedSince = time.Now().Add(-2 * time.Minute)
+	assert.Equal(t, 1, len(ds.FindFreeableCidrs("eni-1")))
+}
+
+func TestFindFreeableCidrsWithoutReclaimMinAge(t *testing.T) {
+	ds := NewDataStore(Testlog, NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+	ipv4Addr := net.IPNet{IP: net.ParseIP("1.1.1.1"), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", ipv4Addr, false))
+
+	// Freeable right away
+	assert.Equal(t, 1, len(ds.FindFreeableCidrs("eni-1")))
+}
+
+func TestGetPendingENIs(t *testing.T) {
+	ds := NewDataStore(Testlog, NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 0, true, false, false))
+	assert.NoError(t, ds.AddENI("eni-2", 1, false, false, false))
+	assert.NoError(t, ds.AddENI("eni-3", 2, false, false, false))
+	assert.NoError(t, ds.AddENI("eni-4", 3, false, true, false))
+	// Out of the minimum ENI lifetime, so only the pending state keeps them attached
+	for _, eni := range ds.eniPool {
+		eni.createTime = time.Now().Add(-2 * minENILifeTime)
+	}
+
+	// The primary ENI and trunk ENI are never pending
+	assert.Equal(t, []string{"eni-2", "eni-3"}, ds.GetPendingENIs())
+	assert.Equal(t, "", ds.RemoveUnusedENIFromStore(0, 0, 0))
+
+	// Once it has an IP, an ENI is no longer pending
+	ipv4Addr := net.IPNet{IP: net.ParseIP("1.1.1.1"), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-2", ipv4Addr, false))
+	assert.Equal(t, []string{"eni-3"}, ds.GetPendingENIs())
+
+	// An empty ENI past the grace period can be deleted
+	ds.eniPool["eni-3"].createTime = time.Now().Add(-2 * pendingENIGracePeriod)
+	assert.Empty(t, ds.GetPendingENIs())
+	removed := ds.RemoveUnusedENIFromStore(0, 0, 0)
+	assert.Contains(t, []string{"eni-2", "eni-3"}, removed)
+}
+
+func TestWarmENIReclaimDwell(t *testing.T) {
+	now := time.Now()
+	ds := NewDataStore(Testlog, NullCheckpoint{}, false)
+	ds.SetClock(func() time.Time { return now })
+	ds.SetWarmENIReclaimDwell(5 * time.Minute)
+	assert.NoError(t, ds.AddENI("eni-1", 0, true, false, false))
+	assert.NoError(t, ds.AddENI("eni-2", 1, false, false, false))
+	ipv4Addr := net.IPNet{IP: net.ParseIP("1.1.1.1"), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-2", ipv4Addr, false))
+	ds.eniPool["eni-2"].createTime = now.Add(-2 * minENILifeTime)
+
+	// Idle since it was added
+	now = now.Add(4 * time.Minute)
+	assert.Equal(t, "", ds.RemoveUnusedENIFromStore(0, 0, 0))
+
+	// A pod comes and goes, the dwell starts over once its IP is unassigned
+	key := IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"}
+	_, _, err := ds.AssignPodIPv4Address(key)
+	assert.NoError(t, err)
+	now = now.Add(6 * time.Minute)
+	_, _, _, err = ds.UnassignPodIPv4Address(key)
+	assert.NoError(t, err)
+	ds.eniPool["eni-2"].AvailableIPv4Cidrs[ipv4Addr.String()].IPv4Addresses["1.1.1.1"].UnassignedTime = time.Time{}
+	now = now.Add(4 * time.Minute)
+	assert.Equal(t, "", ds.RemoveUnusedENIFromStore(0, 0, 0))
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, "eni-2", ds.RemoveUnusedENIFromStore(0, 0, 0))
+}
+
+func TestGetModeMismatchedCidrs(t *testing.T) {
+	ds := NewDataStore(Testlog, NullCheckpoint{}, true)
+	assert.NoError(t, ds.AddENI("eni-1", 0, true, false, false))
+	assert.NoError(t, ds.AddENI("eni-2", 1, false, false, false))
+	_, prefix, _ := net.ParseCIDR("10.1.2.0/28")
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", *prefix, true))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-2", *prefix, true))
+	secondaryIP := net.IPNet{IP: net.ParseIP("10.1.1.1"), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", secondaryIP, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("10.1.1.2"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	assert.Equal(t, map[string]ModeMismatchedCidrs{"eni-1": {Total: 2}}, ds.GetModeMismatchedCidrs())
+
+	// A pod restored from the checkpoint of the previous mode still uses one of the secondary IPs
+	ds.eniPool["eni-1"].AvailableIPv4Cidrs[secondaryIP.String()].IPv4Addresses["10.1.1.1"] = &AddressInfo{
+		Address: "10.1.1.1",
+		IPAMKey: IPAMKey{"net0", "sandbox-1", "eth0"},
+	}
+	assert.Equal(t, map[string]ModeMismatchedCidrs{"eni-1": {Total: 2, InUse: 1}}, ds.GetModeMismatchedCidrs())
+}
+
+func TestGetIPAllocationAges(t *testing.T) {
+	ds := NewDataStore(Testlog, NewTestCheckpoint(struct{}{}), false)
+	now := time.Unix(1600000000, 0)
+	ds.SetClock(func() time.Time { return now })
+
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+	for _, ip := range []string{"1.1.1.1", "1.1.1.2", "1.1.1.3"} {
+		assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+	key1 := IPAMKey{"net0", "sandbox-1", "eth0"}
+	_, _, err := ds.AssignPodIPv4Address(key1)
+	assert.NoError(t, err)
+	now = now.Add(time.Hour)
+	_, _, err = ds.AssignPodIPv4Address(IPAMKey{"net0", "sandbox-2", "eth0"})
+	assert.NoError(t, err)
+	now = now.Add(time.Minute)
+
+	ages := ds.GetIPAllocationAges()
+	assert.ElementsMatch(t, []time.Duration{time.Hour + time.Minute, time.Minute}, ages)
+
+	// Released IPs are no longer counted
+	_, _, _, err = ds.UnassignPodIPv4Address(key1)
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Minute}, ds.GetIPAllocationAges())
+}
+
+func TestGetENIAttachmentAges(t *testing.T) {
+	ds := NewDataStore(Testlog, NewTestCheckpoint(struct{}{}), false)
+	now := time.Unix(1600000000, 0)
+	ds.SetClock(func() time.Time { return now })
+
+	assert.NoError(t, ds.AddENI("eni-1", 0, true, false, false))
+	now = now.Add(time.Hour)
+	assert.NoError(t, ds.AddENI("eni-2", 1, false, false, false))
+	now = now.Add(time.Minute)
+	assert.Equal(t, map[string]time.Duration{"eni-1": time.Hour + time.Minute, "eni-2": time.Minute}, ds.GetENIAttachmentAges())
+
+	// A removed ENI is no longer reported
+	assert.NoError(t, ds.RemoveENIFromDataStore("eni-2", false))
+	assert.Equal(t, map[string]time.Duration{"eni-1": time.Hour + time.Minute}, ds.GetENIAttachmentAges())
+}
+
+func TestReadBackingStoreAllocationTimestamp(t *testing.T) {
+	assignTime := time.Unix(1600000000, 0)
+	checkpoint := NewTestCheckpoint(CheckpointData{
+		Version: CheckpointFormatVersion,
+		Allocations: []CheckpointEntry{
+			{IPAMKey: IPAMKey{"net0", "sandbox-1", "eth0"}, IPv4: "1.1.1.1", AllocationTimestamp: assignTime.Unix()},
+			// Written by an older version, without a timestamp
+			{IPAMKey: IPAMKey{"net0", "sandbox-2", "eth0"}, IPv4: "1.1.1.2"},
+		},
+	})
+	ds := NewDataStore(Testlog, checkpoint, false)
+	ds.CheckpointMigrationPhase = 2
+	now := assignTime.Add(24 * time.Hour)
+	ds.SetClock(func() time.Time { return now })
+
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+	for _, ip := range []string{"1.1.1.1", "1.1.1.2"} {
+		assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+	assert.NoError(t, ds.ReadBackingStore())
+
+	// The restored allocation keeps its age, the one without a timestamp counts from the restore
+	assert.ElementsMatch(t, []time.Duration{24 * time.Hour, 0}, ds.GetIPAllocationAges())
+}
+
+func TestReadBackingStoreIgnoresHostNetworkSandboxes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCRI := mock_cri.NewMockAPIs(ctrl)
+	ds := NewDataStore(Testlog, NullCheckpoint{}, true)
+	ds.cri = mockCRI
+	ds.CheckpointMigrationPhase = 1
+
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(28, 32)}, true))
+	// The node's primary IP is inside the prefix
+	ds.SetReservedIPv4Addresses([]string{"10.0.0.1"})
+	mockCRI.EXPECT().GetRunningPodSandboxes(gomock.Any()).Return([]*cri.SandboxInfo{
+		{ID: "sandbox-host", IP: "10.0.0.1"},
+		{ID: "sandbox-pod", IP: "10.0.0.5"},
+	}, nil)
+	assert.NoError(t, ds.ReadBackingStore())
+
+	// Only the pod's IP is in use, the host-network sandbox is neither assigned nor reported as a conflict
+	_, assigned, _ := ds.GetStats()
+	assert.Equal(t, 1, assigned)
+	assert.Empty(t, ds.GetReservedIPv4Conflicts())
+	_, _, _, err := ds.UnassignPodIPv4Address(IPAMKey{backfillNetworkName, "sandbox-pod", backfillNetworkIface})
+	assert.NoError(t, err)
+}
+
+func TestMaxPodsPerENI(t *testing.T) {
+	ds := NewDataStore(Testlog, NullCheckpoint{}, false)
+	ds.SetMaxPodsPerENI(2)
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+	for _, ip := range []string{"1.1.1.1", "1.1.1.2", "1.1.1.3"} {
+		assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+
+	for _, sandbox := range []string{"sandbox-1", "sandbox-2"} {
+		_, _, err := ds.AssignPodIPv4Address(IPAMKey{"net0", sandbox, "eth0"})
+		assert.NoError(t, err)
+	}
+	// The ENI is at the cap, its free IP is neither assigned nor counted as available
+	_, _, err := ds.AssignPodIPv4Address(IPAMKey{"net0", "sandbox-3", "eth0"})
+	assert.Error(t, err)
+	total, assigned, _ := ds.GetStats()
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 2, assigned)
+
+	// A new ENI takes the next pods
+	assert.NoError(t, ds.AddENI("eni-2", 2, false, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-2", net.IPNet{IP: net.ParseIP("1.1.2.1"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	ip, device, err := ds.AssignPodIPv4Address(IPAMKey{"net0", "sandbox-3", "eth0"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.1.2.1", ip)
+	assert.Equal(t, 2, device)
+
+	// Without the cap all IPs are available
+	ds.SetMaxPodsPerENI(0)
+	total, assigned, _ = ds.GetStats()
+	assert.Equal(t, 4, total)
+	assert.Equal(t, 3, assigned)
+}
+
+func TestIPAllocationMode(t *testing.T) {
+	tests := []struct {
+		isPDEnabled bool
+		mode        string
+	}{
+		{false, IPAllocationModeSecondaryIP},
+		{true, IPAllocationModeIPv4Prefix},
+	}
+	for _, tt := range tests {
+		ds := NewDataStore(Testlog, NullCheckpoint{}, tt.isPDEnabled)
+		assert.Equal(t, tt.mode, ds.IPAllocationMode())
+		assert.Equal(t, tt.mode, ds.GetENIInfos().IPAllocationMode)
+		for _, mode := range []string{IPAllocationModeSecondaryIP, IPAllocationModeIPv4Prefix} {
+			expected := 0.0
+			if mode == tt.mode {
+				expected = 1
+			}
+			assert.Equal(t, expected, testutil.ToFloat64(ipAllocationMode.WithLabelValues(mode)), mode)
+		}
+	}
+}
+
+// newPDDataStoreWithSecondaryIPPod returns a datastore with prefix delegation enabled, where eni-2 has a secondary
+// IP assigned from before prefix delegation was enabled, restored from the checkpoint
+func newPDDataStoreWithSecondaryIPPod(t *testing.T, key IPAMKey) *DataStore {
+	checkpoint := NewTestCheckpoint(CheckpointData{
+		Version:     CheckpointFormatVersion,
+		Allocations: []CheckpointEntry{{IPAMKey: key, IPv4: "1.1.1.1"}},
+	})
+	ds := NewDataStore(Testlog, checkpoint, true)
+	ds.CheckpointMigrationPhase = 2
+	assert.NoError(t, ds.AddENI("eni-1", 0, true, false, false))
+	assert.NoError(t, ds.AddENI("eni-2", 1, false, false, false))
+	ipv4Addr := net.IPNet{IP: net.ParseIP("1.1.1.1"), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-2", ipv4Addr, false))
+	assert.NoError(t, ds.ReadBackingStore())
+	return ds
+}
+
+func TestRemoveUnusedENIAfterSecondaryIPReleasedInPDMode(t *testing.T) {
+	key := IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"}
+	ds := newPDDataStoreWithSecondaryIPPod(t, key)
+	assert.Equal(t, 1, ds.total)
+
+	// The secondary IP is taken out of the total once unassigned
+	_, _, _, err := ds.UnassignPodIPv4Address(key)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, ds.total)
+
+	ds.eniPool["eni-2"].createTime = time.Time{}
+	ds.eniPool["eni-2"].AvailableIPv4Cidrs["1.1.1.1/32"].IPv4Addresses["1.1.1.1"].UnassignedTime = time.Time{}
+	assert.Equal(t, "eni-2", ds.RemoveUnusedENIFromStore(0, 0, 0))
+	assert.Equal(t, 0, ds.total)
+}
+
+func TestForceRemoveENIAfterSecondaryIPReleasedInPDMode(t *testing.T) {
+	key1 := IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"}
+	ds := newPDDataStoreWithSecondaryIPPod(t, key1)
+	_, _, _, err := ds.UnassignPodIPv4Address(key1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, ds.total)
+
+	// A pod is left on a prefix of the ENI
+	_, prefix, _ := net.ParseCIDR("10.1.2.0/28")
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-2", *prefix, true))
+	assert.Equal(t, 16, ds.total)
+	key2 := IPAMKey{NetworkName: "net0", ContainerID: "sandbox-2", IfName: "eth0"}
+	_, _, err = ds.AssignPodIPv4Address(key2)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ds.RemoveENIFromDataStore("eni-2", true))
+	assert.Equal(t, 0, ds.total)
+	assert.Equal(t, 0, ds.assigned)
+	assert.Equal(t, 0, ds.allocatedPrefix)
+}
@@ -0,0 +1,119 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+const (
+	// IPAssignmentStrategyDefault gives a pod the first free address found, in no particular order
+	IPAssignmentStrategyDefault = ""
+	// IPAssignmentStrategyLowest gives a pod the lowest free address, which is deterministic but reuses addresses fast
+	IPAssignmentStrategyLowest = "lowest"
+	// IPAssignmentStrategyLRU gives a pod the free address unassigned the longest time ago, never used addresses
+	// first, to maximize the time before an address is reused
+	IPAssignmentStrategyLRU = "lru"
+	// IPAssignmentStrategyRandom gives a pod a random free address
+	IPAssignmentStrategyRandom = "random"
+)
+
+// freeIPv4Addr is an address that can be given to a pod
+type freeIPv4Addr struct {
+	eni            *ENI
+	cidr           *CidrInfo
+	address        net.IP
+	unassignedTime time.Time
+}
+
+// SetIPAssignmentStrategy sets how a free address is picked for a pod. Except with IPAssignmentStrategyDefault, the
+// addresses of all the ENIs that can take the pod are compared. Addresses in their cooling period are never picked.
+func (ds *DataStore) SetIPAssignmentStrategy(strategy string) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.ipAssignmentStrategy = strategy
+}
+
+// selectFreeIPv4AddrUnsafe returns the free address picked by the IP assignment strategy, along with its ENI and CIDR.
+// The ENI is nil if there is no free address.
+func (ds *DataStore) selectFreeIPv4AddrUnsafe(subnet string) (*ENI, *CidrInfo, string) {
+	candidates := ds.freeIPv4AddrsUnsafe(subnet)
+	if len(candidates) == 0 {
+		return nil, nil, ""
+	}
+
+	selected := candidates[0]
+	switch ds.ipAssignmentStrategy {
+	case IPAssignmentStrategyRandom:
+		selected = candidates[ds.random(len(candidates))]
+	case IPAssignmentStrategyLRU:
+		for _, candidate := range candidates[1:] {
+			if candidate.unassignedTime.Before(selected.unassignedTime) ||
+				(candidate.unassignedTime.Equal(selected.unassignedTime) && bytes.Compare(candidate.address, selected.address) < 0) {
+				selected = candidate
+			}
+		}
+	default:
+		for _, candidate := range candidates[1:] {
+			if bytes.Compare(candidate.address, selected.address) < 0 {
+				selected = candidate
+			}
+		}
+	}
+	ds.log.Debugf("Picked IP %s with the %s IP assignment strategy out of %d free IPs", selected.address,
+		ds.ipAssignmentStrategy, len(candidates))
+	return selected.eni, selected.cidr, selected.address.String()
+}
+
+// freeIPv4AddrsUnsafe returns the free addresses of the ENIs that can take a pod, in the CIDRs of the current IP
+// allocation mode
+func (ds *DataStore) freeIPv4AddrsUnsafe(subnet string) []freeIPv4Addr {
+	var candidates []freeIPv4Addr
+	for _, eni := range ds.eniPool {
+		if !ds.eniAssignableUnsafe(eni, subnet) {
+			continue
+		}
+		for _, availableCidr := range eni.AvailableIPv4Cidrs {
+			if ds.isPDEnabled != availableCidr.IsPrefix {
+				continue
+			}
+			ipnet := availableCidr.Cidr
+			for ip := ipnet.IP.Mask(ipnet.Mask).To4(); ip != nil && ipnet.Contains(ip); ip = nextIPv4Addr(ip) {
+				strPrivateIPv4 := ip.String()
+				if ds.reservedIPv4Addrs[strPrivateIPv4] {
+					continue
+				}
+				candidate := freeIPv4Addr{eni: eni, cidr: availableCidr, address: ip}
+				if addr, ok := availableCidr.IPv4Addresses[strPrivateIPv4]; ok {
+					if addr.Assigned() || addr.inCoolingPeriod() {
+						continue
+					}
+					candidate.unassignedTime = addr.UnassignedTime
+				}
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	return candidates
+}
+
+// nextIPv4Addr returns a copy of ip incremented by one
+func nextIPv4Addr(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	getNextIPv4Addr(next)
+	return next
+}
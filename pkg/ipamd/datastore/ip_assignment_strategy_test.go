@@ -0,0 +1,114 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// strategyTestDatastore returns a datastore whose pool has 1.1.1.1 and 1.1.1.3 on eni-1, and 1.1.1.2 and 1.1.1.4 on
+// eni-2. 1.1.1.1 was unassigned a minute ago, 1.1.1.2 two minutes ago, 1.1.1.3 is still assigned and 1.1.1.4 was
+// never used.
+func strategyTestDatastore(t *testing.T, strategy string) *DataStore {
+	ds := NewDataStore(Testlog, NullCheckpoint{}, false)
+	ds.SetIPAssignmentStrategy(strategy)
+	pool := map[string][]string{"eni-1": {"1.1.1.1", "1.1.1.3"}, "eni-2": {"1.1.1.2", "1.1.1.4"}}
+	for device, eni := range []string{"eni-1", "eni-2"} {
+		assert.NoError(t, ds.AddENI(eni, device, device == 0, false, false))
+		for _, ip := range pool[eni] {
+			assert.NoError(t, ds.AddIPv4CidrToStore(eni, net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+		}
+	}
+
+	unassignedAgo := map[string]time.Duration{"1.1.1.1": time.Minute, "1.1.1.2": 2 * time.Minute}
+	for _, eni := range ds.eniPool {
+		for _, cidr := range eni.AvailableIPv4Cidrs {
+			ip := cidr.Cidr.IP.String()
+			if ip == "1.1.1.4" {
+				continue
+			}
+			addr := &AddressInfo{Address: ip}
+			cidr.IPv4Addresses = map[string]*AddressInfo{ip: addr}
+			ds.assignPodIPv4AddressUnsafe(IPAMKey{"net0", "sandbox-" + ip, "eth0"}, eni, addr)
+			if ago, ok := unassignedAgo[ip]; ok {
+				ds.unassignPodIPv4AddressUnsafe(eni, addr)
+				addr.UnassignedTime = time.Now().Add(-ago)
+			}
+		}
+	}
+	return ds
+}
+
+// assignAll assigns IPs to new pods until the pool is exhausted, and returns them in assignment order
+func assignAll(t *testing.T, ds *DataStore) []string {
+	var ips []string
+	for i := 0; ; i++ {
+		ip, _, err := ds.AssignPodIPv4Address(IPAMKey{"net0", fmt.Sprintf("sandbox-new-%d", i), "eth0"})
+		if err != nil {
+			return ips
+		}
+		ips = append(ips, ip)
+	}
+}
+
+func TestIPAssignmentStrategyLowest(t *testing.T) {
+	ds := strategyTestDatastore(t, IPAssignmentStrategyLowest)
+	assert.Equal(t, []string{"1.1.1.1", "1.1.1.2", "1.1.1.4"}, assignAll(t, ds))
+}
+
+func TestIPAssignmentStrategyLRU(t *testing.T) {
+	ds := strategyTestDatastore(t, IPAssignmentStrategyLRU)
+	// Never used first, then the least recently unassigned
+	assert.Equal(t, []string{"1.1.1.4", "1.1.1.2", "1.1.1.1"}, assignAll(t, ds))
+}
+
+func TestIPAssignmentStrategyRandom(t *testing.T) {
+	ds := strategyTestDatastore(t, IPAssignmentStrategyRandom)
+	var choices []int
+	ds.random = func(n int) int {
+		choices = append(choices, n)
+		return n - 1
+	}
+	assert.ElementsMatch(t, []string{"1.1.1.1", "1.1.1.2", "1.1.1.4"}, assignAll(t, ds))
+	// Each pick is among all the free IPs left
+	assert.Equal(t, []int{3, 2, 1}, choices)
+}
+
+func TestIPAssignmentStrategySkipsCoolingIPs(t *testing.T) {
+	for _, strategy := range []string{IPAssignmentStrategyLowest, IPAssignmentStrategyLRU, IPAssignmentStrategyRandom} {
+		ds := strategyTestDatastore(t, strategy)
+		_, _, _, err := ds.UnassignPodIPv4Address(IPAMKey{"net0", "sandbox-1.1.1.3", "eth0"})
+		assert.NoError(t, err)
+		assert.NotContains(t, assignAll(t, ds), "1.1.1.3", strategy)
+	}
+}
+
+func TestIPAssignmentStrategyLowestPrefix(t *testing.T) {
+	ds := NewDataStore(Testlog, NullCheckpoint{}, true)
+	ds.SetIPAssignmentStrategy(IPAssignmentStrategyLowest)
+	ds.SetReservedIPv4Addresses([]string{"10.0.0.0"})
+	assert.NoError(t, ds.AddENI("eni-1", 0, true, false, false))
+	_, prefix, _ := net.ParseCIDR("10.0.0.0/28")
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", *prefix, true))
+
+	ips := assignAll(t, ds)
+	assert.Len(t, ips, 15)
+	assert.Equal(t, "10.0.0.1", ips[0])
+	assert.Equal(t, "10.0.0.15", ips[14])
+}
@@ -0,0 +1,52 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+// envPodSubnetID is the subnet every ENI for pods is created in, whatever the subnet of the node's primary ENI. The
+// primary ENI is then left to node traffic, none of its IPs or prefixes go to pods.
+const envPodSubnetID = "POD_SUBNET_ID"
+
+func getPodSubnetID() string {
+	return strings.TrimSpace(os.Getenv(envPodSubnetID))
+}
+
+// validatePodSubnetID makes sure ENIs of the instance can be created in the pod subnet, ipamd refuses to start
+// otherwise since no pod would get an IP. A subnet that can't be described for another reason is checked again by
+// every ENI creation.
+func (c *IPAMContext) validatePodSubnetID() error {
+	if c.podSubnetID == "" {
+		return nil
+	}
+	cidr, err := c.awsClient.GetSubnetIPv4CIDR(c.podSubnetID)
+	if err != nil {
+		cause := errors.Cause(err)
+		if cause == awsutils.ErrSubnetNotFound || cause == awsutils.ErrSubnetAZMismatch || cause == awsutils.ErrSubnetVPCMismatch {
+			ipamdErrInc("podSubnetIDInvalid")
+			return errors.Wrapf(err, "invalid %s", envPodSubnetID)
+		}
+		log.Warnf("Unable to validate %s %s, carrying on: %v", envPodSubnetID, c.podSubnetID, err)
+		return nil
+	}
+	log.Infof("Pod ENIs are created in subnet %s with CIDR %s, the primary ENI is left to node traffic", c.podSubnetID, cidr)
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+func TestGetPodSubnetID(t *testing.T) {
+	defer os.Unsetenv(envPodSubnetID)
+
+	assert.Equal(t, "", getPodSubnetID())
+
+	_ = os.Setenv(envPodSubnetID, " subnet-pod ")
+	assert.Equal(t, "subnet-pod", getPodSubnetID())
+}
+
+func TestValidatePodSubnetID(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	// Without a pod subnet there is nothing to describe
+	assert.NoError(t, (&IPAMContext{awsClient: m.awsutils}).validatePodSubnetID())
+
+	mockContext := &IPAMContext{awsClient: m.awsutils, podSubnetID: "subnet-pod"}
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-pod").Return("10.1.0.0/24", nil)
+	assert.NoError(t, mockContext.validatePodSubnetID())
+
+	// A subnet in another AZ keeps ipamd from starting
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-pod").
+		Return("", pkgerrors.Wrap(awsutils.ErrSubnetAZMismatch, "subnet-pod is in us-west-2b"))
+	err := mockContext.validatePodSubnetID()
+	assert.Equal(t, awsutils.ErrSubnetAZMismatch, pkgerrors.Cause(err))
+
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-pod").Return("", pkgerrors.Wrap(awsutils.ErrSubnetNotFound, "subnet-pod"))
+	assert.Error(t, mockContext.validatePodSubnetID())
+
+	// A lookup failure is left to the ENI creations to check again
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-pod").Return("", errors.New("throttled"))
+	assert.NoError(t, mockContext.validatePodSubnetID())
+}
+
+func TestIncreaseIPPoolUsesPodSubnet(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:               m.awsutils,
+		networkClient:           m.network,
+		dataStore:               testDatastore(),
+		primaryIP:               make(map[string]string),
+		maxIPsPerENI:            14,
+		maxENI:                  4,
+		warmENITarget:           1,
+		podSubnetID:             "subnet-pod",
+		disablePrimaryENIPodIPs: true,
+		reconcileCooldownCache:  ReconcileCooldownCache{cache: make(map[string]time.Time)},
+	}
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid).AnyTimes()
+	assert.NoError(t, mockContext.setupENI(primaryENIid, getPrimaryENIMetadata(), false, false))
+	// No pod uses the secondary IPs of the primary ENI, they are unassigned
+	m.awsutils.EXPECT().DeallocIPAddresses(primaryENIid, gomock.Any()).Return(nil)
+	assert.False(t, mockContext.releasePrimaryENIPodIPs())
+
+	// The primary ENI has free slots, but the new IPs come from an ENI of the pod subnet
+	podENI := getSecondaryENIMetadata()
+	podENI.SubnetIPv4CIDR = secSubnet
+	m.awsutils.EXPECT().AllocENI(true, nil, "subnet-pod").Return(secENIid, nil)
+	m.awsutils.EXPECT().AllocIPAddresses(secENIid, 14)
+	m.awsutils.EXPECT().WaitForENIAndIPsAttached(secENIid, 14).Return(podENI, nil)
+	m.network.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, secSubnet)
+	mockContext.increaseDatastorePool(context.Background())
+
+	primaryPool, _, err := mockContext.dataStore.GetENICIDRs(primaryENIid)
+	assert.NoError(t, err)
+	assert.Empty(t, primaryPool)
+	podPool, _, err := mockContext.dataStore.GetENICIDRs(secENIid)
+	assert.NoError(t, err)
+	assert.Contains(t, podPool, ipaddr12)
+	total, _, _ := mockContext.dataStore.GetStats()
+	assert.Equal(t, 1, total)
+}
@@ -0,0 +1,233 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// envEC2CallBatchWindow is the number of milliseconds ipamd holds IP and prefix assignments and unassignments
+	// to coalesce them into fewer EC2 calls per ENI. 0 calls EC2 right away.
+	envEC2CallBatchWindow = "EC2_CALL_BATCH_WINDOW_MS"
+	// maxEC2CallBatchWindow keeps the delayed unassignments within ipReconcileCooldown, so the reconciler does not
+	// put IPs that are still pending back into the datastore
+	maxEC2CallBatchWindow = 30 * time.Second
+)
+
+func getEC2CallBatchWindow() time.Duration {
+	inputStr, found := os.LookupEnv(envEC2CallBatchWindow)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		window := time.Duration(input) * time.Millisecond
+		if window > maxEC2CallBatchWindow {
+			log.Warnf("%s %v is above the maximum, using %v", envEC2CallBatchWindow, window, maxEC2CallBatchWindow)
+			return maxEC2CallBatchWindow
+		}
+		log.Debugf("Using %s %v", envEC2CallBatchWindow, window)
+		return window
+	}
+	log.Warnf("Invalid %s value %q, ignoring it", envEC2CallBatchWindow, inputStr)
+	return 0
+}
+
+// pendingUnassigns are the IPs and prefixes of an ENI already taken out of the datastore and waiting to be unassigned
+type pendingUnassigns struct {
+	ips      []string
+	prefixes []string
+}
+
+// ec2CallBatcher holds the IP and prefix unassignments and the non urgent pool increases for up to a window, so the
+// ones requested in the meantime are made in one EC2 call per ENI. A nil or zero window batcher holds nothing.
+type ec2CallBatcher struct {
+	lock   sync.Mutex
+	window time.Duration
+	now    func() time.Time
+	// unassignSince is when the oldest pending unassignment was queued
+	unassignSince time.Time
+	pending       map[string]*pendingUnassigns
+	// increaseSince is when the oldest deferred pool increase was wanted, zero if there is none
+	increaseSince time.Time
+}
+
+func newEC2CallBatcher(window time.Duration, now func() time.Time) *ec2CallBatcher {
+	return &ec2CallBatcher{window: window, now: now, pending: make(map[string]*pendingUnassigns)}
+}
+
+func (b *ec2CallBatcher) enabled() bool {
+	return b != nil && b.window > 0
+}
+
+// queueUnassign adds IPs or prefixes of the ENI to the pending unassignments
+func (b *ec2CallBatcher) queueUnassign(eniID string, cidrs []string, isPrefix bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.pending) == 0 {
+		b.unassignSince = b.now()
+	}
+	pending, ok := b.pending[eniID]
+	if !ok {
+		pending = &pendingUnassigns{}
+		b.pending[eniID] = pending
+	}
+	if isPrefix {
+		pending.prefixes = append(pending.prefixes, cidrs...)
+	} else {
+		pending.ips = append(pending.ips, cidrs...)
+	}
+}
+
+// takeUnassigns returns and forgets the pending unassignments once the window has passed since the oldest one, or
+// right away if force is set
+func (b *ec2CallBatcher) takeUnassigns(force bool) map[string]*pendingUnassigns {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.pending) == 0 || !force && b.now().Sub(b.unassignSince) < b.window {
+		return nil
+	}
+	pending := b.pending
+	b.pending = make(map[string]*pendingUnassigns)
+	return pending
+}
+
+// takeENIUnassigns returns and forgets the pending unassignments of the ENI
+func (b *ec2CallBatcher) takeENIUnassigns(eniID string) *pendingUnassigns {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	pending := b.pending[eniID]
+	delete(b.pending, eniID)
+	return pending
+}
+
+// deferIncrease returns true while a non urgent pool increase should keep waiting for the window to pass since it
+// was first wanted
+func (b *ec2CallBatcher) deferIncrease(urgent bool) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.now()
+	if b.increaseSince.IsZero() {
+		b.increaseSince = now
+	}
+	if urgent || now.Sub(b.increaseSince) >= b.window {
+		b.increaseSince = time.Time{}
+		return false
+	}
+	return true
+}
+
+// deallocCidrs unassigns the IPs or prefixes, already deleted from the datastore, from the ENI. With a batch window
+// they are only queued, and unassigned by flushEC2Calls together with the ones queued after them.
+func (c *IPAMContext) deallocCidrs(eniID string, cidrs []string, isPrefix bool) error {
+	if !isPrefix {
+		cidrs = c.withoutPrimaryIP(eniID, cidrs)
+	}
+	if c.ec2CallBatcher.enabled() {
+		if len(cidrs) == 0 {
+			return nil
+		}
+		for _, cidr := range cidrs {
+			// Keep the reconciler from adding them back while they are still assigned in EC2
+			c.reconcileCooldownCache.Add(cidr)
+		}
+		c.ec2CallBatcher.queueUnassign(eniID, cidrs, isPrefix)
+		log.Debugf("Queued the unassignment of %v from ENI %s", cidrs, eniID)
+		return nil
+	}
+	if isPrefix {
+		return c.awsClient.DeallocPrefixAddresses(eniID, cidrs)
+	}
+	return c.awsClient.DeallocIPAddresses(eniID, cidrs)
+}
+
+// flushEC2Calls makes the pending unassignments once the batch window has passed, with at most one EC2 call for the
+// IPs and one for the prefixes of each ENI
+func (c *IPAMContext) flushEC2Calls(force bool) {
+	if !c.ec2CallBatcher.enabled() {
+		return
+	}
+	pendingByENI := c.ec2CallBatcher.takeUnassigns(force)
+	eniIDs := make([]string, 0, len(pendingByENI))
+	for eniID := range pendingByENI {
+		eniIDs = append(eniIDs, eniID)
+	}
+	c.teardownLimiter.doPerENI(eniIDs, func(eniID string) {
+		pending := pendingByENI[eniID]
+		if len(pending.prefixes) > 0 {
+			if err := c.awsClient.DeallocPrefixAddresses(eniID, pending.prefixes); err != nil {
+				log.Warnf("Failed to free Prefixes %v from ENI %s: %s", pending.prefixes, eniID, err)
+			}
+		}
+		if len(pending.ips) > 0 {
+			if err := c.awsClient.DeallocIPAddresses(eniID, pending.ips); err != nil {
+				log.Warnf("Failed to free IPs %v from ENI %s: %s", pending.ips, eniID, err)
+			}
+		}
+	})
+}
+
+// dropPendingUnassigns forgets the pending unassignments of an ENI that is being deleted
+func (c *IPAMContext) dropPendingUnassigns(eniID string) {
+	if c.ec2CallBatcher.enabled() {
+		c.ec2CallBatcher.takeENIUnassigns(eniID)
+	}
+}
+
+// restorePendingUnassigns puts the IPs or prefixes of the current allocation mode that are still pending
+// unassignment back into the datastore, so a pool that runs low again reuses them without any EC2 call. It returns
+// true if it put any back.
+func (c *IPAMContext) restorePendingUnassigns() bool {
+	if !c.ec2CallBatcher.enabled() {
+		return false
+	}
+	restored := false
+	for eniID := range c.dataStore.GetENIInfos().ENIs {
+		pending := c.ec2CallBatcher.takeENIUnassigns(eniID)
+		if pending == nil {
+			continue
+		}
+		cidrs, mismatched := pending.ips, pending.prefixes
+		if c.enableIpv4PrefixDelegation {
+			cidrs, mismatched = pending.prefixes, pending.ips
+		}
+		// The ones of the other allocation mode are never handed out again, they still have to be unassigned
+		if len(mismatched) > 0 {
+			c.ec2CallBatcher.queueUnassign(eniID, mismatched, !c.enableIpv4PrefixDelegation)
+		}
+		for _, cidr := range cidrs {
+			ipNet := net.IPNet{IP: net.ParseIP(cidr), Mask: net.IPv4Mask(255, 255, 255, 255)}
+			if c.enableIpv4PrefixDelegation {
+				_, prefix, err := net.ParseCIDR(cidr)
+				if err != nil {
+					continue
+				}
+				ipNet = *prefix
+			}
+			if err := c.dataStore.AddIPv4CidrToStore(eniID, ipNet, c.enableIpv4PrefixDelegation); err != nil {
+				log.Warnf("Failed to put %s back on ENI %s, unassigning it: %v", cidr, eniID, err)
+				c.ec2CallBatcher.queueUnassign(eniID, []string{cidr}, c.enableIpv4PrefixDelegation)
+				continue
+			}
+			c.reconcileCooldownCache.Remove(cidr)
+			restored = true
+		}
+		log.Infof("Reused %v pending unassignment on ENI %s instead of assigning new ones", cidrs, eniID)
+	}
+	return restored
+}
@@ -0,0 +1,175 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEC2CallBatchWindow(t *testing.T) {
+	defer os.Unsetenv(envEC2CallBatchWindow)
+
+	assert.Equal(t, time.Duration(0), getEC2CallBatchWindow())
+
+	_ = os.Setenv(envEC2CallBatchWindow, "500")
+	assert.Equal(t, 500*time.Millisecond, getEC2CallBatchWindow())
+
+	_ = os.Setenv(envEC2CallBatchWindow, "60000")
+	assert.Equal(t, maxEC2CallBatchWindow, getEC2CallBatchWindow())
+
+	_ = os.Setenv(envEC2CallBatchWindow, "-1")
+	assert.Equal(t, time.Duration(0), getEC2CallBatchWindow())
+
+	_ = os.Setenv(envEC2CallBatchWindow, "soon")
+	assert.Equal(t, time.Duration(0), getEC2CallBatchWindow())
+}
+
+func testBatchingContext(m *testMocks, now *time.Time) *IPAMContext {
+	c := &IPAMContext{
+		awsClient:              m.awsutils,
+		networkClient:          m.network,
+		primaryIP:              make(map[string]string),
+		maxIPsPerENI:           14,
+		maxENI:                 4,
+		warmENITarget:          1,
+		reconcileCooldownCache: ReconcileCooldownCache{cache: make(map[string]time.Time)},
+		ec2CallBatcher:         newEC2CallBatcher(time.Second, func() time.Time { return *now }),
+	}
+	c.dataStore = testDatastore()
+	return c
+}
+
+func TestEC2CallBatchingCoalescesUnassigns(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	now := time.Now()
+	c := testBatchingContext(m, &now)
+
+	// Three decreases within the window
+	assert.NoError(t, c.deallocCidrs(primaryENIid, []string{ipaddr01}, false))
+	now = now.Add(300 * time.Millisecond)
+	assert.NoError(t, c.deallocCidrs(primaryENIid, []string{ipaddr02}, false))
+	assert.NoError(t, c.deallocCidrs(primaryENIid, []string{prefix01}, true))
+	now = now.Add(300 * time.Millisecond)
+	assert.NoError(t, c.deallocCidrs(primaryENIid, []string{ipaddr03}, false))
+	assert.NoError(t, c.deallocCidrs(secENIid, nil, false))
+
+	// The reconciler leaves them alone until they are unassigned
+	_, recentlyFreed := c.reconcileCooldownCache.RecentlyFreed(ipaddr02)
+	assert.True(t, recentlyFreed)
+
+	// Nothing is called before the window has passed
+	c.flushEC2Calls(false)
+
+	// Then one call per ENI for the IPs and one for the prefixes, instead of four
+	m.awsutils.EXPECT().DeallocIPAddresses(primaryENIid, []string{ipaddr01, ipaddr02, ipaddr03}).Return(nil).Times(1)
+	m.awsutils.EXPECT().DeallocPrefixAddresses(primaryENIid, []string{prefix01}).Return(nil).Times(1)
+	now = now.Add(time.Second)
+	c.flushEC2Calls(false)
+	c.flushEC2Calls(false)
+}
+
+func TestEC2CallBatchingDisabled(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	c := &IPAMContext{awsClient: m.awsutils}
+
+	m.awsutils.EXPECT().DeallocIPAddresses(primaryENIid, []string{ipaddr01}).Return(nil)
+	m.awsutils.EXPECT().DeallocPrefixAddresses(primaryENIid, []string{prefix01}).Return(errors.New("boom"))
+	assert.NoError(t, c.deallocCidrs(primaryENIid, []string{ipaddr01}, false))
+	assert.Error(t, c.deallocCidrs(primaryENIid, []string{prefix01}, true))
+	assert.False(t, c.restorePendingUnassigns())
+	c.flushEC2Calls(true)
+}
+
+func TestEC2CallBatchingReusesPendingUnassigns(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	now := time.Now()
+	c := testBatchingContext(m, &now)
+	c.warmIPTarget = 1
+	assert.NoError(t, c.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false))
+	ipNet := net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	assert.NoError(t, c.dataStore.AddIPv4CidrToStore(primaryENIid, ipNet, false))
+
+	// The IP is taken out of the pool, then the pool runs low again before it was unassigned
+	assert.NoError(t, c.dataStore.DelIPv4CidrFromStore(primaryENIid, ipNet, false))
+	assert.NoError(t, c.deallocCidrs(primaryENIid, []string{ipaddr02}, false))
+
+	// No EC2 call at all, the unassignment and the assignment cancel out
+	c.increaseDatastorePool(context.Background())
+	total, _, _ := c.dataStore.GetStats()
+	assert.Equal(t, 1, total)
+	found, _ := c.reconcileCooldownCache.RecentlyFreed(ipaddr02)
+	assert.False(t, found)
+	now = now.Add(time.Second)
+	c.flushEC2Calls(false)
+}
+
+func TestEC2CallBatchingDefersIncrease(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	now := time.Now()
+	c := testBatchingContext(m, &now)
+	assert.NoError(t, c.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false))
+	assert.NoError(t, c.dataStore.AddIPv4CidrToStore(primaryENIid,
+		net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+
+	// A free IP is left, so the top up waits for the window
+	c.increaseDatastorePool(context.Background())
+	now = now.Add(500 * time.Millisecond)
+	c.increaseDatastorePool(context.Background())
+
+	m.awsutils.EXPECT().AllocIPAddresses(primaryENIid, gomock.Any()).Return(nil).Times(1)
+	m.awsutils.EXPECT().GetIPv4sFromEC2(primaryENIid).Return(nil, nil).AnyTimes()
+	now = now.Add(500 * time.Millisecond)
+	c.increaseDatastorePool(context.Background())
+}
+
+func TestEC2CallBatchingUrgentIncrease(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	now := time.Now()
+	c := testBatchingContext(m, &now)
+	assert.NoError(t, c.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false))
+
+	// No free IP is left, a pod is waiting for this one: it is not held back by the window
+	m.awsutils.EXPECT().AllocIPAddresses(primaryENIid, gomock.Any()).Return(nil).Times(1)
+	m.awsutils.EXPECT().GetIPv4sFromEC2(primaryENIid).Return(nil, nil).AnyTimes()
+	c.increaseDatastorePool(context.Background())
+}
+
+func TestEC2CallBatchingFlushesOnShutdown(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	now := time.Now()
+	c := testBatchingContext(m, &now)
+
+	assert.NoError(t, c.deallocCidrs(primaryENIid, []string{ipaddr01}, false))
+	assert.NoError(t, c.deallocCidrs(primaryENIid, []string{prefix01}, true))
+
+	// The window hasn't passed, the unassignments are made anyway rather than lost
+	m.awsutils.EXPECT().DeallocIPAddresses(primaryENIid, []string{ipaddr01}).Return(nil)
+	m.awsutils.EXPECT().DeallocPrefixAddresses(primaryENIid, []string{prefix01}).Return(nil)
+	c.shutdown()
+	assert.True(t, c.isTerminating())
+}
@@ -0,0 +1,40 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+// updateENIAttachmentAgeMetrics refreshes the attachment age of every ENI from the datastore, dropping the ENIs that
+// were freed since the last update
+func (c *IPAMContext) updateENIAttachmentAgeMetrics() {
+	eniAttachmentAge.Reset()
+	for eniID, age := range c.dataStore.GetENIAttachmentAges() {
+		eniAttachmentAge.WithLabelValues(eniID).Set(age.Seconds())
+	}
+}
+
+// observeENILifetime records how long a freed ENI was attached, short lifetimes point at ENIs flapping between
+// attach and detach
+func observeENILifetime(eni datastore.ENI) {
+	if eni.AttachedTime.IsZero() {
+		return
+	}
+	lifetime := time.Since(eni.AttachedTime)
+	log.Infof("ENI %s was attached for %s", eni.ID, lifetime.Round(time.Second))
+	eniLifetime.Observe(lifetime.Seconds())
+}
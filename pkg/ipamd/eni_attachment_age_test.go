@@ -0,0 +1,77 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// countMetrics returns the number of metrics the collector currently exposes
+func countMetrics(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 16)
+	c.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+func TestUpdateENIAttachmentAgeMetrics(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{dataStore: testDatastore()}
+	now := time.Unix(1600000000, 0)
+	mockContext.dataStore.SetClock(func() time.Time { return now })
+	assert.NoError(t, mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false))
+	now = now.Add(time.Hour)
+	assert.NoError(t, mockContext.dataStore.AddENI(secENIid, secDevice, false, false, false))
+	now = now.Add(time.Minute)
+
+	mockContext.updateENIAttachmentAgeMetrics()
+	assert.Equal(t, 2, countMetrics(eniAttachmentAge))
+	assert.Equal(t, (time.Hour + time.Minute).Seconds(), testutil.ToFloat64(eniAttachmentAge.WithLabelValues(primaryENIid)))
+	assert.Equal(t, time.Minute.Seconds(), testutil.ToFloat64(eniAttachmentAge.WithLabelValues(secENIid)))
+
+	// Once freed, the ENI is no longer reported
+	assert.NoError(t, mockContext.dataStore.RemoveENIFromDataStore(secENIid, false))
+	mockContext.updateENIAttachmentAgeMetrics()
+	assert.Equal(t, 1, countMetrics(eniAttachmentAge))
+}
+
+func TestSetupENIKeepsEC2AttachTime(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:  m.awsutils,
+		dataStore:  testDatastore(),
+		primaryIP:  make(map[string]string),
+		maxENI:     4,
+		myNodeName: myNodeName,
+	}
+	now := time.Unix(1600000000, 0)
+	mockContext.dataStore.SetClock(func() time.Time { return now })
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid)
+
+	// An ENI found at startup has been attached for longer than ipamd has been running
+	eni := getPrimaryENIMetadata()
+	eni.AttachTime = now.Add(-24 * time.Hour)
+	assert.NoError(t, mockContext.setupENI(primaryENIid, eni, false, false))
+	mockContext.updateENIAttachmentAgeMetrics()
+	assert.Equal(t, (24 * time.Hour).Seconds(), testutil.ToFloat64(eniAttachmentAge.WithLabelValues(primaryENIid)))
+}
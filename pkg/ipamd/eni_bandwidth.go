@@ -0,0 +1,74 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sort"
+)
+
+// ENIPodDistribution is the number of pods using the IPs of one ENI
+type ENIPodDistribution struct {
+	ENIID        string
+	DeviceNumber int
+	IsPrimary    bool
+	Pods         int
+	// PodShare is the fraction of the node's pods on this ENI
+	PodShare float64
+}
+
+// ENIBandwidthHints reports how pods are spread over the ENIs, which share the instance's network bandwidth
+type ENIBandwidthHints struct {
+	InstanceType string
+	// NetworkPerformance is the performance tier of the instance type, empty if it could not be looked up
+	NetworkPerformance string `json:",omitempty"`
+	TotalPods          int
+	// MaxENIPodShare is the largest PodShare of any ENI. It is 1 when all pods use a single ENI.
+	MaxENIPodShare float64
+	ENIs           []ENIPodDistribution
+}
+
+// getENIBandwidthHints builds the bandwidth hints from the instance type and the datastore, ENIs are sorted by device
+// number
+func (c *IPAMContext) getENIBandwidthHints() ENIBandwidthHints {
+	hints := ENIBandwidthHints{InstanceType: c.awsClient.GetInstanceType()}
+	networkPerformance, err := c.awsClient.GetNetworkPerformance()
+	if err != nil {
+		log.Warnf("Failed to get the network performance of instance type %s: %v", hints.InstanceType, err)
+	}
+	hints.NetworkPerformance = networkPerformance
+
+	for _, eni := range c.dataStore.GetENIInfos().ENIs {
+		pods := eni.AssignedIPv4Addresses()
+		hints.TotalPods += pods
+		hints.ENIs = append(hints.ENIs, ENIPodDistribution{
+			ENIID:        eni.ID,
+			DeviceNumber: eni.DeviceNumber,
+			IsPrimary:    eni.IsPrimary,
+			Pods:         pods,
+		})
+	}
+	sort.Slice(hints.ENIs, func(i, j int) bool {
+		return hints.ENIs[i].DeviceNumber < hints.ENIs[j].DeviceNumber
+	})
+	if hints.TotalPods == 0 {
+		return hints
+	}
+	for i := range hints.ENIs {
+		hints.ENIs[i].PodShare = float64(hints.ENIs[i].Pods) / float64(hints.TotalPods)
+		if hints.ENIs[i].PodShare > hints.MaxENIPodShare {
+			hints.MaxENIPodShare = hints.ENIs[i].PodShare
+		}
+	}
+	return hints
+}
@@ -0,0 +1,42 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
+)
+
+// nodeENIConfigName returns the name of the ENIConfig the node resolves to, which new ENIs are created from, or an
+// empty string when it can't be looked up
+func (c *IPAMContext) nodeENIConfigName(ctx context.Context) string {
+	eniConfigName, err := eniconfig.GetNodeSpecificENIConfigName(ctx, c.cachedK8SClient)
+	if err != nil {
+		log.Warnf("Failed to look up the name of the node's ENIConfig: %v", err)
+		return ""
+	}
+	return eniConfigName
+}
+
+// setENIConfig labels the IPs of a new ENI in the datastore metrics with the ENIConfig it was created from. ENIs found
+// attached at startup keep an empty ENIConfig, since the one the node resolves to now may not be the one they came from.
+func (c *IPAMContext) setENIConfig(eni string, eniConfigName string) {
+	if eniConfigName == "" {
+		return
+	}
+	if err := c.dataStore.SetENIConfig(eni, eniConfigName); err != nil {
+		log.Warnf("Failed to record the ENIConfig of ENI %s: %v", eni, err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+)
+
+func TestAllocENIRecordsENIOrigin(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	_ = os.Setenv("MY_NODE_NAME", myNodeName)
+	fakeNode := v1.Node{
+		TypeMeta:   metav1.TypeMeta{Kind: "Node"},
+		ObjectMeta: metav1.ObjectMeta{Name: myNodeName, Labels: map[string]string{"k8s.amazonaws.com/eniConfig": "us-west-2a"}},
+	}
+	assert.NoError(t, m.cachedK8SClient.Create(ctx, &fakeNode))
+	fakeENIConfig := v1alpha1.ENIConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "us-west-2a"},
+		Spec:       v1alpha1.ENIConfigSpec{Subnet: "subnet1", SecurityGroups: []string{"sg1-id"}},
+	}
+	assert.NoError(t, m.cachedK8SClient.Create(ctx, &fakeENIConfig))
+
+	c := &IPAMContext{
+		awsClient:           m.awsutils,
+		networkClient:       m.network,
+		cachedK8SClient:     m.cachedK8SClient,
+		dataStore:           testDatastore(),
+		primaryIP:           make(map[string]string),
+		maxIPsPerENI:        14,
+		useCustomNetworking: true,
+	}
+
+	primaryENIMetadata := getPrimaryENIMetadata()
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	assert.NoError(t, c.setupENI(primaryENIid, primaryENIMetadata, false, false))
+
+	secENIMetadata := getSecondaryENIMetadata()
+	m.awsutils.EXPECT().AllocENI(true, []*string{aws.String("sg1-id")}, "subnet1").Return(secENIid, nil)
+	m.awsutils.EXPECT().AllocIPAddresses(secENIid, gomock.Any())
+	m.awsutils.EXPECT().WaitForENIAndIPsAttached(secENIid, gomock.Any()).Return(secENIMetadata, nil)
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	m.network.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, secENIMetadata.SubnetIPv4CIDR).Return(nil)
+	assert.NoError(t, c.tryAllocateENI(ctx))
+
+	enis := c.dataStore.GetENIInfos().ENIs
+	// The primary ENI is in the node's subnet, not in the ENIConfig's
+	assert.Equal(t, "", enis[primaryENIid].ENIConfig)
+	assert.Equal(t, primaryENIMetadata.SubnetIPv4CIDR, enis[primaryENIid].Subnet)
+	assert.Equal(t, "us-west-2a", enis[secENIid].ENIConfig)
+	assert.Equal(t, secENIMetadata.SubnetIPv4CIDR, enis[secENIid].Subnet)
+
+	// After a restart the ENI is found attached, it is not labeled with the ENIConfig the node resolves to now
+	c.dataStore = testDatastore()
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	m.network.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, secENIMetadata.SubnetIPv4CIDR).Return(nil)
+	assert.NoError(t, c.setupENI(secENIid, secENIMetadata, false, false))
+	enis = c.dataStore.GetENIInfos().ENIs
+	assert.Equal(t, "", enis[secENIid].ENIConfig)
+	assert.Equal(t, secENIMetadata.SubnetIPv4CIDR, enis[secENIid].Subnet)
+}
@@ -0,0 +1,86 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// envENIQuotaWarningPercent makes ipamd warn at startup when the cluster is estimated to use this percentage of the
+// account's ENI quota in the region or more, 0 skips the check
+const envENIQuotaWarningPercent = "ENI_QUOTA_WARNING_PERCENT"
+
+// eniQuotaCheckTimeout bounds the startup ENI quota check
+const eniQuotaCheckTimeout = 30 * time.Second
+
+func getENIQuotaWarningPercent() int {
+	inputStr, found := os.LookupEnv(envENIQuotaWarningPercent)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 && input <= 100 {
+		log.Debugf("Using %s %v", envENIQuotaWarningPercent, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, must be between 0 and 100, not checking the ENI quota", envENIQuotaWarningPercent, inputStr)
+	return 0
+}
+
+// checkENIQuota warns when the nodes of the cluster, each taking as many ENIs as this one, would use warningPercent of
+// the account's ENI quota or more. Other ENIs of the account count toward the quota too, so running close to it makes
+// ENI allocations fail in a confusing way. It is best-effort, a failed lookup is only logged. It returns true if it
+// warned. The nodes are listed from the cache, which already watches them for the node's own lookups.
+func (c *IPAMContext) checkENIQuota(ctx context.Context, warningPercent int) bool {
+	if warningPercent <= 0 {
+		return false
+	}
+	quota, err := c.awsClient.GetENIQuota()
+	if err != nil {
+		log.Infof("Not checking the ENI quota of the account: %v", err)
+		return false
+	}
+	var nodes corev1.NodeList
+	if err := c.cachedK8SClient.List(ctx, &nodes); err != nil {
+		log.Infof("Not checking the ENI quota of the account, failed to list the nodes: %v", err)
+		return false
+	}
+	estimate := len(nodes.Items) * c.dataStore.GetENIs()
+	if estimate*100 < quota*warningPercent {
+		log.Debugf("The %d nodes of the cluster are estimated to use %d of the %d ENIs of the account's quota",
+			len(nodes.Items), estimate, quota)
+		return false
+	}
+	log.Warnf("The %d nodes of the cluster are estimated to use %d ENIs, %d%% or more of the account's quota of %d "+
+		"ENIs in the region, ENIs may fail to be created once it is reached", len(nodes.Items), estimate,
+		warningPercent, quota)
+	return true
+}
+
+// startENIQuotaCheck runs checkENIQuota in the background, bounded by eniQuotaCheckTimeout, so that a slow Service
+// Quotas or API server call does not hold up startup
+func (c *IPAMContext) startENIQuotaCheck(warningPercent int) {
+	if warningPercent <= 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eniQuotaCheckTimeout)
+		defer cancel()
+		c.checkENIQuota(ctx, warningPercent)
+	}()
+}
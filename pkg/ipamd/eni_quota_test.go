@@ -0,0 +1,91 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetENIQuotaWarningPercent(t *testing.T) {
+	defer os.Unsetenv(envENIQuotaWarningPercent)
+
+	assert.Equal(t, 0, getENIQuotaWarningPercent())
+
+	_ = os.Setenv(envENIQuotaWarningPercent, "80")
+	assert.Equal(t, 80, getENIQuotaWarningPercent())
+
+	_ = os.Setenv(envENIQuotaWarningPercent, "120")
+	assert.Equal(t, 0, getENIQuotaWarningPercent())
+}
+
+func TestCheckENIQuota(t *testing.T) {
+	ctx := context.Background()
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	// 10 nodes taking 3 ENIs each
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, m.cachedK8SClient.Create(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}}))
+	}
+	mockContext := &IPAMContext{awsClient: m.awsutils, cachedK8SClient: m.cachedK8SClient, dataStore: testDatastore()}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, mockContext.dataStore.AddENI(fmt.Sprintf("eni-%d", i), i, i == 0, false, false))
+	}
+
+	// The 30 ENIs are 75% of the quota
+	m.awsutils.EXPECT().GetENIQuota().Return(40, nil)
+	assert.True(t, mockContext.checkENIQuota(ctx, 75))
+
+	// Below the threshold
+	m.awsutils.EXPECT().GetENIQuota().Return(50, nil)
+	assert.False(t, mockContext.checkENIQuota(ctx, 75))
+
+	// The check is best-effort
+	m.awsutils.EXPECT().GetENIQuota().Return(0, errors.New("AccessDeniedException"))
+	assert.False(t, mockContext.checkENIQuota(ctx, 75))
+
+	// Disabled, the quota isn't looked up
+	assert.False(t, mockContext.checkENIQuota(ctx, 0))
+}
+
+func TestStartENIQuotaCheck(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	mockContext := &IPAMContext{awsClient: m.awsutils, cachedK8SClient: m.cachedK8SClient, dataStore: testDatastore()}
+
+	// Disabled, nothing runs
+	mockContext.startENIQuotaCheck(0)
+
+	// The check runs in the background, startup does not wait for it
+	checked := make(chan struct{})
+	m.awsutils.EXPECT().GetENIQuota().DoAndReturn(func() (int, error) {
+		close(checked)
+		return 0, errors.New("AccessDeniedException")
+	})
+	mockContext.startENIQuotaCheck(75)
+	select {
+	case <-checked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the ENI quota was not checked")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+const (
+	// envFastIPReleaseGracePeriod releases the IP of a sandbox that has been gone from the container runtime for this
+	// many seconds, without waiting for its CNI DEL or the next ipamd restart. 0 disables it.
+	envFastIPReleaseGracePeriod = "FAST_IP_RELEASE_GRACE_PERIOD_SECONDS"
+)
+
+func getFastIPReleaseGracePeriod() time.Duration {
+	inputStr, found := os.LookupEnv(envFastIPReleaseGracePeriod)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envFastIPReleaseGracePeriod, input)
+		return time.Duration(input) * time.Second
+	}
+	log.Warnf("Invalid %s value %q, not releasing the IPs of gone sandboxes early", envFastIPReleaseGracePeriod, inputStr)
+	return 0
+}
+
+// fastIPRelease tracks the sandboxes holding an IP that the container runtime no longer knows of
+type fastIPRelease struct {
+	gracePeriod time.Duration
+	// goneSince is when each sandbox was first seen gone, by sandbox ID
+	goneSince map[string]time.Time
+}
+
+// releaseGoneSandboxIPs releases the IPs of the sandboxes that have been gone from the container runtime for the
+// grace period. A pod restarting in place keeps its sandbox, ready or not, so only a sandbox missing from the runtime
+// altogether is gone. The IPs assigned less than the grace period ago are left alone too, their sandbox may not be
+// listed yet while it is being created.
+func (c *IPAMContext) releaseGoneSandboxIPs(now time.Time) {
+	r := &c.fastIPRelease
+	if r.gracePeriod <= 0 {
+		return
+	}
+	states, err := c.criClient.GetPodSandboxStates(log)
+	if err != nil {
+		log.Warnf("Failed to list the sandboxes, not releasing the IPs of gone ones: %v", err)
+		return
+	}
+
+	goneSince := make(map[string]time.Time)
+	for _, info := range c.dataStore.AllocatedIPs() {
+		sandboxID := info.IPAMKey.ContainerID
+		if _, ok := states[sandboxID]; ok || now.Sub(info.AssignedTime) < r.gracePeriod {
+			continue
+		}
+		since, ok := goneSince[sandboxID]
+		if !ok {
+			since, ok = r.goneSince[sandboxID]
+		}
+		if !ok {
+			log.Infof("Sandbox %s holding IP %s is gone from the container runtime", sandboxID, info.IP)
+			since = now
+		}
+		if now.Sub(since) < r.gracePeriod {
+			goneSince[sandboxID] = since
+			continue
+		}
+		// Under the sandbox lock, so a DEL of the sandbox coming in meanwhile either released the IP already or
+		// waits for this release
+		unlock := c.sandboxLocks.lockSandbox(sandboxID)
+		_, _, _, err := c.dataStore.UnassignPodIPv4Address(info.IPAMKey)
+		unlock()
+		if err == datastore.ErrUnknownPod {
+			log.Infof("IP %s of gone sandbox %s was already released", info.IP, sandboxID)
+			continue
+		}
+		if err != nil {
+			log.Warnf("Failed to release IP %s of gone sandbox %s: %v", info.IP, sandboxID, err)
+			goneSince[sandboxID] = since
+			continue
+		}
+		log.Infof("Released IP %s of sandbox %s, gone from the container runtime for %s", info.IP, sandboxID,
+			now.Sub(since).Round(time.Second))
+		reconcileCnt.With(prometheus.Labels{"fn": "releaseGoneSandboxIP"}).Inc()
+	}
+	// The sandboxes that came back, or whose IP got released by a CNI DEL, are forgotten
+	r.goneSince = goneSince
+}
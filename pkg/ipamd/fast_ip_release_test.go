@@ -0,0 +1,164 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/cri"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func TestGetFastIPReleaseGracePeriod(t *testing.T) {
+	defer os.Unsetenv(envFastIPReleaseGracePeriod)
+
+	assert.Equal(t, time.Duration(0), getFastIPReleaseGracePeriod())
+
+	_ = os.Setenv(envFastIPReleaseGracePeriod, "30")
+	assert.Equal(t, 30*time.Second, getFastIPReleaseGracePeriod())
+
+	_ = os.Setenv(envFastIPReleaseGracePeriod, "-1")
+	assert.Equal(t, time.Duration(0), getFastIPReleaseGracePeriod())
+}
+
+func TestReleaseGoneSandboxIPs(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		criClient:     m.cri,
+		dataStore:     testDatastore(),
+		fastIPRelease: fastIPRelease{gracePeriod: time.Minute},
+	}
+	now := time.Unix(1600000000, 0)
+	mockContext.dataStore.SetClock(func() time.Time { return now })
+	assert.NoError(t, mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false))
+	for _, ip := range []string{ipaddr01, ipaddr02, ipaddr03} {
+		assert.NoError(t, mockContext.dataStore.AddIPv4CidrToStore(primaryENIid,
+			net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+	restarting := datastore.IPAMKey{NetworkName: "net0", ContainerID: "restarting", IfName: "eth0"}
+	removed := datastore.IPAMKey{NetworkName: "net0", ContainerID: "removed", IfName: "eth0"}
+	for _, key := range []datastore.IPAMKey{restarting, removed} {
+		_, _, err := mockContext.dataStore.AssignPodIPv4Address(key)
+		assert.NoError(t, err)
+	}
+	now = now.Add(time.Minute)
+	// Assigned just now, its sandbox isn't listed yet while being created
+	creating := datastore.IPAMKey{NetworkName: "net0", ContainerID: "creating", IfName: "eth0"}
+	_, _, err := mockContext.dataStore.AssignPodIPv4Address(creating)
+	assert.NoError(t, err)
+	assigned := func() []string {
+		var sandboxes []string
+		for _, info := range mockContext.dataStore.AllocatedIPs() {
+			sandboxes = append(sandboxes, info.IPAMKey.ContainerID)
+		}
+		return sandboxes
+	}
+
+	// The restarting pod's sandbox is stopped but still known to the runtime, the removed one is gone
+	states := map[string]cri.SandboxState{"restarting": cri.SandboxNotReady}
+	m.cri.EXPECT().GetPodSandboxStates(gomock.Any()).Return(states, nil).Times(3)
+
+	// It is only released once gone for the grace period
+	mockContext.releaseGoneSandboxIPs(now)
+	assert.ElementsMatch(t, []string{"restarting", "removed", "creating"}, assigned())
+	now = now.Add(30 * time.Second)
+	mockContext.releaseGoneSandboxIPs(now)
+	assert.ElementsMatch(t, []string{"restarting", "removed", "creating"}, assigned())
+	now = now.Add(30 * time.Second)
+	mockContext.releaseGoneSandboxIPs(now)
+	assert.ElementsMatch(t, []string{"restarting", "creating"}, assigned())
+	assert.Contains(t, mockContext.fastIPRelease.goneSince, "creating")
+
+	// A failed listing, a dial timing out, skips the pass and keeps the sandboxes seen gone
+	m.cri.EXPECT().GetPodSandboxStates(gomock.Any()).Return(nil, errors.New("context deadline exceeded"))
+	mockContext.releaseGoneSandboxIPs(now.Add(time.Hour))
+	assert.ElementsMatch(t, []string{"restarting", "creating"}, assigned())
+	assert.Contains(t, mockContext.fastIPRelease.goneSince, "creating")
+
+	// The sandbox showing up again resets its grace period
+	m.cri.EXPECT().GetPodSandboxStates(gomock.Any()).Return(
+		map[string]cri.SandboxState{"restarting": cri.SandboxReady, "creating": cri.SandboxReady}, nil)
+	now = now.Add(time.Minute)
+	mockContext.releaseGoneSandboxIPs(now)
+	assert.ElementsMatch(t, []string{"restarting", "creating"}, assigned())
+	assert.Empty(t, mockContext.fastIPRelease.goneSince)
+}
+
+func TestReleaseGoneSandboxIPsDisabled(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	// Without a grace period the runtime is never asked
+	mockContext := &IPAMContext{criClient: m.cri, dataStore: testDatastore()}
+	mockContext.releaseGoneSandboxIPs(time.Now())
+}
+
+func TestReleaseGoneSandboxIPsWaitsForSandboxLock(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		criClient:     m.cri,
+		dataStore:     testDatastore(),
+		fastIPRelease: fastIPRelease{gracePeriod: time.Minute},
+	}
+	now := time.Unix(1600000000, 0)
+	mockContext.dataStore.SetClock(func() time.Time { return now })
+	assert.NoError(t, mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false))
+	assert.NoError(t, mockContext.dataStore.AddIPv4CidrToStore(primaryENIid,
+		net.IPNet{IP: net.ParseIP(ipaddr01), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	removed := datastore.IPAMKey{NetworkName: "net0", ContainerID: "removed", IfName: "eth0"}
+	_, _, err := mockContext.dataStore.AssignPodIPv4Address(removed)
+	assert.NoError(t, err)
+	m.cri.EXPECT().GetPodSandboxStates(gomock.Any()).Return(map[string]cri.SandboxState{}, nil).Times(2)
+	now = now.Add(time.Minute)
+	mockContext.releaseGoneSandboxIPs(now)
+	assert.Contains(t, mockContext.fastIPRelease.goneSince, "removed")
+	now = now.Add(time.Minute)
+
+	// A DEL of the sandbox holds its lock, the release waits for it instead of unassigning the IP under it
+	unlock := mockContext.sandboxLocks.lockSandbox("removed")
+	released := make(chan struct{})
+	go func() {
+		mockContext.releaseGoneSandboxIPs(now)
+		close(released)
+	}()
+	select {
+	case <-released:
+		t.Fatal("released the IP while the sandbox lock was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, _, _, err = mockContext.dataStore.UnassignPodIPv4Address(removed)
+	assert.NoError(t, err)
+	unlock()
+
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the release")
+	}
+	// The IP released by the DEL is not retried by the next passes
+	assert.Empty(t, mockContext.dataStore.AllocatedIPs())
+	assert.Empty(t, mockContext.fastIPRelease.goneSince)
+	assert.Empty(t, mockContext.sandboxLocks.locks)
+}
@@ -0,0 +1,79 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// envHostReservedIPs is a comma separated list of IPv4 addresses bound on the host, for example by a host network
+// service, that must never be handed out to pods
+const envHostReservedIPs = "HOST_RESERVED_IPS"
+
+func getHostReservedIPs() []string {
+	var reserved []string
+	for _, s := range strings.Split(os.Getenv(envHostReservedIPs), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil || ip.To4() == nil {
+			log.Warnf("Ignoring invalid IPv4 address %q in %s", s, envHostReservedIPs)
+			continue
+		}
+		reserved = append(reserved, ip.To4().String())
+	}
+	return reserved
+}
+
+// hostIPs returns the addresses that belong to the host: the primary IP of every ENI managed by ipamd, which includes
+// the node's primary IP, and the addresses listed in HOST_RESERVED_IPS
+func (c *IPAMContext) hostIPs() []string {
+	seen := make(map[string]bool)
+	var hostIPs []string
+	add := func(ip string) {
+		if ip != "" && !seen[ip] {
+			seen[ip] = true
+			hostIPs = append(hostIPs, ip)
+		}
+	}
+	for _, ip := range c.primaryIP {
+		add(ip)
+	}
+	for _, ip := range getHostReservedIPs() {
+		add(ip)
+	}
+	sort.Strings(hostIPs)
+	return hostIPs
+}
+
+// updateHostReservedIPs keeps the host addresses out of the pod pool
+func (c *IPAMContext) updateHostReservedIPs() {
+	c.dataStore.SetReservedIPv4Addresses(c.hostIPs())
+}
+
+// checkHostIPConflicts flags every pod that holds an address of the host. ipamd never assigns one, so a conflict means
+// the address became a host address after the pod got it, and traffic to the pod is likely misrouted.
+func (c *IPAMContext) checkHostIPConflicts() int {
+	conflicts := c.dataStore.GetReservedIPv4Conflicts()
+	for ip, ipamKey := range conflicts {
+		log.Errorf("IP %s assigned to sandbox %s conflicts with an address of the host", ip, ipamKey)
+		ipamdErrInc("reconcileHostIPConflict")
+	}
+	return len(conflicts)
+}
@@ -0,0 +1,91 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func TestGetHostReservedIPs(t *testing.T) {
+	defer os.Unsetenv(envHostReservedIPs)
+
+	os.Unsetenv(envHostReservedIPs)
+	assert.Empty(t, getHostReservedIPs())
+
+	os.Setenv(envHostReservedIPs, " 10.0.0.50, not-an-ip,,2001:db8::1,10.0.0.51")
+	assert.Equal(t, []string{"10.0.0.50", "10.0.0.51"}, getHostReservedIPs())
+}
+
+func TestHostIPs(t *testing.T) {
+	defer os.Unsetenv(envHostReservedIPs)
+	os.Setenv(envHostReservedIPs, "10.0.0.50,"+ipaddr01)
+
+	c := &IPAMContext{primaryIP: map[string]string{primaryENIid: ipaddr01, secENIid: ipaddr11}}
+	assert.Equal(t, []string{"10.0.0.50", ipaddr01, ipaddr11}, c.hostIPs())
+}
+
+func TestAddENIsecondaryIPsSkipsHostIPs(t *testing.T) {
+	defer os.Unsetenv(envHostReservedIPs)
+	os.Setenv(envHostReservedIPs, ipaddr03)
+
+	c := &IPAMContext{
+		dataStore: testDatastore(),
+		primaryIP: map[string]string{primaryENIid: ipaddr01},
+	}
+	assert.NoError(t, c.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false))
+	c.updateHostReservedIPs()
+
+	// The pool handed over by EC2 includes the node's primary IP, flagged as a secondary IP by stale metadata
+	c.addENIsecondaryIPsToDataStore([]*ec2.NetworkInterfacePrivateIpAddress{
+		{PrivateIpAddress: aws.String(ipaddr01), Primary: aws.Bool(false)},
+		{PrivateIpAddress: aws.String(ipaddr02), Primary: aws.Bool(false)},
+		{PrivateIpAddress: aws.String(ipaddr03), Primary: aws.Bool(false)},
+	}, primaryENIid)
+
+	total, _, _ := c.dataStore.GetStats()
+	assert.Equal(t, 1, total)
+	ip, _, err := c.dataStore.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"})
+	assert.NoError(t, err)
+	assert.Equal(t, ipaddr02, ip)
+	_, _, err = c.dataStore.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-2", IfName: "eth0"})
+	assert.Error(t, err)
+}
+
+func TestCheckHostIPConflicts(t *testing.T) {
+	c := &IPAMContext{
+		dataStore: testDatastore(),
+		primaryIP: map[string]string{primaryENIid: ipaddr01},
+	}
+	assert.NoError(t, c.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false))
+	assert.NoError(t, c.dataStore.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	_, _, err := c.dataStore.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"})
+	assert.NoError(t, err)
+
+	c.updateHostReservedIPs()
+	assert.Equal(t, 0, c.checkHostIPConflicts())
+
+	// The pod's address is later bound on the host
+	defer os.Unsetenv(envHostReservedIPs)
+	os.Setenv(envHostReservedIPs, ipaddr02)
+	c.updateHostReservedIPs()
+	assert.Equal(t, 1, c.checkHostIPConflicts())
+}
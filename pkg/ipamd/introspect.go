@@ -87,6 +87,12 @@ func (c *IPAMContext) setupIntrospectionServer() *http.Server {
 		"/v1/eni-configs":               eniConfigRequestHandler(c),
 		"/v1/networkutils-env-settings": networkEnvV1RequestHandler(),
 		"/v1/ipamd-env-settings":        ipamdEnvV1RequestHandler(),
+		"/v1/reconcile-status":          reconcileStatusV1RequestHandler(c),
+		"/v1/eni-bandwidth":             eniBandwidthV1RequestHandler(c),
+		"/v1/eni-cleanup-history":       eniCleanupHistoryV1RequestHandler(c),
+		"/v1/ec2-api-status":            ec2APIStatusV1RequestHandler(c),
+		"/v1/warm-target-miss":          warmTargetMissV1RequestHandler(c),
+		"/v1/active-eni-config":         activeENIConfigV1RequestHandler(c),
 	}
 	paths := make([]string, 0, len(serverFunctions))
 	for path := range serverFunctions {
@@ -184,6 +190,78 @@ func ipamdEnvV1RequestHandler() func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+func reconcileStatusV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseJSON, err := json.Marshal(ipam.reconcileStatus.status())
+		if err != nil {
+			log.Errorf("Failed to marshal reconcile status: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logErr(w.Write(responseJSON))
+	}
+}
+
+func eniBandwidthV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseJSON, err := json.Marshal(ipam.getENIBandwidthHints())
+		if err != nil {
+			log.Errorf("Failed to marshal ENI bandwidth hints: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logErr(w.Write(responseJSON))
+	}
+}
+
+func eniCleanupHistoryV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseJSON, err := json.Marshal(ipam.awsClient.GetENICleanupHistory())
+		if err != nil {
+			log.Errorf("Failed to marshal ENI cleanup history: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logErr(w.Write(responseJSON))
+	}
+}
+
+func ec2APIStatusV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseJSON, err := json.Marshal(ipam.awsClient.GetEC2APIStatus())
+		if err != nil {
+			log.Errorf("Failed to marshal EC2 API status: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logErr(w.Write(responseJSON))
+	}
+}
+
+func warmTargetMissV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseJSON, err := json.Marshal(ipam.warmTargetMiss.status())
+		if err != nil {
+			log.Errorf("Failed to marshal warm target miss: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logErr(w.Write(responseJSON))
+	}
+}
+
+func activeENIConfigV1RequestHandler(ipam *IPAMContext) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseJSON, err := json.Marshal(ipam.activeENIConfig.status())
+		if err != nil {
+			log.Errorf("Failed to marshal active ENI config: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logErr(w.Write(responseJSON))
+	}
+}
+
 func logErr(_ int, err error) {
 	if err != nil {
 		log.Errorf("Write failed: %v", err)
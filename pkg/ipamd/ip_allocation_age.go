@@ -0,0 +1,82 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ipAllocationAgeBuckets are the upper bounds, in seconds, of the IP allocation age histogram: 1m, 10m, 1h, 6h, 1d,
+// 7d and 30d
+var ipAllocationAgeBuckets = []float64{60, 600, 3600, 21600, 86400, 604800, 2592000}
+
+// ipAllocationAgeCollector exposes the distribution of the ages of the IPs currently assigned to pods. Unlike a
+// regular histogram, which accumulates every observation, it only reports the snapshot of the last update, so
+// released IPs drop out of it.
+type ipAllocationAgeCollector struct {
+	desc    *prometheus.Desc
+	lock    sync.Mutex
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+func newIPAllocationAgeCollector() *ipAllocationAgeCollector {
+	return &ipAllocationAgeCollector{
+		desc: prometheus.NewDesc("awscni_ip_allocation_age_seconds",
+			"The time since the IPs currently assigned to pods were assigned, updated on reconcile", nil, nil),
+		buckets: make(map[float64]uint64),
+	}
+}
+
+// update replaces the snapshot with the given allocation ages
+func (c *ipAllocationAgeCollector) update(ages []time.Duration) {
+	buckets := make(map[float64]uint64, len(ipAllocationAgeBuckets))
+	sum := 0.0
+	for _, age := range ages {
+		seconds := age.Seconds()
+		sum += seconds
+		for _, bound := range ipAllocationAgeBuckets {
+			if seconds <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.count = uint64(len(ages))
+	c.sum = sum
+	c.buckets = buckets
+}
+
+// Describe implements prometheus.Collector
+func (c *ipAllocationAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *ipAllocationAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ch <- prometheus.MustNewConstHistogram(c.desc, c.count, c.sum, c.buckets)
+}
+
+// updateIPAllocationAgeMetrics refreshes the IP allocation age histogram from the datastore
+func (c *IPAMContext) updateIPAllocationAgeMetrics() {
+	ipAllocationAge.update(c.dataStore.GetIPAllocationAges())
+}
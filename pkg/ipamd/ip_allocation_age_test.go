@@ -0,0 +1,79 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+// collectHistogram returns the histogram reported by the collector
+func collectHistogram(t *testing.T, collector prometheus.Collector) *dto.Histogram {
+	ch := make(chan prometheus.Metric, 1)
+	collector.Collect(ch)
+	metric := &dto.Metric{}
+	assert.NoError(t, (<-ch).Write(metric))
+	return metric.GetHistogram()
+}
+
+func TestUpdateIPAllocationAgeMetrics(t *testing.T) {
+	ds := testDatastore()
+	now := time.Unix(1600000000, 0)
+	ds.SetClock(func() time.Time { return now })
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	for _, ip := range []string{ipaddr01, ipaddr02, ipaddr03, ipaddr11} {
+		assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+
+	// Allocations held for 8 days, 2 hours and 30 seconds
+	oldPod := datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-old", IfName: "eth0"}
+	for _, step := range []struct {
+		key     datastore.IPAMKey
+		advance time.Duration
+	}{
+		{oldPod, 8*24*time.Hour - 2*time.Hour},
+		{datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-2h", IfName: "eth0"}, 2*time.Hour - 30*time.Second},
+		{datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-30s", IfName: "eth0"}, 30 * time.Second},
+	} {
+		_, _, err := ds.AssignPodIPv4Address(step.key)
+		assert.NoError(t, err)
+		now = now.Add(step.advance)
+	}
+
+	mockContext := &IPAMContext{dataStore: ds}
+	mockContext.updateIPAllocationAgeMetrics()
+	histogram := collectHistogram(t, ipAllocationAge)
+	assert.Equal(t, uint64(3), histogram.GetSampleCount())
+	assert.Equal(t, (8*24*time.Hour + 2*time.Hour + 30*time.Second).Seconds(), histogram.GetSampleSum())
+	expected := map[float64]uint64{60: 1, 600: 1, 3600: 1, 21600: 2, 86400: 2, 604800: 2, 2592000: 3}
+	for _, bucket := range histogram.GetBucket() {
+		assert.Equal(t, expected[bucket.GetUpperBound()], bucket.GetCumulativeCount(), bucket.GetUpperBound())
+	}
+	assert.Len(t, histogram.GetBucket(), len(expected))
+
+	// The histogram only reflects the current allocations
+	_, _, _, err := ds.UnassignPodIPv4Address(oldPod)
+	assert.NoError(t, err)
+	mockContext.updateIPAllocationAgeMetrics()
+	histogram = collectHistogram(t, ipAllocationAge)
+	assert.Equal(t, uint64(2), histogram.GetSampleCount())
+	assert.Equal(t, (2*time.Hour + 30*time.Second).Seconds(), histogram.GetSampleSum())
+}
@@ -0,0 +1,40 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+// envIPAssignmentStrategy is how the datastore picks the free address given to a pod, see the
+// datastore.IPAssignmentStrategy constants. Unset keeps picking the first free address found.
+const envIPAssignmentStrategy = "IP_ASSIGNMENT_STRATEGY"
+
+func getIPAssignmentStrategy() string {
+	strategy, found := os.LookupEnv(envIPAssignmentStrategy)
+	if !found {
+		return datastore.IPAssignmentStrategyDefault
+	}
+	switch strategy {
+	case datastore.IPAssignmentStrategyDefault, datastore.IPAssignmentStrategyLowest, datastore.IPAssignmentStrategyLRU,
+		datastore.IPAssignmentStrategyRandom:
+		log.Debugf("Using %s %q", envIPAssignmentStrategy, strategy)
+		return strategy
+	}
+	log.Warnf("Invalid %s value %q, must be %q, %q or %q, ignoring it", envIPAssignmentStrategy, strategy,
+		datastore.IPAssignmentStrategyLowest, datastore.IPAssignmentStrategyLRU, datastore.IPAssignmentStrategyRandom)
+	return datastore.IPAssignmentStrategyDefault
+}
@@ -0,0 +1,36 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func TestGetIPAssignmentStrategy(t *testing.T) {
+	defer os.Unsetenv(envIPAssignmentStrategy)
+
+	assert.Equal(t, datastore.IPAssignmentStrategyDefault, getIPAssignmentStrategy())
+	for _, strategy := range []string{datastore.IPAssignmentStrategyLowest, datastore.IPAssignmentStrategyLRU,
+		datastore.IPAssignmentStrategyRandom} {
+		_ = os.Setenv(envIPAssignmentStrategy, strategy)
+		assert.Equal(t, strategy, getIPAssignmentStrategy())
+	}
+	_ = os.Setenv(envIPAssignmentStrategy, "highest")
+	assert.Equal(t, datastore.IPAssignmentStrategyDefault, getIPAssignmentStrategy())
+}
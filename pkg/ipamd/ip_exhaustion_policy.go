@@ -0,0 +1,102 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+const (
+	// envIPExhaustionPolicy is what a CNI ADD does when the datastore has no free IP for the pod
+	envIPExhaustionPolicy = "IP_EXHAUSTION_POLICY"
+	// envIPExhaustionQueueTimeout is the number of milliseconds a CNI ADD waits for a free IP with the
+	// queue-with-timeout policy
+	envIPExhaustionQueueTimeout = "IP_EXHAUSTION_QUEUE_TIMEOUT_MS"
+
+	// ipExhaustionPolicyReject fails the CNI ADD right away, the kubelet retries it
+	ipExhaustionPolicyReject = "reject"
+	// ipExhaustionPolicyQueue holds the CNI ADD until an IP is freed, by the pool growing or the IP of an exited pod
+	// leaving its cooling period, or the timeout expires
+	ipExhaustionPolicyQueue = "queue-with-timeout"
+
+	defaultIPExhaustionQueueTimeout = 5 * time.Second
+)
+
+// ipExhaustionQueuePollInterval is how often a queued CNI ADD looks for a free IP again
+var ipExhaustionQueuePollInterval = 100 * time.Millisecond
+
+func getIPExhaustionPolicy() string {
+	policy, found := os.LookupEnv(envIPExhaustionPolicy)
+	if !found {
+		return ipExhaustionPolicyReject
+	}
+	switch policy {
+	case ipExhaustionPolicyReject, ipExhaustionPolicyQueue:
+		return policy
+	}
+	log.Warnf("Invalid %s value %q, using %q", envIPExhaustionPolicy, policy, ipExhaustionPolicyReject)
+	return ipExhaustionPolicyReject
+}
+
+func getIPExhaustionQueueTimeout() time.Duration {
+	inputStr, found := os.LookupEnv(envIPExhaustionQueueTimeout)
+	if !found {
+		return defaultIPExhaustionQueueTimeout
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envIPExhaustionQueueTimeout, input)
+		return time.Duration(input) * time.Millisecond
+	}
+	log.Warnf("Invalid %s value %q, using %v", envIPExhaustionQueueTimeout, inputStr, defaultIPExhaustionQueueTimeout)
+	return defaultIPExhaustionQueueTimeout
+}
+
+// queueForPodIPv4Address applies the IP exhaustion policy to an assignment that failed with err: with
+// queue-with-timeout, it assigns the first IP freed for the pod before the timeout expires. Other failures, and all of
+// them with the reject policy, are returned as is.
+func (c *IPAMContext) queueForPodIPv4Address(ctx context.Context, ipamKey datastore.IPAMKey, subnet string, err error) (string, int, error) {
+	if c.ipExhaustionPolicy != ipExhaustionPolicyQueue || errors.Cause(err) != datastore.ErrNoAvailableIPs {
+		return "", -1, err
+	}
+	log.Infof("No free IP for sandbox %s, waiting up to %v for one", ipamKey, c.ipExhaustionQueueTimeout)
+	timeout := time.NewTimer(c.ipExhaustionQueueTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(ipExhaustionQueuePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", -1, errors.Wrap(ctx.Err(), "stopped waiting for a free IP")
+		case <-timeout.C:
+			ipamdErrInc("ipExhaustionQueueTimeout")
+			return "", -1, errors.Wrapf(err, "no IP freed within %v", c.ipExhaustionQueueTimeout)
+		case <-ticker.C:
+			addr, deviceNumber, assignErr := c.dataStore.AssignPodIPv4AddressFromSubnet(ipamKey, subnet)
+			if assignErr == nil {
+				log.Infof("Assigned IP %s freed while sandbox %s was waiting", addr, ipamKey)
+				return addr, deviceNumber, nil
+			}
+			if errors.Cause(assignErr) != datastore.ErrNoAvailableIPs {
+				return "", -1, assignErr
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
+)
+
+func TestGetIPExhaustionPolicy(t *testing.T) {
+	defer os.Unsetenv(envIPExhaustionPolicy)
+	defer os.Unsetenv(envIPExhaustionQueueTimeout)
+
+	assert.Equal(t, ipExhaustionPolicyReject, getIPExhaustionPolicy())
+	assert.Equal(t, defaultIPExhaustionQueueTimeout, getIPExhaustionQueueTimeout())
+
+	_ = os.Setenv(envIPExhaustionPolicy, ipExhaustionPolicyQueue)
+	assert.Equal(t, ipExhaustionPolicyQueue, getIPExhaustionPolicy())
+	_ = os.Setenv(envIPExhaustionQueueTimeout, "1500")
+	assert.Equal(t, 1500*time.Millisecond, getIPExhaustionQueueTimeout())
+
+	_ = os.Setenv(envIPExhaustionPolicy, "wait")
+	assert.Equal(t, ipExhaustionPolicyReject, getIPExhaustionPolicy())
+	_ = os.Setenv(envIPExhaustionQueueTimeout, "-1")
+	assert.Equal(t, defaultIPExhaustionQueueTimeout, getIPExhaustionQueueTimeout())
+}
+
+// exhaustedTestServer returns a server whose only IP is assigned to the sandbox cid-1
+func exhaustedTestServer(t *testing.T, m *testMocks, policy string, timeout time.Duration) server {
+	ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 1, false, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.100"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	_, _, err := ds.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: "cid-1", IfName: "eth0"})
+	assert.NoError(t, err)
+
+	mockContext := &IPAMContext{
+		awsClient:                m.awsutils,
+		networkClient:            m.network,
+		dataStore:                ds,
+		ipExhaustionPolicy:       policy,
+		ipExhaustionQueueTimeout: timeout,
+	}
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil).AnyTimes()
+	m.network.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+	return server{version: "1.2.3", ipamContext: mockContext}
+}
+
+func exhaustedTestAddRequest() *pb.AddNetworkRequest {
+	return &pb.AddNetworkRequest{
+		ClientVersion:     "1.2.3",
+		K8S_POD_NAME:      "pod-2",
+		K8S_POD_NAMESPACE: "default",
+		Netns:             "netns",
+		NetworkName:       "net0",
+		ContainerID:       "cid-2",
+		IfName:            "eth0",
+	}
+}
+
+func TestServer_AddNetworkExhaustionReject(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	rpcServer := exhaustedTestServer(t, m, ipExhaustionPolicyReject, time.Minute)
+
+	// The timeout only applies to the queue policy
+	start := time.Now()
+	resp, err := rpcServer.AddNetwork(context.TODO(), exhaustedTestAddRequest())
+	assert.NoError(t, err)
+	assert.False(t, resp.Success)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestServer_AddNetworkExhaustionQueue(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	rpcServer := exhaustedTestServer(t, m, ipExhaustionPolicyQueue, 10*time.Second)
+
+	// An IP is freed while the ADD is waiting
+	go func() {
+		time.Sleep(3 * ipExhaustionQueuePollInterval)
+		assert.NoError(t, rpcServer.ipamContext.dataStore.AddIPv4CidrToStore("eni-1",
+			net.IPNet{IP: net.ParseIP("192.168.1.101"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}()
+	resp, err := rpcServer.AddNetwork(context.TODO(), exhaustedTestAddRequest())
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, "192.168.1.101", resp.IPv4Addr)
+}
+
+func TestServer_AddNetworkExhaustionQueueTimeout(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	rpcServer := exhaustedTestServer(t, m, ipExhaustionPolicyQueue, 2*ipExhaustionQueuePollInterval)
+
+	resp, err := rpcServer.AddNetwork(context.TODO(), exhaustedTestAddRequest())
+	assert.NoError(t, err)
+	assert.False(t, resp.Success)
+	_, assigned, _ := rpcServer.ipamContext.dataStore.GetStats()
+	assert.Equal(t, 1, assigned)
+}
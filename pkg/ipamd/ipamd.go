@@ -31,16 +31,21 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/cri"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/k8sapi"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/tracing"
 )
 
 // The package ipamd is a long running daemon which manages a warm pool of available IP addresses.
@@ -70,6 +75,16 @@ const (
 	envWarmIPTarget = "WARM_IP_TARGET"
 	noWarmIPTarget  = 0
 
+	// This environment variable is used to specify the number of free IPs in the "warm pool" as a percentage of the
+	// IPs currently assigned to pods. When it is set, WARM_IP_TARGET acts as the minimum warm target and the result
+	// is capped by the number of IPs the node can still allocate.
+	// For example, if WARM_IP_PERCENT is set to 20 and there are 30 pods running on the node, ipamd will try
+	// to make the "warm pool" have 36 IP addresses with 30 being assigned to pods and 6 free IPs.
+	envWarmIPPercent = "WARM_IP_PERCENT"
+	noWarmIPPercent  = 0
+	// minWarmIPPercentTarget keeps at least one free IP when WARM_IP_PERCENT is set on an idle node
+	minWarmIPPercentTarget = 1
+
 	// This environment variable is used to specify the desired minimum number of total IPs.
 	// When it is not set, ipamd defaults to 0.
 	// For example, for a m4.4xlarge node,
@@ -135,10 +150,43 @@ const (
 	//envWarmPrefixTarget is used to keep a /28 prefix in warm pool.
 	envWarmPrefixTarget     = "WARM_PREFIX_TARGET"
 	defaultWarmPrefixTarget = 0
+
+	// envPodInterfaceQueues is used to set the number of tx/rx queues on pod interfaces. When it is not set, the
+	// kernel default (a single queue) is used and the per pod annotation below is ignored.
+	envPodInterfaceQueues = "POD_INTERFACE_QUEUES"
+	noPodInterfaceQueues  = 0
+	// maxPodInterfaceQueues bounds both the env var and the per pod annotation
+	maxPodInterfaceQueues = 64
+
+	// podInterfaceQueuesAnnotation overrides POD_INTERFACE_QUEUES for a single pod
+	podInterfaceQueuesAnnotation = "vpc.amazonaws.com/pod-interface-queues"
+
+	// envFlushConntrackOnENIDetach is used to delete the conntrack entries of an ENI's IPs and prefixes once the ENI
+	// has been freed or found detached, so stale flows are not matched when the addresses are reused.
+	envFlushConntrackOnENIDetach = "FLUSH_CONNTRACK_ON_ENI_DETACH"
+
+	// envIPReclaimMinAge is the number of seconds an IP or prefix must be seen unassigned, over more than one reclaim
+	// pass, before ipamd releases it. This avoids releasing IPs of pods whose DEL/ADD is in flight right after a restart.
+	envIPReclaimMinAge     = "IP_RECLAIM_MIN_AGE_SECONDS"
+	defaultIPReclaimMinAge = 0
+
+	// envStartupENIWait is the number of seconds ipamd waits at startup for the ENIs EC2 reports as attached to the
+	// instance to show up in IMDS, before it sets up the datastore. 0 disables the wait.
+	envStartupENIWait     = "STARTUP_ENI_WAIT_SECONDS"
+	defaultStartupENIWait = 0
+	// startupENIPollInterval is how often IMDS is checked for the expected ENIs during the startup wait
+	startupENIPollInterval = 2 * time.Second
+
+	// envEnablePodSourceValidation makes the CNI plugin turn on strict reverse path filtering on each pod's host veth,
+	// so a pod can only send from the IPs routed to it and spoofed source addresses are dropped on the host.
+	envEnablePodSourceValidation = "ENABLE_POD_SOURCE_VALIDATION"
 )
 
 var log = logger.Get()
 
+// recordENIRemoval logs and counts why an ENI was removed, tests replace it to check the reason
+var recordENIRemoval = awsutils.RecordENIRemoval
+
 var (
 	ipamdErr = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -193,6 +241,56 @@ var (
 		},
 		[]string{"fn"},
 	)
+	modeMismatchedCidrs = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "awscni_mode_mismatched_cidrs",
+			Help: "The number of secondary IPs or prefixes left on ENIs from the previous IP allocation mode",
+		},
+	)
+	addIPLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "awscni_add_ip_latency_seconds",
+			Help:    "The time from an add IP address request being received to its IP being returned, by source of the IP",
+			Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 120},
+		},
+		[]string{"source"},
+	)
+	subnetAvailableIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_subnet_available_ips",
+			Help: "The number of free IPs of the node's subnet, when SUBNET_LOW_IP_THRESHOLD is set",
+		},
+		[]string{"subnet"},
+	)
+	subnetLowIPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_subnet_low_ips",
+			Help: "1 when the node's subnet has fewer free IPs than SUBNET_LOW_IP_THRESHOLD, 0 otherwise",
+		},
+		[]string{"subnet"},
+	)
+	warmTargetMissed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_warm_target_missed",
+			Help: "1 for the reason the IP pool can't grow to its warm target, 0 for the other reasons and once it is met",
+		},
+		[]string{"reason"},
+	)
+	ipAllocationAge  = newIPAllocationAgeCollector()
+	eniAttachmentAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "awscni_eni_attachment_age_seconds",
+			Help: "The time since each ENI of the node was attached, updated on reconcile",
+		},
+		[]string{"eni"},
+	)
+	eniLifetime = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "awscni_eni_lifetime_seconds",
+			Help:    "How long the ENIs freed by ipamd were attached",
+			Buckets: ipAllocationAgeBuckets,
+		},
+	)
 	prometheusRegistered = false
 )
 
@@ -210,19 +308,87 @@ type IPAMContext struct {
 	unmanagedENI         int
 	warmENITarget        int
 	warmIPTarget         int
+	warmIPPercent        int
 	minimumIPTarget      int
 	warmPrefixTarget     int
 	primaryIP            map[string]string // primaryIP is a map from ENI ID to primary IP of that ENI
 	lastNodeIPPoolAction time.Time
 	lastDecreaseIPPool   time.Time
+	// reconcileStatus keeps the outcome of the last few node IP pool reconciles for introspection
+	reconcileStatus reconcileStatusTracker
+	// warmTargetMiss keeps the last reason the IP pool couldn't grow to its warm target, for introspection
+	warmTargetMiss warmTargetMissTracker
 	// reconcileCooldownCache keeps timestamps of the last time an IP address was unassigned from an ENI,
 	// so that we don't reconcile and add it back too quickly if IMDS lags behind reality.
 	reconcileCooldownCache     ReconcileCooldownCache
 	terminating                int32 // Flag to warn that the pod is about to shut down.
 	disableENIProvisioning     bool
+	auditMode                  bool // Only log the pool changes that would change AWS resources.
 	enablePodENI               bool
 	myNodeName                 string
 	enableIpv4PrefixDelegation bool
+	podInterfaceQueues         int
+	enablePodRoutes            bool
+	flushConntrackOnENIDetach  bool
+	enablePodSourceValidation  bool
+	// hasModeMismatchedCidrs is set while ENIs still carry secondary IPs or prefixes from the previous IP allocation mode
+	hasModeMismatchedCidrs bool
+	// eventRecorder records the IP allocation of pods as events, it is nil unless ENABLE_POD_ALLOCATION_EVENTS is set
+	eventRecorder record.EventRecorder
+	// enableSubnetDiscovery picks the subnet of new ENIs among the tagged subnets, the ENIConfig one and the node's one
+	enableSubnetDiscovery bool
+	// maxReconcileDeletions caps the ENIs, IPs and prefixes removed by a reconcile pass, noMaxReconcileDeletions for no cap
+	maxReconcileDeletions int
+	// reconcileDeletions counts the removals of the current reconcile pass
+	reconcileDeletions reconcileDeletions
+	// untrackedIPPolicy is what the reconciler does with IPs on ENIs of the datastore that it does not track
+	untrackedIPPolicy string
+	// ipExhaustionPolicy is what a CNI ADD does when there is no free IP, waiting up to ipExhaustionQueueTimeout for
+	// one with queue-with-timeout
+	ipExhaustionPolicy       string
+	ipExhaustionQueueTimeout time.Duration
+	// teardownLimiter bounds the EC2 calls unassigning IPs and prefixes made at once to POD_TEARDOWN_CONCURRENCY
+	teardownLimiter teardownLimiter
+	// ignoredUntrackedIPs are the IPs left alone by the reconciler with the ignore policy, keyed by ENI
+	ignoredUntrackedIPs map[string]sets.String
+	// ignoredIPsCheckpoint persists ignoredUntrackedIPs across restarts
+	ignoredIPsCheckpoint datastore.Checkpointer
+	// maxPodsPerENI caps the number of pods given an IP from the same ENI, noMaxPodsPerENI if there is no cap
+	maxPodsPerENI int
+	// enablePodSubnetAnnotation makes pods get their IP from the subnet named in their vpc.amazonaws.com/pod-subnet
+	// annotation
+	enablePodSubnetAnnotation bool
+	// enablePodMACAnnotation applies the hardware address of a pod's vpc.amazonaws.com/pod-interface-mac annotation
+	enablePodMACAnnotation bool
+	// ec2CallBatcher coalesces the IP and prefix assignments and unassignments made within EC2_CALL_BATCH_WINDOW_MS
+	ec2CallBatcher *ec2CallBatcher
+	// podSubnets caches the lookups of the subnets requested by pods as podSubnet, keyed by subnet ID
+	podSubnets sync.Map
+	// pendingPodSubnets holds the IDs of the subnets pods found no free IP in, for the pool manager to attach ENIs in
+	pendingPodSubnets sync.Map
+	// subnetLowIPWarning warns when the node's subnet has fewer free IPs than SUBNET_LOW_IP_THRESHOLD
+	subnetLowIPWarning subnetLowIPWarning
+	// criClient lists the running sandboxes, for the startup cleanup of orphaned pod IP rules
+	criClient cri.APIs
+	// disablePrimaryENIPodIPs keeps the secondary IPs and prefixes of the primary ENI out of the pool
+	disablePrimaryENIPodIPs bool
+	// hasPrimaryENIPodIPs is set while the primary ENI still has secondary IPs or prefixes in use by pods from before
+	// disablePrimaryENIPodIPs was set
+	hasPrimaryENIPodIPs bool
+	// podRoutes keeps the routes programmed in the running pods, for reconcilePodRoutes
+	podRoutes podRoutesTracker
+	// maxTotalIPs caps the IPs the node holds for pods, 0 if there is no cap
+	maxTotalIPs int
+	// fastIPRelease releases the IPs of the sandboxes gone from the container runtime
+	fastIPRelease fastIPRelease
+	// sandboxLocks serializes the ADDs, DELs and fast IP releases of the same sandbox
+	sandboxLocks sandboxLocks
+	// warmUpConcurrency is the number of ENIs the startup pool warm-up assigns IPs or prefixes to at once
+	warmUpConcurrency int
+	// activeENIConfig is the ENIConfig the node resolves to with custom networking
+	activeENIConfig activeENIConfigTracker
+	// podSubnetID is the subnet every pod ENI is created in, empty to follow the node's subnet or ENIConfig
+	podSubnetID string
 }
 
 // setUnmanagedENIs will rebuild the set of ENI IDs for ENIs tagged as "no_manage"
@@ -286,8 +452,16 @@ func prometheusRegister() {
 		prometheus.MustRegister(ipMax)
 		prometheus.MustRegister(reconcileCnt)
 		prometheus.MustRegister(addIPCnt)
+		prometheus.MustRegister(addIPLatency)
 		prometheus.MustRegister(delIPCnt)
 		prometheus.MustRegister(podENIErr)
+		prometheus.MustRegister(modeMismatchedCidrs)
+		prometheus.MustRegister(ipAllocationAge)
+		prometheus.MustRegister(subnetAvailableIPs)
+		prometheus.MustRegister(subnetLowIPs)
+		prometheus.MustRegister(warmTargetMissed)
+		prometheus.MustRegister(eniAttachmentAge)
+		prometheus.MustRegister(eniLifetime)
 		prometheusRegistered = true
 	}
 }
@@ -309,16 +483,44 @@ func New(rawK8SClient client.Client, cachedK8SClient client.Client) (*IPAMContex
 		return nil, errors.Wrap(err, "ipamd: can not initialize with AWS SDK interface")
 	}
 	c.awsClient = client
+	c.auditMode = client.IsAuditMode()
 
 	c.primaryIP = make(map[string]string)
 	c.reconcileCooldownCache.cache = make(map[string]time.Time)
 	c.warmENITarget = getWarmENITarget()
 	c.warmIPTarget = getWarmIPTarget()
+	c.warmIPPercent = getWarmIPPercent()
 	c.minimumIPTarget = getMinimumIPTarget()
 	c.warmPrefixTarget = getWarmPrefixTarget()
 
 	c.disableENIProvisioning = disablingENIProvisioning()
 	c.enablePodENI = enablePodENI()
+	c.podInterfaceQueues = getPodInterfaceQueues()
+	c.enablePodRoutes = enablePodRoutes()
+	if c.enablePodRoutes {
+		c.podRoutes.checkpoint = datastore.NewJSONFile(podRoutesPath())
+		c.podRoutes.restore()
+	}
+	c.flushConntrackOnENIDetach = flushConntrackOnENIDetach()
+	c.enablePodSourceValidation = enablePodSourceValidation()
+	c.enablePodSubnetAnnotation = enablePodSubnetAnnotation()
+	c.enablePodMACAnnotation = enablePodMACAnnotation()
+	c.ec2CallBatcher = newEC2CallBatcher(getEC2CallBatchWindow(), time.Now)
+	c.untrackedIPPolicy = getUntrackedIPPolicy()
+	c.ignoredIPsCheckpoint = datastore.NewJSONFile(ignoredUntrackedIPsPath())
+	c.restoreIgnoredUntrackedIPs()
+	c.ipExhaustionPolicy = getIPExhaustionPolicy()
+	c.ipExhaustionQueueTimeout = getIPExhaustionQueueTimeout()
+	c.teardownLimiter = newTeardownLimiter(getPodTeardownConcurrency())
+	c.maxReconcileDeletions = getMaxReconcileDeletions()
+	c.enableSubnetDiscovery = enableSubnetDiscovery()
+	c.podSubnetID = getPodSubnetID()
+	// The primary ENI is in the node's subnet, pods only get IPs of the pod subnet
+	c.disablePrimaryENIPodIPs = disablePrimaryENIPodIPs() || c.podSubnetID != ""
+	c.maxTotalIPs = getMaxTotalIPs()
+	c.fastIPRelease.gracePeriod = getFastIPReleaseGracePeriod()
+	c.warmUpConcurrency = getWarmUpConcurrency()
+	c.activeENIConfig.annotate = enableENIConfigAnnotation()
 
 	hypervisorType, err := c.awsClient.GetInstanceHypervisorFamily()
 	if err != nil {
@@ -331,13 +533,37 @@ func New(rawK8SClient client.Client, cachedK8SClient client.Client) (*IPAMContex
 	}
 	c.awsClient.InitCachedPrefixDelegation(c.enableIpv4PrefixDelegation)
 	c.myNodeName = os.Getenv("MY_NODE_NAME")
+	c.subnetLowIPWarning.threshold = getSubnetLowIPThreshold()
+	subnetLowIPEvents := c.subnetLowIPWarning.threshold > 0 && enableSubnetLowIPEvents()
+	if enablePodAllocationEvents() || subnetLowIPEvents {
+		recorder, err := k8sapi.CreateEventRecorder("aws-node", c.myNodeName)
+		if err != nil {
+			// Events are best-effort, they must not keep ipamd from starting
+			log.Warnf("Failed to create the event recorder, not recording events: %v", err)
+		} else {
+			if enablePodAllocationEvents() {
+				c.eventRecorder = recorder
+			}
+			if subnetLowIPEvents {
+				c.subnetLowIPWarning.recorder = recorder
+			}
+		}
+	}
 	checkpointer := datastore.NewJSONFile(dsBackingStorePath())
 	c.dataStore = datastore.NewDataStore(log, checkpointer, c.enableIpv4PrefixDelegation)
+	c.dataStore.SetReclaimMinAge(getIPReclaimMinAge())
+	c.dataStore.SetIPAssignmentStrategy(getIPAssignmentStrategy())
+	c.dataStore.SetWarmENIReclaimDwell(getWarmENIReclaimDwell(c.awsClient.GetInstanceType()))
+	c.criClient = cri.New()
 
+	if err := c.validatePodSubnetID(); err != nil {
+		return nil, err
+	}
 	err = c.nodeInit()
 	if err != nil {
 		return nil, err
 	}
+	c.startENIQuotaCheck(getENIQuotaWarningPercent())
 
 	mac := c.awsClient.GetPrimaryENImac()
 	// retrieve security groups
@@ -353,6 +579,45 @@ func New(rawK8SClient client.Client, cachedK8SClient client.Client) (*IPAMContex
 	return c, nil
 }
 
+// waitForExpectedENIs polls IMDS until every ENI that EC2 reports as attached to the instance is listed, or the
+// timeout expires. Right after boot the ENIs of the launch template can still be attaching, and setting up the
+// datastore from the partial IMDS data leaves them unused until they get reconciled.
+func (c *IPAMContext) waitForExpectedENIs(timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var missing []string
+	for {
+		expectedENIs, err := c.awsClient.GetExpectedENIs()
+		if err != nil {
+			log.Warnf("Failed to get the expected ENIs from EC2: %v", err)
+		} else {
+			attachedENIs, err := c.awsClient.GetAttachedENIs()
+			if err != nil {
+				log.Warnf("Failed to get the attached ENIs from IMDS: %v", err)
+			} else {
+				attached := make(map[string]bool, len(attachedENIs))
+				for _, eni := range attachedENIs {
+					attached[eni.ENIID] = true
+				}
+				missing = nil
+				for _, eniID := range expectedENIs {
+					if !attached[eniID] {
+						missing = append(missing, eniID)
+					}
+				}
+				if len(missing) == 0 {
+					log.Infof("All %d expected ENIs are attached", len(expectedENIs))
+					return nil
+				}
+				log.Infof("Waiting for ENIs %v to show up in instance metadata", missing)
+			}
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return errors.Errorf("gave up waiting after %v for ENIs %v to show up in instance metadata", timeout, missing)
+		}
+		time.Sleep(interval)
+	}
+}
+
 func (c *IPAMContext) nodeInit() error {
 	ipamdActionsInprogress.WithLabelValues("nodeInit").Add(float64(1))
 	defer ipamdActionsInprogress.WithLabelValues("nodeInit").Sub(float64(1))
@@ -372,21 +637,42 @@ func (c *IPAMContext) nodeInit() error {
 		return err
 	}
 	log.Debugf("Max ip per ENI %d and max prefixes per ENI %d", c.maxIPsPerENI, c.maxPrefixesPerENI)
+	c.initMaxPodsPerENI()
 
-	vpcCIDRs, err := c.awsClient.GetVPCIPv4CIDRs()
-	if err != nil {
+	if startupENIWait := getStartupENIWait(); startupENIWait > 0 {
+		if err := c.waitForExpectedENIs(startupENIWait, startupENIPollInterval); err != nil {
+			// Carry on with what IMDS has, the reconciler picks up ENIs that show up later
+			log.Warnf("ipamd init: %v", err)
+			ipamdErrInc("nodeInitStartupENIWaitTimeout")
+		}
+	}
+
+	// The VPC CIDRs come from IMDS and the ENIs from EC2, fetch them concurrently
+	var vpcCIDRs []string
+	var metadataResult awsutils.DescribeAllENIsResult
+	var g errgroup.Group
+	g.Go(func() error {
+		var err error
+		vpcCIDRs, err = c.awsClient.GetVPCIPv4CIDRs()
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		if metadataResult, err = c.awsClient.DescribeAllENIs(); err != nil {
+			return errors.New("ipamd init: failed to retrieve attached ENIs info")
+		}
+		return nil
+	})
+	if err = g.Wait(); err != nil {
 		return err
 	}
+
 	primaryIP := c.awsClient.GetLocalIPv4()
 	err = c.networkClient.SetupHostNetwork(vpcCIDRs, c.awsClient.GetPrimaryENImac(), &primaryIP, c.enablePodENI)
 	if err != nil {
 		return errors.Wrap(err, "ipamd init: failed to set up host network")
 	}
 
-	metadataResult, err := c.awsClient.DescribeAllENIs()
-	if err != nil {
-		return errors.New("ipamd init: failed to retrieve attached ENIs info")
-	}
 	log.Debugf("DescribeAllENIs success: ENIs: %d, tagged: %d", len(metadataResult.ENIMetadata), len(metadataResult.TagMap))
 	c.awsClient.SetCNIUnmanagedENIs(metadataResult.MultiCardENIIDs)
 	c.setUnmanagedENIs(metadataResult.TagMap)
@@ -402,7 +688,7 @@ func (c *IPAMContext) nodeInit() error {
 		isTrunkENI := eni.ENIID == metadataResult.TrunkENI
 		isEFAENI := metadataResult.EFAENIs[eni.ENIID]
 		if !isTrunkENI {
-			if err := c.awsClient.TagENI(eni.ENIID, metadataResult.TagMap[eni.ENIID]); err != nil {
+			if err := c.awsClient.TagENI(eni.ENIID, metadataResult.TagMap[eni.ENIID]); err != nil && err != awsutils.ErrAuditMode {
 				return errors.Wrapf(err, "ipamd init: failed to tag managed ENI %v", eni.ENIID)
 			}
 		}
@@ -437,16 +723,14 @@ func (c *IPAMContext) nodeInit() error {
 		return err
 	}
 
-	if c.enableIpv4PrefixDelegation {
-		//During upgrade or if prefix delgation knob is disabled to enabled then we
-		//might have secondary IPs attached to ENIs so doing a cleanup if not used before moving on
-		c.tryUnassignIPsFromENIs()
-	} else {
-		//When prefix delegation knob is enabled to disabled then we might
-		//have unused prefixes attached to the ENIs so need to cleanup
-		c.tryUnassignPrefixesFromENIs()
+	//During upgrade or if prefix delegation knob is toggled, ENIs might still have secondary IPs or prefixes
+	//from the previous mode. Release the unused ones before moving on, the others once their pods are gone.
+	c.hasModeMismatchedCidrs = c.releaseModeMismatchedCidrs()
+	if c.disablePrimaryENIPodIPs {
+		c.hasPrimaryENIPodIPs = c.releasePrimaryENIPodIPs()
 	}
 
+	c.cleanUpOrphanedIPRules()
 	if err = c.configureIPRulesForPods(); err != nil {
 		return err
 	}
@@ -489,8 +773,16 @@ func (c *IPAMContext) nodeInit() error {
 		c.askForTrunkENIIfNeeded(ctx)
 	}
 
+	if c.skipInAuditMode("nodeInit", "assign IPs or prefixes to the ENIs for the warm targets") {
+		return nil
+	}
+	// With a warm-up concurrency, fill the pool up to its warm target before reporting ready
+	if c.warmUpConcurrency > 1 {
+		c.warmUpPool(ctx)
+		return nil
+	}
 	// For a new node, attach Cidrs (secondary ips/prefixes)
-	increasedPool, err := c.tryAssignCidrs()
+	increasedPool, err := c.tryAssignCidrs(ctx)
 	if err == nil && increasedPool {
 		c.updateLastNodeIPPoolAction()
 	} else if err != nil {
@@ -530,6 +822,9 @@ func (c *IPAMContext) updateCIDRsRulesOnChange(oldVPCCIDRs []string) []string {
 	old := sets.NewString(oldVPCCIDRs...)
 	new := sets.NewString(newVPCCIDRs...)
 	if !old.Equal(new) {
+		if c.enablePodSubnetAnnotation && !old.IsSuperset(new) {
+			c.forgetInvalidPodSubnets()
+		}
 		primaryIP := c.awsClient.GetLocalIPv4()
 		err = c.networkClient.UpdateHostIptablesRules(newVPCCIDRs, c.awsClient.GetPrimaryENImac(), &primaryIP)
 		if err != nil {
@@ -555,19 +850,36 @@ func (c *IPAMContext) StartNodeIPPoolManager() {
 		}
 		time.Sleep(sleepDuration)
 		c.nodeIPPoolReconcile(ctx, nodeIPPoolReconcileInterval)
+		c.checkSubnetAvailability(time.Now())
+		c.releaseGoneSandboxIPs(time.Now())
+		c.refreshActiveENIConfig(ctx, time.Now())
+		c.reconcilePodRoutes(ctx)
 	}
 }
 
 func (c *IPAMContext) updateIPPoolIfRequired(ctx context.Context) {
 	c.askForTrunkENIIfNeeded(ctx)
+	if c.hasModeMismatchedCidrs {
+		c.hasModeMismatchedCidrs = c.releaseModeMismatchedCidrs()
+	}
+	if c.hasPrimaryENIPodIPs {
+		c.hasPrimaryENIPodIPs = c.releasePrimaryENIPodIPs()
+	}
+	if c.enablePodSubnetAnnotation {
+		c.allocatePodSubnetENIs(ctx)
+	}
 	if c.isDatastorePoolTooLow() {
 		c.increaseDatastorePool(ctx)
-	} else if c.isDatastorePoolTooHigh() {
-		c.decreaseDatastorePool(decreaseIPPoolInterval)
+	} else {
+		c.warmTargetMiss.met()
+		if c.isDatastorePoolTooHigh() {
+			c.decreaseDatastorePool(decreaseIPPoolInterval)
+		}
 	}
 	if c.shouldRemoveExtraENIs() {
 		c.tryFreeENI()
 	}
+	c.flushEC2Calls(false)
 }
 
 // decreaseDatastorePool runs every `interval` and attempts to return unused ENIs and IPs
@@ -598,19 +910,61 @@ func (c *IPAMContext) tryFreeENI() {
 		log.Debug("AWS CNI is terminating, not detaching any ENIs")
 		return
 	}
+	if c.skipInAuditMode("FreeENI", "detach and delete an unused ENI over the warm targets") {
+		return
+	}
 
-	eni := c.dataStore.RemoveUnusedENIFromStore(c.warmIPTarget, c.minimumIPTarget, c.warmPrefixTarget)
+	_, assigned, _ := c.dataStore.GetStats()
+	// Snapshot the ENIs before one is removed from the store, the conntrack flush needs its CIDRs
+	eniInfos := c.dataStore.GetENIInfos()
+	eni := c.dataStore.RemoveUnusedENIFromStore(c.getEffectiveWarmIPTarget(assigned), c.minimumIPTarget, c.warmPrefixTarget)
 	if eni == "" {
 		return
 	}
 
-	log.Debugf("Start freeing ENI %s", eni)
-	err := c.awsClient.FreeENI(eni)
+	c.freeENI(eni, eniInfos.ENIs[eni], awsutils.ENIRemovalWarmShrink)
+}
+
+// freeENI detaches and deletes an ENI that has already been removed from the datastore
+func (c *IPAMContext) freeENI(eniID string, eni datastore.ENI, reason awsutils.ENIRemovalReason) {
+	if eni.IsPrimary {
+		log.Errorf("Not freeing ENI %s, it is the primary ENI of the instance", eniID)
+		ipamdErrInc("freePrimaryENI")
+		return
+	}
+	log.Debugf("Start freeing ENI %s", eniID)
+	// Its IPs and prefixes go away with it
+	c.dropPendingUnassigns(eniID)
+	err := c.awsClient.FreeENI(eniID)
+	if errors.Is(err, awsutils.ErrENIPrimaryInUse) {
+		// Detached but not deleted, the leaked ENI cleanup deletes it once the primary IP is released
+		ipamdErrInc("decreaseIPPoolFreeENIPrimaryIPInUse")
+		log.Warnf("Failed to free ENI %s, its primary IP is still in use: %v", eniID, err)
+		return
+	}
 	if err != nil {
 		ipamdErrInc("decreaseIPPoolFreeENIFailed")
-		log.Errorf("Failed to free ENI %s, err: %v", eni, err)
+		log.Errorf("Failed to free ENI %s, err: %v", eniID, err)
+		return
+	}
+	recordENIRemoval(eniID, reason)
+	observeENILifetime(eni)
+	c.deleteENIConntrackEntries(eni)
+}
+
+// deleteENIConntrackEntries deletes the conntrack entries of all the IPs and prefixes of a detached ENI, when enabled
+func (c *IPAMContext) deleteENIConntrackEntries(eni datastore.ENI) {
+	if !c.flushConntrackOnENIDetach || len(eni.AvailableIPv4Cidrs) == 0 {
 		return
 	}
+	cidrs := make([]net.IPNet, 0, len(eni.AvailableIPv4Cidrs))
+	for _, cidr := range eni.AvailableIPv4Cidrs {
+		cidrs = append(cidrs, cidr.Cidr)
+	}
+	if _, err := c.networkClient.DeleteConntrackEntries(cidrs); err != nil {
+		ipamdErrInc("deleteENIConntrackEntriesFailed")
+		log.Warnf("Failed to delete conntrack entries of ENI %s: %v", eni.ID, err)
+	}
 }
 
 // tryUnassignIPsorPrefixesFromAll determines if there are IPs to free when we have extra IPs beyond the target and warmIPTargetDefined
@@ -625,13 +979,18 @@ func (c *IPAMContext) tryUnassignCidrsFromAll() {
 	}
 
 	if over > 0 {
+		if c.skipInAuditMode("DeallocCidrs", "unassign %d IPs or prefixes over the warm targets", over) {
+			return
+		}
 		eniInfos := c.dataStore.GetENIInfos()
+		deletedCidrsByENI := make(map[string][]datastore.CidrInfo)
+		var eniIDs []string
 		for eniID := range eniInfos.ENIs {
 			//Either returns prefixes or IPs [Cidrs]
 			cidrs := c.dataStore.FindFreeableCidrs(eniID)
 			if cidrs == nil {
 				log.Errorf("Error finding unassigned IPs for ENI %s", eniID)
-				return
+				break
 			}
 
 			// Free the number of Cidrs `over` the warm IP target, unless `over` is greater than the number of available Cidrs on
@@ -658,9 +1017,14 @@ func (c *IPAMContext) tryUnassignCidrsFromAll() {
 				}
 			}
 
-			// Deallocate Cidrs from the instance if they aren't used by pods.
-			c.DeallocCidrs(eniID, deletedCidrs)
+			deletedCidrsByENI[eniID] = deletedCidrs
+			eniIDs = append(eniIDs, eniID)
 		}
+
+		// Deallocate Cidrs from the instance if they aren't used by pods, for several ENIs at once
+		c.teardownLimiter.doPerENI(eniIDs, func(eniID string) {
+			c.DeallocCidrs(eniID, deletedCidrsByENI[eniID])
+		})
 	}
 }
 
@@ -687,8 +1051,38 @@ func (c *IPAMContext) increaseDatastorePool(ctx context.Context) {
 		log.Debug("AWS CNI is terminating, will not try to attach any new IPs or ENIs right now")
 		return
 	}
+
+	if c.atMaxTotalIPs() {
+		log.Debugf("Skipping increase Datastore pool, the node is at the %s cap of %d", envMaxTotalIPs, c.maxTotalIPs)
+		c.warmTargetMiss.record(warmTargetMissMaxTotalIPs, nil)
+		return
+	}
+
+	if c.skipInAuditMode("increaseDatastorePool", "assign IPs or prefixes, or attach an ENI, for the warm targets") {
+		return
+	}
+
+	// IPs and prefixes still waiting to be unassigned cancel out with the ones we need now
+	if c.restorePendingUnassigns() {
+		c.updateLastNodeIPPoolAction()
+		if !c.isDatastorePoolTooLow() {
+			return
+		}
+	}
+	if c.ec2CallBatcher.enabled() {
+		// Without any free IP left the next pod has to wait for this increase, don't hold it back
+		total, assigned, _ := c.dataStore.GetStats()
+		if c.ec2CallBatcher.deferIncrease(total-assigned <= 0) {
+			log.Debugf("Deferring the increase of the Datastore pool to coalesce it with later ones")
+			return
+		}
+	}
+
+	ctx, span := tracing.StartSpan(ctx, "increaseDatastorePool")
+	defer span.End()
+
 	// Try to add more Cidrs to existing ENIs first.
-	increasedPool, err := c.tryAssignCidrs()
+	increasedPool, err := c.tryAssignCidrs(ctx)
 	if err != nil {
 		log.Errorf(err.Error())
 	}
@@ -700,14 +1094,25 @@ func (c *IPAMContext) increaseDatastorePool(ctx context.Context) {
 		if c.enablePodENI && c.dataStore.GetTrunkENI() == "" {
 			reserveSlotForTrunkENI = 1
 		}
-		// If we did not add an IP, try to add an ENI instead.
-		if c.dataStore.GetENIs() < (c.maxENI - c.unmanagedENI - reserveSlotForTrunkENI) {
+		// If we did not add an IP, try to add an ENI instead. An ENI still waiting for its IPs is retried by the
+		// reconciler, attaching another one now would not help.
+		if pending := c.dataStore.GetPendingENIs(); len(pending) > 0 {
+			log.Debugf("Skipping ENI allocation as ENIs %v are still pending IP assignment", pending)
+		} else if c.dataStore.GetENIs() < (c.maxENI - c.unmanagedENI - reserveSlotForTrunkENI) {
 			if err = c.tryAllocateENI(ctx); err == nil {
 				c.updateLastNodeIPPoolAction()
+			} else {
+				c.warmTargetMiss.record(warmTargetMissReason(err), err)
 			}
 		} else {
 			log.Debugf("Skipping ENI allocation as the max ENI limit of %d is already reached (accounting for %d unmanaged ENIs and %d trunk ENIs)",
 				c.maxENI, c.unmanagedENI, reserveSlotForTrunkENI)
+			// The existing ENIs may still have room, when assigning to them failed that is the reason
+			if err != nil {
+				c.warmTargetMiss.record(warmTargetMissReason(err), err)
+			} else {
+				c.warmTargetMiss.record(warmTargetMissInstanceLimit, nil)
+			}
 		}
 	}
 }
@@ -725,7 +1130,7 @@ func (c *IPAMContext) updateLastNodeIPPoolAction() {
 
 func (c *IPAMContext) tryAllocateENI(ctx context.Context) error {
 	var securityGroups []*string
-	var subnet string
+	var subnet, eniConfigName string
 
 	if c.useCustomNetworking {
 		eniCfg, err := eniconfig.MyENIConfig(ctx, c.cachedK8SClient)
@@ -741,9 +1146,39 @@ func (c *IPAMContext) tryAllocateENI(ctx context.Context) error {
 			securityGroups = append(securityGroups, aws.String(sgID))
 		}
 		subnet = eniCfg.Subnet
+		eniConfigName = c.nodeENIConfigName(ctx)
+	}
+	if c.podSubnetID != "" {
+		// The ENIConfig, if any, only provides the security groups
+		log.Infof("Using the pod subnet %s for the new ENI", c.podSubnetID)
+		return c.allocENIWithCidrs(ctx, true, securityGroups, c.podSubnetID, "")
+	}
+	if c.enableSubnetDiscovery {
+		source, resolved, err := c.resolveENISubnet(subnet)
+		if err != nil {
+			log.Errorf("Failed to pick a subnet for the new ENI: %v", err)
+			ipamdErrInc("increaseIPPoolNoSubnet")
+			return err
+		}
+		log.Infof("Using subnet %q from the %s subnet source for the new ENI", resolved, source)
+		if source == subnetSourceNodeDefault {
+			return c.allocENIWithCidrs(ctx, false, nil, "", "")
+		}
+		if source != subnetSourceENIConfig {
+			eniConfigName = ""
+		}
+		return c.allocENIWithCidrs(ctx, true, securityGroups, resolved, eniConfigName)
 	}
+	return c.allocENIWithCidrs(ctx, c.useCustomNetworking, securityGroups, subnet, eniConfigName)
+}
 
-	eni, err := c.awsClient.AllocENI(c.useCustomNetworking, securityGroups, subnet)
+// allocENIWithCidrs attaches a new ENI, from the custom config if useCustomCfg is set, fills it with IPs or prefixes
+// and adds it to the datastore. eniConfigName is the ENIConfig the ENI's subnet comes from, if any.
+func (c *IPAMContext) allocENIWithCidrs(ctx context.Context, useCustomCfg bool, securityGroups []*string, subnet string, eniConfigName string) error {
+	_, span := tracing.StartSpan(ctx, "AllocENI", tracing.AttrSubnetID.String(subnet))
+	eni, err := c.awsClient.AllocENI(useCustomCfg, securityGroups, subnet)
+	span.SetAttributes(tracing.AttrENIID.String(eni))
+	tracing.EndSpan(span, err)
 	if err != nil {
 		log.Errorf("Failed to increase pool size due to not able to allocate ENI %v", err)
 		ipamdErrInc("increaseIPPoolAllocENI")
@@ -752,7 +1187,7 @@ func (c *IPAMContext) tryAllocateENI(ctx context.Context) error {
 
 	resourcesToAllocate := c.GetENIResourcesToAllocate()
 
-	err = c.awsClient.AllocIPAddresses(eni, resourcesToAllocate)
+	err = c.allocIPAddresses(ctx, eni, resourcesToAllocate)
 	if err != nil {
 		log.Warnf("Failed to allocate %d IP addresses on an ENI: %v", resourcesToAllocate, err)
 		// Continue to process the allocated IP addresses
@@ -773,12 +1208,21 @@ func (c *IPAMContext) tryAllocateENI(ctx context.Context) error {
 		log.Errorf("Failed to increase pool size: %v", err)
 		return err
 	}
+	c.setENIConfig(eni, eniConfigName)
+	return err
+}
+
+// allocIPAddresses asks EC2 for more IPs or prefixes on the ENI, wrapped in a trace span
+func (c *IPAMContext) allocIPAddresses(ctx context.Context, eniID string, count int) error {
+	_, span := tracing.StartSpan(ctx, "AllocIPAddresses", tracing.AttrENIID.String(eniID), tracing.AttrIPCount.Int(count))
+	err := c.awsClient.AllocIPAddresses(eniID, count)
+	tracing.EndSpan(span, err)
 	return err
 }
 
 // For an ENI, try to fill in missing IPs on an existing ENI with PD disabled
 // try to fill in missing Prefixes on an existing ENI with PD enabled
-func (c *IPAMContext) tryAssignCidrs() (increasedPool bool, err error) {
+func (c *IPAMContext) tryAssignCidrs(ctx context.Context) (increasedPool bool, err error) {
 	short, _, warmIPTargetDefined := c.datastoreTargetState()
 	if warmIPTargetDefined && short == 0 {
 		log.Infof("Warm IP target set and short is 0 so not assigning Cidrs (IPs or Prefixes)")
@@ -794,14 +1238,14 @@ func (c *IPAMContext) tryAssignCidrs() (increasedPool bool, err error) {
 	}
 
 	if !c.enableIpv4PrefixDelegation {
-		return c.tryAssignIPs()
+		return c.tryAssignIPs(ctx)
 	} else {
-		return c.tryAssignPrefixes()
+		return c.tryAssignPrefixes(ctx)
 	}
 }
 
 // For an ENI, try to fill in missing IPs on an existing ENI
-func (c *IPAMContext) tryAssignIPs() (increasedPool bool, err error) {
+func (c *IPAMContext) tryAssignIPs(ctx context.Context) (increasedPool bool, err error) {
 	// If WARM_IP_TARGET is set, only proceed if we are short of target
 	short, _, warmIPTargetDefined := c.datastoreTargetState()
 	if warmIPTargetDefined && short == 0 {
@@ -810,21 +1254,25 @@ func (c *IPAMContext) tryAssignIPs() (increasedPool bool, err error) {
 
 	// If WARM_IP_TARGET is set we only want to allocate up to that target
 	// to avoid overallocating and releasing
-	toAllocate := c.maxIPsPerENI
+	maxIPsPerENI := c.maxUsableIPsPerENI()
+	toAllocate := maxIPsPerENI
 	if warmIPTargetDefined {
 		toAllocate = short
 	}
+	if toAllocate = c.capAllocation(toAllocate); toAllocate == 0 {
+		return false, nil
+	}
 
 	// Find an ENI where we can add more IPs
-	eni := c.dataStore.GetENINeedsIP(c.maxIPsPerENI, c.useCustomNetworking)
-	if eni != nil && len(eni.AvailableIPv4Cidrs) < c.maxIPsPerENI {
+	eni := c.dataStore.GetENINeedsIP(maxIPsPerENI, c.skipsPrimaryENIIPs())
+	if eni != nil && len(eni.AvailableIPv4Cidrs) < maxIPsPerENI {
 		currentNumberOfAllocatedIPs := len(eni.AvailableIPv4Cidrs)
 		// Try to allocate all available IPs for this ENI
-		err = c.awsClient.AllocIPAddresses(eni.ID, int(math.Min(float64(c.maxIPsPerENI-currentNumberOfAllocatedIPs), float64(toAllocate))))
+		err = c.allocIPAddresses(ctx, eni.ID, int(math.Min(float64(maxIPsPerENI-currentNumberOfAllocatedIPs), float64(toAllocate))))
 		if err != nil {
 			log.Warnf("failed to allocate all available IP addresses on ENI %s, err: %v", eni.ID, err)
 			// Try to just get one more IP
-			err = c.awsClient.AllocIPAddresses(eni.ID, 1)
+			err = c.allocIPAddresses(ctx, eni.ID, 1)
 			if err != nil {
 				ipamdErrInc("increaseIPPoolAllocIPAddressesFailed")
 				return false, errors.Wrap(err, fmt.Sprintf("failed to allocate one IP addresses on ENI %s, err: %v", eni.ID, err))
@@ -843,18 +1291,22 @@ func (c *IPAMContext) tryAssignIPs() (increasedPool bool, err error) {
 	return false, nil
 }
 
-func (c *IPAMContext) tryAssignPrefixes() (increasedPool bool, err error) {
-	toAllocate := c.getPrefixesNeeded()
+func (c *IPAMContext) tryAssignPrefixes(ctx context.Context) (increasedPool bool, err error) {
+	toAllocate := c.capAllocation(c.getPrefixesNeeded())
+	if toAllocate == 0 {
+		return false, nil
+	}
 	// Returns an ENI which has space for more prefixes to be attached, but this
 	// ENI might not suffice the WARM_IP_TARGET/WARM_PREFIX_TARGET
-	eni := c.dataStore.GetENINeedsIP(c.maxPrefixesPerENI, c.useCustomNetworking)
+	maxPrefixesPerENI := c.maxUsablePrefixesPerENI()
+	eni := c.dataStore.GetENINeedsIP(maxPrefixesPerENI, c.skipsPrimaryENIIPs())
 	if eni != nil {
 		currentNumberOfAllocatedPrefixes := len(eni.AvailableIPv4Cidrs)
-		err = c.awsClient.AllocIPAddresses(eni.ID, min((c.maxPrefixesPerENI-currentNumberOfAllocatedPrefixes), toAllocate))
+		err = c.allocIPAddresses(ctx, eni.ID, min((maxPrefixesPerENI-currentNumberOfAllocatedPrefixes), toAllocate))
 		if err != nil {
 			log.Warnf("failed to allocate all available IPv4 Prefixes on ENI %s, err: %v", eni.ID, err)
 			// Try to just get one more prefix
-			err = c.awsClient.AllocIPAddresses(eni.ID, 1)
+			err = c.allocIPAddresses(ctx, eni.ID, 1)
 			if err != nil {
 				ipamdErrInc("increaseIPPoolAllocIPAddressesFailed")
 				return false, errors.Wrap(err, fmt.Sprintf("failed to allocate one IPv4 prefix on ENI %s, err: %v", eni.ID, err))
@@ -882,8 +1334,18 @@ func (c *IPAMContext) setupENI(eni string, eniMetadata awsutils.ENIMetadata, isT
 	if err != nil && err.Error() != datastore.DuplicatedENIError {
 		return errors.Wrapf(err, "failed to add ENI %s to data store", eni)
 	}
+	// The ENIs described by EC2 were attached before now
+	if !eniMetadata.AttachTime.IsZero() {
+		if err := c.dataStore.SetENIAttachedTime(eni, eniMetadata.AttachTime); err != nil {
+			log.Warnf("Failed to set the attach time of ENI %s: %v", eni, err)
+		}
+	}
 	// Store the primary IP of the ENI
 	c.primaryIP[eni] = eniMetadata.PrimaryIPv4Address()
+	if err := c.dataStore.SetENISubnet(eni, eniMetadata.SubnetIPv4CIDR); err != nil {
+		log.Warnf("Failed to record the subnet of ENI %s: %v", eni, err)
+	}
+	c.updateHostReservedIPs()
 
 	// For secondary ENIs, set up the network
 	if eni != primaryENI {
@@ -893,6 +1355,8 @@ func (c *IPAMContext) setupENI(eni string, eniMetadata awsutils.ENIMetadata, isT
 			errRemove := c.dataStore.RemoveENIFromDataStore(eni, true)
 			if errRemove != nil {
 				log.Warnf("failed to remove ENI %s: %v", eni, errRemove)
+			} else {
+				recordENIRemoval(eni, awsutils.ENIRemovalErrorRecovery)
 			}
 			delete(c.primaryIP, eni)
 			return errors.Wrapf(err, "failed to set up ENI %s network", eni)
@@ -900,6 +1364,13 @@ func (c *IPAMContext) setupENI(eni string, eniMetadata awsutils.ENIMetadata, isT
 	}
 
 	log.Infof("Found ENIs having %d secondary IPs and %d Prefixes", len(eniMetadata.IPv4Addresses), len(eniMetadata.IPv4Prefixes))
+	if eni == primaryENI && c.disablePrimaryENIPodIPs {
+		// They are still added, for the pods already using them to be restored, and released once those are gone
+		log.Infof("Not giving the IPs and prefixes of the primary ENI %s to new pods, %s is set", eni, envDisablePrimaryENIPodIPs)
+		if err := c.dataStore.SetENINoNewPods(eni); err != nil {
+			return errors.Wrapf(err, "failed to keep new pods off ENI %s", eni)
+		}
+	}
 	//Either case add the IPs and prefixes to datastore.
 	c.addENIsecondaryIPsToDataStore(eniMetadata.IPv4Addresses, eni)
 	c.addENIprefixesToDataStore(eniMetadata.IPv4Prefixes, eni)
@@ -913,8 +1384,17 @@ func (c *IPAMContext) addENIsecondaryIPsToDataStore(ec2PrivateIpAddrs []*ec2.Net
 		if aws.BoolValue(ec2PrivateIpAddr.Primary) {
 			continue
 		}
+		if c.isIgnoredUntrackedIP(eni, aws.StringValue(ec2PrivateIpAddr.PrivateIpAddress)) {
+			log.Debugf("Not adding IP %s of ENI %s to the pool, %s is %s", aws.StringValue(ec2PrivateIpAddr.PrivateIpAddress),
+				eni, envUntrackedIPPolicy, untrackedIPPolicyIgnore)
+			continue
+		}
 		cidr := net.IPNet{IP: net.ParseIP(aws.StringValue(ec2PrivateIpAddr.PrivateIpAddress)), Mask: net.IPv4Mask(255, 255, 255, 255)}
 		err := c.dataStore.AddIPv4CidrToStore(eni, cidr, false)
+		if err != nil && err.Error() == datastore.IPReservedForHostError {
+			log.Warnf("Skipping IP %s on ENI %s, it is an address of the host", cidr.IP.String(), eni)
+			continue
+		}
 		if err != nil && err.Error() != datastore.IPAlreadyInStoreError {
 			log.Warnf("Failed to increase IP pool, failed to add IP %s to data store", ec2PrivateIpAddr.PrivateIpAddress)
 			// continue to add next address
@@ -1052,7 +1532,7 @@ func (c *IPAMContext) shouldRemoveExtraENIs() bool {
 		warmTarget = (c.warmPrefixTarget + 1)
 	}
 
-	shouldRemoveExtra = available >= (warmTarget)*c.maxIPsPerENI
+	shouldRemoveExtra = available >= (warmTarget)*c.maxUsableIPsPerENI()
 
 	if shouldRemoveExtra {
 		logPoolStats(total, used, c.maxIPsPerENI, c.enableIpv4PrefixDelegation)
@@ -1103,19 +1583,39 @@ func (c *IPAMContext) nodeIPPoolReconcile(ctx context.Context, interval time.Dur
 	defer ipamdActionsInprogress.WithLabelValues("nodeIPPoolReconcile").Sub(float64(1))
 
 	log.Debugf("Reconciling ENI/IP pool info because time since last %v <= %v", timeSinceLast, interval)
+	err := c.reconcileNodeIPPool(ctx)
+	c.reconcileStatus.record(curTime, time.Since(curTime), err)
+	if err != nil {
+		return
+	}
+	total, assigned, totalPrefix := c.dataStore.GetStats()
+	log.Debugf("IP/Prefix Address Pool stats: total: %d, assigned: %d, total prefixes: %d", total, assigned, totalPrefix)
+	c.updateIPAllocationAgeMetrics()
+	c.updateENIAttachmentAgeMetrics()
+	c.lastNodeIPPoolAction = curTime
+}
+
+// reconcileNodeIPPool does a single mark and sweep pass over the attached ENIs. It returns an error when the
+// reconcile had to be aborted; failures on individual ENIs are logged and skipped.
+func (c *IPAMContext) reconcileNodeIPPool(ctx context.Context) error {
 	allENIs, err := c.awsClient.GetAttachedENIs()
 	if err != nil {
 		log.Errorf("IP pool reconcile: Failed to get attached ENI info: %v", err.Error())
 		ipamdErrInc("reconcileFailedGetENIs")
-		return
+		return errors.Wrap(err, "failed to get attached ENI info")
 	}
 	// We must always have at least the primary ENI of the instance
 	if allENIs == nil {
 		log.Error("IP pool reconcile: No ENI found at all in metadata, unable to reconcile")
 		ipamdErrInc("reconcileFailedGetENIs")
-		return
+		return errors.New("no ENI found in instance metadata")
 	}
 	attachedENIs := c.filterUnmanagedENIs(allENIs)
+	c.reconcileDeletions.reset(c.maxReconcileDeletions)
+	defer c.reconcileDeletions.report()
+	// Flag pods holding a host address before the sweep below drops such addresses from the datastore
+	c.updateHostReservedIPs()
+	c.checkHostIPConflicts()
 	currentENIs := c.dataStore.GetENIInfos().ENIs
 	trunkENI := c.dataStore.GetTrunkENI()
 	// Initialize the set with the known EFA interfaces
@@ -1136,7 +1636,7 @@ func (c *IPAMContext) nodeIPPoolReconcile(ctx context.Context, interval time.Dur
 		metadataResult, err := c.awsClient.DescribeAllENIs()
 		if err != nil {
 			log.Warnf("Failed to call EC2 to describe ENIs, aborting reconcile: %v", err)
-			return
+			return errors.Wrap(err, "failed to describe ENIs")
 		}
 
 		if c.enablePodENI && metadataResult.TrunkENI != "" {
@@ -1145,7 +1645,7 @@ func (c *IPAMContext) nodeIPPoolReconcile(ctx context.Context, interval time.Dur
 			if err != nil {
 				podENIErrInc("askForTrunkENIIfNeeded")
 				log.Errorf("Failed to set node label for trunk. Aborting reconcile", err)
-				return
+				return errors.Wrap(err, "failed to set node label for trunk")
 			}
 		}
 		// Update trunk ENI
@@ -1161,6 +1661,11 @@ func (c *IPAMContext) nodeIPPoolReconcile(ctx context.Context, interval time.Dur
 	// Mark phase
 	for _, attachedENI := range attachedENIs {
 		eniIPPool, eniPrefixPool, err := c.dataStore.GetENICIDRs(attachedENI.ENIID)
+		if err == nil && c.disablePrimaryENIPodIPs && c.isPrimaryENI(attachedENI.ENIID) {
+			// Its IPs and prefixes are kept out of the pool, there is nothing to reconcile
+			delete(currentENIs, attachedENI.ENIID)
+			continue
+		}
 		if err == nil {
 			// If the attached ENI is in the data store
 			log.Debugf("Reconcile existing ENI %s IP pool", attachedENI.ENIID)
@@ -1178,7 +1683,7 @@ func (c *IPAMContext) nodeIPPoolReconcile(ctx context.Context, interval time.Dur
 		isTrunkENI := attachedENI.ENIID == trunkENI
 		isEFAENI := efaENIs[attachedENI.ENIID]
 		if !isTrunkENI {
-			if err := c.awsClient.TagENI(attachedENI.ENIID, eniTagMap[attachedENI.ENIID]); err != nil {
+			if err := c.awsClient.TagENI(attachedENI.ENIID, eniTagMap[attachedENI.ENIID]); err != nil && err != awsutils.ErrAuditMode {
 				log.Errorf("IP pool reconcile: failed to tag managed ENI %v: %v", attachedENI.ENIID, err)
 				ipamdErrInc("eniReconcileAdd")
 				continue
@@ -1197,8 +1702,15 @@ func (c *IPAMContext) nodeIPPoolReconcile(ctx context.Context, interval time.Dur
 		reconcileCnt.With(prometheus.Labels{"fn": "eniReconcileAdd"}).Inc()
 	}
 
+	stillAttached := make(map[string]bool, len(allENIs))
+	for _, eni := range allENIs {
+		stillAttached[eni.ENIID] = true
+	}
 	// Sweep phase: since the marked ENI have been removed, the remaining ones needs to be sweeped
 	for eni := range currentENIs {
+		if !c.reconcileDeletions.allow() {
+			continue
+		}
 		log.Infof("Reconcile and delete detached ENI %s", eni)
 		// Force the delete, since aws local metadata has told us that this ENI is no longer
 		// attached, so any IPs assigned from this ENI will no longer work.
@@ -1209,12 +1721,59 @@ func (c *IPAMContext) nodeIPPoolReconcile(ctx context.Context, interval time.Dur
 			continue
 		}
 		delete(c.primaryIP, eni)
+		c.forgetIgnoredUntrackedIPs(eni)
+		if stillAttached[eni] {
+			// The ENI is attached but no longer managed by ipamd, e.g. it got the no_manage tag
+			recordENIRemoval(eni, awsutils.ENIRemovalDrain)
+		} else {
+			recordENIRemoval(eni, awsutils.ENIRemovalDecommission)
+		}
+		c.deleteENIConntrackEntries(currentENIs[eni])
 		reconcileCnt.With(prometheus.Labels{"fn": "eniReconcileDel"}).Inc()
 	}
+
+	c.retryPendingENIs(ctx)
 	log.Debug("Successfully Reconciled ENI/IP pool")
-	total, assigned, totalPrefix := c.dataStore.GetStats()
-	log.Debugf("IP/Prefix Address Pool stats: total: %d, assigned: %d, total prefixes: %d", total, assigned, totalPrefix)
-	c.lastNodeIPPoolAction = curTime
+	return nil
+}
+
+// retryPendingENIs retries the IP or prefix assignment of the attached ENIs that still have none after the metadata
+// has been reconciled, e.g. because the subnet was exhausted when they were allocated
+func (c *IPAMContext) retryPendingENIs(ctx context.Context) {
+	for _, eni := range c.dataStore.GetPendingENIs() {
+		if c.isTerminating() {
+			return
+		}
+		resourcesToAllocate := c.GetENIResourcesToAllocate()
+		if resourcesToAllocate <= 0 {
+			log.Debugf("IP pool reconcile: ENI %s has no IPs or prefixes, but the warm target does not need any", eni)
+			return
+		}
+		log.Infof("IP pool reconcile: ENI %s has no IPs or prefixes, retrying to assign %d", eni, resourcesToAllocate)
+		if err := c.allocIPAddresses(ctx, eni, resourcesToAllocate); err != nil {
+			log.Warnf("IP pool reconcile: failed to assign IPs or prefixes to pending ENI %s, will retry: %v", eni, err)
+			ipamdErrInc("reconcilePendingENIAllocFailed")
+			continue
+		}
+		if c.enableIpv4PrefixDelegation {
+			ec2Prefixes, err := c.awsClient.GetIPv4PrefixesFromEC2(eni)
+			if err != nil {
+				log.Warnf("IP pool reconcile: failed to get the prefixes of pending ENI %s: %v", eni, err)
+				ipamdErrInc("reconcilePendingENIGetAddressesFailed")
+				continue
+			}
+			c.addENIprefixesToDataStore(ec2Prefixes, eni)
+		} else {
+			ec2Addrs, err := c.awsClient.GetIPv4sFromEC2(eni)
+			if err != nil {
+				log.Warnf("IP pool reconcile: failed to get the IPs of pending ENI %s: %v", eni, err)
+				ipamdErrInc("reconcilePendingENIGetAddressesFailed")
+				continue
+			}
+			c.addENIsecondaryIPsToDataStore(ec2Addrs, eni)
+		}
+		reconcileCnt.With(prometheus.Labels{"fn": "eniReconcilePendingENI"}).Inc()
+	}
 }
 
 func (c *IPAMContext) eniIPPoolReconcile(ipPool []string, attachedENI awsutils.ENIMetadata, eni string) {
@@ -1222,7 +1781,7 @@ func (c *IPAMContext) eniIPPoolReconcile(ipPool []string, attachedENI awsutils.E
 	needEC2Reconcile := true
 	// Here we can't trust attachedENI since the IMDS metadata can be stale. We need to check with EC2 API.
 	// +1 is for the primary IP of the ENI that is not added to the ipPool and not available for pods to use.
-	if 1+len(ipPool) != len(attachedENIIPs) {
+	if 1+len(ipPool) != len(attachedENIIPs) && !c.hasOnlyIgnoredIPs(eni, ipPool, attachedENIIPs) {
 		log.Warnf("Instance metadata does not match data store! ipPool: %v, metadata: %v", ipPool, attachedENIIPs)
 		log.Debugf("We need to check the ENI status by calling the EC2 control plane.")
 		// Call EC2 to verify IPs on this ENI
@@ -1235,7 +1794,8 @@ func (c *IPAMContext) eniIPPoolReconcile(ipPool []string, attachedENI awsutils.E
 		needEC2Reconcile = false
 	}
 
-	// Add all known attached IPs to the datastore
+	// Add all known attached IPs to the datastore, or only the ones it already tracks with the ignore policy
+	attachedENIIPs = c.filterUntrackedIPs(eni, ipPool, attachedENIIPs)
 	seenIPs := c.verifyAndAddIPsToDatastore(eni, attachedENIIPs, needEC2Reconcile)
 
 	// Sweep phase, delete remaining IPs since they should not remain in the datastore
@@ -1244,6 +1804,9 @@ func (c *IPAMContext) eniIPPoolReconcile(ipPool []string, attachedENI awsutils.E
 			continue
 		}
 
+		if !c.reconcileDeletions.allow() {
+			continue
+		}
 		log.Debugf("Reconcile and delete IP %s on ENI %s", existingIP, eni)
 		// Force the delete, since we have verified with EC2 that these secondary IPs are no longer assigned to this ENI
 		ipv4Addr := net.IPNet{IP: net.ParseIP(existingIP), Mask: net.IPv4Mask(255, 255, 255, 255)}
@@ -1286,6 +1849,9 @@ func (c *IPAMContext) eniPrefixPoolReconcile(ipPool []string, attachedENI awsuti
 			continue
 		}
 
+		if !c.reconcileDeletions.allow() {
+			continue
+		}
 		log.Debugf("Reconcile and delete Prefix %s on ENI %s", existingIP, eni)
 		// Force the delete, since we have verified with EC2 that these secondary IPs are no longer assigned to this ENI
 		_, ipv4Cidr, err := net.ParseCIDR(existingIP)
@@ -1361,6 +1927,10 @@ func (c *IPAMContext) verifyAndAddIPsToDatastore(eni string, attachedENIIPs []*e
 		log.Infof("Trying to add %s", strPrivateIPv4)
 		// Try to add the IP
 		err := c.dataStore.AddIPv4CidrToStore(eni, ipv4Addr, false)
+		if err != nil && err.Error() == datastore.IPReservedForHostError {
+			log.Warnf("Reconcile and skip IP %s on ENI %s, it is an address of the host", strPrivateIPv4, eni)
+			continue
+		}
 		if err != nil && err.Error() != datastore.IPAlreadyInStoreError {
 			log.Errorf("Failed to reconcile IP %s on ENI %s", strPrivateIPv4, eni)
 			ipamdErrInc("ipReconcileAdd")
@@ -1482,6 +2052,37 @@ func getWarmIPTarget() int {
 	return noWarmIPTarget
 }
 
+func getWarmIPPercent() int {
+	inputStr, found := os.LookupEnv(envWarmIPPercent)
+
+	if !found {
+		return noWarmIPPercent
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil {
+		if input >= 0 {
+			log.Debugf("Using WARM_IP_PERCENT %v", input)
+			return input
+		}
+	}
+	return noWarmIPPercent
+}
+
+// getEffectiveWarmIPTarget returns the warm IP target for the given number of assigned IPs. Without WARM_IP_PERCENT
+// this is WARM_IP_TARGET, otherwise it is the percentage of assigned IPs, floored at WARM_IP_TARGET and capped by the
+// number of IPs the node can still allocate.
+func (c *IPAMContext) getEffectiveWarmIPTarget(assigned int) int {
+	if c.warmIPPercent == noWarmIPPercent {
+		return c.warmIPTarget
+	}
+	target := datastore.DivCeil(assigned*c.warmIPPercent, 100)
+	target = max(target, max(c.warmIPTarget, minWarmIPPercentTarget))
+	if c.maxENI > 0 && c.maxIPsPerENI > 0 {
+		target = min(target, max(c.maxIPsPerENI*(c.maxENI-c.unmanagedENI)-assigned, 0))
+	}
+	return target
+}
+
 func getMinimumIPTarget() int {
 	inputStr, found := os.LookupEnv(envMinimumIPTarget)
 
@@ -1498,6 +2099,79 @@ func getMinimumIPTarget() int {
 	return noMinimumIPTarget
 }
 
+func getIPReclaimMinAge() time.Duration {
+	inputStr, found := os.LookupEnv(envIPReclaimMinAge)
+
+	if !found {
+		return defaultIPReclaimMinAge
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using IP_RECLAIM_MIN_AGE_SECONDS %v", input)
+		return time.Duration(input) * time.Second
+	}
+	log.Warnf("Invalid %s value %q, ignoring it", envIPReclaimMinAge, inputStr)
+	return defaultIPReclaimMinAge
+}
+
+func getStartupENIWait() time.Duration {
+	inputStr, found := os.LookupEnv(envStartupENIWait)
+
+	if !found {
+		return defaultStartupENIWait
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using STARTUP_ENI_WAIT_SECONDS %v", input)
+		return time.Duration(input) * time.Second
+	}
+	log.Warnf("Invalid %s value %q, ignoring it", envStartupENIWait, inputStr)
+	return defaultStartupENIWait
+}
+
+func flushConntrackOnENIDetach() bool {
+	return getEnvBoolWithDefault(envFlushConntrackOnENIDetach, false)
+}
+
+func enablePodSourceValidation() bool {
+	return getEnvBoolWithDefault(envEnablePodSourceValidation, false)
+}
+
+func getPodInterfaceQueues() int {
+	inputStr, found := os.LookupEnv(envPodInterfaceQueues)
+
+	if !found {
+		return noPodInterfaceQueues
+	}
+
+	if input, err := strconv.Atoi(inputStr); err == nil && input > 0 {
+		if input > maxPodInterfaceQueues {
+			log.Warnf("%s %d is more than the maximum of %d, using %d", envPodInterfaceQueues, input, maxPodInterfaceQueues, maxPodInterfaceQueues)
+			return maxPodInterfaceQueues
+		}
+		log.Debugf("Using POD_INTERFACE_QUEUES %v", input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, ignoring it", envPodInterfaceQueues, inputStr)
+	return noPodInterfaceQueues
+}
+
+// getPodInterfaceQueueCount returns the number of queues to set up on the pod's interface, honoring the
+// vpc.amazonaws.com/pod-interface-queues annotation when it holds a valid value.
+func (c *IPAMContext) getPodInterfaceQueueCount(pod *corev1.Pod) int {
+	val, ok := pod.Annotations[podInterfaceQueuesAnnotation]
+	if !ok {
+		return c.podInterfaceQueues
+	}
+	numQueues, err := strconv.Atoi(val)
+	if err != nil || numQueues < 1 || numQueues > maxPodInterfaceQueues {
+		log.Warnf("Ignoring invalid %s annotation %q on pod %s/%s, must be between 1 and %d",
+			podInterfaceQueuesAnnotation, val, pod.Namespace, pod.Name, maxPodInterfaceQueues)
+		return c.podInterfaceQueues
+	}
+	return numQueues
+}
+
 func disablingENIProvisioning() bool {
 	return getEnvBoolWithDefault(envDisableENIProvisioning, false)
 }
@@ -1538,22 +2212,23 @@ func (c *IPAMContext) filterUnmanagedENIs(enis []awsutils.ENIMetadata) []awsutil
 // With prefix delegation this function determines the number of Prefixes `short` or `over`
 func (c *IPAMContext) datastoreTargetState() (short int, over int, enabled bool) {
 
-	if c.warmIPTarget == noWarmIPTarget && c.minimumIPTarget == noMinimumIPTarget {
+	if c.warmIPTarget == noWarmIPTarget && c.minimumIPTarget == noMinimumIPTarget && c.warmIPPercent == noWarmIPPercent {
 		// there is no WARM_IP_TARGET defined and no MINIMUM_IP_TARGET, fallback to use all IP addresses on ENI
 		return 0, 0, false
 	}
 
 	total, assigned, totalPrefix := c.dataStore.GetStats()
 	available := total - assigned
+	warmIPTarget := c.getEffectiveWarmIPTarget(assigned)
 
 	// short is greater than 0 when we have fewer available IPs than the warm IP target
-	short = max(c.warmIPTarget-available, 0)
+	short = max(warmIPTarget-available, 0)
 
 	// short is greater than the warm IP target alone when we have fewer total IPs than the minimum target
 	short = max(short, c.minimumIPTarget-total)
 
 	// over is the number of available IPs we have beyond the warm IP target
-	over = max(available-c.warmIPTarget, 0)
+	over = max(available-warmIPTarget, 0)
 
 	// over is less than the warm IP target alone if it would imply reducing total IPs below the minimum target
 	over = max(min(over, total-c.minimumIPTarget), 0)
@@ -1570,7 +2245,7 @@ func (c *IPAMContext) datastoreTargetState() (short int, over int, enabled bool)
 		// Over will have number of IPs more than needed but with PD we would have allocated in chunks of /28
 		// Say assigned = 1, warm ip target = 16, this will need 2 prefixes. But over will return 15.
 		// Hence we need to check if 'over' number of IPs are needed to maintain the warm targets
-		prefixNeededForWarmIP := datastore.DivCeil(assigned+c.warmIPTarget, numIPsPerPrefix)
+		prefixNeededForWarmIP := datastore.DivCeil(assigned+warmIPTarget, numIPsPerPrefix)
 		prefixNeededForMinIP := datastore.DivCeil(c.minimumIPTarget, numIPsPerPrefix)
 
 		// over will be number of prefixes over than needed but could be spread across used prefixes,
@@ -1579,11 +2254,11 @@ func (c *IPAMContext) datastoreTargetState() (short int, over int, enabled bool)
 		freePrefixes := c.dataStore.GetFreePrefixes()
 		overPrefix := max(min(freePrefixes, totalPrefix-prefixNeededForWarmIP), 0)
 		overPrefix = max(min(overPrefix, totalPrefix-prefixNeededForMinIP), 0)
-		log.Debugf("Current warm IP stats : target: %d, total: %d, assigned: %d, available: %d, short(prefixes): %d, over(prefixes): %d", c.warmIPTarget, total, assigned, available, shortPrefix, overPrefix)
+		log.Debugf("Current warm IP stats : target: %d, total: %d, assigned: %d, available: %d, short(prefixes): %d, over(prefixes): %d", warmIPTarget, total, assigned, available, shortPrefix, overPrefix)
 		return shortPrefix, overPrefix, true
 
 	}
-	log.Debugf("Current warm IP stats: target: %d, total: %d, assigned: %d, available: %d, short: %d, over %d", c.warmIPTarget, total, assigned, available, short, over)
+	log.Debugf("Current warm IP stats: target: %d, total: %d, assigned: %d, available: %d, short: %d, over %d", warmIPTarget, total, assigned, available, short, over)
 
 	return short, over, true
 }
@@ -1613,9 +2288,40 @@ func (c *IPAMContext) isTerminating() bool {
 // GetConfigForDebug returns the active values of the configuration env vars (for debugging purposes).
 func GetConfigForDebug() map[string]interface{} {
 	return map[string]interface{}{
-		envWarmIPTarget:     getWarmIPTarget(),
-		envWarmENITarget:    getWarmENITarget(),
-		envCustomNetworkCfg: UseCustomNetworkCfg(),
+		envWarmIPTarget:              getWarmIPTarget(),
+		envWarmIPPercent:             getWarmIPPercent(),
+		envWarmENITarget:             getWarmENITarget(),
+		envCustomNetworkCfg:          UseCustomNetworkCfg(),
+		envPodInterfaceQueues:        getPodInterfaceQueues(),
+		envEnablePodRoutes:           enablePodRoutes(),
+		envFlushConntrackOnENIDetach: flushConntrackOnENIDetach(),
+		envIPReclaimMinAge:           getIPReclaimMinAge().String(),
+		envHostReservedIPs:           strings.Join(getHostReservedIPs(), ","),
+		envStartupENIWait:            getStartupENIWait().String(),
+		envEnablePodAllocationEvents: enablePodAllocationEvents(),
+		envEnablePodSubnetAnnotation: enablePodSubnetAnnotation(),
+		envEnablePodMACAnnotation:    enablePodMACAnnotation(),
+		envEC2CallBatchWindow:        getEC2CallBatchWindow().String(),
+		envIPExhaustionPolicy:        getIPExhaustionPolicy(),
+		envIPExhaustionQueueTimeout:  getIPExhaustionQueueTimeout().String(),
+		envPodTeardownConcurrency:    getPodTeardownConcurrency(),
+		envMaxPodsPerENI:             getMaxPodsPerENI(),
+		envUntrackedIPPolicy:         getUntrackedIPPolicy(),
+		envIPAssignmentStrategy:      getIPAssignmentStrategy(),
+		envMaxReconcileDeletions:     getMaxReconcileDeletions(),
+		envEnableSubnetDiscovery:     enableSubnetDiscovery(),
+		envWarmENIReclaimDwell:       os.Getenv(envWarmENIReclaimDwell),
+		envWarmENIReclaimDwellByType: os.Getenv(envWarmENIReclaimDwellByType),
+		envEnablePodSourceValidation: enablePodSourceValidation(),
+		envSubnetLowIPThreshold:      getSubnetLowIPThreshold(),
+		envEnableSubnetLowIPEvents:   enableSubnetLowIPEvents(),
+		envENIQuotaWarningPercent:    getENIQuotaWarningPercent(),
+		envDisablePrimaryENIPodIPs:   disablePrimaryENIPodIPs(),
+		envMaxTotalIPs:               getMaxTotalIPs(),
+		envFastIPReleaseGracePeriod:  os.Getenv(envFastIPReleaseGracePeriod),
+		envWarmUpConcurrency:         getWarmUpConcurrency(),
+		envEnableENIConfigAnnotation: enableENIConfigAnnotation(),
+		envPodSubnetID:               getPodSubnetID(),
 	}
 }
 
@@ -1708,14 +2414,6 @@ func (c *IPAMContext) GetPod(podName, namespace string) (*corev1.Pod, error) {
 	return &pod, nil
 }
 
-func (c *IPAMContext) tryUnassignIPsFromENIs() {
-	log.Debugf("In tryUnassignIPsFromENIs")
-	eniInfos := c.dataStore.GetENIInfos()
-	for eniID := range eniInfos.ENIs {
-		c.tryUnassignIPFromENI(eniID)
-	}
-}
-
 func (c *IPAMContext) tryUnassignIPFromENI(eniID string) {
 	freeableIPs := c.dataStore.FreeableIPs(eniID)
 
@@ -1723,6 +2421,9 @@ func (c *IPAMContext) tryUnassignIPFromENI(eniID string) {
 		log.Debugf("No freeable IPs")
 		return
 	}
+	if c.skipInAuditMode("DeallocIPAddresses", "unassign IP addresses %v from ENI %s", freeableIPs, eniID) {
+		return
+	}
 
 	// Delete IPs from datastore
 	var deletedIPs []string
@@ -1740,25 +2441,21 @@ func (c *IPAMContext) tryUnassignIPFromENI(eniID string) {
 	}
 
 	// Deallocate IPs from the instance if they aren't used by pods.
-	if err := c.awsClient.DeallocIPAddresses(eniID, deletedIPs); err != nil {
+	if err := c.deallocCidrs(eniID, deletedIPs, false); err != nil {
 		log.Warnf("Failed to decrease IP pool by removing IPs %v from ENI %s: %s", deletedIPs, eniID, err)
 	} else {
 		log.Debugf("Successfully decreased IP pool by removing IPs %v from ENI %s", deletedIPs, eniID)
 	}
 }
 
-func (c *IPAMContext) tryUnassignPrefixesFromENIs() {
-	eniInfos := c.dataStore.GetENIInfos()
-	for eniID := range eniInfos.ENIs {
-		c.tryUnassignPrefixFromENI(eniID)
-	}
-}
-
 func (c *IPAMContext) tryUnassignPrefixFromENI(eniID string) {
 	freeablePrefixes := c.dataStore.FreeablePrefixes(eniID)
 	if len(freeablePrefixes) == 0 {
 		return
 	}
+	if c.skipInAuditMode("DeallocPrefixAddresses", "unassign prefixes %v from ENI %s", freeablePrefixes, eniID) {
+		return
+	}
 	// Delete Prefixes from datastore
 	var deletedPrefixes []string
 	for _, toDelete := range freeablePrefixes {
@@ -1775,7 +2472,7 @@ func (c *IPAMContext) tryUnassignPrefixFromENI(eniID string) {
 	}
 
 	// Deallocate IPs from the instance if they aren't used by pods.
-	if err := c.awsClient.DeallocPrefixAddresses(eniID, deletedPrefixes); err != nil {
+	if err := c.deallocCidrs(eniID, deletedPrefixes, true); err != nil {
 		log.Warnf("Failed to delete prefix %v from ENI %s: %s", deletedPrefixes, eniID, err)
 	} else {
 		log.Debugf("Successfully prefix removing IPs %v from ENI %s", deletedPrefixes, eniID)
@@ -1785,15 +2482,15 @@ func (c *IPAMContext) tryUnassignPrefixFromENI(eniID string) {
 func (c *IPAMContext) GetENIResourcesToAllocate() int {
 	var resourcesToAllocate int
 	if !c.enableIpv4PrefixDelegation {
-		resourcesToAllocate = c.maxIPsPerENI
+		resourcesToAllocate = c.maxUsableIPsPerENI()
 		short, _, warmTargetDefined := c.datastoreTargetState()
 		if warmTargetDefined {
 			resourcesToAllocate = short
 		}
 	} else {
-		resourcesToAllocate = c.getPrefixesNeeded()
+		resourcesToAllocate = min(c.getPrefixesNeeded(), c.maxUsablePrefixesPerENI())
 	}
-	return resourcesToAllocate
+	return c.capAllocation(resourcesToAllocate)
 }
 
 func (c *IPAMContext) GetIPv4Limit() (int, int, error) {
@@ -1829,7 +2526,7 @@ func (c *IPAMContext) isDatastorePoolTooLow() bool {
 	available := total - used
 
 	warmTarget := c.warmENITarget
-	totalIPs := c.maxIPsPerENI
+	totalIPs := c.maxUsableIPsPerENI()
 
 	if c.enableIpv4PrefixDelegation {
 		warmTarget = c.warmPrefixTarget
@@ -1890,11 +2587,11 @@ func (c *IPAMContext) DeallocCidrs(eniID string, deletableCidrs []datastore.Cidr
 		}
 	}
 
-	if err := c.awsClient.DeallocPrefixAddresses(eniID, deletablePrefixes); err != nil {
+	if err := c.deallocCidrs(eniID, deletablePrefixes, true); err != nil {
 		log.Warnf("Failed to free Prefixes %v from ENI %s: %s", deletablePrefixes, eniID, err)
 	}
 
-	if err := c.awsClient.DeallocIPAddresses(eniID, deletableIPs); err != nil {
+	if err := c.deallocCidrs(eniID, deletableIPs, false); err != nil {
 		log.Warnf("Failed to free IPs %v from ENI %s: %s", deletableIPs, eniID, err)
 	}
 }
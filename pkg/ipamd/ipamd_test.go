@@ -15,18 +15,26 @@ package ipamd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/vishvananda/netlink"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -40,9 +48,11 @@ import (
 	eniconfigscheme "github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
 	mock_awsutils "github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils/mocks"
+	mock_cri "github.com/aws/amazon-vpc-cni-k8s/pkg/cri/mocks"
 	mock_eniconfig "github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig/mocks"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
 	mock_networkutils "github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils/mocks"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/tracing"
 )
 
 const (
@@ -74,6 +84,7 @@ type testMocks struct {
 	cachedK8SClient client.Client
 	network         *mock_networkutils.MockNetworkAPIs
 	eniconfig       *mock_eniconfig.MockENIConfig
+	cri             *mock_cri.MockAPIs
 }
 
 func setup(t *testing.T) *testMocks {
@@ -89,6 +100,7 @@ func setup(t *testing.T) *testMocks {
 		cachedK8SClient: testclient.NewFakeClientWithScheme(k8sSchema),
 		network:         mock_networkutils.NewMockNetworkAPIs(ctrl),
 		eniconfig:       mock_eniconfig.NewMockENIConfig(ctrl),
+		cri:             mock_cri.NewMockAPIs(ctrl),
 	}
 }
 
@@ -117,6 +129,7 @@ func TestNodeInit(t *testing.T) {
 		networkClient:   m.network,
 		dataStore:       datastore.NewDataStore(log, datastore.NewTestCheckpoint(fakeCheckpoint), false),
 		myNodeName:      myNodeName,
+		criClient:       m.cri,
 	}
 	mockContext.dataStore.CheckpointMigrationPhase = 2
 
@@ -155,7 +168,8 @@ func TestNodeInit(t *testing.T) {
 	m.awsutils.EXPECT().GetLocalIPv4().Return(primaryIP)
 
 	var rules []netlink.Rule
-	m.network.EXPECT().GetRuleList().Return(rules, nil)
+	m.network.EXPECT().GetRuleList().Return(rules, nil).Times(2)
+	m.cri.EXPECT().GetRunningPodSandboxes(gomock.Any()).Return(nil, nil)
 
 	m.network.EXPECT().UpdateRuleListBySrc(gomock.Any(), gomock.Any())
 
@@ -201,6 +215,7 @@ func TestNodeInitwithPDenabled(t *testing.T) {
 		dataStore:                  datastore.NewDataStore(log, datastore.NewTestCheckpoint(fakeCheckpoint), true),
 		myNodeName:                 myNodeName,
 		enableIpv4PrefixDelegation: true,
+		criClient:                  m.cri,
 	}
 	mockContext.dataStore.CheckpointMigrationPhase = 2
 
@@ -238,7 +253,8 @@ func TestNodeInitwithPDenabled(t *testing.T) {
 	m.awsutils.EXPECT().SetCNIUnmanagedENIs(resp.MultiCardENIIDs).AnyTimes()
 
 	var rules []netlink.Rule
-	m.network.EXPECT().GetRuleList().Return(rules, nil)
+	m.network.EXPECT().GetRuleList().Return(rules, nil).Times(2)
+	m.cri.EXPECT().GetRunningPodSandboxes(gomock.Any()).Return(nil, nil)
 
 	//m.network.EXPECT().UseExternalSNAT().Return(false)
 	m.network.EXPECT().UpdateRuleListBySrc(gomock.Any(), gomock.Any())
@@ -452,6 +468,58 @@ func testIncreaseIPPool(t *testing.T, useENIConfig bool) {
 	mockContext.increaseDatastorePool(ctx)
 }
 
+func TestIncreaseIPPoolTracing(t *testing.T) {
+	_ = os.Unsetenv(envCustomNetworkCfg)
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := tracing.Setup(sdktrace.WithSyncer(exporter), "test")
+	defer func() {
+		_ = shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}()
+
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		maxIPsPerENI:  14,
+		maxENI:        4,
+		warmENITarget: 1,
+		networkClient: m.network,
+		primaryIP:     make(map[string]string),
+		terminating:   int32(0),
+	}
+	mockContext.dataStore = testDatastore()
+
+	m.awsutils.EXPECT().AllocENI(false, nil, "").Return(secENIid, nil)
+	m.awsutils.EXPECT().AllocIPAddresses(secENIid, 14)
+	m.awsutils.EXPECT().WaitForENIAndIPsAttached(secENIid, 14).Return(awsutils.ENIMetadata{
+		ENIID:          secENIid,
+		MAC:            secMAC,
+		DeviceNumber:   secDevice,
+		SubnetIPv4CIDR: secSubnet,
+	}, nil)
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	m.network.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, secSubnet)
+
+	mockContext.increaseDatastorePool(context.Background())
+
+	spans := make(map[string]*sdktrace.SpanSnapshot)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	root, ok := spans["increaseDatastorePool"]
+	assert.True(t, ok)
+	for _, name := range []string{"AllocENI", "AllocIPAddresses"} {
+		span, ok := spans[name]
+		if assert.True(t, ok, "missing span %s", name) {
+			assert.Equal(t, root.SpanContext.SpanID(), span.Parent.SpanID())
+			assert.Contains(t, span.Attributes, tracing.AttrENIID.String(secENIid))
+		}
+	}
+	assert.Contains(t, spans["AllocIPAddresses"].Attributes, tracing.AttrIPCount.Int(14))
+}
+
 func TestIncreasePrefixPoolDefault(t *testing.T) {
 	_ = os.Unsetenv(envCustomNetworkCfg)
 	testIncreasePrefixPool(t, false)
@@ -579,6 +647,35 @@ func testIncreasePrefixPool(t *testing.T, useENIConfig bool) {
 	mockContext.increaseDatastorePool(ctx)
 }
 
+func TestIncreaseIPPoolDeviceIndexBaseFull(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		dataStore:     testDatastore(),
+		maxIPsPerENI:  1,
+		warmENITarget: 1,
+	}
+	// An m5.large with ENI_DEVICE_INDEX_BASE 2 only takes the primary ENI and the one at device index 2
+	m.awsutils.EXPECT().GetENILimit().Return(2, nil)
+	maxENI, err := mockContext.getMaxENI()
+	assert.NoError(t, err)
+	mockContext.maxENI = maxENI
+	for i, eni := range []string{primaryENIid, secENIid} {
+		assert.NoError(t, mockContext.dataStore.AddENI(eni, i, i == 0, false, false))
+		assert.NoError(t, mockContext.dataStore.AddIPv4CidrToStore(eni,
+			net.IPNet{IP: net.ParseIP([]string{ipaddr01, ipaddr11}[i]), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+
+	// Both ENIs are full, but no other ENI can be attached above the base so none is allocated
+	mockContext.increaseDatastorePool(context.Background())
+	miss := mockContext.warmTargetMiss.status()
+	if assert.NotNil(t, miss) {
+		assert.Equal(t, warmTargetMissInstanceLimit, miss.Reason)
+	}
+}
+
 func TestTryAddIPToENI(t *testing.T) {
 	_ = os.Unsetenv(envCustomNetworkCfg)
 	m := setup(t)
@@ -743,6 +840,232 @@ func TestNodeIPPoolReconcile(t *testing.T) {
 	assert.Equal(t, 0, curENIs.TotalIPs)
 }
 
+func TestNodeIPPoolReconcileDeletesConntrackEntries(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	mockContext := &IPAMContext{
+		awsClient:                 m.awsutils,
+		networkClient:             m.network,
+		primaryIP:                 make(map[string]string),
+		terminating:               int32(0),
+		flushConntrackOnENIDetach: true,
+	}
+
+	mockContext.dataStore = testDatastore()
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false)
+	_ = mockContext.dataStore.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}, false)
+	_ = mockContext.dataStore.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr03), Mask: net.IPv4Mask(255, 255, 255, 255)}, false)
+	_ = mockContext.dataStore.AddENI(secENIid, secDevice, false, false, false)
+	_ = mockContext.dataStore.AddIPv4CidrToStore(secENIid, net.IPNet{IP: net.ParseIP(ipaddr12), Mask: net.IPv4Mask(255, 255, 255, 255)}, false)
+
+	m.awsutils.EXPECT().GetPrimaryENI().AnyTimes().Return(primaryENIid)
+	m.awsutils.EXPECT().IsUnmanagedENI(primaryENIid).AnyTimes().Return(false)
+	m.awsutils.EXPECT().IsCNIUnmanagedENI(primaryENIid).AnyTimes().Return(false)
+
+	// The secondary ENI is no longer attached, only its IPs are flushed
+	m.awsutils.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{getPrimaryENIMetadata()}, nil)
+	m.network.EXPECT().DeleteConntrackEntries([]net.IPNet{
+		{IP: net.ParseIP(ipaddr12), Mask: net.IPv4Mask(255, 255, 255, 255)},
+	}).Return(uint(3), nil)
+
+	mockContext.nodeIPPoolReconcile(ctx, 0)
+	curENIs := mockContext.dataStore.GetENIInfos()
+	assert.Equal(t, 1, len(curENIs.ENIs))
+	assert.NotContains(t, curENIs.ENIs, secENIid)
+}
+
+// captureENIRemovals replaces recordENIRemoval for the duration of the test and returns the recorded reasons by ENI
+func captureENIRemovals(t *testing.T) map[string]awsutils.ENIRemovalReason {
+	removals := make(map[string]awsutils.ENIRemovalReason)
+	recordENIRemoval = func(eniID string, reason awsutils.ENIRemovalReason) {
+		removals[eniID] = reason
+	}
+	t.Cleanup(func() { recordENIRemoval = awsutils.RecordENIRemoval })
+	return removals
+}
+
+func TestFreeENIRecordsReason(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	removals := captureENIRemovals(t)
+
+	mockContext := &IPAMContext{awsClient: m.awsutils, networkClient: m.network}
+
+	m.awsutils.EXPECT().FreeENI(secENIid).Return(errors.New("DependencyViolation"))
+	mockContext.freeENI(secENIid, datastore.ENI{ID: secENIid}, awsutils.ENIRemovalWarmShrink)
+	assert.Empty(t, removals)
+
+	m.awsutils.EXPECT().FreeENI(secENIid).Return(nil)
+	mockContext.freeENI(secENIid, datastore.ENI{ID: secENIid}, awsutils.ENIRemovalWarmShrink)
+	assert.Equal(t, map[string]awsutils.ENIRemovalReason{secENIid: awsutils.ENIRemovalWarmShrink}, removals)
+}
+
+func TestSetupENIFailureRecordsReason(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	removals := captureENIRemovals(t)
+
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		networkClient: m.network,
+		primaryIP:     make(map[string]string),
+		dataStore:     testDatastore(),
+	}
+
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	m.network.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, primarySubnet).Return(errors.New("not able to set route"))
+	assert.Error(t, mockContext.setupENI(secENIid, getSecondaryENIMetadata(), false, false))
+	assert.Equal(t, map[string]awsutils.ENIRemovalReason{secENIid: awsutils.ENIRemovalErrorRecovery}, removals)
+}
+
+func TestNodeIPPoolReconcileRecordsENIRemovalReason(t *testing.T) {
+	tests := []struct {
+		name         string
+		attachedENIs []awsutils.ENIMetadata
+		want         awsutils.ENIRemovalReason
+	}{
+		{"detached", []awsutils.ENIMetadata{getPrimaryENIMetadata()}, awsutils.ENIRemovalDecommission},
+		{"attached but unmanaged", []awsutils.ENIMetadata{getPrimaryENIMetadata(), getSecondaryENIMetadata()}, awsutils.ENIRemovalDrain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := setup(t)
+			defer m.ctrl.Finish()
+			removals := captureENIRemovals(t)
+
+			mockContext := &IPAMContext{
+				awsClient:     m.awsutils,
+				networkClient: m.network,
+				primaryIP:     map[string]string{primaryENIid: ipaddr01, secENIid: ipaddr11},
+				dataStore:     testDatastore(),
+			}
+			_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false)
+			_ = mockContext.dataStore.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}, false)
+			_ = mockContext.dataStore.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr03), Mask: net.IPv4Mask(255, 255, 255, 255)}, false)
+			_ = mockContext.dataStore.AddENI(secENIid, secDevice, false, false, false)
+			_ = mockContext.dataStore.AddIPv4CidrToStore(secENIid, net.IPNet{IP: net.ParseIP(ipaddr12), Mask: net.IPv4Mask(255, 255, 255, 255)}, false)
+
+			m.awsutils.EXPECT().GetPrimaryENI().AnyTimes().Return(primaryENIid)
+			m.awsutils.EXPECT().IsUnmanagedENI(primaryENIid).AnyTimes().Return(false)
+			m.awsutils.EXPECT().IsCNIUnmanagedENI(primaryENIid).AnyTimes().Return(false)
+			m.awsutils.EXPECT().IsUnmanagedENI(secENIid).AnyTimes().Return(true)
+			m.awsutils.EXPECT().GetAttachedENIs().Return(tt.attachedENIs, nil)
+
+			mockContext.nodeIPPoolReconcile(context.Background(), 0)
+			assert.NotContains(t, mockContext.dataStore.GetENIInfos().ENIs, secENIid)
+			assert.Equal(t, map[string]awsutils.ENIRemovalReason{secENIid: tt.want}, removals)
+		})
+	}
+}
+
+func TestDeleteENIConntrackEntries(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	_, prefix, _ := net.ParseCIDR(prefix01)
+	eni := datastore.ENI{
+		ID: secENIid,
+		AvailableIPv4Cidrs: map[string]*datastore.CidrInfo{
+			prefix01: {Cidr: *prefix, IsPrefix: true},
+		},
+	}
+
+	// Disabled, nothing is flushed
+	mockContext := &IPAMContext{networkClient: m.network}
+	mockContext.deleteENIConntrackEntries(eni)
+
+	// Enabled, the whole prefix is flushed and a failure is only logged
+	mockContext.flushConntrackOnENIDetach = true
+	m.network.EXPECT().DeleteConntrackEntries([]net.IPNet{*prefix}).Return(uint(0), errors.New("netlink error"))
+	mockContext.deleteENIConntrackEntries(eni)
+
+	// An ENI without any IPs doesn't need a flush
+	mockContext.deleteENIConntrackEntries(datastore.ENI{ID: secENIid})
+}
+
+func TestNodeIPPoolReconcilePendingENI(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		networkClient: m.network,
+		primaryIP:     map[string]string{primaryENIid: ipaddr01, secENIid: ipaddr11},
+		terminating:   int32(0),
+		maxIPsPerENI:  14,
+	}
+
+	mockContext.dataStore = testDatastore()
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false)
+	_ = mockContext.dataStore.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}, false)
+	_ = mockContext.dataStore.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr03), Mask: net.IPv4Mask(255, 255, 255, 255)}, false)
+	// Just attached, without any secondary IP
+	_ = mockContext.dataStore.AddENI(secENIid, secDevice, false, false, false)
+
+	primary := true
+	secPrimaryIP := ipaddr11
+	zeroIPENI := awsutils.ENIMetadata{
+		ENIID:          secENIid,
+		MAC:            secMAC,
+		DeviceNumber:   secDevice,
+		SubnetIPv4CIDR: secSubnet,
+		IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+			{PrivateIpAddress: &secPrimaryIP, Primary: &primary},
+		},
+	}
+	eniMetadataList := []awsutils.ENIMetadata{getPrimaryENIMetadata(), zeroIPENI}
+
+	m.awsutils.EXPECT().GetPrimaryENI().AnyTimes().Return(primaryENIid)
+	m.awsutils.EXPECT().IsUnmanagedENI(gomock.Any()).AnyTimes().Return(false)
+	m.awsutils.EXPECT().IsCNIUnmanagedENI(gomock.Any()).AnyTimes().Return(false)
+	m.awsutils.EXPECT().GetAttachedENIs().Return(eniMetadataList, nil).Times(2)
+
+	// The first retry fails, the subnet is out of IPs. The ENI is kept and retried on the next pass.
+	m.awsutils.EXPECT().AllocIPAddresses(secENIid, 14).Return(errors.New("InsufficientFreeAddressesInSubnet"))
+	mockContext.nodeIPPoolReconcile(ctx, 0)
+	assert.Equal(t, []string{secENIid}, mockContext.dataStore.GetPendingENIs())
+	assert.Equal(t, 2, mockContext.dataStore.GetENIInfos().TotalIPs)
+
+	// The second retry succeeds, the ENI is populated and no longer pending
+	notPrimary := false
+	secIP := ipaddr12
+	m.awsutils.EXPECT().AllocIPAddresses(secENIid, 14).Return(nil)
+	m.awsutils.EXPECT().GetIPv4sFromEC2(secENIid).Return([]*ec2.NetworkInterfacePrivateIpAddress{
+		{PrivateIpAddress: &secPrimaryIP, Primary: &primary},
+		{PrivateIpAddress: &secIP, Primary: &notPrimary},
+	}, nil)
+	mockContext.nodeIPPoolReconcile(ctx, 0)
+	assert.Empty(t, mockContext.dataStore.GetPendingENIs())
+	curENIs := mockContext.dataStore.GetENIInfos()
+	assert.Equal(t, 3, curENIs.TotalIPs)
+	assert.Equal(t, 1, len(curENIs.ENIs[secENIid].AvailableIPv4Cidrs))
+}
+
+func TestIncreaseIPPoolWithPendingENI(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		networkClient: m.network,
+		primaryIP:     make(map[string]string),
+		terminating:   int32(0),
+		maxIPsPerENI:  14,
+		maxENI:        4,
+		warmENITarget: 1,
+	}
+	mockContext.dataStore = testDatastore()
+	_ = mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false)
+	_ = mockContext.dataStore.AddENI(secENIid, secDevice, false, false, false)
+
+	// Assigning IPs to the pending ENI fails, but no other ENI is allocated
+	m.awsutils.EXPECT().AllocIPAddresses(gomock.Any(), gomock.Any()).Return(errors.New("InsufficientFreeAddressesInSubnet")).Times(2)
+	mockContext.increaseDatastorePool(context.Background())
+}
+
 func TestNodePrefixPoolReconcile(t *testing.T) {
 	m := setup(t)
 	defer m.ctrl.Finish()
@@ -844,6 +1167,173 @@ func TestNodePrefixPoolReconcile(t *testing.T) {
 	assert.Equal(t, 0, curENIs.TotalIPs)
 }
 
+func TestNodeIPPoolReconcileStatus(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		networkClient: m.network,
+		primaryIP:     make(map[string]string),
+		terminating:   int32(0),
+	}
+	mockContext.dataStore = testDatastore()
+
+	getStatus := func() ReconcileStatus {
+		rr := httptest.NewRecorder()
+		reconcileStatusV1RequestHandler(mockContext)(rr, httptest.NewRequest(http.MethodGet, "/v1/reconcile-status", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var status ReconcileStatus
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+		return status
+	}
+
+	status := getStatus()
+	assert.Nil(t, status.Last)
+	assert.Empty(t, status.History)
+
+	// Force a failure
+	m.awsutils.EXPECT().GetAttachedENIs().Return(nil, errors.New("IMDS unavailable"))
+	mockContext.nodeIPPoolReconcile(ctx, 0)
+
+	status = getStatus()
+	assert.NotNil(t, status.Last)
+	assert.False(t, status.Last.Success)
+	assert.Contains(t, status.Last.Error, "IMDS unavailable")
+	assert.Equal(t, 1, len(status.History))
+
+	// A successful reconcile is reported as the last one, with the failure kept in the history
+	primaryENIMetadata := getPrimaryENIMetadata()
+	m.awsutils.EXPECT().GetPrimaryENI().AnyTimes().Return(primaryENIid)
+	m.awsutils.EXPECT().IsUnmanagedENI(primaryENIid).AnyTimes().Return(false)
+	m.awsutils.EXPECT().IsCNIUnmanagedENI(primaryENIid).AnyTimes().Return(false)
+	m.awsutils.EXPECT().TagENI(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	eniMetadataList := []awsutils.ENIMetadata{primaryENIMetadata}
+	m.awsutils.EXPECT().GetAttachedENIs().Return(eniMetadataList, nil)
+	resp := awsutils.DescribeAllENIsResult{
+		ENIMetadata: eniMetadataList,
+		TagMap:      map[string]awsutils.TagMap{},
+		EFAENIs:     make(map[string]bool),
+	}
+	m.awsutils.EXPECT().DescribeAllENIs().Return(resp, nil)
+	m.awsutils.EXPECT().SetCNIUnmanagedENIs(resp.MultiCardENIIDs).AnyTimes()
+	mockContext.nodeIPPoolReconcile(ctx, 0)
+
+	status = getStatus()
+	assert.True(t, status.Last.Success)
+	assert.Empty(t, status.Last.Error)
+	assert.Equal(t, 2, len(status.History))
+	assert.False(t, status.History[1].Success)
+}
+
+func TestENIBandwidthV1RequestHandler(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	assert.NoError(t, ds.AddENI(secENIid, secDevice, false, false, false))
+	for _, ip := range []string{ipaddr11, ipaddr12, "10.10.20.13"} {
+		assert.NoError(t, ds.AddIPv4CidrToStore(secENIid, net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+	// Fill every IP, so 1 pod lands on the primary ENI and 3 on the secondary ENI
+	for i := 0; i < 4; i++ {
+		_, _, err := ds.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: fmt.Sprintf("sandbox-%d", i), IfName: "eth0"})
+		assert.NoError(t, err)
+	}
+
+	mockContext := &IPAMContext{awsClient: m.awsutils, dataStore: ds}
+	m.awsutils.EXPECT().GetInstanceType().Return("m5.large")
+	m.awsutils.EXPECT().GetNetworkPerformance().Return("Up to 10 Gigabit", nil)
+
+	rr := httptest.NewRecorder()
+	eniBandwidthV1RequestHandler(mockContext)(rr, httptest.NewRequest(http.MethodGet, "/v1/eni-bandwidth", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var hints ENIBandwidthHints
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &hints))
+	assert.Equal(t, ENIBandwidthHints{
+		InstanceType:       "m5.large",
+		NetworkPerformance: "Up to 10 Gigabit",
+		TotalPods:          4,
+		MaxENIPodShare:     0.75,
+		ENIs: []ENIPodDistribution{
+			{ENIID: primaryENIid, DeviceNumber: primaryDevice, IsPrimary: true, Pods: 1, PodShare: 0.25},
+			{ENIID: secENIid, DeviceNumber: secDevice, Pods: 3, PodShare: 0.75},
+		},
+	}, hints)
+}
+
+func TestENICleanupHistoryHandler(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	deletedAt := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	records := []awsutils.ENICleanupRecord{{
+		ENIID:    secENIid,
+		SubnetID: "subnet-0123456789abcdef0",
+		Tags:     map[string]string{"node.k8s.amazonaws.com/instance_id": "i-01234567890abcdef"},
+		Time:     deletedAt,
+		Reason:   awsutils.ENICleanupReasonLeaked,
+	}}
+	m.awsutils.EXPECT().GetENICleanupHistory().Return(records)
+
+	mockContext := &IPAMContext{awsClient: m.awsutils}
+	rr := httptest.NewRecorder()
+	eniCleanupHistoryV1RequestHandler(mockContext)(rr, httptest.NewRequest(http.MethodGet, "/v1/eni-cleanup-history", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var got []awsutils.ENICleanupRecord
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, records, got)
+}
+
+func TestENIHandlerIPAllocationMode(t *testing.T) {
+	for isPDEnabled, mode := range map[bool]string{false: "secondary-ip", true: "ipv4-prefix"} {
+		mockContext := &IPAMContext{dataStore: datastore.NewDataStore(log, datastore.NullCheckpoint{}, isPDEnabled)}
+		rr := httptest.NewRecorder()
+		eniV1RequestHandler(mockContext)(rr, httptest.NewRequest(http.MethodGet, "/v1/enis", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var got datastore.ENIInfos
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, mode, got.IPAllocationMode)
+	}
+}
+
+func TestENIBandwidthHintsWithoutNetworkPerformance(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+
+	mockContext := &IPAMContext{awsClient: m.awsutils, dataStore: ds}
+	m.awsutils.EXPECT().GetInstanceType().Return("m5.large")
+	m.awsutils.EXPECT().GetNetworkPerformance().Return("", errors.New("AccessDenied"))
+
+	hints := mockContext.getENIBandwidthHints()
+	assert.Equal(t, "m5.large", hints.InstanceType)
+	assert.Empty(t, hints.NetworkPerformance)
+	assert.Equal(t, 0, hints.TotalPods)
+	assert.Equal(t, 0.0, hints.MaxENIPodShare)
+	assert.Equal(t, []ENIPodDistribution{{ENIID: primaryENIid, DeviceNumber: primaryDevice, IsPrimary: true}}, hints.ENIs)
+}
+
+func TestReconcileStatusTrackerHistory(t *testing.T) {
+	var tracker reconcileStatusTracker
+	start := time.Now()
+	for i := 0; i < reconcileHistorySize+3; i++ {
+		tracker.record(start.Add(time.Duration(i)*time.Second), time.Millisecond, nil)
+	}
+	status := tracker.status()
+	assert.Equal(t, reconcileHistorySize, len(status.History))
+	assert.True(t, status.Last.StartTime.Equal(start.Add(time.Duration(reconcileHistorySize+2)*time.Second)))
+	assert.True(t, status.History[reconcileHistorySize-1].StartTime.Equal(start.Add(3*time.Second)))
+}
+
 func TestGetWarmENITarget(t *testing.T) {
 	m := setup(t)
 	defer m.ctrl.Finish()
@@ -926,6 +1416,214 @@ func TestGetWarmIPTargetState(t *testing.T) {
 	assert.Equal(t, 0, over)
 }
 
+func TestGetWarmIPPercent(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	_ = os.Setenv("WARM_IP_PERCENT", "20")
+	assert.Equal(t, 20, getWarmIPPercent())
+
+	_ = os.Unsetenv("WARM_IP_PERCENT")
+	assert.Equal(t, noWarmIPPercent, getWarmIPPercent())
+
+	_ = os.Setenv("WARM_IP_PERCENT", "-5")
+	assert.Equal(t, noWarmIPPercent, getWarmIPPercent())
+
+	_ = os.Setenv("WARM_IP_PERCENT", "non-integer-string")
+	assert.Equal(t, noWarmIPPercent, getWarmIPPercent())
+	_ = os.Unsetenv("WARM_IP_PERCENT")
+}
+
+func TestGetEffectiveWarmIPTarget(t *testing.T) {
+	tests := []struct {
+		name          string
+		warmIPTarget  int
+		warmIPPercent int
+		maxENI        int
+		maxIPsPerENI  int
+		assigned      int
+		want          int
+	}{
+		{"percent not set uses WARM_IP_TARGET", 5, noWarmIPPercent, 4, 10, 30, 5},
+		{"scales with assigned IPs", 0, 20, 4, 10, 30, 6},
+		{"rounds up", 0, 20, 4, 10, 11, 3},
+		{"idle node keeps the minimum floor", 0, 20, 4, 10, 0, minWarmIPPercentTarget},
+		{"WARM_IP_TARGET is the floor", 5, 20, 4, 10, 10, 5},
+		{"capped by instance limits", 0, 50, 4, 10, 36, 4},
+		{"no capacity left", 0, 50, 4, 10, 40, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &IPAMContext{
+				warmIPTarget:  tt.warmIPTarget,
+				warmIPPercent: tt.warmIPPercent,
+				maxENI:        tt.maxENI,
+				maxIPsPerENI:  tt.maxIPsPerENI,
+			}
+			assert.Equal(t, tt.want, c.getEffectiveWarmIPTarget(tt.assigned))
+		})
+	}
+}
+
+func TestGetWarmIPTargetStateWithWarmIPPercent(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		networkClient: m.network,
+		primaryIP:     make(map[string]string),
+		terminating:   int32(0),
+		warmIPPercent: 50,
+		maxENI:        4,
+		maxIPsPerENI:  10,
+	}
+	mockContext.dataStore = testDatastore()
+
+	// empty datastore only needs the floor
+	short, over, warmIPTargetDefined := mockContext.datastoreTargetState()
+	assert.True(t, warmIPTargetDefined)
+	assert.Equal(t, minWarmIPPercentTarget, short)
+	assert.Equal(t, 0, over)
+
+	_ = mockContext.dataStore.AddENI("eni-1", 1, true, false, false)
+	for i := 1; i <= 6; i++ {
+		ipv4Addr := net.IPNet{IP: net.IPv4(1, 1, 1, byte(i)), Mask: net.IPv4Mask(255, 255, 255, 255)}
+		_ = mockContext.dataStore.AddIPv4CidrToStore("eni-1", ipv4Addr, false)
+	}
+	// 6 free IPs, nothing assigned
+	short, over, _ = mockContext.datastoreTargetState()
+	assert.Equal(t, 0, short)
+	assert.Equal(t, 5, over)
+
+	// assign 4 IPs, warm target becomes 2 with 2 free
+	for i := 0; i < 4; i++ {
+		key := datastore.IPAMKey{NetworkName: "net0", ContainerID: fmt.Sprintf("sandbox-%d", i), IfName: "eth0"}
+		_, _, err := mockContext.dataStore.AssignPodIPv4Address(key)
+		assert.NoError(t, err)
+	}
+	short, over, _ = mockContext.datastoreTargetState()
+	assert.Equal(t, 0, short)
+	assert.Equal(t, 0, over)
+
+	// assign the remaining 2 IPs, warm target becomes 3 with none free
+	for i := 4; i < 6; i++ {
+		key := datastore.IPAMKey{NetworkName: "net0", ContainerID: fmt.Sprintf("sandbox-%d", i), IfName: "eth0"}
+		_, _, err := mockContext.dataStore.AssignPodIPv4Address(key)
+		assert.NoError(t, err)
+	}
+	short, over, _ = mockContext.datastoreTargetState()
+	assert.Equal(t, 3, short)
+	assert.Equal(t, 0, over)
+}
+
+func TestGetPodInterfaceQueues(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	defer os.Unsetenv(envPodInterfaceQueues)
+
+	_ = os.Unsetenv(envPodInterfaceQueues)
+	assert.Equal(t, noPodInterfaceQueues, getPodInterfaceQueues())
+
+	_ = os.Setenv(envPodInterfaceQueues, "4")
+	assert.Equal(t, 4, getPodInterfaceQueues())
+
+	_ = os.Setenv(envPodInterfaceQueues, "1000")
+	assert.Equal(t, maxPodInterfaceQueues, getPodInterfaceQueues())
+
+	_ = os.Setenv(envPodInterfaceQueues, "0")
+	assert.Equal(t, noPodInterfaceQueues, getPodInterfaceQueues())
+
+	_ = os.Setenv(envPodInterfaceQueues, "non-integer-string")
+	assert.Equal(t, noPodInterfaceQueues, getPodInterfaceQueues())
+}
+
+func TestGetIPReclaimMinAge(t *testing.T) {
+	defer os.Unsetenv(envIPReclaimMinAge)
+
+	_ = os.Unsetenv(envIPReclaimMinAge)
+	assert.Equal(t, time.Duration(defaultIPReclaimMinAge), getIPReclaimMinAge())
+
+	_ = os.Setenv(envIPReclaimMinAge, "90")
+	assert.Equal(t, 90*time.Second, getIPReclaimMinAge())
+
+	_ = os.Setenv(envIPReclaimMinAge, "-1")
+	assert.Equal(t, time.Duration(defaultIPReclaimMinAge), getIPReclaimMinAge())
+
+	_ = os.Setenv(envIPReclaimMinAge, "non-integer-string")
+	assert.Equal(t, time.Duration(defaultIPReclaimMinAge), getIPReclaimMinAge())
+}
+
+func TestGetStartupENIWait(t *testing.T) {
+	defer os.Unsetenv(envStartupENIWait)
+
+	_ = os.Unsetenv(envStartupENIWait)
+	assert.Equal(t, time.Duration(defaultStartupENIWait), getStartupENIWait())
+
+	_ = os.Setenv(envStartupENIWait, "120")
+	assert.Equal(t, 120*time.Second, getStartupENIWait())
+
+	_ = os.Setenv(envStartupENIWait, "-5")
+	assert.Equal(t, time.Duration(defaultStartupENIWait), getStartupENIWait())
+
+	_ = os.Setenv(envStartupENIWait, "non-integer-string")
+	assert.Equal(t, time.Duration(defaultStartupENIWait), getStartupENIWait())
+}
+
+func TestWaitForExpectedENIs(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{awsClient: m.awsutils}
+	primary := getPrimaryENIMetadata()
+	secondary := getSecondaryENIMetadata()
+
+	// The secondary ENI of the launch template shows up in IMDS on the third poll
+	m.awsutils.EXPECT().GetExpectedENIs().Return([]string{primaryENIid, secENIid}, nil).Times(3)
+	gomock.InOrder(
+		m.awsutils.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{primary}, nil),
+		m.awsutils.EXPECT().GetAttachedENIs().Return(nil, errors.New("IMDS unavailable")),
+		m.awsutils.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{primary, secondary}, nil),
+	)
+	assert.NoError(t, mockContext.waitForExpectedENIs(time.Second, time.Millisecond))
+}
+
+func TestWaitForExpectedENIsTimeout(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{awsClient: m.awsutils}
+	m.awsutils.EXPECT().GetExpectedENIs().Return([]string{primaryENIid, secENIid}, nil).MinTimes(1)
+	m.awsutils.EXPECT().GetAttachedENIs().Return([]awsutils.ENIMetadata{getPrimaryENIMetadata()}, nil).MinTimes(1)
+
+	start := time.Now()
+	err := mockContext.waitForExpectedENIs(50*time.Millisecond, 10*time.Millisecond)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), secENIid)
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+}
+
+func TestGetPodInterfaceQueueCount(t *testing.T) {
+	c := &IPAMContext{podInterfaceQueues: 2}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        int
+	}{
+		{"no annotation", nil, 2},
+		{"annotation overrides", map[string]string{podInterfaceQueuesAnnotation: "8"}, 8},
+		{"annotation above max", map[string]string{podInterfaceQueuesAnnotation: "65"}, 2},
+		{"annotation zero", map[string]string{podInterfaceQueuesAnnotation: "0"}, 2},
+		{"annotation not a number", map[string]string{podInterfaceQueuesAnnotation: "many"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "ns", Annotations: tt.annotations}}
+			assert.Equal(t, tt.want, c.getPodInterfaceQueueCount(pod))
+		})
+	}
+}
+
 func TestGetWarmIPTargetStatewithPDenabled(t *testing.T) {
 	m := setup(t)
 	defer m.ctrl.Finish()
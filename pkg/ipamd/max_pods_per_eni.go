@@ -0,0 +1,75 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+const (
+	// envMaxPodsPerENI caps the number of pods given an IP from the same ENI, below the IPs the ENI can hold, so pods
+	// are spread over more ENIs and share their bandwidth with fewer others
+	envMaxPodsPerENI = "MAX_PODS_PER_ENI"
+	// noMaxPodsPerENI means the pods per ENI are only limited by the IPs the ENI can hold
+	noMaxPodsPerENI = 0
+)
+
+func getMaxPodsPerENI() int {
+	inputStr, found := os.LookupEnv(envMaxPodsPerENI)
+	if !found {
+		return noMaxPodsPerENI
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envMaxPodsPerENI, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, ignoring it", envMaxPodsPerENI, inputStr)
+	return noMaxPodsPerENI
+}
+
+// initMaxPodsPerENI clamps MAX_PODS_PER_ENI to the number of IPs an ENI can hold, the IPs of all its prefixes with
+// prefix delegation, and hands it to the datastore. It must run once the limits of the instance type are known.
+func (c *IPAMContext) initMaxPodsPerENI() {
+	c.maxPodsPerENI = getMaxPodsPerENI()
+	if c.maxPodsPerENI > c.maxIPsPerENI {
+		log.Warnf("%s %d is more than the %d IPs an ENI can hold, using %d", envMaxPodsPerENI, c.maxPodsPerENI,
+			c.maxIPsPerENI, c.maxIPsPerENI)
+		c.maxPodsPerENI = c.maxIPsPerENI
+	}
+	c.dataStore.SetMaxPodsPerENI(c.maxPodsPerENI)
+}
+
+// maxUsableIPsPerENI returns how many of an ENI's IPs pods can get, that is the per-ENI pod cap if one is set
+func (c *IPAMContext) maxUsableIPsPerENI() int {
+	if c.maxPodsPerENI != noMaxPodsPerENI && c.maxPodsPerENI < c.maxIPsPerENI {
+		return c.maxPodsPerENI
+	}
+	return c.maxIPsPerENI
+}
+
+// maxUsablePrefixesPerENI returns how many prefixes an ENI needs with prefix delegation, that is the ones holding the
+// per-ENI pod cap if one is set. More would only be assigned to the ENI and never given to pods.
+func (c *IPAMContext) maxUsablePrefixesPerENI() int {
+	if c.maxPodsPerENI == noMaxPodsPerENI {
+		return c.maxPrefixesPerENI
+	}
+	_, ipsPerPrefix, _ := datastore.GetPrefixDelegationDefaults()
+	if prefixes := datastore.DivCeil(c.maxPodsPerENI, ipsPerPrefix); prefixes < c.maxPrefixesPerENI {
+		return prefixes
+	}
+	return c.maxPrefixesPerENI
+}
@@ -0,0 +1,149 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func TestIncreaseDatastorePoolAtMaxPodsPerENI(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	for _, ip := range []string{ipaddr01, ipaddr02, ipaddr03} {
+		assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+	for _, sandbox := range []string{"sandbox-1", "sandbox-2"} {
+		_, _, err := ds.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: sandbox, IfName: "eth0"})
+		assert.NoError(t, err)
+	}
+
+	mockContext := &IPAMContext{
+		awsClient:       m.awsutils,
+		dataStore:       ds,
+		maxIPsPerENI:    14,
+		maxENI:          4,
+		warmIPTarget:    1,
+		minimumIPTarget: noMinimumIPTarget,
+		warmIPPercent:   noWarmIPPercent,
+		primaryIP:       make(map[string]string),
+	}
+
+	// Without the cap the free IP meets the warm IP target
+	assert.False(t, mockContext.isDatastorePoolTooLow())
+
+	// With two pods per ENI the free IP can't be used, a new ENI is needed
+	os.Setenv(envMaxPodsPerENI, "2")
+	defer os.Unsetenv(envMaxPodsPerENI)
+	mockContext.initMaxPodsPerENI()
+	assert.True(t, mockContext.isDatastorePoolTooLow())
+	m.awsutils.EXPECT().AllocENI(false, nil, "").Return("", errors.New("no more ENIs"))
+	mockContext.increaseDatastorePool(context.TODO())
+}
+
+func TestIncreasePrefixPoolAtMaxPodsPerENI(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := testDatastorewithPrefix()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	_, prefix, _ := net.ParseCIDR(prefix01)
+	assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, *prefix, true))
+	for i := 0; i < 16; i++ {
+		_, _, err := ds.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: fmt.Sprintf("sandbox-%d", i), IfName: "eth0"})
+		assert.NoError(t, err)
+	}
+
+	mockContext := &IPAMContext{
+		awsClient:                  m.awsutils,
+		dataStore:                  ds,
+		enableIpv4PrefixDelegation: true,
+		maxIPsPerENI:               4 * 16,
+		maxPrefixesPerENI:          4,
+		maxENI:                     4,
+		warmPrefixTarget:           1,
+		warmIPTarget:               noWarmIPTarget,
+		minimumIPTarget:            noMinimumIPTarget,
+		warmIPPercent:              noWarmIPPercent,
+		primaryIP:                  make(map[string]string),
+	}
+	os.Setenv(envMaxPodsPerENI, "16")
+	defer os.Unsetenv(envMaxPodsPerENI)
+	mockContext.initMaxPodsPerENI()
+	assert.Equal(t, 1, mockContext.maxUsablePrefixesPerENI())
+
+	// The ENI has all the pods its prefix holds, another prefix on it would never be used so a new ENI is needed
+	assert.True(t, mockContext.isDatastorePoolTooLow())
+	m.awsutils.EXPECT().AllocENI(false, nil, "").Return("", errors.New("no more ENIs"))
+	mockContext.increaseDatastorePool(context.TODO())
+}
+
+func TestMaxUsablePrefixesPerENI(t *testing.T) {
+	mockContext := &IPAMContext{maxPrefixesPerENI: 4}
+	assert.Equal(t, 4, mockContext.maxUsablePrefixesPerENI())
+	for maxPods, want := range map[int]int{1: 1, 16: 1, 17: 2, 64: 4, 100: 4} {
+		mockContext.maxPodsPerENI = maxPods
+		assert.Equal(t, want, mockContext.maxUsablePrefixesPerENI(), maxPods)
+	}
+
+	// With prefix delegation an ENI holds the IPs of all its prefixes
+	os.Setenv(envMaxPodsPerENI, "50")
+	defer os.Unsetenv(envMaxPodsPerENI)
+	mockContext = &IPAMContext{dataStore: testDatastorewithPrefix(), maxIPsPerENI: 4 * 16, maxPrefixesPerENI: 4}
+	mockContext.initMaxPodsPerENI()
+	assert.Equal(t, 50, mockContext.maxPodsPerENI)
+	assert.Equal(t, 4, mockContext.maxUsablePrefixesPerENI())
+}
+
+func TestInitMaxPodsPerENI(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int
+	}{
+		{"", noMaxPodsPerENI},
+		{"abc", noMaxPodsPerENI},
+		{"-1", noMaxPodsPerENI},
+		{"0", noMaxPodsPerENI},
+		{"5", 5},
+		// Clamped to the IPs an ENI can hold
+		{"50", 14},
+	}
+	for _, tt := range tests {
+		if tt.value == "" {
+			os.Unsetenv(envMaxPodsPerENI)
+		} else {
+			os.Setenv(envMaxPodsPerENI, tt.value)
+		}
+		mockContext := &IPAMContext{dataStore: testDatastore(), maxIPsPerENI: 14}
+		mockContext.initMaxPodsPerENI()
+		assert.Equal(t, tt.expected, mockContext.maxPodsPerENI, tt.value)
+		if tt.expected == noMaxPodsPerENI {
+			assert.Equal(t, 14, mockContext.maxUsableIPsPerENI())
+		} else {
+			assert.Equal(t, tt.expected, mockContext.maxUsableIPsPerENI())
+		}
+	}
+	os.Unsetenv(envMaxPodsPerENI)
+}
@@ -0,0 +1,83 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// envMaxTotalIPs caps the IPs the node holds for pods, assigned or warm, so a single large node can't exhaust a
+	// subnet shared with others. 0 means no cap.
+	envMaxTotalIPs = "MAX_TOTAL_IPS"
+	// ipsPerPrefix is the number of IPs of a delegated /28 prefix
+	ipsPerPrefix = 16
+)
+
+func getMaxTotalIPs() int {
+	inputStr, found := os.LookupEnv(envMaxTotalIPs)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envMaxTotalIPs, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, not capping the IPs of the node", envMaxTotalIPs, inputStr)
+	return 0
+}
+
+// heldIPs returns the number of IPs of the secondary IPs and prefixes in the datastore, whether in use or not
+func (c *IPAMContext) heldIPs() int {
+	held := 0
+	for _, eni := range c.dataStore.GetENIInfos().ENIs {
+		for _, cidr := range eni.AvailableIPv4Cidrs {
+			held += cidr.Size()
+		}
+	}
+	return held
+}
+
+// capAllocation returns how many of the toAllocate IPs, or prefixes with prefix delegation, can be added without going
+// over MAX_TOTAL_IPS
+func (c *IPAMContext) capAllocation(toAllocate int) int {
+	if c.maxTotalIPs <= 0 {
+		return toAllocate
+	}
+	room := c.maxTotalIPs - c.heldIPs()
+	if c.enableIpv4PrefixDelegation {
+		room /= ipsPerPrefix
+	}
+	if room <= 0 {
+		return 0
+	}
+	return min(toAllocate, room)
+}
+
+// atMaxTotalIPs returns true if not even one more IP, or prefix with prefix delegation, fits under MAX_TOTAL_IPS
+func (c *IPAMContext) atMaxTotalIPs() bool {
+	return c.capAllocation(1) == 0
+}
+
+// maxTotalIPsError explains a failed pod IP assignment by the cap when the node is at it, so the pod fails clearly and
+// gets rescheduled rather than waiting on a pool that is not going to grow
+func (c *IPAMContext) maxTotalIPsError(err error) error {
+	if !c.atMaxTotalIPs() {
+		return err
+	}
+	return errors.Wrapf(err, "the node holds %d IPs, it is at the %s cap of %d", c.heldIPs(), envMaxTotalIPs, c.maxTotalIPs)
+}
@@ -0,0 +1,108 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func TestGetMaxTotalIPs(t *testing.T) {
+	defer os.Unsetenv(envMaxTotalIPs)
+
+	assert.Equal(t, 0, getMaxTotalIPs())
+
+	_ = os.Setenv(envMaxTotalIPs, "64")
+	assert.Equal(t, 64, getMaxTotalIPs())
+
+	_ = os.Setenv(envMaxTotalIPs, "-1")
+	assert.Equal(t, 0, getMaxTotalIPs())
+}
+
+func TestIncreaseDatastorePoolMaxTotalIPs(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	const maxTotalIPs = 5
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		dataStore:     datastoreWith3FreeIPs(),
+		maxIPsPerENI:  14,
+		maxENI:        4,
+		warmENITarget: 1,
+		primaryIP:     map[string]string{primaryENIid: "10.10.10.10"},
+		maxTotalIPs:   maxTotalIPs,
+	}
+
+	// The ENI has room for 11 more IPs, only 2 fit under the cap
+	m.awsutils.EXPECT().AllocIPAddresses(primaryENIid, 2).Return(nil)
+	ec2Addrs := []*ec2.NetworkInterfacePrivateIpAddress{{PrivateIpAddress: aws.String("10.10.10.10"), Primary: aws.Bool(true)}}
+	for i := 11; i <= 15; i++ {
+		ec2Addrs = append(ec2Addrs, &ec2.NetworkInterfacePrivateIpAddress{
+			PrivateIpAddress: aws.String(fmt.Sprintf("10.10.10.%d", i)), Primary: aws.Bool(false)})
+	}
+	m.awsutils.EXPECT().GetIPv4sFromEC2(primaryENIid).Return(ec2Addrs, nil)
+	mockContext.increaseDatastorePool(context.Background())
+	assert.Equal(t, maxTotalIPs, mockContext.heldIPs())
+
+	// At the cap, neither IPs nor ENIs are added
+	mockContext.increaseDatastorePool(context.Background())
+	assert.Equal(t, maxTotalIPs, mockContext.heldIPs())
+	if miss := mockContext.warmTargetMiss.status(); assert.NotNil(t, miss) {
+		assert.Equal(t, warmTargetMissMaxTotalIPs, miss.Reason)
+	}
+
+	// The pods beyond the cap fail, saying why
+	for i := 0; i < maxTotalIPs; i++ {
+		_, _, err := mockContext.dataStore.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: fmt.Sprintf("sandbox-%d", i), IfName: "eth0"})
+		assert.NoError(t, err)
+	}
+	_, _, err := mockContext.dataStore.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-extra", IfName: "eth0"})
+	assert.Error(t, err)
+	assert.Contains(t, mockContext.maxTotalIPsError(err).Error(), "at the MAX_TOTAL_IPS cap of 5")
+}
+
+func TestCapAllocationPrefixes(t *testing.T) {
+	ds := testDatastorewithPrefix()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	_, prefix, _ := net.ParseCIDR("10.10.20.0/28")
+	assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, *prefix, true))
+	c := &IPAMContext{dataStore: ds, enableIpv4PrefixDelegation: true}
+
+	// Without a cap nothing changes
+	assert.Equal(t, 3, c.capAllocation(3))
+
+	// 24 IPs left under the cap only fit one more prefix
+	c.maxTotalIPs = 40
+	assert.Equal(t, 1, c.capAllocation(3))
+	assert.False(t, c.atMaxTotalIPs())
+
+	c.maxTotalIPs = 31
+	assert.Equal(t, 0, c.capAllocation(3))
+	assert.True(t, c.atMaxTotalIPs())
+
+	// Below the cap the error is left as is
+	c.maxTotalIPs = 0
+	err := fmt.Errorf("no free IP")
+	assert.Equal(t, err, c.maxTotalIPsError(err))
+}
@@ -0,0 +1,51 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+// releaseModeMismatchedCidrs releases the secondary IPs (with prefix delegation enabled) or prefixes (with it
+// disabled) that ENIs still carry from before the IP allocation mode changed. The ones still used by pods keep
+// serving them, new pods only get addresses of the current mode, and they are released by a later call once their
+// pods are gone. It returns true while some of them are left.
+func (c *IPAMContext) releaseModeMismatchedCidrs() bool {
+	mismatched := c.dataStore.GetModeMismatchedCidrs()
+	if len(mismatched) == 0 {
+		modeMismatchedCidrs.Set(0)
+		return false
+	}
+
+	kind := "prefixes"
+	if c.enableIpv4PrefixDelegation {
+		kind = "secondary IPs"
+	}
+	for eniID, stats := range mismatched {
+		log.Infof("ENI %s has %d %s from the previous IP allocation mode, %d in use by pods", eniID, stats.Total, kind, stats.InUse)
+		if stats.InUse < stats.Total {
+			if c.enableIpv4PrefixDelegation {
+				c.tryUnassignIPFromENI(eniID)
+			} else {
+				c.tryUnassignPrefixFromENI(eniID)
+			}
+		}
+	}
+
+	remaining := 0
+	for _, stats := range c.dataStore.GetModeMismatchedCidrs() {
+		remaining += stats.Total
+	}
+	modeMismatchedCidrs.Set(float64(remaining))
+	if remaining > 0 {
+		log.Infof("Keeping %d %s from the previous IP allocation mode until they are no longer in use", remaining, kind)
+	}
+	return remaining > 0
+}
@@ -0,0 +1,172 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+// getMixedModeENIMetadata returns ENIs as left by secondary IP mode before prefix delegation was enabled: the
+// primary ENI has two secondary IPs next to a prefix, the secondary ENI only has a prefix
+func getMixedModeENIMetadata() (awsutils.ENIMetadata, awsutils.ENIMetadata) {
+	eni1 := awsutils.ENIMetadata{
+		ENIID:          primaryENIid,
+		MAC:            primaryMAC,
+		DeviceNumber:   primaryDevice,
+		SubnetIPv4CIDR: primarySubnet,
+		IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+			{PrivateIpAddress: aws.String(ipaddr01), Primary: aws.Bool(true)},
+			{PrivateIpAddress: aws.String(ipaddr02), Primary: aws.Bool(false)},
+			{PrivateIpAddress: aws.String(ipaddr03), Primary: aws.Bool(false)},
+		},
+		IPv4Prefixes: []*ec2.Ipv4PrefixSpecification{{Ipv4Prefix: aws.String(prefix01)}},
+	}
+	eni2 := awsutils.ENIMetadata{
+		ENIID:          secENIid,
+		MAC:            secMAC,
+		DeviceNumber:   secDevice,
+		SubnetIPv4CIDR: secSubnet,
+		IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+			{PrivateIpAddress: aws.String(ipaddr11), Primary: aws.Bool(true)},
+		},
+		IPv4Prefixes: []*ec2.Ipv4PrefixSpecification{{Ipv4Prefix: aws.String(prefix02)}},
+	}
+	return eni1, eni2
+}
+
+func TestNodeInitWithModeMismatchedENIs(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	// A pod started before prefix delegation was enabled still uses a secondary IP
+	oldPod := datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-old", IfName: "eth0"}
+	fakeCheckpoint := datastore.CheckpointData{
+		Version:     datastore.CheckpointFormatVersion,
+		Allocations: []datastore.CheckpointEntry{{IPAMKey: oldPod, IPv4: ipaddr02}},
+	}
+	mockContext := &IPAMContext{
+		awsClient:                  m.awsutils,
+		rawK8SClient:               m.rawK8SClient,
+		cachedK8SClient:            m.cachedK8SClient,
+		maxIPsPerENI:               224,
+		maxPrefixesPerENI:          14,
+		maxENI:                     4,
+		warmENITarget:              1,
+		warmIPTarget:               3,
+		primaryIP:                  make(map[string]string),
+		networkClient:              m.network,
+		dataStore:                  datastore.NewDataStore(log, datastore.NewTestCheckpoint(fakeCheckpoint), true),
+		myNodeName:                 myNodeName,
+		enableIpv4PrefixDelegation: true,
+		criClient:                  m.cri,
+	}
+	mockContext.dataStore.CheckpointMigrationPhase = 2
+
+	eni1, eni2 := getMixedModeENIMetadata()
+	var cidrs []string
+	primaryIP := net.ParseIP(ipaddr01)
+	m.awsutils.EXPECT().GetENILimit().Return(4, nil)
+	m.awsutils.EXPECT().GetENIIPv4Limit().Return(14, nil)
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().AnyTimes().Return(cidrs, nil)
+	m.awsutils.EXPECT().GetPrimaryENImac().Return("")
+	m.awsutils.EXPECT().GetPrimaryENI().AnyTimes().Return(primaryENIid)
+	m.awsutils.EXPECT().GetLocalIPv4().Return(primaryIP)
+	m.awsutils.EXPECT().IsUnmanagedENI(gomock.Any()).Return(false).AnyTimes()
+	m.awsutils.EXPECT().IsCNIUnmanagedENI(gomock.Any()).Return(false).AnyTimes()
+	m.awsutils.EXPECT().TagENI(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	m.awsutils.EXPECT().SetCNIUnmanagedENIs(gomock.Any()).AnyTimes()
+	m.awsutils.EXPECT().DescribeAllENIs().Return(awsutils.DescribeAllENIsResult{
+		ENIMetadata: []awsutils.ENIMetadata{eni1, eni2},
+		TagMap:      map[string]awsutils.TagMap{},
+		EFAENIs:     make(map[string]bool),
+	}, nil)
+	m.network.EXPECT().SetupHostNetwork(cidrs, "", &primaryIP, false).Return(nil)
+	m.network.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, secSubnet)
+	var rules []netlink.Rule
+	m.network.EXPECT().GetRuleList().Return(rules, nil).Times(2)
+	m.cri.EXPECT().GetRunningPodSandboxes(gomock.Any()).Return(nil, nil)
+	m.network.EXPECT().UpdateRuleListBySrc(gomock.Any(), gomock.Any())
+	_ = m.cachedK8SClient.Create(ctx, &v1.Node{
+		TypeMeta:   metav1.TypeMeta{Kind: "Node"},
+		ObjectMeta: metav1.ObjectMeta{Name: myNodeName},
+	})
+
+	// Only the unused secondary IP is released, the one of the running pod is kept
+	m.awsutils.EXPECT().DeallocIPAddresses(primaryENIid, []string{ipaddr03}).Return(nil)
+
+	assert.NoError(t, mockContext.nodeInit())
+	assert.True(t, mockContext.hasModeMismatchedCidrs)
+	assert.Equal(t, map[string]datastore.ModeMismatchedCidrs{primaryENIid: {Total: 1, InUse: 1}},
+		mockContext.dataStore.GetModeMismatchedCidrs())
+	assert.Equal(t, float64(1), testutil.ToFloat64(modeMismatchedCidrs))
+
+	// New pods get addresses from the prefixes
+	newPod := datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-new", IfName: "eth0"}
+	ip, _, err := mockContext.dataStore.AssignPodIPv4Address(newPod)
+	assert.NoError(t, err)
+	_, prefix1, _ := net.ParseCIDR(prefix01)
+	_, prefix2, _ := net.ParseCIDR(prefix02)
+	assert.True(t, prefix1.Contains(net.ParseIP(ip)) || prefix2.Contains(net.ParseIP(ip)), ip)
+
+	// Once the old pod is gone its secondary IP is released too, without skewing the pool stats
+	_, _, _, err = mockContext.dataStore.UnassignPodIPv4Address(oldPod)
+	assert.NoError(t, err)
+	totalBefore, _, _ := mockContext.dataStore.GetStats()
+	m.awsutils.EXPECT().DeallocIPAddresses(primaryENIid, []string{ipaddr02}).Return(nil)
+	assert.False(t, mockContext.releaseModeMismatchedCidrs())
+	total, assigned, prefixes := mockContext.dataStore.GetStats()
+	assert.Equal(t, totalBefore, total)
+	assert.Equal(t, 32, total)
+	assert.Equal(t, 1, assigned)
+	assert.Equal(t, 2, prefixes)
+	assert.Empty(t, mockContext.dataStore.GetModeMismatchedCidrs())
+	assert.Equal(t, float64(0), testutil.ToFloat64(modeMismatchedCidrs))
+}
+
+func TestReleaseModeMismatchedPrefixes(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	// Prefix delegation was disabled, the ENI still has a prefix next to its secondary IPs
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	_, prefix, _ := net.ParseCIDR(prefix01)
+	assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, *prefix, true))
+	mockContext := &IPAMContext{awsClient: m.awsutils, dataStore: ds}
+
+	m.awsutils.EXPECT().DeallocPrefixAddresses(primaryENIid, []string{prefix01}).Return(nil)
+	assert.False(t, mockContext.releaseModeMismatchedCidrs())
+	total, _, prefixes := ds.GetStats()
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 0, prefixes)
+
+	// Nothing left to release
+	assert.False(t, mockContext.releaseModeMismatchedCidrs())
+}
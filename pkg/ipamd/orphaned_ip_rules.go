@@ -0,0 +1,84 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+// podRuleIP returns the pod IP of an IP rule added by the CNI plugin for a pod, or "" if it is not such a rule
+func podRuleIP(rule netlink.Rule) string {
+	isHostIP := func(ipNet *net.IPNet) bool {
+		ones, bits := ipNet.Mask.Size()
+		return ones == 32 && bits == 32
+	}
+	switch rule.Priority {
+	case networkutils.ToPodRulePriority:
+		if rule.Dst != nil && isHostIP(rule.Dst) && rule.Src == nil && rule.Table == unix.RT_TABLE_MAIN {
+			return rule.Dst.IP.String()
+		}
+	case networkutils.FromPodRulePriority:
+		if rule.Src != nil && isHostIP(rule.Src) {
+			return rule.Src.IP.String()
+		}
+	}
+	return ""
+}
+
+// cleanUpOrphanedIPRules deletes the pod IP rules whose IP is neither assigned in the datastore nor used by a running
+// sandbox. Such rules are left behind when ipamd or the node crashed before the CNI DEL of a pod, and pile up in the
+// rule table. It is best-effort: nothing is deleted unless both the rules and the sandboxes could be listed.
+func (c *IPAMContext) cleanUpOrphanedIPRules() {
+	rules, err := c.networkClient.GetRuleList()
+	if err != nil {
+		log.Warnf("Failed to list the IP rules, not cleaning up orphaned pod rules: %v", err)
+		return
+	}
+	sandboxes, err := c.criClient.GetRunningPodSandboxes(log)
+	if err != nil {
+		log.Warnf("Failed to list the running sandboxes, not cleaning up orphaned pod rules: %v", err)
+		return
+	}
+
+	inUse := sets.NewString()
+	for _, info := range c.dataStore.AllocatedIPs() {
+		inUse.Insert(info.IP)
+	}
+	for _, sandbox := range sandboxes {
+		inUse.Insert(sandbox.IP)
+	}
+
+	deleted := 0
+	for _, rule := range rules {
+		ip := podRuleIP(rule)
+		if ip == "" || inUse.Has(ip) {
+			continue
+		}
+		if err := c.networkClient.DeleteRule(rule); err != nil {
+			log.Warnf("Failed to delete the orphaned IP rule %v of %s: %v", rule, ip, err)
+			continue
+		}
+		log.Infof("Deleted the orphaned IP rule %v of %s, no pod uses it", rule, ip)
+		deleted++
+	}
+	if deleted > 0 {
+		log.Infof("Deleted %d orphaned pod IP rules", deleted)
+	}
+}
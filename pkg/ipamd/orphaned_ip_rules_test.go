@@ -0,0 +1,112 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/cri"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+)
+
+func hostIPNet(ip string) *net.IPNet {
+	return &net.IPNet{IP: net.ParseIP(ip).To4(), Mask: net.CIDRMask(32, 32)}
+}
+
+func toPodRule(ip string) netlink.Rule {
+	return netlink.Rule{Priority: networkutils.ToPodRulePriority, Dst: hostIPNet(ip), Table: unix.RT_TABLE_MAIN}
+}
+
+func fromPodRule(ip string, table int) netlink.Rule {
+	return netlink.Rule{Priority: networkutils.FromPodRulePriority, Src: hostIPNet(ip), Table: table}
+}
+
+func TestPodRuleIP(t *testing.T) {
+	_, vpcCIDR, _ := net.ParseCIDR("10.0.0.0/16")
+	for _, tc := range []struct {
+		name string
+		rule netlink.Rule
+		want string
+	}{
+		{"to pod", toPodRule("10.0.0.5"), "10.0.0.5"},
+		{"from pod", fromPodRule("10.0.0.6", 2), "10.0.0.6"},
+		{"from pod to the VPC", netlink.Rule{Priority: networkutils.FromPodRulePriority, Src: hostIPNet("10.0.0.7"), Dst: vpcCIDR, Table: 2}, "10.0.0.7"},
+		{"host rule", netlink.Rule{Priority: 1024, Dst: vpcCIDR, Invert: true, Table: unix.RT_TABLE_MAIN}, ""},
+		{"to a CIDR", netlink.Rule{Priority: networkutils.ToPodRulePriority, Dst: vpcCIDR, Table: unix.RT_TABLE_MAIN}, ""},
+		{"local rule", netlink.Rule{Priority: 20, Table: unix.RT_TABLE_LOCAL}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, podRuleIP(tc.rule))
+		})
+	}
+}
+
+func TestCleanUpOrphanedIPRules(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(secENIid, secDevice, false, false, false))
+	for _, ip := range []string{"10.0.0.5", "10.0.0.6"} {
+		assert.NoError(t, ds.AddIPv4CidrToStore(secENIid, *hostIPNet(ip), false))
+	}
+	_, _, err := ds.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: "sandbox-1", IfName: "eth0"})
+	assert.NoError(t, err)
+	assigned := ds.AllocatedIPs()[0].IP
+	mockContext := &IPAMContext{networkClient: m.network, dataStore: ds, criClient: m.cri}
+
+	_, vpcCIDR, _ := net.ParseCIDR("10.0.0.0/16")
+	hostRule := netlink.Rule{Priority: 1024, Dst: vpcCIDR, Invert: true, Table: unix.RT_TABLE_MAIN}
+	m.network.EXPECT().GetRuleList().Return([]netlink.Rule{
+		hostRule,
+		// The pod of the datastore
+		toPodRule(assigned), fromPodRule(assigned, 2),
+		// A running pod the datastore does not know, e.g. it was added right before the checkpoint was lost
+		toPodRule("10.0.0.20"), fromPodRule("10.0.0.20", 2),
+		// Orphans
+		toPodRule("10.0.0.30"), fromPodRule("10.0.0.30", 3), toPodRule("10.0.0.31"),
+	}, nil)
+	m.cri.EXPECT().GetRunningPodSandboxes(gomock.Any()).Return([]*cri.SandboxInfo{
+		{ID: "sandbox-1", IP: assigned},
+		{ID: "sandbox-2", IP: "10.0.0.20"},
+	}, nil)
+
+	var deleted []netlink.Rule
+	m.network.EXPECT().DeleteRule(gomock.Any()).DoAndReturn(func(rule netlink.Rule) error {
+		deleted = append(deleted, rule)
+		return nil
+	}).Times(3)
+	mockContext.cleanUpOrphanedIPRules()
+	assert.Equal(t, []netlink.Rule{toPodRule("10.0.0.30"), fromPodRule("10.0.0.30", 3), toPodRule("10.0.0.31")}, deleted)
+}
+
+func TestCleanUpOrphanedIPRulesCRIFailure(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{networkClient: m.network, dataStore: testDatastore(), criClient: m.cri}
+	m.network.EXPECT().GetRuleList().Return([]netlink.Rule{toPodRule("10.0.0.30")}, nil)
+	m.cri.EXPECT().GetRunningPodSandboxes(gomock.Any()).Return(nil, errors.New("CRI socket not found"))
+
+	// Without the running sandboxes nothing can be told orphaned, so nothing is deleted
+	mockContext.cleanUpOrphanedIPRules()
+}
@@ -0,0 +1,71 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// envEnablePodAllocationEvents makes ipamd record a Kubernetes event on each pod it assigned an IP to
+	envEnablePodAllocationEvents = "ENABLE_POD_ALLOCATION_EVENTS"
+
+	// podIPAllocatedReason is the reason of the event recorded on a pod after a successful ADD
+	podIPAllocatedReason = "IPAllocated"
+)
+
+func enablePodAllocationEvents() bool {
+	return getEnvBoolWithDefault(envEnablePodAllocationEvents, false)
+}
+
+// recordPodAllocationEvent records the IP, ENI and subnet assigned to a pod as an event on the pod, so that they show
+// in `kubectl describe pod`. It is best-effort: the recorder posts events in the background and drops them on error.
+func (c *IPAMContext) recordPodAllocationEvent(pod *corev1.Pod, podName, podNamespace, addr string, deviceNumber int) {
+	if c.eventRecorder == nil || podName == "" || podNamespace == "" {
+		return
+	}
+	if pod == nil {
+		// The pod is only fetched when a feature needs it. The event needs its UID to show on the pod, so fetch it
+		// from the cache rather than the API server.
+		pod = &corev1.Pod{}
+		podKey := types.NamespacedName{Namespace: podNamespace, Name: podName}
+		if err := c.cachedK8SClient.Get(context.TODO(), podKey, pod); err != nil {
+			log.Debugf("Not recording the IP allocation event of pod %s/%s: %v", podNamespace, podName, err)
+			return
+		}
+	}
+
+	var message string
+	if deviceNumber < 0 {
+		message = fmt.Sprintf("Assigned IP %s from a branch ENI", addr)
+	} else {
+		eniID, subnet := c.eniByDeviceNumber(deviceNumber)
+		message = fmt.Sprintf("Assigned IP %s from ENI %s in subnet %s", addr, eniID, subnet)
+	}
+	c.eventRecorder.Event(pod, corev1.EventTypeNormal, podIPAllocatedReason, message)
+}
+
+// eniByDeviceNumber returns the ID and subnet of the ENI attached at the given device number, or empty strings
+func (c *IPAMContext) eniByDeviceNumber(deviceNumber int) (string, string) {
+	for eniID, eni := range c.dataStore.GetENIInfos().ENIs {
+		if eni.DeviceNumber == deviceNumber {
+			return eniID, eni.Subnet
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,65 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEnablePodAllocationEvents(t *testing.T) {
+	defer os.Unsetenv(envEnablePodAllocationEvents)
+
+	os.Unsetenv(envEnablePodAllocationEvents)
+	assert.False(t, enablePodAllocationEvents())
+
+	os.Setenv(envEnablePodAllocationEvents, "true")
+	assert.True(t, enablePodAllocationEvents())
+}
+
+func TestRecordPodAllocationEvent(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	// Disabled, nothing to record to
+	c := &IPAMContext{cachedK8SClient: m.cachedK8SClient, dataStore: testDatastore()}
+	c.recordPodAllocationEvent(nil, "pod-1", "default", "10.0.0.10", 0)
+
+	recorder := record.NewFakeRecorder(10)
+	c.eventRecorder = recorder
+
+	// A pod missing from the cache can't be tied to the event
+	c.recordPodAllocationEvent(nil, "pod-1", "default", "10.0.0.10", 0)
+	assert.Equal(t, 0, len(recorder.Events))
+
+	// Branch ENI pods get their IP from the pod-eni annotation
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default"}}
+	c.recordPodAllocationEvent(pod, "pod-1", "default", "10.0.0.10", -1)
+	assert.Equal(t, "Normal IPAllocated Assigned IP 10.0.0.10 from a branch ENI", <-recorder.Events)
+
+	// Without the pod at hand it is fetched from the cache
+	assert.NoError(t, m.cachedK8SClient.Create(context.Background(), pod))
+	c.recordPodAllocationEvent(nil, "pod-1", "default", "10.0.0.12", -1)
+	assert.Equal(t, "Normal IPAllocated Assigned IP 10.0.0.12 from a branch ENI", <-recorder.Events)
+
+	// Requests without pod details can't be tied to a pod
+	c.recordPodAllocationEvent(nil, "", "", "10.0.0.11", -1)
+	assert.Equal(t, 0, len(recorder.Events))
+}
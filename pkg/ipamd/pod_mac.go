@@ -0,0 +1,78 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"crypto/sha256"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// envEnablePodMACAnnotation makes ipamd honor the vpc.amazonaws.com/pod-interface-mac annotation on CNI ADD
+	envEnablePodMACAnnotation = "ENABLE_POD_MAC_ANNOTATION"
+	// podMACAnnotation is the hardware address of the pod interface, or podMACFromUID to derive it from the pod UID.
+	// Pods without it keep the kernel assigned address.
+	podMACAnnotation = "vpc.amazonaws.com/pod-interface-mac"
+	podMACFromUID    = "uid"
+)
+
+func enablePodMACAnnotation() bool {
+	return getEnvBoolWithDefault(envEnablePodMACAnnotation, false)
+}
+
+// getPodMAC returns the hardware address requested by the pod's annotation, or an empty string when the pod has none
+// or it is not a valid unicast MAC address, in which case the kernel assigns one
+func getPodMAC(pod *corev1.Pod) string {
+	val, ok := pod.Annotations[podMACAnnotation]
+	if !ok {
+		return ""
+	}
+	if val == podMACFromUID {
+		if pod.UID == "" {
+			log.Warnf("Ignoring the %s annotation on pod %s/%s, the pod has no UID", podMACAnnotation, pod.Namespace, pod.Name)
+			return ""
+		}
+		return deriveMAC(string(pod.UID)).String()
+	}
+	mac, err := net.ParseMAC(val)
+	if err != nil || !isUnicastMAC(mac) {
+		log.Warnf("Ignoring the invalid %s annotation %q on pod %s/%s, must be a unicast MAC address or %q",
+			podMACAnnotation, val, pod.Namespace, pod.Name, podMACFromUID)
+		return ""
+	}
+	return mac.String()
+}
+
+// deriveMAC returns a locally administered unicast MAC address that only depends on the seed
+func deriveMAC(seed string) net.HardwareAddr {
+	sum := sha256.Sum256([]byte(seed))
+	mac := net.HardwareAddr(sum[:6])
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+	return mac
+}
+
+// isUnicastMAC returns true for a 6 byte MAC address that is neither multicast nor all zeros
+func isUnicastMAC(mac net.HardwareAddr) bool {
+	if len(mac) != 6 || mac[0]&0x01 != 0 {
+		return false
+	}
+	for _, b := range mac {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPodMAC(t *testing.T) {
+	pod := func(annotation string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod",
+			Namespace:   "default",
+			UID:         "6e1c3c1a-1b0e-4a4c-9d7e-2f0d0c1e5b3a",
+			Annotations: map[string]string{podMACAnnotation: annotation},
+		}}
+	}
+
+	assert.Equal(t, "", getPodMAC(&corev1.Pod{}))
+	assert.Equal(t, "0a:58:0a:00:00:01", getPodMAC(pod("0A:58:0A:00:00:01")))
+	for _, invalid := range []string{"not-a-mac", "01:00:5e:00:00:01", "00:00:00:00:00:00", "00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01"} {
+		assert.Equal(t, "", getPodMAC(pod(invalid)), invalid)
+	}
+
+	// The derived address is stable for a pod UID, locally administered and unicast
+	derived := getPodMAC(pod(podMACFromUID))
+	assert.Equal(t, derived, getPodMAC(pod(podMACFromUID)))
+	mac, err := net.ParseMAC(derived)
+	assert.NoError(t, err)
+	assert.True(t, isUnicastMAC(mac))
+	assert.Equal(t, byte(0x02), mac[0]&0x02)
+	other := pod(podMACFromUID)
+	other.UID = "0b7f8c2e-7a55-4c86-8a8e-6d5b4a3c2d1e"
+	assert.NotEqual(t, derived, getPodMAC(other))
+
+	noUID := pod(podMACFromUID)
+	noUID.UID = ""
+	assert.Equal(t, "", getPodMAC(noUID))
+}
@@ -0,0 +1,250 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+const (
+	// envEnablePodRoutes makes ipamd look up PodRoutes resources on CNI ADD and hand the extra routes to the CNI
+	// plugin, then keep the routes of the running pods in sync with the PodRoutes
+	envEnablePodRoutes = "ENABLE_POD_ROUTES"
+
+	// podRoutesFile keeps the routes of the running pods next to the datastore backing store, for the pods added
+	// before a restart to be kept in sync too
+	podRoutesFile = "pod-routes.json"
+)
+
+func podRoutesPath() string {
+	return filepath.Join(filepath.Dir(dsBackingStorePath()), podRoutesFile)
+}
+
+func enablePodRoutes() bool {
+	return getEnvBoolWithDefault(envEnablePodRoutes, false)
+}
+
+// getPodRoutes returns the validated routes of every PodRoutes in the pod's namespace whose selector matches the pod.
+// The list is read through the cached client, so PodRoutes are watched and changes apply to the next ADD and
+// reconcilePodRoutes.
+func (c *IPAMContext) getPodRoutes(ctx context.Context, pod *corev1.Pod) ([]string, error) {
+	var podRoutesList v1alpha1.PodRoutesList
+	if err := c.cachedK8SClient.List(ctx, &podRoutesList, client.InNamespace(pod.Namespace)); err != nil {
+		return nil, errors.Wrapf(err, "failed to list PodRoutes in namespace %s", pod.Namespace)
+	}
+	// Sort so the routes are programmed in the same order on every node
+	sort.Slice(podRoutesList.Items, func(i, j int) bool {
+		return podRoutesList.Items[i].Name < podRoutesList.Items[j].Name
+	})
+
+	var routes []string
+	seen := sets.NewString()
+	for _, podRoutes := range podRoutesList.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&podRoutes.Spec.PodSelector)
+		if err != nil {
+			log.Warnf("Ignoring PodRoutes %s/%s with an invalid pod selector: %v", podRoutes.Namespace, podRoutes.Name, err)
+			continue
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		for _, route := range podRoutes.Spec.Routes {
+			dst, err := validatePodRoute(route)
+			if err != nil {
+				log.Warnf("Ignoring route %q of PodRoutes %s/%s: %v", route, podRoutes.Namespace, podRoutes.Name, err)
+				continue
+			}
+			if seen.Has(dst.String()) {
+				continue
+			}
+			seen.Insert(dst.String())
+			routes = append(routes, dst.String())
+		}
+	}
+	return routes, nil
+}
+
+// validatePodRoute parses an extra pod route. The pod's default route is owned by the CNI, so a route for 0.0.0.0/0
+// is rejected rather than silently replacing it.
+func validatePodRoute(route string) (*net.IPNet, error) {
+	_, dst, err := net.ParseCIDR(strings.TrimSpace(route))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CIDR")
+	}
+	if dst.IP.To4() == nil {
+		return nil, errors.New("only IPv4 routes are supported")
+	}
+	if ones, _ := dst.Mask.Size(); ones == 0 {
+		return nil, errors.New("conflicts with the pod's default route")
+	}
+	return dst, nil
+}
+
+// podRoutesEntry is a running pod and the routes programmed in its network namespace
+type podRoutesEntry struct {
+	PodName      string   `json:"podName"`
+	PodNamespace string   `json:"podNamespace"`
+	Netns        string   `json:"netns"`
+	IfName       string   `json:"ifName"`
+	Routes       []string `json:"routes"`
+}
+
+// podRoutesTracker keeps the podRoutesEntry of each sandbox, by sandbox ID. The zero value tracks nothing across
+// restarts, checkpoint persists the entries.
+type podRoutesTracker struct {
+	lock       sync.Mutex
+	entries    map[string]podRoutesEntry
+	checkpoint datastore.Checkpointer
+}
+
+// record tracks the routes the CNI plugin programmed in the sandbox on ADD
+func (t *podRoutesTracker) record(sandboxID string, entry podRoutesEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.entries == nil {
+		t.entries = make(map[string]podRoutesEntry)
+	}
+	t.entries[sandboxID] = entry
+	t.persistUnsafe()
+}
+
+// forget stops tracking the sandbox, on DEL
+func (t *podRoutesTracker) forget(sandboxID string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.entries[sandboxID]; !ok {
+		return
+	}
+	delete(t.entries, sandboxID)
+	t.persistUnsafe()
+}
+
+// setRoutes updates the routes of the sandbox once reprogrammed, unless it was deleted meanwhile
+func (t *podRoutesTracker) setRoutes(sandboxID string, routes []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	entry, ok := t.entries[sandboxID]
+	if !ok {
+		return
+	}
+	entry.Routes = routes
+	t.entries[sandboxID] = entry
+	t.persistUnsafe()
+}
+
+func (t *podRoutesTracker) snapshot() map[string]podRoutesEntry {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	entries := make(map[string]podRoutesEntry, len(t.entries))
+	for sandboxID, entry := range t.entries {
+		entries[sandboxID] = entry
+	}
+	return entries
+}
+
+// restore loads the entries of the pods added before ipamd restarted
+func (t *podRoutesTracker) restore() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var data map[string]podRoutesEntry
+	if err := t.checkpoint.Restore(&data); err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Failed to restore the routes of the running pods, they are only updated on their next ADD: %v", err)
+		}
+		return
+	}
+	t.entries = data
+}
+
+func (t *podRoutesTracker) persistUnsafe() {
+	if t.checkpoint == nil {
+		return
+	}
+	if err := t.checkpoint.Checkpoint(t.entries); err != nil {
+		log.Warnf("Failed to persist the routes of the running pods: %v", err)
+		ipamdErrInc("persistPodRoutes")
+	}
+}
+
+// reconcilePodRoutes reprograms the routes of the running pods whose matching PodRoutes changed since their ADD, or
+// since the last reconcile. A pod whose routes can't be updated, e.g. because its network namespace is not visible
+// to ipamd, is retried by the next reconcile.
+func (c *IPAMContext) reconcilePodRoutes(ctx context.Context) {
+	if !c.enablePodRoutes {
+		return
+	}
+	for sandboxID, entry := range c.podRoutes.snapshot() {
+		var pod corev1.Pod
+		podKey := types.NamespacedName{Namespace: entry.PodNamespace, Name: entry.PodName}
+		if err := c.cachedK8SClient.Get(ctx, podKey, &pod); err != nil {
+			// A deleted pod is forgotten on its DEL
+			log.Debugf("Not reconciling the routes of pod %s: %v", podKey, err)
+			continue
+		}
+		routes, err := c.getPodRoutes(ctx, &pod)
+		if err != nil {
+			log.Warnf("Failed to reconcile the pod routes: %v", err)
+			return
+		}
+		add, del := diffPodRoutes(entry.Routes, routes)
+		if len(add) == 0 && len(del) == 0 {
+			continue
+		}
+		if err := c.networkClient.UpdatePodRoutes(entry.Netns, entry.IfName, add, del); err != nil {
+			log.Warnf("Failed to update the routes of pod %s: %v", podKey, err)
+			ipamdErrInc("reconcilePodRoutes")
+			continue
+		}
+		log.Infof("Updated the routes of pod %s from %v to %v", podKey, entry.Routes, routes)
+		c.podRoutes.setRoutes(sandboxID, routes)
+	}
+}
+
+// diffPodRoutes returns the routes of want missing from have, and the routes of have no longer in want
+func diffPodRoutes(have, want []string) ([]*net.IPNet, []*net.IPNet) {
+	haveSet, wantSet := sets.NewString(have...), sets.NewString(want...)
+	var add, del []*net.IPNet
+	for _, route := range want {
+		if !haveSet.Has(route) {
+			if _, dst, err := net.ParseCIDR(route); err == nil {
+				add = append(add, dst)
+			}
+		}
+	}
+	for _, route := range have {
+		if !wantSet.Has(route) {
+			if _, dst, err := net.ParseCIDR(route); err == nil {
+				del = append(del, dst)
+			}
+		}
+	}
+	return add, del
+}
@@ -0,0 +1,258 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	testclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func TestEnablePodRoutes(t *testing.T) {
+	defer os.Unsetenv(envEnablePodRoutes)
+
+	os.Unsetenv(envEnablePodRoutes)
+	assert.False(t, enablePodRoutes())
+
+	os.Setenv(envEnablePodRoutes, "true")
+	assert.True(t, enablePodRoutes())
+}
+
+func TestValidatePodRoute(t *testing.T) {
+	tests := []struct {
+		route   string
+		want    string
+		wantErr bool
+	}{
+		{route: "10.1.0.0/16", want: "10.1.0.0/16"},
+		{route: " 10.1.2.3/16 ", want: "10.1.0.0/16"},
+		{route: "192.168.1.1/32", want: "192.168.1.1/32"},
+		{route: "0.0.0.0/0", wantErr: true},
+		{route: "2001:db8::/32", wantErr: true},
+		{route: "10.1.0.0", wantErr: true},
+		{route: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			dst, err := validatePodRoute(tt.route)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, dst.String())
+		})
+	}
+}
+
+func TestGetPodRoutes(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	podRoutes := []*v1alpha1.PodRoutes{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "b-routes", Namespace: "default"},
+			Spec: v1alpha1.PodRoutesSpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				Routes:      []string{"10.2.0.0/16", "10.1.0.0/16", "0.0.0.0/0", "not-a-cidr"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-routes", Namespace: "default"},
+			Spec: v1alpha1.PodRoutesSpec{
+				Routes: []string{"10.1.0.0/16"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-routes", Namespace: "default"},
+			Spec: v1alpha1.PodRoutesSpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				Routes:      []string{"10.3.0.0/16"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "bad-selector", Namespace: "default"},
+			Spec: v1alpha1.PodRoutesSpec{
+				PodSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: "Bogus", Values: []string{"web"}},
+				}},
+				Routes: []string{"10.4.0.0/16"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-namespace", Namespace: "kube-system"},
+			Spec: v1alpha1.PodRoutesSpec{
+				Routes: []string{"10.5.0.0/16"},
+			},
+		},
+	}
+	for _, pr := range podRoutes {
+		assert.NoError(t, m.cachedK8SClient.Create(ctx, pr))
+	}
+
+	mockContext := &IPAMContext{cachedK8SClient: m.cachedK8SClient}
+
+	webPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"app": "web"}}}
+	routes, err := mockContext.getPodRoutes(ctx, webPod)
+	assert.NoError(t, err)
+	// PodRoutes are applied in name order and duplicates are dropped
+	assert.Equal(t, []string{"10.1.0.0/16", "10.2.0.0/16"}, routes)
+
+	otherPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}
+	routes, err = mockContext.getPodRoutes(ctx, otherPod)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.1.0.0/16"}, routes)
+
+	emptyPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "empty"}}
+	routes, err = mockContext.getPodRoutes(ctx, emptyPod)
+	assert.NoError(t, err)
+	assert.Empty(t, routes)
+}
+
+func TestReconcilePodRoutes(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	webPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"app": "web"}}}
+	assert.NoError(t, m.cachedK8SClient.Create(ctx, webPod))
+	webRoutes := &v1alpha1.PodRoutes{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-routes", Namespace: "default"},
+		Spec: v1alpha1.PodRoutesSpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Routes:      []string{"10.1.0.0/16"},
+		},
+	}
+	assert.NoError(t, m.cachedK8SClient.Create(ctx, webRoutes))
+	setRoutes := func(routes ...string) {
+		assert.NoError(t, m.cachedK8SClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web-routes"}, webRoutes))
+		webRoutes.Spec.Routes = routes
+		assert.NoError(t, m.cachedK8SClient.Update(ctx, webRoutes))
+	}
+	cidr := func(s string) *net.IPNet {
+		_, dst, _ := net.ParseCIDR(s)
+		return dst
+	}
+
+	checkpoint := datastore.NewTestCheckpoint(nil)
+	mockContext := &IPAMContext{
+		cachedK8SClient: m.cachedK8SClient,
+		networkClient:   m.network,
+		enablePodRoutes: true,
+		podRoutes:       podRoutesTracker{checkpoint: checkpoint},
+	}
+	mockContext.podRoutes.record("web-sandbox", podRoutesEntry{PodName: "web", PodNamespace: "default",
+		Netns: "/var/run/netns/cni-1", IfName: "eth0", Routes: []string{"10.1.0.0/16"}})
+	// Its pod is gone, the DEL is on its way
+	mockContext.podRoutes.record("gone-sandbox", podRoutesEntry{PodName: "gone", PodNamespace: "default",
+		Netns: "/var/run/netns/cni-2", IfName: "eth0"})
+
+	// In sync, nothing to do
+	mockContext.reconcilePodRoutes(ctx)
+
+	// The PodRoutes changed, the running pod is reprogrammed
+	setRoutes("10.2.0.0/16")
+	m.network.EXPECT().UpdatePodRoutes("/var/run/netns/cni-1", "eth0", []*net.IPNet{cidr("10.2.0.0/16")}, []*net.IPNet{cidr("10.1.0.0/16")}).Return(nil)
+	mockContext.reconcilePodRoutes(ctx)
+	assert.Equal(t, []string{"10.2.0.0/16"}, mockContext.podRoutes.snapshot()["web-sandbox"].Routes)
+
+	// A failed update is retried by the next reconcile
+	setRoutes("10.2.0.0/16", "10.3.0.0/16")
+	m.network.EXPECT().UpdatePodRoutes("/var/run/netns/cni-1", "eth0", []*net.IPNet{cidr("10.3.0.0/16")}, nil).Return(errors.New("netlink error"))
+	mockContext.reconcilePodRoutes(ctx)
+	assert.Equal(t, []string{"10.2.0.0/16"}, mockContext.podRoutes.snapshot()["web-sandbox"].Routes)
+	m.network.EXPECT().UpdatePodRoutes("/var/run/netns/cni-1", "eth0", []*net.IPNet{cidr("10.3.0.0/16")}, nil).Return(nil)
+	mockContext.reconcilePodRoutes(ctx)
+	assert.Equal(t, []string{"10.2.0.0/16", "10.3.0.0/16"}, mockContext.podRoutes.snapshot()["web-sandbox"].Routes)
+
+	// The pods survive a restart
+	restored := podRoutesTracker{checkpoint: checkpoint}
+	restored.restore()
+	assert.Equal(t, mockContext.podRoutes.snapshot(), restored.snapshot())
+
+	// And are forgotten on DEL
+	mockContext.podRoutes.forget("gone-sandbox")
+	mockContext.podRoutes.forget("web-sandbox")
+	assert.Empty(t, mockContext.podRoutes.snapshot())
+}
+
+func TestServer_AddNetworkPodRoutesLookupFailure(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-2", Namespace: "default"}}
+	// Without the PodRoutes kind in its scheme, listing them fails
+	noPodRoutesSchema := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(noPodRoutesSchema))
+
+	tests := []struct {
+		name          string
+		podExists     bool
+		canListRoutes bool
+	}{
+		{"pod not found", false, true},
+		{"PodRoutes list failure", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := setup(t)
+			defer m.ctrl.Finish()
+
+			ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+			assert.NoError(t, ds.AddENI("eni-1", 1, false, false, false))
+			assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.100"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+			cachedK8SClient := m.cachedK8SClient
+			if !tt.canListRoutes {
+				cachedK8SClient = testclient.NewFakeClientWithScheme(noPodRoutesSchema)
+			}
+			if tt.podExists {
+				assert.NoError(t, m.rawK8SClient.Create(context.Background(), pod.DeepCopy()))
+			}
+			mockContext := &IPAMContext{
+				awsClient:       m.awsutils,
+				networkClient:   m.network,
+				rawK8SClient:    m.rawK8SClient,
+				cachedK8SClient: cachedK8SClient,
+				dataStore:       ds,
+				enablePodRoutes: true,
+				podRoutes:       podRoutesTracker{checkpoint: datastore.NewTestCheckpoint(nil)},
+			}
+			m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil).AnyTimes()
+			m.network.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+			rpcServer := server{version: "1.2.3", ipamContext: mockContext}
+
+			// The pod is added without the extra routes, the reconcile loop adds them later
+			resp, err := rpcServer.AddNetwork(context.Background(), exhaustedTestAddRequest())
+			assert.NoError(t, err)
+			assert.True(t, resp.Success)
+			assert.Equal(t, "192.168.1.100", resp.IPv4Addr)
+			assert.Empty(t, resp.PodRoutes)
+			entry, ok := mockContext.podRoutes.snapshot()["cid-2"]
+			assert.True(t, ok)
+			assert.Equal(t, "pod-2", entry.PodName)
+			assert.Empty(t, entry.Routes)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/eniconfig"
+)
+
+const (
+	// envEnablePodSubnetAnnotation makes ipamd honor the vpc.amazonaws.com/pod-subnet annotation on CNI ADD
+	envEnablePodSubnetAnnotation = "ENABLE_POD_SUBNET_ANNOTATION"
+	// podSubnetAnnotation names the subnet a pod's IP must come from, overriding the node's default subnet or ENIConfig
+	podSubnetAnnotation = "vpc.amazonaws.com/pod-subnet"
+	// podSubnetRefreshInterval is how long a pod subnet lookup is cached. Subnets rejected because they did not exist
+	// yet, e.g. the ones created in a CIDR just added to the VPC, become usable once it expires.
+	podSubnetRefreshInterval = 5 * time.Minute
+)
+
+// podSubnet is a cached pod subnet lookup
+type podSubnet struct {
+	// cidr is the IPv4 CIDR of the subnet, empty if pods can't use it
+	cidr        string
+	describedAt time.Time
+}
+
+func enablePodSubnetAnnotation() bool {
+	return getEnvBoolWithDefault(envEnablePodSubnetAnnotation, false)
+}
+
+// getPodSubnet returns the ID and IPv4 CIDR of the subnet requested by the pod's annotation, or empty strings when the
+// pod has none or it names a subnet outside the node's VPC and availability zone, in which case the pod falls back to
+// the node's pool. Lookups are cached for podSubnetRefreshInterval, and the invalid ones until the VPC CIDRs change.
+func (c *IPAMContext) getPodSubnet(pod *corev1.Pod) (string, string) {
+	subnetID := pod.Annotations[podSubnetAnnotation]
+	if subnetID == "" {
+		return "", ""
+	}
+	var cached podSubnet
+	if value, ok := c.podSubnets.Load(subnetID); ok {
+		cached = value.(podSubnet)
+		if time.Since(cached.describedAt) < podSubnetRefreshInterval {
+			if cached.cidr == "" {
+				log.Warnf("Ignoring the invalid %s annotation %q on pod %s/%s", podSubnetAnnotation, subnetID, pod.Namespace, pod.Name)
+				return "", ""
+			}
+			return subnetID, cached.cidr
+		}
+	}
+
+	cidr, err := c.awsClient.GetSubnetIPv4CIDR(subnetID)
+	if err != nil {
+		cause := errors.Cause(err)
+		if cause == awsutils.ErrSubnetNotFound || cause == awsutils.ErrSubnetAZMismatch || cause == awsutils.ErrSubnetVPCMismatch {
+			c.podSubnets.Store(subnetID, podSubnet{describedAt: time.Now()})
+		} else if cached.cidr != "" {
+			// A subnet never moves, keep using it until it can be described again
+			log.Warnf("Failed to refresh subnet %s of pod %s/%s, using its cached CIDR %s: %v",
+				subnetID, pod.Namespace, pod.Name, cached.cidr, err)
+			return subnetID, cached.cidr
+		}
+		log.Warnf("Ignoring the %s annotation %q on pod %s/%s, using the node's default subnet: %v",
+			podSubnetAnnotation, subnetID, pod.Namespace, pod.Name, err)
+		ipamdErrInc("podSubnetInvalid")
+		return "", ""
+	}
+	if cached.cidr == "" && !cached.describedAt.IsZero() {
+		log.Infof("Subnet %s requested by pods is now usable with CIDR %s", subnetID, cidr)
+	}
+	c.podSubnets.Store(subnetID, podSubnet{cidr: cidr, describedAt: time.Now()})
+	return subnetID, cidr
+}
+
+// forgetInvalidPodSubnets drops the cached pod subnet lookups that found no usable subnet, so the next pods requesting
+// them look them up again. Called when CIDRs are added to the VPC, since new subnets are likely to follow.
+func (c *IPAMContext) forgetInvalidPodSubnets() {
+	c.podSubnets.Range(func(key, value interface{}) bool {
+		if value.(podSubnet).cidr == "" {
+			log.Debugf("Forgetting invalid pod subnet %s", key)
+			c.podSubnets.Delete(key)
+		}
+		return true
+	})
+}
+
+// requestPodSubnetENI asks the pool manager to attach an ENI in the subnet, after a pod requesting it found no free IP
+func (c *IPAMContext) requestPodSubnetENI(subnetID string) {
+	c.pendingPodSubnets.Store(subnetID, struct{}{})
+}
+
+// allocatePodSubnetENIs attaches an ENI in each subnet requested by pods that had no free IP in them, as long as the
+// instance has room for more ENIs. Pods that could not get an IP are retried by the kubelet and succeed once the ENI
+// is set up.
+func (c *IPAMContext) allocatePodSubnetENIs(ctx context.Context) {
+	c.pendingPodSubnets.Range(func(key, _ interface{}) bool {
+		subnetID := key.(string)
+		if c.disableENIProvisioning || c.isTerminating() {
+			return false
+		}
+		if c.dataStore.GetENIs() >= c.maxENI-c.unmanagedENI {
+			log.Warnf("Unable to attach an ENI in subnet %s requested by pods, the instance already has %d ENIs",
+				subnetID, c.dataStore.GetENIs())
+			return false
+		}
+		if c.skipInAuditMode("AllocENI", "attach an ENI in subnet %s requested by pods", subnetID) {
+			return true
+		}
+		c.pendingPodSubnets.Delete(subnetID)
+
+		var securityGroups []*string
+		if c.useCustomNetworking {
+			eniCfg, err := eniconfig.MyENIConfig(ctx, c.cachedK8SClient)
+			if err != nil {
+				log.Errorf("Failed to get the ENIConfig for the security groups of an ENI in subnet %s: %v", subnetID, err)
+				return true
+			}
+			securityGroups = aws.StringSlice(eniCfg.SecurityGroups)
+		}
+		log.Infof("Attaching an ENI in subnet %s requested by pods", subnetID)
+		if err := c.allocENIWithCidrs(ctx, true, securityGroups, subnetID, ""); err != nil {
+			log.Errorf("Failed to attach an ENI in subnet %s requested by pods: %v", subnetID, err)
+			ipamdErrInc("podSubnetAllocENIFailed")
+		}
+		return true
+	})
+}
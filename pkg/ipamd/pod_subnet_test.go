@@ -0,0 +1,227 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
+)
+
+// podSubnetTestServer returns a server whose datastore has an ENI in 192.168.1.0/24 and one in 10.1.0.0/24, with one
+// free IP each
+func podSubnetTestServer(t *testing.T, m *testMocks) server {
+	ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.100"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	assert.NoError(t, ds.SetENISubnet("eni-1", "192.168.1.0/24"))
+	assert.NoError(t, ds.AddENI("eni-2", 2, false, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-2", net.IPNet{IP: net.ParseIP("10.1.0.10"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	assert.NoError(t, ds.SetENISubnet("eni-2", "10.1.0.0/24"))
+
+	mockContext := &IPAMContext{
+		awsClient:                 m.awsutils,
+		rawK8SClient:              m.rawK8SClient,
+		networkClient:             m.network,
+		dataStore:                 ds,
+		enablePodSubnetAnnotation: true,
+	}
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil).AnyTimes()
+	m.network.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+	return server{version: "1.2.3", ipamContext: mockContext}
+}
+
+func createPodWithSubnet(t *testing.T, m *testMocks, name, subnetID string) *pb.AddNetworkRequest {
+	assert.NoError(t, m.rawK8SClient.Create(context.TODO(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{podSubnetAnnotation: subnetID},
+		},
+	}))
+	return &pb.AddNetworkRequest{
+		ClientVersion:     "1.2.3",
+		K8S_POD_NAME:      name,
+		K8S_POD_NAMESPACE: "default",
+		Netns:             "netns",
+		NetworkName:       "net0",
+		ContainerID:       "cid-" + name,
+		IfName:            "eth0",
+	}
+}
+
+func TestServer_AddNetworkPodSubnet(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	rpcServer := podSubnetTestServer(t, m)
+
+	// The subnet is only described once
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-b").Return("10.1.0.0/24", nil).Times(1)
+
+	resp, err := rpcServer.AddNetwork(context.TODO(), createPodWithSubnet(t, m, "pod-1", "subnet-b"))
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, "10.1.0.10", resp.IPv4Addr)
+	assert.Equal(t, int32(2), resp.DeviceNumber)
+
+	// The subnet has no free IP left, the free IP in the other subnet is not used and an ENI is requested
+	resp, err = rpcServer.AddNetwork(context.TODO(), createPodWithSubnet(t, m, "pod-2", "subnet-b"))
+	assert.NoError(t, err)
+	assert.False(t, resp.Success)
+	_, pending := rpcServer.ipamContext.pendingPodSubnets.Load("subnet-b")
+	assert.True(t, pending)
+}
+
+func TestServer_AddNetworkInvalidPodSubnet(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	rpcServer := podSubnetTestServer(t, m)
+
+	// A subnet in another AZ is rejected once and remembered
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-other-az").
+		Return("", pkgerrors.Wrap(awsutils.ErrSubnetAZMismatch, "subnet-other-az")).Times(1)
+
+	// The pods fall back to the node's pool
+	for _, name := range []string{"pod-1", "pod-2"} {
+		resp, err := rpcServer.AddNetwork(context.TODO(), createPodWithSubnet(t, m, name, "subnet-other-az"))
+		assert.NoError(t, err)
+		assert.True(t, resp.Success)
+	}
+	_, pending := rpcServer.ipamContext.pendingPodSubnets.Load("subnet-other-az")
+	assert.False(t, pending)
+}
+
+func TestGetPodSubnetTransientErr(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	mockContext := &IPAMContext{awsClient: m.awsutils}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{podSubnetAnnotation: "subnet-b"}}}
+
+	// API failures are not cached, the next pod tries again
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-b").Return("", errors.New("throttled"))
+	subnetID, cidr := mockContext.getPodSubnet(pod)
+	assert.Empty(t, subnetID)
+	assert.Empty(t, cidr)
+
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-b").Return("10.1.0.0/24", nil)
+	subnetID, cidr = mockContext.getPodSubnet(pod)
+	assert.Equal(t, "subnet-b", subnetID)
+	assert.Equal(t, "10.1.0.0/24", cidr)
+}
+
+func TestAllocatePodSubnetENIs(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	mockContext := &IPAMContext{awsClient: m.awsutils, dataStore: ds, maxENI: 2}
+
+	// No ENI requested
+	mockContext.allocatePodSubnetENIs(context.TODO())
+
+	mockContext.requestPodSubnetENI("subnet-b")
+	m.awsutils.EXPECT().AllocENI(true, nil, "subnet-b").Return("", errors.New("no free IPs in subnet"))
+	mockContext.allocatePodSubnetENIs(context.TODO())
+	// The request is dropped, the next pod failing to get an IP requests the ENI again
+	_, pending := mockContext.pendingPodSubnets.Load("subnet-b")
+	assert.False(t, pending)
+
+	// The instance has no room for another ENI
+	assert.NoError(t, ds.AddENI(secENIid, secDevice, false, false, false))
+	mockContext.requestPodSubnetENI("subnet-b")
+	mockContext.allocatePodSubnetENIs(context.TODO())
+	_, pending = mockContext.pendingPodSubnets.Load("subnet-b")
+	assert.True(t, pending)
+}
+
+func TestGetPodSubnetRefresh(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	mockContext := &IPAMContext{awsClient: m.awsutils}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{podSubnetAnnotation: "subnet-new"}}}
+
+	// The subnet does not exist yet, which is remembered
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-new").Return("", pkgerrors.Wrap(awsutils.ErrSubnetNotFound, "subnet-new")).Times(1)
+	for i := 0; i < 2; i++ {
+		subnetID, _ := mockContext.getPodSubnet(pod)
+		assert.Empty(t, subnetID)
+	}
+
+	// Once the lookup expires the subnet, created in the meantime, is used
+	mockContext.podSubnets.Store("subnet-new", podSubnet{describedAt: time.Now().Add(-podSubnetRefreshInterval)})
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-new").Return("10.20.0.0/24", nil).Times(1)
+	subnetID, cidr := mockContext.getPodSubnet(pod)
+	assert.Equal(t, "subnet-new", subnetID)
+	assert.Equal(t, "10.20.0.0/24", cidr)
+
+	// A valid subnet that can't be described again keeps its CIDR
+	mockContext.podSubnets.Store("subnet-new", podSubnet{cidr: "10.20.0.0/24", describedAt: time.Now().Add(-podSubnetRefreshInterval)})
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-new").Return("", errors.New("throttled"))
+	subnetID, cidr = mockContext.getPodSubnet(pod)
+	assert.Equal(t, "subnet-new", subnetID)
+	assert.Equal(t, "10.20.0.0/24", cidr)
+}
+
+func TestServer_AddNetworkPodSubnetAddedToVPC(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	rpcServer := podSubnetTestServer(t, m)
+	mockContext := rpcServer.ipamContext
+	mockContext.maxENI = 3
+
+	// The subnet is to be created in a CIDR not in the VPC yet, the pod falls back to the node's pool
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-new").Return("", pkgerrors.Wrap(awsutils.ErrSubnetNotFound, "subnet-new"))
+	resp, err := rpcServer.AddNetwork(context.TODO(), createPodWithSubnet(t, m, "pod-1", "subnet-new"))
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+
+	// The VPC gains the CIDR of the new subnet
+	primaryIP := net.ParseIP(ipaddr01)
+	m.awsutils.EXPECT().GetLocalIPv4().Return(primaryIP)
+	m.awsutils.EXPECT().GetPrimaryENImac().Return(primaryMAC)
+	m.network.EXPECT().UpdateHostIptablesRules([]string{"10.10.0.0/16"}, primaryMAC, &primaryIP).Return(nil)
+	assert.Equal(t, []string{"10.10.0.0/16"}, mockContext.updateCIDRsRulesOnChange([]string{"192.168.0.0/16"}))
+
+	// The next pod finds the subnet, which has no ENI yet, and the pool manager attaches one in it
+	m.awsutils.EXPECT().GetSubnetIPv4CIDR("subnet-new").Return("10.20.0.0/24", nil)
+	resp, err = rpcServer.AddNetwork(context.TODO(), createPodWithSubnet(t, m, "pod-2", "subnet-new"))
+	assert.NoError(t, err)
+	assert.False(t, resp.Success)
+	m.awsutils.EXPECT().AllocENI(true, nil, "subnet-new").Return("", errors.New("not attached in this test"))
+	mockContext.allocatePodSubnetENIs(context.TODO())
+}
+
+func TestForgetInvalidPodSubnets(t *testing.T) {
+	mockContext := &IPAMContext{}
+	mockContext.podSubnets.Store("subnet-b", podSubnet{cidr: "10.1.0.0/24", describedAt: time.Now()})
+	mockContext.podSubnets.Store("subnet-new", podSubnet{describedAt: time.Now()})
+
+	mockContext.forgetInvalidPodSubnets()
+	_, ok := mockContext.podSubnets.Load("subnet-b")
+	assert.True(t, ok)
+	_, ok = mockContext.podSubnets.Load("subnet-new")
+	assert.False(t, ok)
+}
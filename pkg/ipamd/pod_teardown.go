@@ -0,0 +1,88 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	// envPodTeardownConcurrency is the number of EC2 calls unassigning IPs and prefixes that ipamd makes at once, for
+	// the pods being deleted and for the pool shrinking after them
+	envPodTeardownConcurrency = "POD_TEARDOWN_CONCURRENCY"
+	// defaultPodTeardownConcurrency shrinks the pool one ENI at a time and doesn't hold back the pods being deleted
+	defaultPodTeardownConcurrency = 1
+	// maxPodTeardownConcurrency keeps a mass teardown from hitting the EC2 API rate limits
+	maxPodTeardownConcurrency = 16
+)
+
+func getPodTeardownConcurrency() int {
+	inputStr, found := os.LookupEnv(envPodTeardownConcurrency)
+	if !found {
+		return defaultPodTeardownConcurrency
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 1 {
+		if input > maxPodTeardownConcurrency {
+			log.Warnf("%s %d is above the maximum, using %d", envPodTeardownConcurrency, input, maxPodTeardownConcurrency)
+			return maxPodTeardownConcurrency
+		}
+		log.Debugf("Using %s %d", envPodTeardownConcurrency, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, using %d", envPodTeardownConcurrency, inputStr, defaultPodTeardownConcurrency)
+	return defaultPodTeardownConcurrency
+}
+
+// teardownLimiter bounds the number of unassignments running at once. A nil limiter doesn't hold back the ones of
+// pods being deleted, and runs the ones of the pool shrinking one ENI after the other.
+type teardownLimiter chan struct{}
+
+// newTeardownLimiter returns a limiter of concurrency unassignments at once, or a nil one for 1: the pods being deleted
+// would otherwise queue behind each other's EC2 calls
+func newTeardownLimiter(concurrency int) teardownLimiter {
+	if concurrency <= 1 {
+		return nil
+	}
+	return make(teardownLimiter, concurrency)
+}
+
+// do runs fn once fewer than the limit of unassignments are running
+func (l teardownLimiter) do(fn func()) {
+	if l != nil {
+		l <- struct{}{}
+		defer func() { <-l }()
+	}
+	fn()
+}
+
+// doPerENI runs fn for each ENI in parallel, within the limit, and returns once they are all done
+func (l teardownLimiter) doPerENI(eniIDs []string, fn func(eniID string)) {
+	if l == nil {
+		for _, eniID := range eniIDs {
+			fn(eniID)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for _, eniID := range eniIDs {
+		wg.Add(1)
+		go func(eniID string) {
+			defer wg.Done()
+			l.do(func() { fn(eniID) })
+		}(eniID)
+	}
+	wg.Wait()
+}
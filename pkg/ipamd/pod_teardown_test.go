@@ -0,0 +1,178 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
+)
+
+func TestGetPodTeardownConcurrency(t *testing.T) {
+	defer os.Unsetenv(envPodTeardownConcurrency)
+
+	assert.Equal(t, defaultPodTeardownConcurrency, getPodTeardownConcurrency())
+
+	_ = os.Setenv(envPodTeardownConcurrency, "8")
+	assert.Equal(t, 8, getPodTeardownConcurrency())
+
+	_ = os.Setenv(envPodTeardownConcurrency, "100")
+	assert.Equal(t, maxPodTeardownConcurrency, getPodTeardownConcurrency())
+
+	_ = os.Setenv(envPodTeardownConcurrency, "0")
+	assert.Equal(t, defaultPodTeardownConcurrency, getPodTeardownConcurrency())
+}
+
+func TestServer_DelNetworkMassTeardown(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			m := setup(t)
+			defer m.ctrl.Finish()
+
+			// Prefix delegation is on but the pods got secondary IPs, each DEL unassigns its IP from EC2
+			const pods = 200
+			ds := testDatastore()
+			for i := 0; i < 4; i++ {
+				eniID := fmt.Sprintf("eni-%d", i)
+				assert.NoError(t, ds.AddENI(eniID, i, i == 0, false, false))
+				for j := 0; j < pods/4; j++ {
+					ip := net.IPv4(10, 0, byte(i), byte(j+10))
+					assert.NoError(t, ds.AddIPv4CidrToStore(eniID, net.IPNet{IP: ip, Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+				}
+			}
+			for i := 0; i < pods; i++ {
+				_, _, err := ds.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: fmt.Sprintf("cid-%d", i), IfName: "eth0"})
+				assert.NoError(t, err)
+			}
+			mockContext := &IPAMContext{
+				awsClient:                  m.awsutils,
+				networkClient:              m.network,
+				dataStore:                  ds,
+				enableIpv4PrefixDelegation: true,
+				teardownLimiter:            newTeardownLimiter(concurrency),
+			}
+			rpcServer := server{version: "1.2.3", ipamContext: mockContext}
+
+			const callDuration = 10 * time.Millisecond
+			var inFlight, maxInFlight, unassigned int32
+			// A DEL waiting for its turn may find the IPs of the ones before it freeable too, and unassign them in its call
+			m.awsutils.EXPECT().DeallocIPAddresses(gomock.Any(), gomock.Any()).DoAndReturn(func(_ string, ips []string) error {
+				atomic.AddInt32(&unassigned, int32(len(ips)))
+				current := atomic.AddInt32(&inFlight, 1)
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+						break
+					}
+				}
+				time.Sleep(callDuration)
+				atomic.AddInt32(&inFlight, -1)
+				return nil
+			}).MinTimes(1)
+
+			// The node is drained, all the DELs arrive at once
+			start := time.Now()
+			var wg sync.WaitGroup
+			for i := 0; i < pods; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					resp, err := rpcServer.DelNetwork(context.TODO(), &pb.DelNetworkRequest{
+						ClientVersion: "1.2.3",
+						NetworkName:   "net0",
+						ContainerID:   fmt.Sprintf("cid-%d", i),
+						IfName:        "eth0",
+					})
+					assert.NoError(t, err)
+					assert.True(t, resp.Success)
+				}(i)
+			}
+			wg.Wait()
+			assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+			_, assigned, _ := ds.GetStats()
+			assert.Equal(t, 0, assigned)
+			assert.Empty(t, rpcServer.ipamContext.sandboxLocks.locks)
+			assert.Equal(t, int32(pods), unassigned)
+			if concurrency > 1 {
+				assert.LessOrEqual(t, maxInFlight, int32(concurrency))
+			} else {
+				// The default doesn't queue the DELs behind each other's EC2 calls
+				assert.Greater(t, maxInFlight, int32(1))
+			}
+		})
+	}
+}
+
+func TestTryUnassignCidrsFromAllConcurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			m := setup(t)
+			defer m.ctrl.Finish()
+
+			ds := testDatastore()
+			for i := 0; i < 4; i++ {
+				eniID := fmt.Sprintf("eni-%d", i)
+				assert.NoError(t, ds.AddENI(eniID, i, i == 0, false, false))
+				for j := 0; j < 3; j++ {
+					ip := net.IPv4(10, 0, byte(i), byte(j+10))
+					assert.NoError(t, ds.AddIPv4CidrToStore(eniID, net.IPNet{IP: ip, Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+				}
+			}
+			mockContext := &IPAMContext{
+				awsClient:              m.awsutils,
+				dataStore:              ds,
+				warmIPTarget:           1,
+				maxIPsPerENI:           14,
+				reconcileCooldownCache: ReconcileCooldownCache{cache: make(map[string]time.Time)},
+				teardownLimiter:        newTeardownLimiter(concurrency),
+			}
+
+			// Each ENI's IPs are unassigned in one slow call, never more of them at once than the limit
+			const callDuration = 100 * time.Millisecond
+			var inFlight, maxInFlight int32
+			m.awsutils.EXPECT().DeallocPrefixAddresses(gomock.Any(), gomock.Any()).Return(nil).Times(4)
+			m.awsutils.EXPECT().DeallocIPAddresses(gomock.Any(), gomock.Any()).DoAndReturn(func(string, []string) error {
+				current := atomic.AddInt32(&inFlight, 1)
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+						break
+					}
+				}
+				time.Sleep(callDuration)
+				atomic.AddInt32(&inFlight, -1)
+				return nil
+			}).Times(4)
+
+			start := time.Now()
+			mockContext.tryUnassignCidrsFromAll()
+			elapsed := time.Since(start)
+			assert.LessOrEqual(t, maxInFlight, int32(concurrency))
+			if concurrency > 1 {
+				assert.Less(t, int64(elapsed), int64(4*callDuration))
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// envWarmUpConcurrency is the number of ENIs ipamd assigns IPs or prefixes to at once while filling the pool up to
+	// its warm target at startup. ENIs are still attached one at a time.
+	envWarmUpConcurrency = "WARM_UP_CONCURRENCY"
+	// defaultWarmUpConcurrency leaves the pool to the pool manager, which fills one ENI per pass
+	defaultWarmUpConcurrency = 1
+	// maxWarmUpConcurrency keeps the warm-up from hitting the EC2 API rate limits
+	maxWarmUpConcurrency = 8
+)
+
+func getWarmUpConcurrency() int {
+	inputStr, found := os.LookupEnv(envWarmUpConcurrency)
+	if !found {
+		return defaultWarmUpConcurrency
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 1 {
+		if input > maxWarmUpConcurrency {
+			log.Warnf("%s %d is above the maximum, using %d", envWarmUpConcurrency, input, maxWarmUpConcurrency)
+			return maxWarmUpConcurrency
+		}
+		log.Debugf("Using %s %d", envWarmUpConcurrency, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, using %d", envWarmUpConcurrency, inputStr, defaultWarmUpConcurrency)
+	return defaultWarmUpConcurrency
+}
+
+// warmUpPool fills the pool up to its warm target at startup, so the node reaches it before the pool manager would.
+// Each round assigns IPs or prefixes to the ENIs with room left, up to warmUpConcurrency of them at once, then
+// attaches one more ENI if the pool is still short. It stops once the target is met or a round adds nothing.
+func (c *IPAMContext) warmUpPool(ctx context.Context) {
+	for c.isDatastorePoolTooLow() && !c.isTerminating() {
+		total, _, prefixes := c.dataStore.GetStats()
+		if c.assignCidrsConcurrently(ctx) {
+			c.updateLastNodeIPPoolAction()
+		}
+		if c.isDatastorePoolTooLow() {
+			c.increaseDatastorePool(ctx)
+		}
+		if newTotal, _, newPrefixes := c.dataStore.GetStats(); newTotal == total && newPrefixes == prefixes {
+			log.Infof("Stopping the pool warm-up, the last round added no IPs nor prefixes")
+			return
+		}
+	}
+}
+
+// assignCidrsConcurrently assigns IPs, or prefixes with prefix delegation, to the ENIs with room left, up to
+// warmUpConcurrency of them at once, and returns true if any got some. Like tryAssignCidrs, it only assigns what the
+// warm target is short of, and nothing over MAX_TOTAL_IPS.
+func (c *IPAMContext) assignCidrsConcurrently(ctx context.Context) bool {
+	maxPerENI := c.maxUsableIPsPerENI()
+	toAllocate := math.MaxInt32
+	if c.enableIpv4PrefixDelegation {
+		maxPerENI = c.maxUsablePrefixesPerENI()
+		toAllocate = c.getPrefixesNeeded()
+	} else if short, _, warmIPTargetDefined := c.datastoreTargetState(); warmIPTargetDefined {
+		toAllocate = short
+	}
+	toAllocate = c.capAllocation(toAllocate)
+
+	enis := c.dataStore.GetENIsNeedIP(maxPerENI, c.skipsPrimaryENIIPs())
+	ids := make([]string, 0, len(enis))
+	for id := range enis {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	limiter := make(chan struct{}, c.warmUpConcurrency)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	assigned := false
+	for _, id := range ids {
+		if toAllocate <= 0 {
+			break
+		}
+		count := min(maxPerENI-enis[id], toAllocate)
+		toAllocate -= count
+
+		wg.Add(1)
+		limiter <- struct{}{}
+		go func(eniID string, count int) {
+			defer func() {
+				<-limiter
+				wg.Done()
+			}()
+			if !c.assignCidrsToENI(ctx, eniID, count) {
+				return
+			}
+			lock.Lock()
+			assigned = true
+			lock.Unlock()
+		}(id, count)
+	}
+	wg.Wait()
+	return assigned
+}
+
+// assignCidrsToENI assigns count IPs or prefixes to the ENI and adds the ones EC2 reports to the datastore, it returns
+// false if none could be assigned
+func (c *IPAMContext) assignCidrsToENI(ctx context.Context, eniID string, count int) bool {
+	if err := c.allocIPAddresses(ctx, eniID, count); err != nil {
+		log.Warnf("Failed to assign %d IPs or prefixes to ENI %s during the pool warm-up: %v", count, eniID, err)
+		ipamdErrInc("warmUpAllocIPAddressesFailed")
+		return false
+	}
+	if c.enableIpv4PrefixDelegation {
+		ec2Prefixes, err := c.awsClient.GetIPv4PrefixesFromEC2(eniID)
+		if err != nil {
+			log.Warnf("Failed to get the prefixes of ENI %s during the pool warm-up: %v", eniID, err)
+			ipamdErrInc("warmUpGetENIprefixesFailed")
+			return false
+		}
+		c.addENIprefixesToDataStore(ec2Prefixes, eniID)
+		return true
+	}
+	ec2Addrs, err := c.awsClient.GetIPv4sFromEC2(eniID)
+	if err != nil {
+		log.Warnf("Failed to get the IPs of ENI %s during the pool warm-up: %v", eniID, err)
+		ipamdErrInc("warmUpGetENIaddressesFailed")
+		return false
+	}
+	c.addENIsecondaryIPsToDataStore(ec2Addrs, eniID)
+	return true
+}
@@ -0,0 +1,120 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWarmUpConcurrency(t *testing.T) {
+	defer os.Unsetenv(envWarmUpConcurrency)
+
+	assert.Equal(t, defaultWarmUpConcurrency, getWarmUpConcurrency())
+
+	_ = os.Setenv(envWarmUpConcurrency, "4")
+	assert.Equal(t, 4, getWarmUpConcurrency())
+
+	_ = os.Setenv(envWarmUpConcurrency, "100")
+	assert.Equal(t, maxWarmUpConcurrency, getWarmUpConcurrency())
+
+	_ = os.Setenv(envWarmUpConcurrency, "0")
+	assert.Equal(t, defaultWarmUpConcurrency, getWarmUpConcurrency())
+}
+
+func TestWarmUpPool(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	const (
+		enis       = 4
+		ipsPerENI  = 3
+		assignTime = 50 * time.Millisecond
+	)
+	mockContext := &IPAMContext{
+		awsClient:         m.awsutils,
+		dataStore:         testDatastore(),
+		maxIPsPerENI:      ipsPerENI,
+		maxENI:            enis,
+		warmIPTarget:      enis * ipsPerENI,
+		warmUpConcurrency: 2,
+	}
+
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	for i := 1; i <= enis; i++ {
+		eniID := fmt.Sprintf("eni-%d", i)
+		assert.NoError(t, mockContext.dataStore.AddENI(eniID, i, false, false, false))
+
+		m.awsutils.EXPECT().AllocIPAddresses(eniID, ipsPerENI).DoAndReturn(func(string, int) error {
+			lock.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			lock.Unlock()
+			time.Sleep(assignTime)
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+			return nil
+		})
+		var addrs []*ec2.NetworkInterfacePrivateIpAddress
+		for j := 1; j <= ipsPerENI; j++ {
+			addrs = append(addrs, &ec2.NetworkInterfacePrivateIpAddress{PrivateIpAddress: aws.String(fmt.Sprintf("10.0.%d.%d", i, j))})
+		}
+		m.awsutils.EXPECT().GetIPv4sFromEC2(eniID).Return(addrs, nil)
+	}
+
+	start := time.Now()
+	mockContext.warmUpPool(context.Background())
+	elapsed := time.Since(start)
+
+	// The target is met, with no more than two ENIs getting their IPs at once
+	total, _, _ := mockContext.dataStore.GetStats()
+	assert.Equal(t, enis*ipsPerENI, total)
+	assert.False(t, mockContext.isDatastorePoolTooLow())
+	assert.Equal(t, 2, maxInFlight)
+	assert.Less(t, int64(elapsed), int64(enis*assignTime), "warming up one ENI after the other takes %s", enis*assignTime)
+}
+
+func TestWarmUpPoolStopsWithoutProgress(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:         m.awsutils,
+		dataStore:         testDatastore(),
+		maxIPsPerENI:      3,
+		maxENI:            1,
+		warmIPTarget:      3,
+		warmUpConcurrency: 2,
+	}
+	assert.NoError(t, mockContext.dataStore.AddENI(secENIid, secDevice, false, false, false))
+	// The ENI can't get IPs, and there is no room for another one
+	m.awsutils.EXPECT().AllocIPAddresses(secENIid, 3).Return(errors.New("throttled")).Times(2)
+	m.awsutils.EXPECT().AllocIPAddresses(secENIid, 1).Return(errors.New("throttled"))
+
+	mockContext.warmUpPool(context.Background())
+	assert.True(t, mockContext.isDatastorePoolTooLow())
+}
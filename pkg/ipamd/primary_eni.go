@@ -0,0 +1,72 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+// envDisablePrimaryENIPodIPs keeps the secondary IPs and prefixes of the primary ENI out of the pool, pods only get IPs
+// of the secondary ENIs. The primary ENI and its primary IP are never freed either way.
+const envDisablePrimaryENIPodIPs = "DISABLE_PRIMARY_ENI_POD_IPS"
+
+func disablePrimaryENIPodIPs() bool {
+	return getEnvBoolWithDefault(envDisablePrimaryENIPodIPs, false)
+}
+
+// skipsPrimaryENIIPs returns true if no new IP or prefix is to be assigned to the primary ENI for pods
+func (c *IPAMContext) skipsPrimaryENIIPs() bool {
+	return c.useCustomNetworking || c.disablePrimaryENIPodIPs
+}
+
+// isPrimaryENI returns true for the primary ENI of the instance, which is attached with the instance and must outlive
+// the CNI
+func (c *IPAMContext) isPrimaryENI(eniID string) bool {
+	return eniID == c.awsClient.GetPrimaryENI()
+}
+
+// releasePrimaryENIPodIPs unassigns the secondary IPs and prefixes of the primary ENI that no pod uses, once
+// DISABLE_PRIMARY_ENI_POD_IPS is set. The ones still used by pods from before keep serving them, and are released by
+// a later call once their pods are gone. It returns true while some of them are left.
+func (c *IPAMContext) releasePrimaryENIPodIPs() bool {
+	eniID := c.awsClient.GetPrimaryENI()
+	c.tryUnassignIPFromENI(eniID)
+	c.tryUnassignPrefixFromENI(eniID)
+
+	ipPool, prefixPool, err := c.dataStore.GetENICIDRs(eniID)
+	if err != nil {
+		return false
+	}
+	remaining := len(ipPool) + len(prefixPool)
+	if remaining > 0 {
+		log.Infof("Keeping %d IPs and prefixes of the primary ENI %s until they are no longer in use, %s is set",
+			remaining, eniID, envDisablePrimaryENIPodIPs)
+	}
+	return remaining > 0
+}
+
+// withoutPrimaryIP returns the IPs of the ENI to unassign, without the ENI's primary IP. The datastore never holds it,
+// this guards against unassigning it from a stale or mistaken list.
+func (c *IPAMContext) withoutPrimaryIP(eniID string, ips []string) []string {
+	primaryIP, ok := c.primaryIP[eniID]
+	if !ok {
+		return ips
+	}
+	kept := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if ip == primaryIP {
+			log.Errorf("Not unassigning IP %s, it is the primary IP of ENI %s", ip, eniID)
+			ipamdErrInc("unassignPrimaryIP")
+			continue
+		}
+		kept = append(kept, ip)
+	}
+	return kept
+}
@@ -0,0 +1,146 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+func TestSetupPrimaryENIPodIPs(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		disabled  bool
+		wantTotal int
+	}{
+		{"pooled", false, 2},
+		{"disabled", true, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := setup(t)
+			defer m.ctrl.Finish()
+
+			mockContext := &IPAMContext{
+				awsClient:               m.awsutils,
+				networkClient:           m.network,
+				dataStore:               testDatastore(),
+				primaryIP:               make(map[string]string),
+				disablePrimaryENIPodIPs: tc.disabled,
+			}
+			m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid)
+			assert.NoError(t, mockContext.setupENI(primaryENIid, getPrimaryENIMetadata(), false, false))
+
+			// The secondary IPs are pooled unless disabled, the primary IP never is
+			total, _, _ := mockContext.dataStore.GetStats()
+			assert.Equal(t, tc.wantTotal, total)
+			ipPool, _, err := mockContext.dataStore.GetENICIDRs(primaryENIid)
+			assert.NoError(t, err)
+			assert.NotContains(t, ipPool, ipaddr01)
+			assert.Equal(t, ipaddr01, mockContext.primaryIP[primaryENIid])
+		})
+	}
+}
+
+func TestSkipsPrimaryENIIPs(t *testing.T) {
+	assert.False(t, (&IPAMContext{}).skipsPrimaryENIIPs())
+	assert.True(t, (&IPAMContext{disablePrimaryENIPodIPs: true}).skipsPrimaryENIIPs())
+	assert.True(t, (&IPAMContext{useCustomNetworking: true}).skipsPrimaryENIIPs())
+}
+
+func TestPrimaryENINeverFreed(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	mockContext := &IPAMContext{
+		awsClient:    m.awsutils,
+		dataStore:    ds,
+		primaryIP:    map[string]string{primaryENIid: ipaddr01},
+		maxIPsPerENI: 14,
+		maxENI:       4,
+	}
+
+	// The primary ENI of an idle node is not freed, whatever the targets
+	mockContext.tryFreeENI()
+	_, _, err := ds.GetENICIDRs(primaryENIid)
+	assert.NoError(t, err)
+
+	// Nor when it is handed over by mistake
+	mockContext.freeENI(primaryENIid, datastore.ENI{ID: primaryENIid, IsPrimary: true}, awsutils.ENIRemovalWarmShrink)
+}
+
+func TestDeallocCidrsKeepsPrimaryIP(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := &IPAMContext{
+		awsClient:              m.awsutils,
+		primaryIP:              map[string]string{primaryENIid: ipaddr01},
+		reconcileCooldownCache: ReconcileCooldownCache{cache: make(map[string]time.Time)},
+	}
+	m.awsutils.EXPECT().DeallocIPAddresses(primaryENIid, []string{ipaddr02}).Return(nil)
+	assert.NoError(t, mockContext.deallocCidrs(primaryENIid, []string{ipaddr01, ipaddr02}, false))
+}
+
+func TestReleasePrimaryENIPodIPs(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	// A pod got an IP of the primary ENI before DISABLE_PRIMARY_ENI_POD_IPS was set
+	runningPod := datastore.IPAMKey{NetworkName: "net0", ContainerID: "running", IfName: "eth0"}
+	checkpoint := datastore.NewTestCheckpoint(datastore.CheckpointData{
+		Version:     datastore.CheckpointFormatVersion,
+		Allocations: []datastore.CheckpointEntry{{IPAMKey: runningPod, IPv4: ipaddr02}},
+	})
+	mockContext := &IPAMContext{
+		awsClient:               m.awsutils,
+		networkClient:           m.network,
+		dataStore:               datastore.NewDataStore(log, checkpoint, false),
+		primaryIP:               make(map[string]string),
+		disablePrimaryENIPodIPs: true,
+		reconcileCooldownCache:  ReconcileCooldownCache{cache: make(map[string]time.Time)},
+	}
+	mockContext.dataStore.CheckpointMigrationPhase = 2
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid).AnyTimes()
+	assert.NoError(t, mockContext.setupENI(primaryENIid, getPrimaryENIMetadata(), false, false))
+	assert.NoError(t, mockContext.dataStore.ReadBackingStore())
+
+	// The running pod keeps its IP, the unused one is unassigned
+	m.awsutils.EXPECT().DeallocIPAddresses(primaryENIid, []string{ipaddr03}).Return(nil)
+	assert.True(t, mockContext.releasePrimaryENIPodIPs())
+	ipPool, _, err := mockContext.dataStore.GetENICIDRs(primaryENIid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ipaddr02}, ipPool)
+	total, assigned, _ := mockContext.dataStore.GetStats()
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 1, assigned)
+
+	// No new pod gets an IP of the primary ENI
+	_, _, err = mockContext.dataStore.AssignPodIPv4Address(datastore.IPAMKey{NetworkName: "net0", ContainerID: "new", IfName: "eth0"})
+	assert.Error(t, err)
+
+	// Once the pod is gone, its IP is unassigned too
+	_, _, _, err = mockContext.dataStore.UnassignPodIPv4Address(runningPod)
+	assert.NoError(t, err)
+	m.awsutils.EXPECT().DeallocIPAddresses(primaryENIid, []string{ipaddr02}).Return(nil)
+	assert.False(t, mockContext.releasePrimaryENIPodIPs())
+}
@@ -0,0 +1,74 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// envMaxReconcileDeletions caps the number of ENIs, IPs and prefixes a single IP pool reconcile pass removes from
+	// the datastore, as a guard against a glitch in the metadata wiping out the pool. The excess is left to the next
+	// passes.
+	envMaxReconcileDeletions = "MAX_RECONCILE_DELETIONS"
+	// noMaxReconcileDeletions means a reconcile pass removes everything that is gone
+	noMaxReconcileDeletions = 0
+)
+
+func getMaxReconcileDeletions() int {
+	inputStr, found := os.LookupEnv(envMaxReconcileDeletions)
+	if !found {
+		return noMaxReconcileDeletions
+	}
+	if input, err := strconv.Atoi(inputStr); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envMaxReconcileDeletions, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, ignoring it", envMaxReconcileDeletions, inputStr)
+	return noMaxReconcileDeletions
+}
+
+// reconcileDeletions counts the deletions of the current reconcile pass against the cap
+type reconcileDeletions struct {
+	max      int
+	done     int
+	deferred int
+}
+
+// reset starts a new reconcile pass allowing max deletions, noMaxReconcileDeletions for no cap
+func (d *reconcileDeletions) reset(max int) {
+	*d = reconcileDeletions{max: max}
+}
+
+// allow returns true if one more deletion fits in the current pass, and counts it
+func (d *reconcileDeletions) allow() bool {
+	if d.max != noMaxReconcileDeletions && d.done >= d.max {
+		d.deferred++
+		return false
+	}
+	d.done++
+	return true
+}
+
+// report logs and counts the deletions the current pass had to leave to the next ones
+func (d *reconcileDeletions) report() {
+	if d.deferred == 0 {
+		return
+	}
+	log.Warnf("IP pool reconcile: reached the cap of %d deletions, deferring %d more to the next passes", d.max, d.deferred)
+	reconcileCnt.With(prometheus.Labels{"fn": "reconcileDeletionDeferred"}).Add(float64(d.deferred))
+}
@@ -0,0 +1,93 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+func TestReconcileNodeIPPoolMaxDeletions(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	// The datastore has two secondary IPs on the primary ENI and a secondary ENI, all of which are gone
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	assert.NoError(t, ds.AddENI(secENIid, secDevice, false, false, false))
+	for _, ip := range []string{ipaddr02, ipaddr03} {
+		assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+	mockContext := &IPAMContext{
+		awsClient:             m.awsutils,
+		networkClient:         m.network,
+		dataStore:             ds,
+		primaryIP:             map[string]string{primaryENIid: ipaddr01, secENIid: ipaddr11},
+		maxReconcileDeletions: 2,
+	}
+
+	primaryOnly := []*ec2.NetworkInterfacePrivateIpAddress{{PrivateIpAddress: aws.String(ipaddr01), Primary: aws.Bool(true)}}
+	attachedENIs := []awsutils.ENIMetadata{{
+		ENIID:          primaryENIid,
+		MAC:            primaryMAC,
+		DeviceNumber:   primaryDevice,
+		SubnetIPv4CIDR: primarySubnet,
+		IPv4Addresses:  primaryOnly,
+	}}
+	m.awsutils.EXPECT().IsUnmanagedENI(gomock.Any()).Return(false).AnyTimes()
+	m.awsutils.EXPECT().IsCNIUnmanagedENI(gomock.Any()).Return(false).AnyTimes()
+	m.awsutils.EXPECT().GetAttachedENIs().Return(attachedENIs, nil).Times(2)
+	m.awsutils.EXPECT().GetIPv4sFromEC2(primaryENIid).Return(primaryOnly, nil)
+
+	// The first pass stops at the cap, after the two IPs
+	assert.NoError(t, mockContext.reconcileNodeIPPool(ctx))
+	total, _, _ := ds.GetStats()
+	assert.Equal(t, 0, total)
+	assert.Len(t, ds.GetENIInfos().ENIs, 2)
+	assert.Equal(t, 1, mockContext.reconcileDeletions.deferred)
+
+	// The next pass removes the ENI
+	assert.NoError(t, mockContext.reconcileNodeIPPool(ctx))
+	assert.Len(t, ds.GetENIInfos().ENIs, 1)
+	assert.Equal(t, 0, mockContext.reconcileDeletions.deferred)
+}
+
+func TestReconcileDeletionsNoCap(t *testing.T) {
+	var deletions reconcileDeletions
+	deletions.reset(noMaxReconcileDeletions)
+	for i := 0; i < 100; i++ {
+		assert.True(t, deletions.allow())
+	}
+	assert.Equal(t, 0, deletions.deferred)
+}
+
+func TestGetMaxReconcileDeletions(t *testing.T) {
+	defer os.Unsetenv(envMaxReconcileDeletions)
+
+	assert.Equal(t, noMaxReconcileDeletions, getMaxReconcileDeletions())
+	_ = os.Setenv(envMaxReconcileDeletions, "10")
+	assert.Equal(t, 10, getMaxReconcileDeletions())
+	_ = os.Setenv(envMaxReconcileDeletions, "-1")
+	assert.Equal(t, noMaxReconcileDeletions, getMaxReconcileDeletions())
+}
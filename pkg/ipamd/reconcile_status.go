@@ -0,0 +1,77 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+)
+
+// reconcileHistorySize is the number of past reconcile results kept for introspection
+const reconcileHistorySize = 10
+
+// ReconcileResult is the outcome of a single node IP pool reconcile
+type ReconcileResult struct {
+	StartTime  time.Time
+	DurationMs int64
+	Success    bool
+	Error      string `json:",omitempty"`
+}
+
+// ReconcileStatus contains the last reconcile result and the recent history, newest first
+type ReconcileStatus struct {
+	Last    *ReconcileResult
+	History []ReconcileResult
+}
+
+// reconcileStatusTracker is a fixed size ring buffer of reconcile results. The zero value is ready to use.
+type reconcileStatusTracker struct {
+	lock    sync.Mutex
+	results [reconcileHistorySize]ReconcileResult
+	next    int
+	count   int
+}
+
+func (t *reconcileStatusTracker) record(start time.Time, duration time.Duration, err error) {
+	result := ReconcileResult{
+		StartTime:  start,
+		DurationMs: duration.Milliseconds(),
+		Success:    err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.results[t.next] = result
+	t.next = (t.next + 1) % reconcileHistorySize
+	if t.count < reconcileHistorySize {
+		t.count++
+	}
+}
+
+func (t *reconcileStatusTracker) status() ReconcileStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	status := ReconcileStatus{History: make([]ReconcileResult, 0, t.count)}
+	for i := 1; i <= t.count; i++ {
+		status.History = append(status.History, t.results[(t.next-i+reconcileHistorySize)%reconcileHistorySize])
+	}
+	if t.count > 0 {
+		last := status.History[0]
+		status.Last = &last
+	}
+	return status
+}
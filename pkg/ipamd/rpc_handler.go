@@ -20,6 +20,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -33,7 +34,9 @@ import (
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/networkutils"
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/tracing"
 	"github.com/aws/amazon-vpc-cni-k8s/rpc"
+	corev1 "k8s.io/api/core/v1"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 )
 
@@ -64,23 +67,40 @@ func (s *server) AddNetwork(ctx context.Context, in *rpc.AddNetworkRequest) (*rp
 	log.Debugf("AddNetworkRequest: %s", in)
 	addIPCnt.Inc()
 
+	ctx, span := tracing.StartSpan(tracing.ExtractGRPC(ctx), "AddNetwork",
+		tracing.AttrPodName.String(in.K8S_POD_NAME),
+		tracing.AttrPodNamespace.String(in.K8S_POD_NAMESPACE),
+		tracing.AttrContainerID.String(in.ContainerID))
+	defer span.End()
+
 	// Do this early, but after logging trace
 	if err := s.validateVersion(in.ClientVersion); err != nil {
 		log.Warnf("Rejecting AddNetwork request: %v", err)
 		return nil, err
 	}
+	defer s.ipamContext.sandboxLocks.lockSandbox(in.ContainerID)()
+	// Measured once the sandbox lock is held, so a DEL of the sandbox still running doesn't count
+	start := time.Now()
+	source := addIPSourceFailed
+	defer func() { observeAddIPLatency(start, source) }()
 
 	failureResponse := rpc.AddNetworkReply{Success: false}
 	var deviceNumber, vlanID, trunkENILinkIndex int
 	var addr, branchENIMAC, podENISubnetGW string
+	ipSource := addIPSourceBranchENI
 	var err error
+	var pod *corev1.Pod
+	numQueues := s.ipamContext.podInterfaceQueues
 	if s.ipamContext.enablePodENI {
 		// Check pod spec for Branch ENI
-		pod, err := s.ipamContext.GetPod(in.K8S_POD_NAME, in.K8S_POD_NAMESPACE)
+		pod, err = s.ipamContext.GetPod(in.K8S_POD_NAME, in.K8S_POD_NAMESPACE)
 		if err != nil {
 			log.Warnf("Send AddNetworkReply: Failed to get pod: %v", err)
 			return &failureResponse, nil
 		}
+		if numQueues != noPodInterfaceQueues {
+			numQueues = s.ipamContext.getPodInterfaceQueueCount(pod)
+		}
 		limits := pod.Spec.Containers[0].Resources.Limits
 		for resName := range limits {
 			if strings.HasPrefix(string(resName), "vpc.amazonaws.com/pod-eni") {
@@ -127,8 +147,38 @@ func (s *server) AddNetwork(ctx context.Context, in *rpc.AddNetworkRequest) (*rp
 				}
 			}
 		}
+	} else if numQueues != noPodInterfaceQueues || s.ipamContext.enablePodRoutes || s.ipamContext.enablePodSubnetAnnotation ||
+		s.ipamContext.enablePodMACAnnotation {
+		pod, err = s.ipamContext.GetPod(in.K8S_POD_NAME, in.K8S_POD_NAMESPACE)
+		if err != nil {
+			// The pod is only needed for the queue count override, the subnet and MAC annotations and the pod routes,
+			// fall back to the node wide settings. The reconcile loop adds the pod routes once the pod can be read.
+			log.Warnf("Failed to get pod to check its annotations, using %d queues, the node's subnets, a kernel assigned MAC and no pod routes: %v",
+				numQueues, err)
+		} else if numQueues != noPodInterfaceQueues {
+			numQueues = s.ipamContext.getPodInterfaceQueueCount(pod)
+		}
+	}
+	var podMAC string
+	if s.ipamContext.enablePodMACAnnotation && pod != nil {
+		podMAC = getPodMAC(pod)
+	}
+	var podRoutes []string
+	if s.ipamContext.enablePodRoutes && pod != nil {
+		// The routes are extras, the pod starts without them and the reconcile loop adds them later
+		routes, routesErr := s.ipamContext.getPodRoutes(ctx, pod)
+		if routesErr != nil {
+			log.Warnf("Unable to get the routes of pod %s/%s, adding it without them: %v", in.K8S_POD_NAMESPACE,
+				in.K8S_POD_NAME, routesErr)
+		} else {
+			podRoutes = routes
+		}
 	}
 	if addr == "" {
+		var podSubnetID, podSubnet string
+		if s.ipamContext.enablePodSubnetAnnotation && pod != nil {
+			podSubnetID, podSubnet = s.ipamContext.getPodSubnet(pod)
+		}
 		if in.ContainerID == "" || in.IfName == "" || in.NetworkName == "" {
 			log.Errorf("Unable to generate IPAMKey from %+v", in)
 			return &failureResponse, nil
@@ -138,8 +188,20 @@ func (s *server) AddNetwork(ctx context.Context, in *rpc.AddNetworkRequest) (*rp
 			IfName:      in.IfName,
 			NetworkName: in.NetworkName,
 		}
-		addr, deviceNumber, err = s.ipamContext.dataStore.AssignPodIPv4Address(ipamKey)
+		_, assignSpan := tracing.StartSpan(ctx, "AssignPodIPv4Address")
+		ipSource = addIPSourceWarmPool
+		addr, deviceNumber, err = s.ipamContext.dataStore.AssignPodIPv4AddressFromSubnet(ipamKey, podSubnet)
+		if err != nil {
+			if podSubnetID != "" {
+				s.ipamContext.requestPodSubnetENI(podSubnetID)
+			}
+			ipSource = addIPSourceQueued
+			addr, deviceNumber, err = s.ipamContext.queueForPodIPv4Address(ctx, ipamKey, podSubnet, err)
+		}
+		assignSpan.SetAttributes(tracing.AttrIPv4Addr.String(addr))
+		tracing.EndSpan(assignSpan, err)
 		if err != nil {
+			err = s.ipamContext.maxTotalIPsError(err)
 			log.Warnf("Send AddNetworkReply: unable to assign IPv4 address for pod, err: %v", err)
 			return &failureResponse, nil
 		}
@@ -172,9 +234,22 @@ func (s *server) AddNetwork(ctx context.Context, in *rpc.AddNetworkRequest) (*rp
 		PodENIMAC:       branchENIMAC,
 		PodENISubnetGW:  podENISubnetGW,
 		ParentIfIndex:   int32(trunkENILinkIndex),
+		NumQueues:       int32(numQueues),
+		PodRoutes:       podRoutes,
+		PodInterfaceMAC: podMAC,
+		// Pods with their own branch ENI are isolated by their security groups instead
+		PodSourceValidation: s.ipamContext.enablePodSourceValidation && vlanID == 0,
 	}
 
+	source = ipSource
+	span.SetAttributes(tracing.AttrIPv4Addr.String(addr))
 	log.Infof("Send AddNetworkReply: IPv4Addr %s, DeviceNumber: %d, err: %v", addr, deviceNumber, err)
+	if s.ipamContext.enablePodRoutes {
+		// Tracked even without routes, for the PodRoutes created later to apply to the pod
+		s.ipamContext.podRoutes.record(in.ContainerID, podRoutesEntry{PodName: in.K8S_POD_NAME, PodNamespace: in.K8S_POD_NAMESPACE,
+			Netns: in.Netns, IfName: in.IfName, Routes: podRoutes})
+	}
+	s.ipamContext.recordPodAllocationEvent(pod, in.K8S_POD_NAME, in.K8S_POD_NAMESPACE, addr, deviceNumber)
 	return &resp, nil
 }
 
@@ -195,6 +270,10 @@ func (s *server) DelNetwork(ctx context.Context, in *rpc.DelNetworkRequest) (*rp
 		log.Warnf("Rejecting DelNetwork request: %v", err)
 		return nil, err
 	}
+	defer s.ipamContext.sandboxLocks.lockSandbox(in.ContainerID)()
+	if s.ipamContext.enablePodRoutes {
+		s.ipamContext.podRoutes.forget(in.ContainerID)
+	}
 
 	ipamKey := datastore.IPAMKey{
 		ContainerID: in.ContainerID,
@@ -212,10 +291,10 @@ func (s *server) DelNetwork(ctx context.Context, in *rpc.DelNetworkRequest) (*rp
 		// secondary IP. Hence now see if we need free up a prefix is no other pods are using it.
 		if s.ipamContext.enableIpv4PrefixDelegation && eni.AvailableIPv4Cidrs[cidrStr] != nil && eni.AvailableIPv4Cidrs[cidrStr].IsPrefix == false {
 			log.Debugf("IP belongs to secondary pool with PD enabled so free IP from EC2")
-			s.ipamContext.tryUnassignIPFromENI(eni.ID)
+			s.ipamContext.teardownLimiter.do(func() { s.ipamContext.tryUnassignIPFromENI(eni.ID) })
 		} else if !s.ipamContext.enableIpv4PrefixDelegation && eni.AvailableIPv4Cidrs[cidrStr] == nil {
 			log.Debugf("IP belongs to prefix pool with PD disabled so try free prefix from EC2")
-			s.ipamContext.tryUnassignPrefixFromENI(eni.ID)
+			s.ipamContext.teardownLimiter.do(func() { s.ipamContext.tryUnassignPrefixFromENI(eni.ID) })
 		}
 	}
 
@@ -289,5 +368,11 @@ func (c *IPAMContext) shutdownListener() {
 	<-sig
 	log.Info("Received shutdown signal, setting 'terminating' to true")
 	// We received an interrupt signal, shut down.
+	c.shutdown()
+}
+
+// shutdown sets ipamd to terminating and makes the unassignments still waiting for their batch window
+func (c *IPAMContext) shutdown() {
 	c.setTerminating()
+	c.flushEC2Calls(true)
 }
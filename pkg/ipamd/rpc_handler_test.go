@@ -16,6 +16,7 @@ package ipamd
 import (
 	"context"
 	"net"
+	"sync"
 	"testing"
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
@@ -23,6 +24,9 @@ import (
 	pb "github.com/aws/amazon-vpc-cni-k8s/rpc"
 
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestServer_VersionCheck(t *testing.T) {
@@ -79,6 +83,124 @@ func TestServer_VersionCheck(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestServer_AddNetworkNumQueues(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "queued-pod",
+			Namespace:   "default",
+			Annotations: map[string]string{podInterfaceQueuesAnnotation: "8"},
+		},
+	}
+	assert.NoError(t, m.rawK8SClient.Create(context.TODO(), pod))
+
+	ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.100"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.101"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+
+	mockContext := &IPAMContext{
+		awsClient:          m.awsutils,
+		rawK8SClient:       m.rawK8SClient,
+		networkClient:      m.network,
+		dataStore:          ds,
+		podInterfaceQueues: 2,
+	}
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil).AnyTimes()
+	m.network.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	rpcServer := server{version: "1.2.3", ipamContext: mockContext}
+
+	// The annotation overrides the node wide setting
+	resp, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		ClientVersion:     "1.2.3",
+		K8S_POD_NAME:      "queued-pod",
+		K8S_POD_NAMESPACE: "default",
+		Netns:             "netns",
+		NetworkName:       "net0",
+		ContainerID:       "cid-1",
+		IfName:            "eth0",
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, int32(8), resp.NumQueues)
+
+	// Pods without the annotation get the node wide setting
+	resp, err = rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		ClientVersion:     "1.2.3",
+		K8S_POD_NAME:      "other-pod",
+		K8S_POD_NAMESPACE: "default",
+		Netns:             "netns",
+		NetworkName:       "net0",
+		ContainerID:       "cid-2",
+		IfName:            "eth0",
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, int32(2), resp.NumQueues)
+}
+
+func TestServer_AddNetworkPodMAC(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	for name, mac := range map[string]string{"mac-pod": "0A:58:0A:00:00:01", "invalid-mac-pod": "ff:ff:ff:ff:ff:ff"} {
+		assert.NoError(t, m.rawK8SClient.Create(context.TODO(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{podMACAnnotation: mac},
+			},
+		}))
+	}
+
+	ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 1, true, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.100"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.101"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+
+	mockContext := &IPAMContext{
+		awsClient:              m.awsutils,
+		rawK8SClient:           m.rawK8SClient,
+		networkClient:          m.network,
+		dataStore:              ds,
+		enablePodMACAnnotation: true,
+	}
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil).AnyTimes()
+	m.network.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	rpcServer := server{version: "1.2.3", ipamContext: mockContext}
+
+	resp, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		ClientVersion:     "1.2.3",
+		K8S_POD_NAME:      "mac-pod",
+		K8S_POD_NAMESPACE: "default",
+		Netns:             "netns",
+		NetworkName:       "net0",
+		ContainerID:       "cid-1",
+		IfName:            "eth0",
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, "0a:58:0a:00:00:01", resp.PodInterfaceMAC)
+
+	// A broadcast address is refused, the kernel assigns the MAC
+	resp, err = rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		ClientVersion:     "1.2.3",
+		K8S_POD_NAME:      "invalid-mac-pod",
+		K8S_POD_NAMESPACE: "default",
+		Netns:             "netns",
+		NetworkName:       "net0",
+		ContainerID:       "cid-2",
+		IfName:            "eth0",
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, "", resp.PodInterfaceMAC)
+}
+
 func TestServer_AddNetwork(t *testing.T) {
 	type getVPCIPv4CIDRsCall struct {
 		cidrs []string
@@ -224,3 +346,133 @@ func TestServer_AddNetwork(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_AddNetworkRecordsAllocationEvent(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 1, false, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.100"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default"}}
+	assert.NoError(t, m.cachedK8SClient.Create(context.TODO(), pod))
+
+	recorder := record.NewFakeRecorder(10)
+	mockContext := &IPAMContext{
+		awsClient:       m.awsutils,
+		networkClient:   m.network,
+		cachedK8SClient: m.cachedK8SClient,
+		dataStore:       ds,
+		eventRecorder:   recorder,
+	}
+	assert.NoError(t, ds.SetENISubnet("eni-1", "192.168.1.0/24"))
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil).AnyTimes()
+	m.network.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	rpcServer := server{version: "1.2.3", ipamContext: mockContext}
+	addReq := &pb.AddNetworkRequest{
+		ClientVersion:     "1.2.3",
+		K8S_POD_NAME:      "pod-1",
+		K8S_POD_NAMESPACE: "default",
+		Netns:             "netns",
+		NetworkName:       "net0",
+		ContainerID:       "cid-1",
+		IfName:            "eth0",
+	}
+	resp, err := rpcServer.AddNetwork(context.TODO(), addReq)
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, 1, len(recorder.Events))
+	assert.Equal(t, "Normal IPAllocated Assigned IP 192.168.1.100 from ENI eni-1 in subnet 192.168.1.0/24", <-recorder.Events)
+
+	// The pool is exhausted, the failed ADD records nothing
+	addReq.K8S_POD_NAME = "pod-2"
+	addReq.ContainerID = "cid-2"
+	resp, err = rpcServer.AddNetwork(context.TODO(), addReq)
+	assert.NoError(t, err)
+	assert.False(t, resp.Success)
+	assert.Equal(t, 0, len(recorder.Events))
+}
+
+func TestServer_AddNetworkConcurrentSameSandbox(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 1, false, false, false))
+	for _, ip := range []string{"192.168.1.100", "192.168.1.101"} {
+		assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	}
+	mockContext := &IPAMContext{
+		awsClient:     m.awsutils,
+		networkClient: m.network,
+		dataStore:     ds,
+	}
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil).AnyTimes()
+	m.network.EXPECT().UseExternalSNAT().Return(true).AnyTimes()
+
+	rpcServer := server{version: "1.2.3", ipamContext: mockContext}
+	addReq := &pb.AddNetworkRequest{
+		ClientVersion:     "1.2.3",
+		K8S_POD_NAME:      "pod-1",
+		K8S_POD_NAMESPACE: "default",
+		Netns:             "netns",
+		NetworkName:       "net0",
+		ContainerID:       "cid-1",
+		IfName:            "eth0",
+	}
+
+	// Kubelet retrying the ADD of the same sandbox
+	var wg sync.WaitGroup
+	replies := make([]*pb.AddNetworkReply, 2)
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := rpcServer.AddNetwork(context.TODO(), addReq)
+			assert.NoError(t, err)
+			replies[i] = resp
+		}(i)
+	}
+	wg.Wait()
+
+	assert.True(t, replies[0].Success)
+	assert.True(t, replies[1].Success)
+	assert.Equal(t, replies[0].IPv4Addr, replies[1].IPv4Addr)
+	_, assigned, _ := ds.GetStats()
+	assert.Equal(t, 1, assigned)
+	assert.Empty(t, rpcServer.ipamContext.sandboxLocks.locks)
+}
+
+func TestServer_AddNetworkPodSourceValidation(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	ds := datastore.NewDataStore(log, datastore.NullCheckpoint{}, false)
+	assert.NoError(t, ds.AddENI("eni-1", 1, false, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore("eni-1", net.IPNet{IP: net.ParseIP("192.168.1.100"), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+
+	mockContext := &IPAMContext{
+		awsClient:                 m.awsutils,
+		networkClient:             m.network,
+		dataStore:                 ds,
+		enablePodSourceValidation: true,
+	}
+	m.awsutils.EXPECT().GetVPCIPv4CIDRs().Return([]string{"10.10.0.0/16"}, nil)
+	m.network.EXPECT().UseExternalSNAT().Return(true)
+
+	rpcServer := server{version: "1.2.3", ipamContext: mockContext}
+	resp, err := rpcServer.AddNetwork(context.TODO(), &pb.AddNetworkRequest{
+		ClientVersion:     "1.2.3",
+		K8S_POD_NAME:      "pod-1",
+		K8S_POD_NAMESPACE: "default",
+		Netns:             "netns",
+		NetworkName:       "net0",
+		ContainerID:       "cid-1",
+		IfName:            "eth0",
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.True(t, resp.PodSourceValidation)
+}
@@ -0,0 +1,56 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import "sync"
+
+// sandboxLocks serializes the RPCs for the same sandbox, e.g. the concurrent ADDs kubelet can issue while retrying,
+// so the second one finds the IP assigned by the first instead of racing with it. The release of the IPs of gone
+// sandboxes takes it too, so it does not race with their DEL. The zero value is ready to use.
+type sandboxLocks struct {
+	lock  sync.Mutex
+	locks map[string]*sandboxLock
+}
+
+type sandboxLock struct {
+	sync.Mutex
+	// waiters is the number of callers holding or waiting for the lock, it is dropped from the map at zero
+	waiters int
+}
+
+// lockSandbox blocks until the caller holds the lock of containerID, and returns the function releasing it
+func (s *sandboxLocks) lockSandbox(containerID string) func() {
+	s.lock.Lock()
+	if s.locks == nil {
+		s.locks = make(map[string]*sandboxLock)
+	}
+	l, ok := s.locks[containerID]
+	if !ok {
+		l = &sandboxLock{}
+		s.locks[containerID] = l
+	}
+	l.waiters++
+	s.lock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.lock.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(s.locks, containerID)
+		}
+		s.lock.Unlock()
+	}
+}
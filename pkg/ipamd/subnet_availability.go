@@ -0,0 +1,106 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// envSubnetLowIPThreshold makes ipamd warn when the node's subnet has fewer free IPs than this, 0 never warns
+	envSubnetLowIPThreshold = "SUBNET_LOW_IP_THRESHOLD"
+	// envEnableSubnetLowIPEvents also records the warning as an event on the node
+	envEnableSubnetLowIPEvents = "ENABLE_SUBNET_LOW_IP_EVENTS"
+
+	// subnetLowIPsReason is the reason of the event recorded on the node when its subnet runs low on IPs
+	subnetLowIPsReason = "SubnetLowIPs"
+	// subnetAvailabilityCheckInterval spaces the DescribeSubnets calls of every node of the subnet
+	subnetAvailabilityCheckInterval = 5 * time.Minute
+)
+
+func getSubnetLowIPThreshold() int64 {
+	inputStr, found := os.LookupEnv(envSubnetLowIPThreshold)
+	if !found {
+		return 0
+	}
+	if input, err := strconv.ParseInt(inputStr, 10, 64); err == nil && input >= 0 {
+		log.Debugf("Using %s %v", envSubnetLowIPThreshold, input)
+		return input
+	}
+	log.Warnf("Invalid %s value %q, not warning about the free IPs of the subnet", envSubnetLowIPThreshold, inputStr)
+	return 0
+}
+
+func enableSubnetLowIPEvents() bool {
+	return getEnvBoolWithDefault(envEnableSubnetLowIPEvents, false)
+}
+
+// subnetLowIPWarning tracks whether the node's subnet has fewer free IPs than the threshold, to warn once when it
+// drops below it
+type subnetLowIPWarning struct {
+	threshold int64
+	// recorder records the warning on the node, it is nil unless ENABLE_SUBNET_LOW_IP_EVENTS is set
+	recorder  record.EventRecorder
+	lastCheck time.Time
+	low       bool
+}
+
+// checkSubnetAvailability compares the free IPs of the node's subnet with the threshold, every
+// subnetAvailabilityCheckInterval. The subnet is shared by the whole AZ, so running low there is the lead time to add
+// CIDRs before pods fail to get IPs on every node.
+func (c *IPAMContext) checkSubnetAvailability(now time.Time) {
+	w := &c.subnetLowIPWarning
+	if w.threshold <= 0 || now.Sub(w.lastCheck) < subnetAvailabilityCheckInterval {
+		return
+	}
+	w.lastCheck = now
+
+	// Pods get their IPs from the pod subnet when there is one
+	availability, err := c.awsClient.GetSubnetAvailability(c.podSubnetID)
+	if err != nil {
+		log.Warnf("Failed to get the free IPs of the node's subnet: %v", err)
+		return
+	}
+	low := availability.AvailableIPs < w.threshold
+	subnetAvailableIPs.WithLabelValues(availability.SubnetID).Set(float64(availability.AvailableIPs))
+	if low {
+		subnetLowIPs.WithLabelValues(availability.SubnetID).Set(1)
+	} else {
+		subnetLowIPs.WithLabelValues(availability.SubnetID).Set(0)
+	}
+	if low == w.low {
+		return
+	}
+	w.low = low
+	if !low {
+		log.Infof("Subnet %s has %d free IPs, no longer below the threshold of %d", availability.SubnetID,
+			availability.AvailableIPs, w.threshold)
+		return
+	}
+
+	message := fmt.Sprintf("Subnet %s has %d free IPs left, below the threshold of %d", availability.SubnetID,
+		availability.AvailableIPs, w.threshold)
+	log.Warn(message)
+	if w.recorder != nil {
+		node := &corev1.ObjectReference{Kind: "Node", Name: c.myNodeName, UID: types.UID(c.myNodeName)}
+		w.recorder.Event(node, corev1.EventTypeWarning, subnetLowIPsReason, message)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+func TestGetSubnetLowIPThreshold(t *testing.T) {
+	defer os.Unsetenv(envSubnetLowIPThreshold)
+
+	assert.Equal(t, int64(0), getSubnetLowIPThreshold())
+
+	_ = os.Setenv(envSubnetLowIPThreshold, "256")
+	assert.Equal(t, int64(256), getSubnetLowIPThreshold())
+
+	_ = os.Setenv(envSubnetLowIPThreshold, "-1")
+	assert.Equal(t, int64(0), getSubnetLowIPThreshold())
+}
+
+func TestCheckSubnetAvailability(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	recorder := record.NewFakeRecorder(10)
+	mockContext := &IPAMContext{
+		awsClient:          m.awsutils,
+		myNodeName:         "node-1",
+		subnetLowIPWarning: subnetLowIPWarning{threshold: 100, recorder: recorder},
+	}
+	const subnet = "subnet-low"
+	availability := func(availableIPs int64) {
+		m.awsutils.EXPECT().GetSubnetAvailability("").
+			Return(awsutils.SubnetAvailability{SubnetID: subnet, AvailableIPs: availableIPs}, nil)
+	}
+	now := time.Now()
+
+	// Above the threshold, nothing to warn about
+	availability(500)
+	mockContext.checkSubnetAvailability(now)
+	assert.Equal(t, float64(500), testutil.ToFloat64(subnetAvailableIPs.WithLabelValues(subnet)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(subnetLowIPs.WithLabelValues(subnet)))
+	assert.Empty(t, recorder.Events)
+
+	// The subnet is only checked once per interval
+	mockContext.checkSubnetAvailability(now.Add(time.Minute))
+
+	// It drops below the threshold, the warning fires once
+	now = now.Add(subnetAvailabilityCheckInterval)
+	availability(50)
+	mockContext.checkSubnetAvailability(now)
+	assert.Equal(t, float64(50), testutil.ToFloat64(subnetAvailableIPs.WithLabelValues(subnet)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(subnetLowIPs.WithLabelValues(subnet)))
+	if assert.Len(t, recorder.Events, 1) {
+		assert.Contains(t, <-recorder.Events, "Warning SubnetLowIPs Subnet subnet-low has 50 free IPs left")
+	}
+
+	now = now.Add(subnetAvailabilityCheckInterval)
+	availability(40)
+	mockContext.checkSubnetAvailability(now)
+	assert.Empty(t, recorder.Events)
+
+	// A failed lookup keeps the last state
+	now = now.Add(subnetAvailabilityCheckInterval)
+	m.awsutils.EXPECT().GetSubnetAvailability("").Return(awsutils.SubnetAvailability{}, errors.New("throttled"))
+	mockContext.checkSubnetAvailability(now)
+	assert.Equal(t, float64(1), testutil.ToFloat64(subnetLowIPs.WithLabelValues(subnet)))
+
+	// CIDRs were added, it recovers
+	now = now.Add(subnetAvailabilityCheckInterval)
+	availability(1000)
+	mockContext.checkSubnetAvailability(now)
+	assert.Equal(t, float64(0), testutil.ToFloat64(subnetLowIPs.WithLabelValues(subnet)))
+	assert.Empty(t, recorder.Events)
+}
+
+func TestCheckSubnetAvailabilityDisabled(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	// Without a threshold the subnet is never described
+	mockContext := &IPAMContext{awsClient: m.awsutils}
+	mockContext.checkSubnetAvailability(time.Now())
+}
@@ -0,0 +1,97 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+const (
+	// envEnableSubnetDiscovery makes new ENIs go to the subnets tagged with kubernetes.io/role/cni, falling back to the
+	// ENIConfig subnet and then the node's subnet when they are exhausted
+	envEnableSubnetDiscovery = "ENABLE_SUBNET_DISCOVERY"
+
+	// subnetSourceTag is the subnets of the VPC tagged with kubernetes.io/role/cni
+	subnetSourceTag = "tag"
+	// subnetSourceENIConfig is the subnet of the node's ENIConfig, with custom networking
+	subnetSourceENIConfig = "eniconfig"
+	// subnetSourceNodeDefault is the subnet of the node's primary ENI
+	subnetSourceNodeDefault = "node"
+)
+
+// ErrSubnetsExhausted is returned when none of the subnet sources has enough free IPs for a new ENI
+var ErrSubnetsExhausted = errors.New("no subnet has enough free IPs for a new ENI")
+
+func enableSubnetDiscovery() bool {
+	return getEnvBoolWithDefault(envEnableSubnetDiscovery, false)
+}
+
+// resolveENISubnet returns the subnet source and subnet of a new ENI: the tagged subnet with the most free IPs, else the
+// ENIConfig subnet when eniConfigSubnet is set, else the node's subnet, the first one with enough free IPs for the ENI
+// winning. The node's subnet is returned as an empty subnet. A source whose free IPs can't be checked is used as is and
+// left to EC2.
+func (c *IPAMContext) resolveENISubnet(eniConfigSubnet string) (string, string, error) {
+	needed := c.ipsNeededByNewENI()
+
+	taggedSubnets, err := c.awsClient.GetTaggedSubnets()
+	if err != nil {
+		log.Warnf("Failed to discover the tagged subnets, falling back to the next subnet source: %v", err)
+	} else if len(taggedSubnets) == 0 {
+		log.Debugf("No tagged subnet found, falling back to the next subnet source")
+	} else if taggedSubnets[0].AvailableIPs < needed {
+		log.Infof("The tagged subnets are exhausted, %s has the most free IPs with %d, %d are needed",
+			taggedSubnets[0].SubnetID, taggedSubnets[0].AvailableIPs, needed)
+	} else {
+		return subnetSourceTag, taggedSubnets[0].SubnetID, nil
+	}
+
+	if eniConfigSubnet != "" {
+		if c.subnetHasFreeIPs(eniConfigSubnet, needed) {
+			return subnetSourceENIConfig, eniConfigSubnet, nil
+		}
+	}
+
+	if c.subnetHasFreeIPs("", needed) {
+		return subnetSourceNodeDefault, "", nil
+	}
+	return "", "", errors.Wrapf(ErrSubnetsExhausted, "%d free IPs needed", needed)
+}
+
+// subnetHasFreeIPs returns true if the subnet, the node's one if empty, has at least needed free IPs, or if that can't
+// be checked
+func (c *IPAMContext) subnetHasFreeIPs(subnet string, needed int64) bool {
+	availability, err := c.awsClient.GetSubnetAvailability(subnet)
+	if err != nil {
+		log.Warnf("Failed to get the free IPs of subnet %q, trying it anyway: %v", subnet, err)
+		return true
+	}
+	if availability.AvailableIPs < needed {
+		log.Infof("Subnet %s is exhausted with %d free IPs, %d are needed", availability.SubnetID, availability.AvailableIPs, needed)
+		return false
+	}
+	return true
+}
+
+// ipsNeededByNewENI returns the number of subnet IPs a new ENI takes: its primary IP and the secondary IPs or prefixes
+// it gets right away
+func (c *IPAMContext) ipsNeededByNewENI() int64 {
+	resources := int64(c.GetENIResourcesToAllocate())
+	if c.enableIpv4PrefixDelegation {
+		_, numIPsPerPrefix, _ := datastore.GetPrefixDelegationDefaults()
+		resources *= int64(numIPsPerPrefix)
+	}
+	return 1 + resources
+}
@@ -0,0 +1,193 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	perrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/awsutils"
+)
+
+func TestResolveENISubnet(t *testing.T) {
+	const eniConfigSubnet = "subnet-eniconfig"
+	// A new ENI takes its primary IP and 9 secondary IPs
+	const needed = 10
+	tests := []struct {
+		name            string
+		taggedSubnets   []awsutils.SubnetAvailability
+		taggedErr       error
+		eniConfigSubnet string
+		eniConfigFree   int64
+		nodeFree        int64
+		wantSource      string
+		wantSubnet      string
+		wantErr         error
+	}{
+		{
+			name:          "tagged subnet wins",
+			taggedSubnets: []awsutils.SubnetAvailability{{SubnetID: "subnet-tag-1", AvailableIPs: 100}, {SubnetID: "subnet-tag-2", AvailableIPs: 50}},
+			wantSource:    subnetSourceTag,
+			wantSubnet:    "subnet-tag-1",
+		},
+		{
+			name:            "tagged subnets exhausted, ENIConfig wins",
+			taggedSubnets:   []awsutils.SubnetAvailability{{SubnetID: "subnet-tag-1", AvailableIPs: needed - 1}},
+			eniConfigSubnet: eniConfigSubnet,
+			eniConfigFree:   needed,
+			wantSource:      subnetSourceENIConfig,
+			wantSubnet:      eniConfigSubnet,
+		},
+		{
+			name:            "no tagged subnet, ENIConfig wins",
+			eniConfigSubnet: eniConfigSubnet,
+			eniConfigFree:   100,
+			wantSource:      subnetSourceENIConfig,
+			wantSubnet:      eniConfigSubnet,
+		},
+		{
+			name:            "tag discovery failing, ENIConfig wins",
+			taggedErr:       errors.New("throttled"),
+			eniConfigSubnet: eniConfigSubnet,
+			eniConfigFree:   100,
+			wantSource:      subnetSourceENIConfig,
+			wantSubnet:      eniConfigSubnet,
+		},
+		{
+			name:            "tagged and ENIConfig subnets exhausted, node subnet wins",
+			taggedSubnets:   []awsutils.SubnetAvailability{{SubnetID: "subnet-tag-1", AvailableIPs: 0}},
+			eniConfigSubnet: eniConfigSubnet,
+			eniConfigFree:   needed - 1,
+			nodeFree:        needed,
+			wantSource:      subnetSourceNodeDefault,
+		},
+		{
+			name:          "no custom networking, node subnet wins",
+			taggedSubnets: []awsutils.SubnetAvailability{{SubnetID: "subnet-tag-1", AvailableIPs: 0}},
+			nodeFree:      100,
+			wantSource:    subnetSourceNodeDefault,
+		},
+		{
+			name:            "every subnet exhausted",
+			taggedSubnets:   []awsutils.SubnetAvailability{{SubnetID: "subnet-tag-1", AvailableIPs: 0}},
+			eniConfigSubnet: eniConfigSubnet,
+			wantErr:         ErrSubnetsExhausted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := setup(t)
+			defer m.ctrl.Finish()
+
+			m.awsutils.EXPECT().GetTaggedSubnets().Return(tt.taggedSubnets, tt.taggedErr)
+			if tt.wantSource != subnetSourceTag {
+				if tt.eniConfigSubnet != "" {
+					m.awsutils.EXPECT().GetSubnetAvailability(eniConfigSubnet).
+						Return(awsutils.SubnetAvailability{SubnetID: eniConfigSubnet, AvailableIPs: tt.eniConfigFree}, nil)
+				}
+				if tt.wantSource != subnetSourceENIConfig {
+					m.awsutils.EXPECT().GetSubnetAvailability("").
+						Return(awsutils.SubnetAvailability{SubnetID: "subnet-node", AvailableIPs: tt.nodeFree}, nil)
+				}
+			}
+
+			mockContext := &IPAMContext{awsClient: m.awsutils, dataStore: testDatastore(), maxIPsPerENI: needed - 1}
+			source, subnet, err := mockContext.resolveENISubnet(tt.eniConfigSubnet)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, perrors.Cause(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSource, source)
+			assert.Equal(t, tt.wantSubnet, subnet)
+		})
+	}
+}
+
+func TestResolveENISubnetAvailabilityErr(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	// The free IPs of the ENIConfig subnet can't be checked, it is left to EC2
+	m.awsutils.EXPECT().GetTaggedSubnets().Return(nil, nil)
+	m.awsutils.EXPECT().GetSubnetAvailability("subnet-eniconfig").Return(awsutils.SubnetAvailability{}, errors.New("throttled"))
+	mockContext := &IPAMContext{awsClient: m.awsutils, dataStore: testDatastore(), maxIPsPerENI: 9}
+	source, subnet, err := mockContext.resolveENISubnet("subnet-eniconfig")
+	assert.NoError(t, err)
+	assert.Equal(t, subnetSourceENIConfig, source)
+	assert.Equal(t, "subnet-eniconfig", subnet)
+}
+
+func TestIPsNeededByNewENIWithPrefixes(t *testing.T) {
+	mockContext := &IPAMContext{dataStore: testDatastore(), enableIpv4PrefixDelegation: true, maxPrefixesPerENI: 4}
+	// The primary IP and one /28 prefix
+	assert.Equal(t, int64(17), mockContext.ipsNeededByNewENI())
+}
+
+func TestIncreaseDatastorePoolResumesOnceSubnetHasFreeIPs(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+	ctx := context.Background()
+
+	mockContext := &IPAMContext{
+		awsClient:             m.awsutils,
+		networkClient:         m.network,
+		maxIPsPerENI:          14,
+		maxENI:                4,
+		warmENITarget:         1,
+		primaryIP:             make(map[string]string),
+		enableSubnetDiscovery: true,
+	}
+	mockContext.dataStore = testDatastore()
+	needed := mockContext.ipsNeededByNewENI()
+
+	// Every subnet is exhausted, no ENI is attached
+	m.awsutils.EXPECT().GetTaggedSubnets().Return([]awsutils.SubnetAvailability{{SubnetID: "subnet-tag-1", AvailableIPs: 0}}, nil)
+	m.awsutils.EXPECT().GetSubnetAvailability("").Return(awsutils.SubnetAvailability{SubnetID: "subnet-node", AvailableIPs: needed - 1}, nil)
+	mockContext.increaseDatastorePool(ctx)
+	miss := mockContext.warmTargetMiss.status()
+	if assert.NotNil(t, miss) {
+		assert.True(t, miss.Missing)
+		assert.Equal(t, warmTargetMissSubnetFull, miss.Reason)
+	}
+
+	// A subnet tagged later, in a CIDR added to the VPC, is picked up by the next pass
+	m.awsutils.EXPECT().GetTaggedSubnets().Return([]awsutils.SubnetAvailability{
+		{SubnetID: "subnet-tag-2", AvailableIPs: 100}, {SubnetID: "subnet-tag-1", AvailableIPs: 0}}, nil)
+	m.awsutils.EXPECT().AllocENI(true, nil, "subnet-tag-2").Return(secENIid, nil)
+	m.awsutils.EXPECT().AllocIPAddresses(secENIid, 14)
+	m.awsutils.EXPECT().WaitForENIAndIPsAttached(secENIid, 14).Return(awsutils.ENIMetadata{
+		ENIID:          secENIid,
+		MAC:            secMAC,
+		DeviceNumber:   secDevice,
+		SubnetIPv4CIDR: secSubnet,
+		IPv4Addresses: []*ec2.NetworkInterfacePrivateIpAddress{
+			{PrivateIpAddress: aws.String(ipaddr11), Primary: aws.Bool(true)},
+			{PrivateIpAddress: aws.String(ipaddr12), Primary: aws.Bool(false)},
+		},
+	}, nil)
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	m.network.EXPECT().SetupENINetwork(gomock.Any(), secMAC, secDevice, secSubnet)
+	mockContext.increaseDatastorePool(ctx)
+	assert.Equal(t, 1, mockContext.dataStore.GetENIs())
+	total, _, _ := mockContext.dataStore.GetStats()
+	assert.Equal(t, 1, total)
+}
@@ -0,0 +1,154 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// envUntrackedIPPolicy decides what the reconciler does with the secondary IPs EC2 reports on an ENI of the
+	// datastore that the datastore does not track, e.g. IPs assigned to the ENI outside of the CNI
+	envUntrackedIPPolicy = "UNTRACKED_IP_POLICY"
+	// untrackedIPPolicyAdopt adds the untracked IPs to the pool, so pods can get them
+	untrackedIPPolicyAdopt = "adopt"
+	// untrackedIPPolicyIgnore leaves the untracked IPs alone, they are neither given to pods nor released
+	untrackedIPPolicyIgnore = "ignore"
+
+	// ignoredUntrackedIPsFile keeps the ignored IPs next to the datastore backing store, since setupENI would add them
+	// to the pool again after a restart
+	ignoredUntrackedIPsFile = "ignored-untracked-ips.json"
+)
+
+func ignoredUntrackedIPsPath() string {
+	return filepath.Join(filepath.Dir(dsBackingStorePath()), ignoredUntrackedIPsFile)
+}
+
+func getUntrackedIPPolicy() string {
+	policy, found := os.LookupEnv(envUntrackedIPPolicy)
+	if !found {
+		return untrackedIPPolicyAdopt
+	}
+	switch policy {
+	case untrackedIPPolicyAdopt, untrackedIPPolicyIgnore:
+		return policy
+	}
+	log.Warnf("Invalid %s value %q, must be %q or %q, using %q", envUntrackedIPPolicy, policy, untrackedIPPolicyAdopt,
+		untrackedIPPolicyIgnore, untrackedIPPolicyAdopt)
+	return untrackedIPPolicyAdopt
+}
+
+// untrackedIPsOf returns the IPs of attachedENIIPs that are neither the ENI's primary IP nor in the datastore's ipPool
+func (c *IPAMContext) untrackedIPsOf(eni string, ipPool []string, attachedENIIPs []*ec2.NetworkInterfacePrivateIpAddress) sets.String {
+	tracked := sets.NewString(ipPool...)
+	untracked := sets.NewString()
+	for _, privateIPv4 := range attachedENIIPs {
+		ip := aws.StringValue(privateIPv4.PrivateIpAddress)
+		if ip != c.primaryIP[eni] && !tracked.Has(ip) {
+			untracked.Insert(ip)
+		}
+	}
+	return untracked
+}
+
+// hasOnlyIgnoredIPs returns true if, with the ignore policy, the only IPs of attachedENIIPs missing from the datastore
+// are the ones ignored by a previous reconcile, so there is no need to check with EC2 again
+func (c *IPAMContext) hasOnlyIgnoredIPs(eni string, ipPool []string, attachedENIIPs []*ec2.NetworkInterfacePrivateIpAddress) bool {
+	if c.untrackedIPPolicy != untrackedIPPolicyIgnore {
+		return false
+	}
+	ignored, ok := c.ignoredUntrackedIPs[eni]
+	return ok && 1+len(ipPool)+ignored.Len() == len(attachedENIIPs) && c.untrackedIPsOf(eni, ipPool, attachedENIIPs).Equal(ignored)
+}
+
+// filterUntrackedIPs drops the IPs the datastore does not track from attachedENIIPs when the policy is ignore, and
+// remembers them for hasOnlyIgnoredIPs. With the adopt policy attachedENIIPs is returned as is.
+func (c *IPAMContext) filterUntrackedIPs(eni string, ipPool []string, attachedENIIPs []*ec2.NetworkInterfacePrivateIpAddress) []*ec2.NetworkInterfacePrivateIpAddress {
+	if c.untrackedIPPolicy != untrackedIPPolicyIgnore {
+		return attachedENIIPs
+	}
+	untracked := c.untrackedIPsOf(eni, ipPool, attachedENIIPs)
+	if untracked.Len() == 0 {
+		c.forgetIgnoredUntrackedIPs(eni)
+		return attachedENIIPs
+	}
+	if !untracked.Equal(c.ignoredUntrackedIPs[eni]) {
+		log.Infof("Ignoring IPs %v on ENI %s that the datastore does not track", untracked.List(), eni)
+		if c.ignoredUntrackedIPs == nil {
+			c.ignoredUntrackedIPs = make(map[string]sets.String)
+		}
+		c.ignoredUntrackedIPs[eni] = untracked
+		c.persistIgnoredUntrackedIPs()
+	}
+
+	filtered := make([]*ec2.NetworkInterfacePrivateIpAddress, 0, len(attachedENIIPs)-untracked.Len())
+	for _, privateIPv4 := range attachedENIIPs {
+		if !untracked.Has(aws.StringValue(privateIPv4.PrivateIpAddress)) {
+			filtered = append(filtered, privateIPv4)
+		}
+	}
+	return filtered
+}
+
+// isIgnoredUntrackedIP returns true if the IP of the ENI is left alone with the ignore policy, so must not be pooled
+func (c *IPAMContext) isIgnoredUntrackedIP(eni, ip string) bool {
+	return c.untrackedIPPolicy == untrackedIPPolicyIgnore && c.ignoredUntrackedIPs[eni].Has(ip)
+}
+
+// forgetIgnoredUntrackedIPs drops the ignored IPs of the ENI, once EC2 no longer reports them or the ENI is gone
+func (c *IPAMContext) forgetIgnoredUntrackedIPs(eni string) {
+	if _, ok := c.ignoredUntrackedIPs[eni]; !ok {
+		return
+	}
+	delete(c.ignoredUntrackedIPs, eni)
+	c.persistIgnoredUntrackedIPs()
+}
+
+// restoreIgnoredUntrackedIPs loads the IPs ignored before ipamd restarted, for setupENI to keep them out of the pool
+func (c *IPAMContext) restoreIgnoredUntrackedIPs() {
+	if c.untrackedIPPolicy != untrackedIPPolicyIgnore || c.ignoredIPsCheckpoint == nil {
+		return
+	}
+	var data map[string][]string
+	if err := c.ignoredIPsCheckpoint.Restore(&data); err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Failed to restore the ignored untracked IPs, they may be added to the pool: %v", err)
+		}
+		return
+	}
+	c.ignoredUntrackedIPs = make(map[string]sets.String, len(data))
+	for eni, ips := range data {
+		log.Infof("Still ignoring IPs %v on ENI %s that the datastore does not track", ips, eni)
+		c.ignoredUntrackedIPs[eni] = sets.NewString(ips...)
+	}
+}
+
+func (c *IPAMContext) persistIgnoredUntrackedIPs() {
+	if c.ignoredIPsCheckpoint == nil {
+		return
+	}
+	data := make(map[string][]string, len(c.ignoredUntrackedIPs))
+	for eni, ips := range c.ignoredUntrackedIPs {
+		data[eni] = ips.List()
+	}
+	if err := c.ignoredIPsCheckpoint.Checkpoint(data); err != nil {
+		log.Warnf("Failed to persist the ignored untracked IPs: %v", err)
+		ipamdErrInc("persistIgnoredUntrackedIPs")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/amazon-vpc-cni-k8s/pkg/ipamd/datastore"
+)
+
+// setupUntrackedIPContext returns a context whose datastore only tracks ipaddr02 on the primary ENI, while EC2 also
+// assigned ipaddr03 to it
+func setupUntrackedIPContext(t *testing.T, m *testMocks, policy string) *IPAMContext {
+	ds := testDatastore()
+	assert.NoError(t, ds.AddENI(primaryENIid, primaryDevice, true, false, false))
+	assert.NoError(t, ds.AddIPv4CidrToStore(primaryENIid, net.IPNet{IP: net.ParseIP(ipaddr02), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+	return &IPAMContext{
+		awsClient:         m.awsutils,
+		dataStore:         ds,
+		primaryIP:         map[string]string{primaryENIid: ipaddr01},
+		untrackedIPPolicy: policy,
+	}
+}
+
+func TestEniIPPoolReconcileAdoptUntrackedIPs(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := setupUntrackedIPContext(t, m, untrackedIPPolicyAdopt)
+	eniMetadata := getPrimaryENIMetadata()
+	m.awsutils.EXPECT().GetIPv4sFromEC2(primaryENIid).Return(eniMetadata.IPv4Addresses, nil)
+
+	mockContext.eniIPPoolReconcile([]string{ipaddr02}, eniMetadata, primaryENIid)
+	total, _, _ := mockContext.dataStore.GetStats()
+	assert.Equal(t, 2, total)
+	assert.Empty(t, mockContext.ignoredUntrackedIPs)
+}
+
+func TestEniIPPoolReconcileIgnoreUntrackedIPs(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	mockContext := setupUntrackedIPContext(t, m, untrackedIPPolicyIgnore)
+	eniMetadata := getPrimaryENIMetadata()
+	m.awsutils.EXPECT().GetIPv4sFromEC2(primaryENIid).Return(eniMetadata.IPv4Addresses, nil).Times(1)
+
+	mockContext.eniIPPoolReconcile([]string{ipaddr02}, eniMetadata, primaryENIid)
+	total, _, _ := mockContext.dataStore.GetStats()
+	assert.Equal(t, 1, total)
+	assert.Equal(t, map[string]sets.String{primaryENIid: sets.NewString(ipaddr03)}, mockContext.ignoredUntrackedIPs)
+
+	// The next reconcile does not check with EC2 again for the IP already ignored
+	mockContext.eniIPPoolReconcile([]string{ipaddr02}, eniMetadata, primaryENIid)
+	total, _, _ = mockContext.dataStore.GetStats()
+	assert.Equal(t, 1, total)
+
+	// Once EC2 no longer reports the IP, it is forgotten
+	eniMetadata.IPv4Addresses = eniMetadata.IPv4Addresses[:2]
+	mockContext.eniIPPoolReconcile([]string{ipaddr02}, eniMetadata, primaryENIid)
+	assert.Empty(t, mockContext.ignoredUntrackedIPs)
+}
+
+func TestIgnoredUntrackedIPsSurviveRestart(t *testing.T) {
+	m := setup(t)
+	defer m.ctrl.Finish()
+
+	checkpoint := datastore.NewTestCheckpoint(nil)
+	mockContext := setupUntrackedIPContext(t, m, untrackedIPPolicyIgnore)
+	mockContext.ignoredIPsCheckpoint = checkpoint
+	eniMetadata := getPrimaryENIMetadata()
+	m.awsutils.EXPECT().GetIPv4sFromEC2(primaryENIid).Return(eniMetadata.IPv4Addresses, nil).Times(1)
+	mockContext.eniIPPoolReconcile([]string{ipaddr02}, eniMetadata, primaryENIid)
+
+	// After a restart, setupENI adds the IPs IMDS reports but the ignored one
+	restarted := &IPAMContext{
+		awsClient:            m.awsutils,
+		networkClient:        m.network,
+		dataStore:            testDatastore(),
+		primaryIP:            make(map[string]string),
+		untrackedIPPolicy:    untrackedIPPolicyIgnore,
+		ignoredIPsCheckpoint: checkpoint,
+	}
+	restarted.restoreIgnoredUntrackedIPs()
+	m.awsutils.EXPECT().GetPrimaryENI().Return(primaryENIid)
+	assert.NoError(t, restarted.setupENI(primaryENIid, eniMetadata, false, false))
+	ipPool, _, err := restarted.dataStore.GetENICIDRs(primaryENIid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ipaddr02}, ipPool)
+
+	// Nor does the next reconcile check with EC2 again
+	restarted.eniIPPoolReconcile(ipPool, eniMetadata, primaryENIid)
+	total, _, _ := restarted.dataStore.GetStats()
+	assert.Equal(t, 1, total)
+
+	// Once EC2 no longer reports the IP, it is no longer persisted either
+	eniMetadata.IPv4Addresses = eniMetadata.IPv4Addresses[:2]
+	restarted.eniIPPoolReconcile(ipPool, eniMetadata, primaryENIid)
+	restarted.ignoredUntrackedIPs = nil
+	restarted.restoreIgnoredUntrackedIPs()
+	assert.Empty(t, restarted.ignoredUntrackedIPs)
+}
+
+func TestGetUntrackedIPPolicy(t *testing.T) {
+	defer os.Unsetenv(envUntrackedIPPolicy)
+
+	assert.Equal(t, untrackedIPPolicyAdopt, getUntrackedIPPolicy())
+	_ = os.Setenv(envUntrackedIPPolicy, untrackedIPPolicyIgnore)
+	assert.Equal(t, untrackedIPPolicyIgnore, getUntrackedIPPolicy())
+	_ = os.Setenv(envUntrackedIPPolicy, "release")
+	assert.Equal(t, untrackedIPPolicyAdopt, getUntrackedIPPolicy())
+}
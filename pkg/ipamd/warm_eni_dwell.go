@@ -0,0 +1,80 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// envWarmENIReclaimDwell is the number of seconds an ENI without pods must stay idle before ipamd frees it to
+	// shrink the warm pool. 0 frees it as soon as it is no longer needed.
+	envWarmENIReclaimDwell     = "WARM_ENI_RECLAIM_DWELL_SECONDS"
+	defaultWarmENIReclaimDwell = 0
+
+	// envWarmENIReclaimDwellByType overrides WARM_ENI_RECLAIM_DWELL_SECONDS per instance type or size, as a comma
+	// separated list of `<instance type or size>=<seconds>`, e.g. `large=30,24xlarge=600,m5.metal=900`
+	envWarmENIReclaimDwellByType = "WARM_ENI_RECLAIM_DWELL_BY_INSTANCE_TYPE"
+)
+
+func parseWarmENIReclaimDwell(inputStr string) (time.Duration, bool) {
+	input, err := strconv.Atoi(strings.TrimSpace(inputStr))
+	if err != nil || input < 0 {
+		return 0, false
+	}
+	return time.Duration(input) * time.Second, true
+}
+
+// getWarmENIReclaimDwell returns the warm ENI reclaim dwell of the instance type: the override of the instance type,
+// else the one of its size (the part after the dot), else the global value
+func getWarmENIReclaimDwell(instanceType string) time.Duration {
+	dwell := time.Duration(defaultWarmENIReclaimDwell)
+	if inputStr, found := os.LookupEnv(envWarmENIReclaimDwell); found {
+		if input, ok := parseWarmENIReclaimDwell(inputStr); ok {
+			dwell = input
+		} else {
+			log.Warnf("Invalid %s value %q, ignoring it", envWarmENIReclaimDwell, inputStr)
+		}
+	}
+
+	overrides := map[string]time.Duration{}
+	for _, entry := range strings.Split(os.Getenv(envWarmENIReclaimDwellByType), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			log.Warnf("Invalid %s entry %q, ignoring it", envWarmENIReclaimDwellByType, entry)
+			continue
+		}
+		input, ok := parseWarmENIReclaimDwell(parts[1])
+		if !ok {
+			log.Warnf("Invalid %s entry %q, ignoring it", envWarmENIReclaimDwellByType, entry)
+			continue
+		}
+		overrides[strings.TrimSpace(parts[0])] = input
+	}
+
+	size := instanceType[strings.LastIndex(instanceType, ".")+1:]
+	if override, ok := overrides[instanceType]; ok {
+		dwell = override
+	} else if override, ok := overrides[size]; ok {
+		dwell = override
+	}
+	log.Debugf("Using a warm ENI reclaim dwell of %v for %s", dwell, instanceType)
+	return dwell
+}
@@ -0,0 +1,45 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWarmENIReclaimDwell(t *testing.T) {
+	defer os.Unsetenv(envWarmENIReclaimDwell)
+	defer os.Unsetenv(envWarmENIReclaimDwellByType)
+
+	assert.Equal(t, time.Duration(0), getWarmENIReclaimDwell("m5.large"))
+
+	_ = os.Setenv(envWarmENIReclaimDwell, "60")
+	assert.Equal(t, time.Minute, getWarmENIReclaimDwell("m5.large"))
+
+	_ = os.Setenv(envWarmENIReclaimDwellByType, "large=30, 24xlarge=600,m5.24xlarge=900,xlarge=bad,4xlarge")
+	// The instance type wins over its size, which wins over the global value
+	assert.Equal(t, 30*time.Second, getWarmENIReclaimDwell("m5.large"))
+	assert.Equal(t, 600*time.Second, getWarmENIReclaimDwell("c5.24xlarge"))
+	assert.Equal(t, 900*time.Second, getWarmENIReclaimDwell("m5.24xlarge"))
+	assert.Equal(t, time.Minute, getWarmENIReclaimDwell("m5.2xlarge"))
+	// Invalid entries are ignored
+	assert.Equal(t, time.Minute, getWarmENIReclaimDwell("m5.xlarge"))
+	assert.Equal(t, time.Minute, getWarmENIReclaimDwell("m5.4xlarge"))
+
+	_ = os.Setenv(envWarmENIReclaimDwell, "-1")
+	assert.Equal(t, time.Duration(0), getWarmENIReclaimDwell("m5.2xlarge"))
+}
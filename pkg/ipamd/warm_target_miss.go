@@ -0,0 +1,124 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+)
+
+const (
+	// warmTargetMissInstanceLimit is the instance type taking no more ENIs, or IPs on its ENIs
+	warmTargetMissInstanceLimit = "instance_limit"
+	// warmTargetMissSubnetFull is the subnet having no free IPs or prefixes left
+	warmTargetMissSubnetFull = "subnet_full"
+	// warmTargetMissThrottling is the EC2 API throttling ipamd
+	warmTargetMissThrottling = "throttling"
+	// warmTargetMissQuota is the account running out of its ENI quota in the region
+	warmTargetMissQuota = "quota"
+	// warmTargetMissMaxTotalIPs is the node holding as many IPs as MAX_TOTAL_IPS allows
+	warmTargetMissMaxTotalIPs = "max_total_ips"
+	// warmTargetMissOther is any other failure
+	warmTargetMissOther = "other"
+)
+
+// warmTargetMissReasons are all the reasons, for the metric to drop the previous one
+var warmTargetMissReasons = []string{warmTargetMissInstanceLimit, warmTargetMissSubnetFull, warmTargetMissThrottling,
+	warmTargetMissQuota, warmTargetMissMaxTotalIPs, warmTargetMissOther}
+
+// warmTargetMissReason returns the reason the pool couldn't grow because of err
+func warmTargetMissReason(err error) string {
+	if errors.Cause(err) == ErrSubnetsExhausted {
+		return warmTargetMissSubnetFull
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return warmTargetMissOther
+	}
+	switch aerr.Code() {
+	case "AttachmentLimitExceeded", "PrivateIpAddressLimitExceeded":
+		return warmTargetMissInstanceLimit
+	case "InsufficientFreeAddressesInSubnet", "InsufficientCidrBlocks":
+		return warmTargetMissSubnetFull
+	case "RequestLimitExceeded", "Throttling", "ThrottlingException":
+		return warmTargetMissThrottling
+	case "NetworkInterfaceLimitExceeded":
+		return warmTargetMissQuota
+	}
+	return warmTargetMissOther
+}
+
+// WarmTargetMiss is the last time the pool couldn't grow to its warm target, and why
+type WarmTargetMiss struct {
+	// Missing is true until the pool reaches its warm target again
+	Missing bool
+	Time    time.Time
+	Reason  string
+	Error   string `json:",omitempty"`
+}
+
+// warmTargetMissTracker keeps the last warm target miss. The zero value is ready to use.
+type warmTargetMissTracker struct {
+	lock sync.Mutex
+	last *WarmTargetMiss
+}
+
+// record notes that the pool couldn't grow for the reason, err being the failure behind it if any
+func (t *warmTargetMissTracker) record(reason string, err error) {
+	miss := WarmTargetMiss{Missing: true, Time: time.Now(), Reason: reason}
+	if err != nil {
+		miss.Error = err.Error()
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.last == nil || !t.last.Missing || t.last.Reason != reason {
+		log.Warnf("The IP pool can't grow to its warm target: %s", reason)
+	}
+	t.last = &miss
+	for _, r := range warmTargetMissReasons {
+		if r == reason {
+			warmTargetMissed.WithLabelValues(r).Set(1)
+		} else {
+			warmTargetMissed.WithLabelValues(r).Set(0)
+		}
+	}
+}
+
+// met notes that the pool reached its warm target, the last miss is kept for introspection
+func (t *warmTargetMissTracker) met() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.last == nil || !t.last.Missing {
+		return
+	}
+	log.Infof("The IP pool reached its warm target again")
+	t.last.Missing = false
+	for _, r := range warmTargetMissReasons {
+		warmTargetMissed.WithLabelValues(r).Set(0)
+	}
+}
+
+func (t *warmTargetMissTracker) status() *WarmTargetMiss {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.last == nil {
+		return nil
+	}
+	last := *t.last
+	return &last
+}
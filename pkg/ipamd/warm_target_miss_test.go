@@ -0,0 +1,98 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ipamd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmTargetMissReason(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{awserr.New("AttachmentLimitExceeded", "", nil), warmTargetMissInstanceLimit},
+		{errors.Wrap(awserr.New("PrivateIpAddressLimitExceeded", "", nil), "failed to allocate"), warmTargetMissInstanceLimit},
+		{errors.Wrap(awserr.New("InsufficientFreeAddressesInSubnet", "", nil), "failed to create ENI"), warmTargetMissSubnetFull},
+		{errors.Wrapf(ErrSubnetsExhausted, "%d free IPs needed", 15), warmTargetMissSubnetFull},
+		{errors.Wrap(awserr.New("RequestLimitExceeded", "", nil), "failed to create ENI"), warmTargetMissThrottling},
+		{awserr.New("NetworkInterfaceLimitExceeded", "", nil), warmTargetMissQuota},
+		{errors.New("no ENI config"), warmTargetMissOther},
+	} {
+		assert.Equal(t, tc.want, warmTargetMissReason(tc.err), "%v", tc.err)
+	}
+}
+
+func TestIncreaseDatastorePoolRecordsWarmTargetMiss(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		allocErr error
+		want     string
+	}{
+		{"subnet full", errors.Wrap(awserr.New("InsufficientFreeAddressesInSubnet", "", nil), "failed to create ENI"), warmTargetMissSubnetFull},
+		{"throttling", errors.Wrap(awserr.New("RequestLimitExceeded", "", nil), "failed to create ENI"), warmTargetMissThrottling},
+		{"quota", errors.Wrap(awserr.New("NetworkInterfaceLimitExceeded", "", nil), "failed to create ENI"), warmTargetMissQuota},
+		{"instance limit", nil, warmTargetMissInstanceLimit},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := setup(t)
+			defer m.ctrl.Finish()
+
+			mockContext := &IPAMContext{
+				awsClient:     m.awsutils,
+				maxIPsPerENI:  1,
+				maxENI:        4,
+				warmENITarget: 1,
+			}
+			mockContext.dataStore = testDatastore()
+			// The only ENI is full
+			assert.NoError(t, mockContext.dataStore.AddENI(primaryENIid, primaryDevice, true, false, false))
+			assert.NoError(t, mockContext.dataStore.AddIPv4CidrToStore(primaryENIid,
+				net.IPNet{IP: net.ParseIP(ipaddr01), Mask: net.IPv4Mask(255, 255, 255, 255)}, false))
+			if tc.allocErr != nil {
+				m.awsutils.EXPECT().AllocENI(false, nil, "").Return("", tc.allocErr)
+			} else {
+				mockContext.maxENI = 1
+			}
+
+			mockContext.increaseDatastorePool(context.Background())
+			miss := mockContext.warmTargetMiss.status()
+			if assert.NotNil(t, miss) {
+				assert.True(t, miss.Missing)
+				assert.Equal(t, tc.want, miss.Reason)
+			}
+			for _, reason := range warmTargetMissReasons {
+				want := float64(0)
+				if reason == tc.want {
+					want = 1
+				}
+				assert.Equal(t, want, testutil.ToFloat64(warmTargetMissed.WithLabelValues(reason)), reason)
+			}
+
+			// Once the target is met, the reason is kept but no longer reported as missing
+			mockContext.warmTargetMiss.met()
+			miss = mockContext.warmTargetMiss.status()
+			assert.False(t, miss.Missing)
+			assert.Equal(t, tc.want, miss.Reason)
+			assert.Equal(t, float64(0), testutil.ToFloat64(warmTargetMissed.WithLabelValues(tc.want)))
+		})
+	}
+}
@@ -5,10 +5,13 @@ import (
 
 	eniconfigscheme "github.com/aws/amazon-vpc-cni-k8s/pkg/apis/crd/v1alpha1"
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -79,6 +82,18 @@ func GetKubeClientSet() (kubernetes.Interface, error) {
 	return clientSet, nil
 }
 
+// CreateEventRecorder creates a recorder that posts events on behalf of the given component running on the given node.
+// Events are sent in the background, so recording one never blocks the caller.
+func CreateEventRecorder(component, nodeName string) (record.EventRecorder, error) {
+	clientSet, err := GetKubeClientSet()
+	if err != nil {
+		return nil, err
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")})
+	return broadcaster.NewRecorder(clientgoscheme.Scheme, corev1.EventSource{Component: component, Host: nodeName}), nil
+}
+
 func CheckAPIServerConnectivity() error {
 	restCfg, err := ctrl.GetConfig()
 	if err != nil {
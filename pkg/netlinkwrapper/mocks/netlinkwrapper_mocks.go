@@ -91,6 +91,21 @@ func (mr *MockNetLinkMockRecorder) AddrList(arg0, arg1 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddrList", reflect.TypeOf((*MockNetLink)(nil).AddrList), arg0, arg1)
 }
 
+// ConntrackDeleteFilter mocks base method
+func (m *MockNetLink) ConntrackDeleteFilter(arg0 netlink.ConntrackTableType, arg1 netlink.InetFamily, arg2 netlink.CustomConntrackFilter) (uint, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConntrackDeleteFilter", arg0, arg1, arg2)
+	ret0, _ := ret[0].(uint)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConntrackDeleteFilter indicates an expected call of ConntrackDeleteFilter
+func (mr *MockNetLinkMockRecorder) ConntrackDeleteFilter(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConntrackDeleteFilter", reflect.TypeOf((*MockNetLink)(nil).ConntrackDeleteFilter), arg0, arg1, arg2)
+}
+
 // LinkAdd mocks base method
 func (m *MockNetLink) LinkAdd(arg0 netlink.Link) error {
 	m.ctrl.T.Helper()
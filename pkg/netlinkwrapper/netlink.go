@@ -64,6 +64,8 @@ type NetLink interface {
 	RuleList(family int) ([]netlink.Rule, error)
 	// LinkSetMTU is equivalent to `ip link set dev $link mtu $mtu`
 	LinkSetMTU(link netlink.Link, mtu int) error
+	// ConntrackDeleteFilter is equivalent to `conntrack -D` with the flows selected by filter
+	ConntrackDeleteFilter(table netlink.ConntrackTableType, family netlink.InetFamily, filter netlink.CustomConntrackFilter) (uint, error)
 }
 
 type netLink struct {
@@ -158,6 +160,10 @@ func (*netLink) LinkSetMTU(link netlink.Link, mtu int) error {
 	return netlink.LinkSetMTU(link, mtu)
 }
 
+func (*netLink) ConntrackDeleteFilter(table netlink.ConntrackTableType, family netlink.InetFamily, filter netlink.CustomConntrackFilter) (uint, error) {
+	return netlink.ConntrackDeleteFilter(table, family, filter)
+}
+
 // IsNotExistsError returns true if the error type is syscall.ESRCH
 // This helps us determine if we should ignore this error as the route
 // that we want to cleanup has been deleted already routing table
@@ -50,6 +50,35 @@ func (m *MockNetworkAPIs) EXPECT() *MockNetworkAPIsMockRecorder {
 	return m.recorder
 }
 
+// DeleteConntrackEntries mocks base method
+func (m *MockNetworkAPIs) DeleteConntrackEntries(arg0 []net.IPNet) (uint, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteConntrackEntries", arg0)
+	ret0, _ := ret[0].(uint)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteConntrackEntries indicates an expected call of DeleteConntrackEntries
+func (mr *MockNetworkAPIsMockRecorder) DeleteConntrackEntries(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteConntrackEntries", reflect.TypeOf((*MockNetworkAPIs)(nil).DeleteConntrackEntries), arg0)
+}
+
+// DeleteRule mocks base method
+func (m *MockNetworkAPIs) DeleteRule(arg0 netlink.Rule) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRule", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteRule indicates an expected call of DeleteRule
+func (mr *MockNetworkAPIsMockRecorder) DeleteRule(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRule", reflect.TypeOf((*MockNetworkAPIs)(nil).DeleteRule), arg0)
+}
+
 // DeleteRuleListBySrc mocks base method
 func (m *MockNetworkAPIs) DeleteRuleListBySrc(arg0 net.IPNet) error {
 	m.ctrl.T.Helper()
@@ -165,6 +194,20 @@ func (mr *MockNetworkAPIsMockRecorder) UpdateHostIptablesRules(arg0, arg1, arg2
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateHostIptablesRules", reflect.TypeOf((*MockNetworkAPIs)(nil).UpdateHostIptablesRules), arg0, arg1, arg2)
 }
 
+// UpdatePodRoutes mocks base method
+func (m *MockNetworkAPIs) UpdatePodRoutes(arg0, arg1 string, arg2, arg3 []*net.IPNet) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePodRoutes", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePodRoutes indicates an expected call of UpdatePodRoutes
+func (mr *MockNetworkAPIsMockRecorder) UpdatePodRoutes(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePodRoutes", reflect.TypeOf((*MockNetworkAPIs)(nil).UpdatePodRoutes), arg0, arg1, arg2, arg3)
+}
+
 // UpdateRuleListBySrc mocks base method
 func (m *MockNetworkAPIs) UpdateRuleListBySrc(arg0 []netlink.Rule, arg1 net.IPNet) error {
 	m.ctrl.T.Helper()
@@ -35,6 +35,7 @@ import (
 
 	"github.com/aws/amazon-vpc-cni-k8s/pkg/utils/logger"
 
+	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/vishvananda/netlink"
 
@@ -47,13 +48,18 @@ const (
 	// Local rule, needs to come after the pod ENI rules
 	localRulePriority = 20
 
-	// 513 - 1023, can be used priority lower than toPodRulePriority but higher than default nonVPC CIDR rule
+	// ToPodRulePriority is the priority of the "to <pod IP> lookup main" rule the CNI plugin adds for each pod
+	ToPodRulePriority = 512
+
+	// 513 - 1023, can be used priority lower than ToPodRulePriority but higher than default nonVPC CIDR rule
 
 	// 1024 is reserved for (ip rule not to <VPC's subnet> table main)
 	hostRulePriority = 1024
 
-	// 1025 - 1535 can be used priority lower than fromPodRulePriority but higher than default nonVPC CIDR rule
-	fromPodRulePriority = 1536
+	// 1025 - 1535 can be used priority lower than FromPodRulePriority but higher than default nonVPC CIDR rule
+
+	// FromPodRulePriority is the priority of the "from <pod IP> lookup <ENI table>" rule of the pods of secondary ENIs
+	FromPodRulePriority = 1536
 
 	// Main route table
 	mainRoutingTable = unix.RT_TABLE_MAIN
@@ -102,6 +108,16 @@ const (
 	// envMTU gives a way to configure the MTU size for new ENIs attached. Range is from 576 to 9001.
 	envMTU = "AWS_VPC_ENI_MTU"
 
+	// envTCPMSSClamp clamps the MSS of TCP connections opened by or to pods. "pmtu" clamps it to the path MTU of the
+	// route out of the node, a number sets it to a fixed value, which is needed when the low MTU link is further down
+	// the path, e.g. a VPN or a peering connection. Empty, the default, disables clamping.
+	envTCPMSSClamp = "AWS_VPC_K8S_CNI_TCP_MSS_CLAMP"
+	// tcpMSSClampPMTU is the envTCPMSSClamp value that clamps the MSS to the path MTU
+	tcpMSSClampPMTU = "pmtu"
+	// Range of fixed MSS values, the MSS of the minimum and maximum MTUs less the IPv4 and TCP headers
+	minimumTCPMSS = minimumMTU - 40
+	maximumTCPMSS = maximumMTU - 40
+
 	// envVethPrefix is the environment variable to configure the prefix of the host side veth device names
 	envVethPrefix = "AWS_VPC_K8S_CNI_VETHPREFIX"
 
@@ -139,7 +155,12 @@ type NetworkAPIs interface {
 	GetRuleListBySrc(ruleList []netlink.Rule, src net.IPNet) ([]netlink.Rule, error)
 	UpdateRuleListBySrc(ruleList []netlink.Rule, src net.IPNet) error
 	DeleteRuleListBySrc(src net.IPNet) error
+	DeleteRule(rule netlink.Rule) error
 	GetLinkByMac(mac string, retryInterval time.Duration) (netlink.Link, error)
+	// DeleteConntrackEntries deletes the conntrack flows that have an address in any of the given CIDRs
+	DeleteConntrackEntries(cidrs []net.IPNet) (uint, error)
+	// UpdatePodRoutes adds and deletes extra routes of a running pod in its network namespace
+	UpdatePodRoutes(netnsPath string, ifName string, add []*net.IPNet, del []*net.IPNet) error
 }
 
 type linuxNetwork struct {
@@ -150,6 +171,8 @@ type linuxNetwork struct {
 	shouldConfigureRpFilter bool
 	mtu                     int
 	vethPrefix              string
+	// tcpMSSClamp is the validated envTCPMSSClamp value, empty when clamping is disabled
+	tcpMSSClamp string
 
 	netLink     netlinkwrapper.NetLink
 	ns          nswrapper.NS
@@ -190,6 +213,7 @@ func New() NetworkAPIs {
 		mainENIMark:             getConnmark(),
 		mtu:                     GetEthernetMTU(""),
 		vethPrefix:              getVethPrefixName(),
+		tcpMSSClamp:             getTCPMSSClamp(),
 
 		netLink: netlinkwrapper.NewNetLink(),
 		ns:      nswrapper.NewNS(),
@@ -342,6 +366,14 @@ func (n *linuxNetwork) updateHostIptablesRules(vpcCIDRs []string, primaryMAC str
 	if err := n.updateIptablesRules(iptablesConnmarkRules, ipt); err != nil {
 		return err
 	}
+
+	iptablesMSSClampRules, err := n.buildIptablesMSSClampRules(ipt)
+	if err != nil {
+		return err
+	}
+	if err := n.updateIptablesRules(iptablesMSSClampRules, ipt); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -572,6 +604,62 @@ func (n *linuxNetwork) buildIptablesConnmarkRules(vpcCIDRs []string, ipt iptable
 	return iptableRules, nil
 }
 
+// buildIptablesMSSClampRules returns the rules that clamp the MSS of the SYN and SYN-ACK packets sent by or to pods. The
+// TCPMSS rule lives in its own chain so that a rule left behind by a previous value is cleaned up as a stale rule.
+func (n *linuxNetwork) buildIptablesMSSClampRules(ipt iptablesIface) ([]iptablesRule, error) {
+	const chain = "AWS-MSS-CLAMP-CHAIN-0"
+	log.Debugf("Setup Host Network: iptables -N %s -t mangle", chain)
+	if err := ipt.NewChain("mangle", chain); err != nil && !containChainExistErr(err) {
+		log.Errorf("ipt.NewChain error for chain [%s]: %v", chain, err)
+		return []iptablesRule{}, errors.Wrapf(err, "host network setup: failed to add chain")
+	}
+
+	var iptableRules []iptablesRule
+	iptableRules = append(iptableRules, iptablesRule{
+		name:        "MSS clamp jump for pod TCP handshakes",
+		shouldExist: n.tcpMSSClamp != "",
+		table:       "mangle",
+		chain:       "FORWARD",
+		rule: []string{
+			"-i", n.vethPrefix + "+", "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN",
+			"-m", "comment", "--comment", "AWS, MSS clamp", "-j", chain,
+		}}, iptablesRule{
+		name:        "MSS clamp jump for TCP handshakes to pods",
+		shouldExist: n.tcpMSSClamp != "",
+		table:       "mangle",
+		chain:       "FORWARD",
+		rule: []string{
+			"-o", n.vethPrefix + "+", "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN",
+			"-m", "comment", "--comment", "AWS, MSS clamp", "-j", chain,
+		}})
+
+	if n.tcpMSSClamp != "" {
+		rule := []string{"-m", "comment", "--comment", "AWS, MSS clamp", "-j", "TCPMSS"}
+		if n.tcpMSSClamp == tcpMSSClampPMTU {
+			rule = append(rule, "--clamp-mss-to-pmtu")
+		} else {
+			rule = append(rule, "--set-mss", n.tcpMSSClamp)
+		}
+		log.Debugf("Setup Host Network: iptables -t mangle -A %s %s", chain, strings.Join(rule, " "))
+		iptableRules = append(iptableRules, iptablesRule{
+			name:        "MSS clamp",
+			shouldExist: true,
+			table:       "mangle",
+			chain:       chain,
+			rule:        rule,
+		})
+	}
+
+	mssClampStaleRules, err := computeStaleIptablesRules(ipt, "mangle", "AWS-MSS-CLAMP-CHAIN", iptableRules, []string{chain})
+	if err != nil {
+		return []iptablesRule{}, err
+	}
+	iptableRules = append(iptableRules, mssClampStaleRules...)
+
+	log.Debugf("iptableRules: %v", iptableRules)
+	return iptableRules, nil
+}
+
 func (n *linuxNetwork) updateIptablesRules(iptableRules []iptablesRule, ipt iptablesIface) error {
 	for _, rule := range iptableRules {
 		log.Debugf("execute iptable rule : %s", rule.name)
@@ -704,6 +792,7 @@ func GetConfigForDebug() map[string]interface{} {
 		envVethPrefix:        getVethPrefixName(),
 		envNodePortSupport:   nodePortSupportEnabled(),
 		envRandomizeSNAT:     typeOfSNAT(),
+		envTCPMSSClamp:       getTCPMSSClamp(),
 	}
 }
 
@@ -805,6 +894,26 @@ func getConnmark() uint32 {
 	return defaultConnmark
 }
 
+// getTCPMSSClamp returns the validated AWS_VPC_K8S_CNI_TCP_MSS_CLAMP value, either "pmtu" or a fixed MSS. Invalid
+// values disable clamping.
+func getTCPMSSClamp() string {
+	value := strings.TrimSpace(os.Getenv(envTCPMSSClamp))
+	if value == "" || strings.EqualFold(value, tcpMSSClampPMTU) {
+		return strings.ToLower(value)
+	}
+	mss, err := strconv.Atoi(value)
+	if err != nil {
+		log.Errorf("Failed to parse %s %q, must be %q or a number; MSS clamping disabled", envTCPMSSClamp, value, tcpMSSClampPMTU)
+		return ""
+	}
+	if mss < minimumTCPMSS || mss > maximumTCPMSS {
+		log.Errorf("%s %d out of range, must be between %d and %d; MSS clamping disabled", envTCPMSSClamp, mss,
+			minimumTCPMSS, maximumTCPMSS)
+		return ""
+	}
+	return strconv.Itoa(mss)
+}
+
 // GetLinkByMac returns linux netlink based on interface MAC
 func (n *linuxNetwork) GetLinkByMac(mac string, retryInterval time.Duration) (netlink.Link, error) {
 	return linkByMac(mac, n.netLink, retryInterval)
@@ -1027,6 +1136,72 @@ func (n *linuxNetwork) DeleteRuleListBySrc(src net.IPNet) error {
 	return nil
 }
 
+// DeleteRule deletes the IP rule, a rule that is already gone is not an error
+func (n *linuxNetwork) DeleteRule(rule netlink.Rule) error {
+	if err := n.netLink.RuleDel(&rule); err != nil && !containsNoSuchRule(err) {
+		return errors.Wrapf(err, "DeleteRule: failed to delete rule %v", rule)
+	}
+	return nil
+}
+
+// DeleteConntrackEntries deletes the conntrack flows to or from an address in any of the given CIDRs, so that flows
+// SNATed to an address that is no longer on the node don't linger
+func (n *linuxNetwork) DeleteConntrackEntries(cidrs []net.IPNet) (uint, error) {
+	if len(cidrs) == 0 {
+		return 0, nil
+	}
+	deleted, err := n.netLink.ConntrackDeleteFilter(netlink.ConntrackTable, unix.AF_INET, cidrConntrackFilter(cidrs))
+	if err != nil {
+		return deleted, errors.Wrapf(err, "DeleteConntrackEntries: failed to delete conntrack entries for %v", cidrs)
+	}
+	log.Infof("Deleted %d conntrack entries for %v", deleted, cidrs)
+	return deleted, nil
+}
+
+// UpdatePodRoutes replaces the routes to add and deletes the ones to delete in the network namespace of a running pod.
+// They go through the same dummy next hop (169.254.1.1) on the pod interface as the routes the CNI plugin programs
+// on ADD. A route to delete that is already gone is not an error.
+func (n *linuxNetwork) UpdatePodRoutes(netnsPath string, ifName string, add []*net.IPNet, del []*net.IPNet) error {
+	gw := net.IPv4(169, 254, 1, 1)
+	return n.ns.WithNetNSPath(netnsPath, func(ns.NetNS) error {
+		link, err := n.netLink.LinkByName(ifName)
+		if err != nil {
+			return errors.Wrapf(err, "UpdatePodRoutes: failed to find link %s", ifName)
+		}
+		for _, dst := range del {
+			err := n.netLink.RouteDel(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Gw: gw})
+			if err != nil && !errors.Is(err, syscall.ESRCH) {
+				return errors.Wrapf(err, "UpdatePodRoutes: failed to delete route to %s", dst)
+			}
+		}
+		for _, dst := range add {
+			if err := n.netLink.RouteReplace(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Gw: gw}); err != nil {
+				return errors.Wrapf(err, "UpdatePodRoutes: failed to replace route to %s", dst)
+			}
+		}
+		return nil
+	})
+}
+
+// cidrConntrackFilter matches the conntrack flows that have an address in one of its CIDRs as a source or destination,
+// in either direction. netlink.ConntrackFilter ANDs its conditions, so it cannot express this.
+type cidrConntrackFilter []net.IPNet
+
+func (f cidrConntrackFilter) contains(ip net.IP) bool {
+	for _, cidr := range f {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchConntrackFlow implements netlink.CustomConntrackFilter
+func (f cidrConntrackFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	return f.contains(flow.Forward.SrcIP) || f.contains(flow.Forward.DstIP) ||
+		f.contains(flow.Reverse.SrcIP) || f.contains(flow.Reverse.DstIP)
+}
+
 // UpdateRuleListBySrc modify IP rules that have a matching source IP
 func (n *linuxNetwork) UpdateRuleListBySrc(ruleList []netlink.Rule, src net.IPNet) error {
 	log.Debugf("Update Rule List[%v] for source[%v] ", ruleList, src)
@@ -1061,7 +1236,7 @@ func (n *linuxNetwork) UpdateRuleListBySrc(ruleList []netlink.Rule, src net.IPNe
 
 	podRule.Src = &src
 	podRule.Table = srcRuleTable
-	podRule.Priority = fromPodRulePriority
+	podRule.Priority = FromPodRulePriority
 
 	err = n.netLink.RuleAdd(podRule)
 	if err != nil {
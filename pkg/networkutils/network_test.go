@@ -20,9 +20,11 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
@@ -218,6 +220,102 @@ func TestUpdateRuleListBySrc(t *testing.T) {
 	}
 }
 
+func TestDeleteRule(t *testing.T) {
+	ctrl, mockNetLink, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{netLink: mockNetLink}
+	rule := netlink.Rule{Src: testENINetIPNet, Table: testTable, Priority: FromPodRulePriority}
+
+	mockNetLink.EXPECT().RuleDel(&rule).Return(nil)
+	assert.NoError(t, ln.DeleteRule(rule))
+
+	// A rule that is already gone is not an error
+	mockNetLink.EXPECT().RuleDel(&rule).Return(syscall.ENOENT)
+	assert.NoError(t, ln.DeleteRule(rule))
+
+	mockNetLink.EXPECT().RuleDel(&rule).Return(syscall.EPERM)
+	assert.Error(t, ln.DeleteRule(rule))
+}
+
+func TestDeleteConntrackEntries(t *testing.T) {
+	ctrl, mockNetLink, _, _, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{netLink: mockNetLink}
+
+	_, eniIP, _ := net.ParseCIDR("10.10.10.20/32")
+	_, eniPrefix, _ := net.ParseCIDR("10.10.30.0/28")
+	flow := func(origSrc, origDst, replySrc, replyDst string) *netlink.ConntrackFlow {
+		f := &netlink.ConntrackFlow{}
+		f.Forward.SrcIP = net.ParseIP(origSrc)
+		f.Forward.DstIP = net.ParseIP(origDst)
+		f.Reverse.SrcIP = net.ParseIP(replySrc)
+		f.Reverse.DstIP = net.ParseIP(replyDst)
+		return f
+	}
+	flows := []*netlink.ConntrackFlow{
+		// Pod to remote, SNATed to the pod IP
+		flow("10.10.10.20", "52.1.1.1", "52.1.1.1", "10.10.10.20"),
+		// Remote to a pod in the prefix
+		flow("10.20.0.5", "10.10.30.7", "10.10.30.7", "10.20.0.5"),
+		// DNATed to a pod IP, only the reply tuple has it
+		flow("10.20.0.5", "172.20.0.10", "10.10.10.20", "10.20.0.5"),
+		// Unrelated
+		flow("10.10.10.21", "52.1.1.1", "52.1.1.1", "10.10.10.21"),
+		flow("10.10.30.16", "52.1.1.1", "52.1.1.1", "10.10.30.16"),
+	}
+
+	var matched []*netlink.ConntrackFlow
+	mockNetLink.EXPECT().ConntrackDeleteFilter(netlink.ConntrackTableType(netlink.ConntrackTable), netlink.InetFamily(unix.AF_INET), gomock.Any()).DoAndReturn(
+		func(_ netlink.ConntrackTableType, _ netlink.InetFamily, filter netlink.CustomConntrackFilter) (uint, error) {
+			for _, f := range flows {
+				if filter.MatchConntrackFlow(f) {
+					matched = append(matched, f)
+				}
+			}
+			return uint(len(matched)), nil
+		})
+
+	deleted, err := ln.DeleteConntrackEntries([]net.IPNet{*eniIP, *eniPrefix})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), deleted)
+	assert.Equal(t, flows[:3], matched)
+
+	// Nothing to delete, netlink is not called
+	deleted, err = ln.DeleteConntrackEntries(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(0), deleted)
+
+	mockNetLink.EXPECT().ConntrackDeleteFilter(netlink.ConntrackTableType(netlink.ConntrackTable), netlink.InetFamily(unix.AF_INET), gomock.Any()).Return(uint(0), errors.New("netlink error"))
+	_, err = ln.DeleteConntrackEntries([]net.IPNet{*eniIP})
+	assert.Error(t, err)
+}
+
+func TestUpdatePodRoutes(t *testing.T) {
+	ctrl, mockNetLink, _, mockNS, _, _ := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{netLink: mockNetLink, ns: mockNS}
+	const netnsPath = "/var/run/netns/cni-1"
+	mockNS.EXPECT().WithNetNSPath(netnsPath, gomock.Any()).DoAndReturn(
+		func(_ string, toRun func(ns.NetNS) error) error { return toRun(nil) }).Times(2)
+	eth0 := mock_netlink.NewMockLink(ctrl)
+	eth0.EXPECT().Attrs().Return(&netlink.LinkAttrs{Index: 3}).AnyTimes()
+	mockNetLink.EXPECT().LinkByName("eth0").Return(eth0, nil).Times(2)
+
+	_, added, _ := net.ParseCIDR("10.1.0.0/16")
+	_, deleted, _ := net.ParseCIDR("10.2.0.0/16")
+	gw := net.IPv4(169, 254, 1, 1)
+	// A route already gone is not an error
+	mockNetLink.EXPECT().RouteDel(&netlink.Route{LinkIndex: 3, Dst: deleted, Gw: gw}).Return(syscall.ESRCH)
+	mockNetLink.EXPECT().RouteReplace(&netlink.Route{LinkIndex: 3, Dst: added, Gw: gw}).Return(nil)
+	assert.NoError(t, ln.UpdatePodRoutes(netnsPath, "eth0", []*net.IPNet{added}, []*net.IPNet{deleted}))
+
+	mockNetLink.EXPECT().RouteReplace(gomock.Any()).Return(errors.New("netlink error"))
+	assert.Error(t, ln.UpdatePodRoutes(netnsPath, "eth0", []*net.IPNet{added}, nil))
+}
+
 func TestSetupHostNetworkNodePortEnabled(t *testing.T) {
 	ctrl, mockNetLink, _, mockNS, mockIptables, mockProcSys := setup(t)
 	defer ctrl.Finish()
@@ -697,6 +795,72 @@ func TestUpdateHostIptablesRules(t *testing.T) {
 			},
 		}, mockIptables.dataplaneState)
 }
+func TestUpdateHostIptablesRulesTCPMSSClamp(t *testing.T) {
+	ctrl, mockNetLink, _, mockNS, mockIptables, mockProcSys := setup(t)
+	defer ctrl.Finish()
+
+	ln := &linuxNetwork{
+		useExternalSNAT: true,
+		mainENIMark:     defaultConnmark,
+		mtu:             testMTU,
+		vethPrefix:      eniPrefix,
+		tcpMSSClamp:     tcpMSSClampPMTU,
+
+		netLink: mockNetLink,
+		ns:      mockNS,
+		newIptables: func() (iptablesIface, error) {
+			return mockIptables, nil
+		},
+		procSys: mockProcSys,
+	}
+
+	// SYNs sent by pods and SYN-ACKs sent to them, and the other way around
+	jumpRules := [][]string{
+		{"-i", "eni+", "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-m", "comment", "--comment", "AWS, MSS clamp", "-j", "AWS-MSS-CLAMP-CHAIN-0"},
+		{"-o", "eni+", "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-m", "comment", "--comment", "AWS, MSS clamp", "-j", "AWS-MSS-CLAMP-CHAIN-0"},
+	}
+	vpcCIDRs := []string{"10.10.0.0/16"}
+	err := ln.UpdateHostIptablesRules(vpcCIDRs, loopback, &testENINetIP)
+	assert.NoError(t, err)
+	assert.Equal(t, jumpRules, mockIptables.dataplaneState["mangle"]["FORWARD"])
+	assert.Equal(t, [][]string{{"-m", "comment", "--comment", "AWS, MSS clamp", "-j", "TCPMSS", "--clamp-mss-to-pmtu"}},
+		mockIptables.dataplaneState["mangle"]["AWS-MSS-CLAMP-CHAIN-0"])
+
+	// Switching to a fixed MSS replaces the previous rule
+	ln.tcpMSSClamp = "1360"
+	err = ln.UpdateHostIptablesRules(vpcCIDRs, loopback, &testENINetIP)
+	assert.NoError(t, err)
+	assert.Equal(t, jumpRules, mockIptables.dataplaneState["mangle"]["FORWARD"])
+	assert.Equal(t, [][]string{{"-m", "comment", "--comment", "AWS, MSS clamp", "-j", "TCPMSS", "--set-mss", "1360"}},
+		mockIptables.dataplaneState["mangle"]["AWS-MSS-CLAMP-CHAIN-0"])
+
+	// Disabling clamping removes the rules
+	ln.tcpMSSClamp = ""
+	err = ln.UpdateHostIptablesRules(vpcCIDRs, loopback, &testENINetIP)
+	assert.NoError(t, err)
+	assert.Empty(t, mockIptables.dataplaneState["mangle"]["FORWARD"])
+	assert.Empty(t, mockIptables.dataplaneState["mangle"]["AWS-MSS-CLAMP-CHAIN-0"])
+}
+
+func TestGetTCPMSSClamp(t *testing.T) {
+	defer os.Unsetenv(envTCPMSSClamp)
+
+	for value, expected := range map[string]string{
+		"":      "",
+		"pmtu":  tcpMSSClampPMTU,
+		"PMTU":  tcpMSSClampPMTU,
+		"1360":  "1360",
+		" 536 ": "536",
+		"8961":  "8961",
+		"535":   "",
+		"8962":  "",
+		"mtu":   "",
+	} {
+		_ = os.Setenv(envTCPMSSClamp, value)
+		assert.Equal(t, expected, getTCPMSSClamp(), value)
+	}
+}
+
 func TestSetupHostNetworkMultipleCIDRs(t *testing.T) {
 	ctrl, mockNetLink, _, mockNS, mockIptables, mockProcSys := setup(t)
 	defer ctrl.Finish()
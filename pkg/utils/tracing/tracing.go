@@ -0,0 +1,169 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package tracing wraps OpenTelemetry so allocation paths can emit spans. Until Init installs an exporter, the global
+// tracer provider is the OpenTelemetry no-op provider and starting spans costs next to nothing.
+package tracing
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	envEnableTracing       = "ENABLE_TRACING"
+	envTracingEndpoint     = "TRACING_EXPORTER_ENDPOINT"
+	defaultTracingEndpoint = "localhost:4317"
+
+	tracerName = "github.com/aws/amazon-vpc-cni-k8s"
+)
+
+// Span attribute keys shared by the allocation paths
+const (
+	AttrENIID        = attribute.Key("aws.eni.id")
+	AttrSubnetID     = attribute.Key("aws.subnet.id")
+	AttrIPv4Addr     = attribute.Key("aws.ipv4.address")
+	AttrIPCount      = attribute.Key("aws.ipv4.count")
+	AttrPodName      = attribute.Key("k8s.pod.name")
+	AttrPodNamespace = attribute.Key("k8s.namespace.name")
+	AttrContainerID  = attribute.Key("container.id")
+)
+
+// Enabled returns true if ENABLE_TRACING is set to true
+func Enabled() bool {
+	if strValue := os.Getenv(envEnableTracing); strValue != "" {
+		enabled, err := strconv.ParseBool(strValue)
+		if err == nil {
+			return enabled
+		}
+	}
+	return false
+}
+
+// GetExporterEndpoint returns the OTLP gRPC endpoint spans are exported to
+func GetExporterEndpoint() string {
+	endpoint := os.Getenv(envTracingEndpoint)
+	if endpoint == "" {
+		endpoint = defaultTracingEndpoint
+	}
+	return endpoint
+}
+
+// Init sets up the global tracer provider with an OTLP exporter when tracing is enabled. The returned function flushes
+// and stops the exporter; it is safe to call when tracing is disabled.
+func Init(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	if !Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	return InitWithEndpoint(ctx, GetExporterEndpoint(), serviceName)
+}
+
+// InitWithEndpoint sets up the global tracer provider with an OTLP exporter sending to endpoint, for the CNI plugin
+// that gets its settings from the CNI config instead of the environment. The returned function flushes and stops the
+// exporter.
+func InitWithEndpoint(ctx context.Context, endpoint, serviceName string) (func(context.Context) error, error) {
+	exporter, err := otlp.NewExporter(ctx, otlpgrpc.NewDriver(
+		otlpgrpc.WithEndpoint(endpoint),
+		otlpgrpc.WithInsecure(),
+	))
+	if err != nil {
+		return nil, errors.Wrap(err, "tracing: failed to create OTLP exporter")
+	}
+	return Setup(sdktrace.WithBatcher(exporter), serviceName), nil
+}
+
+// Setup installs a tracer provider built around the given span processor option as the global provider and returns
+// its shutdown function. Tests use it with an in-memory exporter.
+func Setup(processor sdktrace.TracerProviderOption, serviceName string) func(context.Context) error {
+	provider := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown
+}
+
+// StartSpan starts a span as a child of the span in ctx, if any
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan marks the span as failed if err is set, then ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ExtractGRPC returns a context carrying the remote span context sent by the gRPC client, so spans started while
+// handling the request join the caller's trace.
+func ExtractGRPC(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+}
+
+// InjectGRPC returns a context whose outgoing gRPC metadata carries the span context of ctx, for the server to
+// continue the trace with ExtractGRPC
+func InjectGRPC(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// metadataCarrier adapts gRPC metadata to the OpenTelemetry TextMapCarrier interface
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	values := metadata.MD(m).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (m metadataCarrier) Set(key string, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
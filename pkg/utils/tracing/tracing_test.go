@@ -0,0 +1,154 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func setupInMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := Setup(sdktrace.WithSyncer(exporter), "test")
+	t.Cleanup(func() {
+		_ = shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+	return exporter
+}
+
+func TestEnabled(t *testing.T) {
+	defer os.Unsetenv(envEnableTracing)
+
+	os.Unsetenv(envEnableTracing)
+	assert.False(t, Enabled())
+
+	os.Setenv(envEnableTracing, "true")
+	assert.True(t, Enabled())
+
+	os.Setenv(envEnableTracing, "yes please")
+	assert.False(t, Enabled())
+}
+
+func TestGetExporterEndpoint(t *testing.T) {
+	defer os.Unsetenv(envTracingEndpoint)
+
+	os.Unsetenv(envTracingEndpoint)
+	assert.Equal(t, defaultTracingEndpoint, GetExporterEndpoint())
+
+	os.Setenv(envTracingEndpoint, "otel-collector:4317")
+	assert.Equal(t, "otel-collector:4317", GetExporterEndpoint())
+}
+
+func TestInitDisabled(t *testing.T) {
+	os.Unsetenv(envEnableTracing)
+	shutdown, err := Init(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	// The global provider stays a no-op, so spans are not recorded
+	_, span := StartSpan(context.Background(), "noop")
+	assert.False(t, span.IsRecording())
+	span.End()
+}
+
+func TestStartAndEndSpan(t *testing.T) {
+	exporter := setupInMemoryTracing(t)
+
+	ctx, parent := StartSpan(context.Background(), "parent", AttrENIID.String("eni-1"))
+	_, child := StartSpan(ctx, "child")
+	EndSpan(child, errors.New("boom"))
+	EndSpan(parent, nil)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].StatusCode)
+	assert.Equal(t, "boom", spans[0].StatusMessage)
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.Contains(t, spans[1].Attributes, AttrENIID.String("eni-1"))
+}
+
+func TestExtractGRPC(t *testing.T) {
+	exporter := setupInMemoryTracing(t)
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	md := metadata.Pairs("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	ctx := ExtractGRPC(metadata.NewIncomingContext(context.Background(), md))
+	_, span := StartSpan(ctx, "AddNetwork")
+	span.End()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, traceID, spans[0].SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+}
+
+func TestExtractGRPCWithoutMetadata(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, ctx, ExtractGRPC(ctx))
+}
+
+func TestInjectGRPC(t *testing.T) {
+	exporter := setupInMemoryTracing(t)
+
+	// A server that starts a span for each request, like ipamd does
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := StartSpan(ExtractGRPC(ctx), "server")
+		defer span.End()
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx, client := StartSpan(context.Background(), "client")
+	ctx = InjectGRPC(metadata.AppendToOutgoingContext(ctx, "key", "value"))
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	client.End()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "server", spans[0].Name)
+	assert.Equal(t, spans[1].SpanContext.TraceID(), spans[0].SpanContext.TraceID())
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.True(t, spans[0].Parent.IsRemote())
+	// The metadata already set on the context is kept
+	md, _ := metadata.FromOutgoingContext(ctx)
+	assert.Equal(t, []string{"value"}, md.Get("key"))
+}
@@ -126,10 +126,18 @@ type AddNetworkReply struct {
 	UseExternalSNAT bool     `protobuf:"varint,5,opt,name=UseExternalSNAT,proto3" json:"UseExternalSNAT,omitempty"`
 	VPCcidrs        []string `protobuf:"bytes,6,rep,name=VPCcidrs,proto3" json:"VPCcidrs,omitempty"`
 	// start of pod-eni parameters
-	PodVlanId            int32    `protobuf:"varint,7,opt,name=PodVlanId,proto3" json:"PodVlanId,omitempty"`
-	PodENIMAC            string   `protobuf:"bytes,8,opt,name=PodENIMAC,proto3" json:"PodENIMAC,omitempty"`
-	PodENISubnetGW       string   `protobuf:"bytes,9,opt,name=PodENISubnetGW,proto3" json:"PodENISubnetGW,omitempty"`
-	ParentIfIndex        int32    `protobuf:"varint,10,opt,name=ParentIfIndex,proto3" json:"ParentIfIndex,omitempty"`
+	PodVlanId      int32  `protobuf:"varint,7,opt,name=PodVlanId,proto3" json:"PodVlanId,omitempty"`
+	PodENIMAC      string `protobuf:"bytes,8,opt,name=PodENIMAC,proto3" json:"PodENIMAC,omitempty"`
+	PodENISubnetGW string `protobuf:"bytes,9,opt,name=PodENISubnetGW,proto3" json:"PodENISubnetGW,omitempty"`
+	ParentIfIndex  int32  `protobuf:"varint,10,opt,name=ParentIfIndex,proto3" json:"ParentIfIndex,omitempty"`
+	// number of tx/rx queues on the pod interface, 0 keeps the kernel default
+	NumQueues int32 `protobuf:"varint,11,opt,name=NumQueues,proto3" json:"NumQueues,omitempty"`
+	// extra IPv4 CIDRs to route via the pod's default gateway, from matching PodRoutes
+	PodRoutes []string `protobuf:"bytes,12,rep,name=PodRoutes,proto3" json:"PodRoutes,omitempty"`
+	// pin the pod's source IPs with strict reverse path filtering on its host veth
+	PodSourceValidation bool `protobuf:"varint,13,opt,name=PodSourceValidation,proto3" json:"PodSourceValidation,omitempty"`
+	// hardware address of the pod interface, empty keeps the kernel assigned one
+	PodInterfaceMAC      string   `protobuf:"bytes,14,opt,name=PodInterfaceMAC,proto3" json:"PodInterfaceMAC,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -223,6 +231,34 @@ func (m *AddNetworkReply) GetParentIfIndex() int32 {
 	return 0
 }
 
+func (m *AddNetworkReply) GetNumQueues() int32 {
+	if m != nil {
+		return m.NumQueues
+	}
+	return 0
+}
+
+func (m *AddNetworkReply) GetPodRoutes() []string {
+	if m != nil {
+		return m.PodRoutes
+	}
+	return nil
+}
+
+func (m *AddNetworkReply) GetPodSourceValidation() bool {
+	if m != nil {
+		return m.PodSourceValidation
+	}
+	return false
+}
+
+func (m *AddNetworkReply) GetPodInterfaceMAC() string {
+	if m != nil {
+		return m.PodInterfaceMAC
+	}
+	return ""
+}
+
 type DelNetworkRequest struct {
 	ClientVersion              string   `protobuf:"bytes,9,opt,name=ClientVersion,proto3" json:"ClientVersion,omitempty"`
 	K8S_POD_NAME               string   `protobuf:"bytes,1,opt,name=K8S_POD_NAME,json=K8SPODNAME,proto3" json:"K8S_POD_NAME,omitempty"`
@@ -394,39 +430,43 @@ func init() {
 }
 
 var fileDescriptor_77a6da22d6a3feb1 = []byte{
-	// 507 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x94, 0xc1, 0x6e, 0xd3, 0x4e,
-	0x10, 0xc6, 0x9b, 0xa4, 0x71, 0x92, 0xf9, 0xf7, 0x4f, 0x94, 0x55, 0x15, 0xad, 0x22, 0x0e, 0x91,
-	0x85, 0x50, 0xc5, 0xa1, 0x07, 0xe0, 0x50, 0x21, 0x2e, 0xc6, 0x36, 0x68, 0x55, 0x75, 0x63, 0xd9,
-	0x25, 0x1c, 0x23, 0xc7, 0x9e, 0x4a, 0x56, 0xdd, 0xb5, 0x59, 0x3b, 0xa5, 0x7d, 0x03, 0x78, 0x20,
-	0xc4, 0x81, 0x97, 0x43, 0x5e, 0x3b, 0xb5, 0xe3, 0xa0, 0x72, 0xe1, 0xc0, 0x71, 0xbe, 0xf9, 0x3e,
-	0x8d, 0x66, 0xfc, 0xf3, 0xc2, 0x48, 0xa6, 0xc1, 0x69, 0x2a, 0x93, 0x3c, 0x21, 0x3d, 0x99, 0x06,
-	0xfa, 0xf7, 0x2e, 0x4c, 0x8c, 0x30, 0xe4, 0x98, 0x7f, 0x49, 0xe4, 0xb5, 0x8b, 0x9f, 0x37, 0x98,
-	0xe5, 0xe4, 0x19, 0xfc, 0x6f, 0xc6, 0x11, 0x8a, 0x7c, 0x89, 0x32, 0x8b, 0x12, 0x41, 0x87, 0xf3,
-	0xce, 0xc9, 0xc8, 0xdd, 0x15, 0xc9, 0x1c, 0x8e, 0xce, 0xcf, 0xbc, 0x95, 0xb3, 0xb0, 0x56, 0xdc,
-	0xb8, 0xb0, 0x69, 0x47, 0x99, 0xe0, 0xfc, 0xcc, 0x73, 0x16, 0x56, 0xa1, 0x90, 0x17, 0x30, 0x69,
-	0x3a, 0x3c, 0xc7, 0x30, 0x6d, 0xda, 0x55, 0xb6, 0x71, 0x6d, 0x53, 0x32, 0x79, 0x03, 0xb3, 0xad,
-	0x97, 0xf1, 0xf7, 0xae, 0xb1, 0x32, 0x17, 0xfc, 0xd2, 0x60, 0xdc, 0x76, 0x57, 0xcc, 0xa2, 0x3d,
-	0x15, 0x9a, 0x96, 0x21, 0xd5, 0x7f, 0x68, 0x33, 0x8b, 0xcc, 0xe1, 0x3f, 0x33, 0x11, 0xb9, 0x1f,
-	0x09, 0x94, 0xcc, 0xa2, 0x03, 0x65, 0x6e, 0x4a, 0x64, 0x0a, 0x1a, 0xbb, 0xe2, 0xfe, 0x0d, 0xd2,
-	0xbe, 0x6a, 0x56, 0x55, 0x91, 0xac, 0x76, 0x57, 0x4d, 0xad, 0x4c, 0x36, 0x24, 0x72, 0x0c, 0x7d,
-	0x8e, 0xb9, 0xc8, 0xe8, 0xa1, 0xea, 0x95, 0x85, 0xfe, 0xb3, 0x0b, 0xe3, 0xe6, 0xdd, 0xd2, 0xf8,
-	0x9e, 0x50, 0x18, 0x78, 0x9b, 0x20, 0xc0, 0x2c, 0x53, 0xa7, 0x18, 0xba, 0xdb, 0x92, 0xcc, 0x60,
-	0xc8, 0x9c, 0xdb, 0xd7, 0x46, 0x18, 0xca, 0x6a, 0xfd, 0x87, 0x9a, 0xe8, 0x70, 0x64, 0xe1, 0x6d,
-	0x14, 0x20, 0xdf, 0xdc, 0xac, 0x51, 0xaa, 0x31, 0x7d, 0x77, 0x47, 0x23, 0x27, 0x30, 0xfe, 0x98,
-	0xa1, 0x7d, 0x97, 0xa3, 0x14, 0x7e, 0xec, 0x71, 0xe3, 0x52, 0xad, 0x31, 0x74, 0xdb, 0x72, 0x31,
-	0x69, 0xe9, 0x98, 0x41, 0x14, 0xca, 0x8c, 0x6a, 0xf3, 0x5e, 0x31, 0x69, 0x5b, 0x93, 0xa7, 0x30,
-	0x72, 0x92, 0x70, 0x19, 0xfb, 0x82, 0x85, 0xea, 0x46, 0x7d, 0xb7, 0x16, 0xaa, 0xae, 0xcd, 0xd9,
-	0x85, 0x61, 0x56, 0xdf, 0xbb, 0x16, 0xc8, 0x73, 0x78, 0x52, 0x16, 0xde, 0x66, 0x2d, 0x30, 0xff,
-	0xf0, 0x89, 0x8e, 0x94, 0xa5, 0xa5, 0x16, 0xe4, 0x38, 0xbe, 0x44, 0x91, 0xb3, 0x2b, 0x26, 0x42,
-	0xbc, 0xa3, 0xa0, 0xe6, 0xec, 0x8a, 0xfa, 0x8f, 0x2e, 0x4c, 0x2c, 0x8c, 0xff, 0x44, 0xdd, 0xe8,
-	0xdf, 0xa6, 0x6e, 0x0a, 0x9a, 0x8b, 0x7e, 0x96, 0x88, 0x2d, 0x53, 0x65, 0xd5, 0xa6, 0x71, 0xf8,
-	0x18, 0x8d, 0xda, 0x63, 0x34, 0x0e, 0xf6, 0x68, 0xd4, 0xbf, 0x75, 0x60, 0xdc, 0xbc, 0xdc, 0xdf,
-	0xe3, 0xae, 0xf7, 0x1b, 0xee, 0x76, 0x88, 0x39, 0x6c, 0x11, 0xf3, 0xf2, 0x6b, 0x07, 0xc0, 0xe4,
-	0xec, 0x9d, 0x1f, 0x5c, 0xa3, 0x08, 0xc9, 0x5b, 0x80, 0xfa, 0x8f, 0x20, 0xd3, 0xd3, 0xe2, 0xa5,
-	0xd9, 0x7b, 0x5a, 0x66, 0xc7, 0x7b, 0x7a, 0x1a, 0xdf, 0xeb, 0x07, 0x45, 0xba, 0xde, 0xab, 0x4a,
-	0xef, 0x21, 0x52, 0xa5, 0x5b, 0x07, 0xd0, 0x0f, 0xd6, 0x9a, 0x7a, 0xd2, 0x5e, 0xfd, 0x0a, 0x00,
-	0x00, 0xff, 0xff, 0xeb, 0x4a, 0x98, 0xac, 0xdf, 0x04, 0x00, 0x00,
+	// 572 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x94, 0xcf, 0x6e, 0xd3, 0x4e,
+	0x10, 0xc7, 0x7f, 0x69, 0x1a, 0x37, 0x99, 0xfe, 0x89, 0xba, 0xbf, 0x2a, 0x5a, 0x45, 0x1c, 0x22,
+	0x0b, 0xa1, 0x8a, 0x43, 0x85, 0x80, 0x43, 0x85, 0xb8, 0x18, 0x3b, 0xa0, 0x55, 0xd5, 0xad, 0xb1,
+	0x4b, 0x38, 0x46, 0xae, 0x77, 0x2a, 0x59, 0x75, 0xd7, 0x61, 0xbd, 0x2e, 0xed, 0x1b, 0xc0, 0x03,
+	0x21, 0x1e, 0x84, 0x17, 0x42, 0x5e, 0x3b, 0x75, 0xfe, 0x54, 0xe5, 0xc2, 0x81, 0xe3, 0x7c, 0x66,
+	0x46, 0xe3, 0x19, 0x7f, 0xbf, 0x0b, 0x3d, 0x35, 0x8b, 0x8f, 0x66, 0x2a, 0xd3, 0x19, 0x69, 0xab,
+	0x59, 0x6c, 0xff, 0xd8, 0x80, 0x7d, 0x47, 0x08, 0x8e, 0xfa, 0x6b, 0xa6, 0xae, 0x02, 0xfc, 0x52,
+	0x60, 0xae, 0xc9, 0x53, 0xd8, 0x75, 0xd3, 0x04, 0xa5, 0x9e, 0xa0, 0xca, 0x93, 0x4c, 0xd2, 0xee,
+	0xa8, 0x75, 0xd8, 0x0b, 0x96, 0x21, 0x19, 0xc1, 0xce, 0xc9, 0x71, 0x38, 0xf5, 0xcf, 0xbc, 0x29,
+	0x77, 0x4e, 0xc7, 0xb4, 0x65, 0x8a, 0xe0, 0xe4, 0x38, 0xf4, 0xcf, 0xbc, 0x92, 0x90, 0xe7, 0xb0,
+	0xbf, 0x58, 0x11, 0xfa, 0x8e, 0x3b, 0xa6, 0x1b, 0xa6, 0xac, 0xdf, 0x94, 0x19, 0x4c, 0xde, 0xc0,
+	0x70, 0x5e, 0xcb, 0xf8, 0xfb, 0xc0, 0x99, 0xba, 0x67, 0xfc, 0xdc, 0x61, 0x7c, 0x1c, 0x4c, 0x99,
+	0x47, 0xdb, 0xa6, 0x69, 0x50, 0x35, 0x99, 0xfc, 0x7d, 0x9a, 0x79, 0x64, 0x04, 0xdb, 0x6e, 0x26,
+	0x75, 0x94, 0x48, 0x54, 0xcc, 0xa3, 0x5b, 0xa6, 0x78, 0x11, 0x91, 0x01, 0x58, 0xec, 0x92, 0x47,
+	0xd7, 0x48, 0x3b, 0x26, 0x59, 0x47, 0x65, 0x67, 0xbd, 0xbb, 0x49, 0x5a, 0x55, 0xe7, 0x02, 0x22,
+	0x07, 0xd0, 0xe1, 0xa8, 0x65, 0x4e, 0x37, 0x4d, 0xae, 0x0a, 0xec, 0x5f, 0x6d, 0xe8, 0x2f, 0xde,
+	0x6d, 0x96, 0xde, 0x11, 0x0a, 0x5b, 0x61, 0x11, 0xc7, 0x98, 0xe7, 0xe6, 0x14, 0xdd, 0x60, 0x1e,
+	0x92, 0x21, 0x74, 0x99, 0x7f, 0xf3, 0xda, 0x11, 0x42, 0xd5, 0xeb, 0xdf, 0xc7, 0xc4, 0x86, 0x1d,
+	0x0f, 0x6f, 0x92, 0x18, 0x79, 0x71, 0x7d, 0x81, 0xca, 0x8c, 0xe9, 0x04, 0x4b, 0x8c, 0x1c, 0x42,
+	0xff, 0x53, 0x8e, 0xe3, 0x5b, 0x8d, 0x4a, 0x46, 0x69, 0xc8, 0x9d, 0x73, 0xb3, 0x46, 0x37, 0x58,
+	0xc5, 0xe5, 0xa4, 0x89, 0xef, 0xc6, 0x89, 0x50, 0x39, 0xb5, 0x46, 0xed, 0x72, 0xd2, 0x3c, 0x26,
+	0x4f, 0xa0, 0xe7, 0x67, 0x62, 0x92, 0x46, 0x92, 0x09, 0x73, 0xa3, 0x4e, 0xd0, 0x80, 0x3a, 0x3b,
+	0xe6, 0xec, 0xd4, 0x71, 0xeb, 0xff, 0xdd, 0x00, 0xf2, 0x0c, 0xf6, 0xaa, 0x20, 0x2c, 0x2e, 0x24,
+	0xea, 0x0f, 0x9f, 0x69, 0xcf, 0x94, 0xac, 0xd0, 0x52, 0x39, 0x7e, 0xa4, 0x50, 0x6a, 0x76, 0xc9,
+	0xa4, 0xc0, 0x5b, 0x0a, 0x66, 0xce, 0x32, 0x2c, 0x67, 0xf1, 0xe2, 0xfa, 0x63, 0x81, 0x05, 0xe6,
+	0x74, 0xbb, 0xfa, 0x92, 0x7b, 0x50, 0x7f, 0x49, 0x90, 0x15, 0x1a, 0x73, 0xba, 0x63, 0x96, 0x68,
+	0x00, 0x79, 0x01, 0xff, 0xfb, 0x99, 0x08, 0xb3, 0x42, 0xc5, 0x38, 0x89, 0xd2, 0x44, 0x44, 0xba,
+	0x54, 0xe8, 0xae, 0xb9, 0xc7, 0x43, 0xa9, 0xf2, 0x7a, 0x7e, 0x26, 0x98, 0xd4, 0xa8, 0x2e, 0xa3,
+	0x18, 0xcb, 0xfd, 0xf6, 0x2a, 0x0d, 0xae, 0x60, 0xfb, 0xe7, 0x06, 0xec, 0x7b, 0x98, 0xfe, 0xc9,
+	0x0d, 0xbd, 0x7f, 0xdb, 0x0d, 0x03, 0xb0, 0x02, 0x8c, 0xf2, 0x4c, 0xce, 0xb5, 0x5e, 0x45, 0xab,
+	0x2e, 0xe9, 0x3e, 0xe6, 0x12, 0xeb, 0x31, 0x97, 0x6c, 0xad, 0xb9, 0xc4, 0xfe, 0xde, 0x82, 0xfe,
+	0xe2, 0xe5, 0xfe, 0x9e, 0x1f, 0xda, 0x0f, 0xf8, 0x61, 0x49, 0xc9, 0x9b, 0x2b, 0x4a, 0x7e, 0xf9,
+	0xad, 0x05, 0xe0, 0x72, 0xf6, 0x2e, 0x8a, 0xaf, 0x50, 0x0a, 0xf2, 0x16, 0xa0, 0x71, 0x2a, 0x19,
+	0x1c, 0x95, 0x2f, 0xe0, 0xda, 0x93, 0x37, 0x3c, 0x58, 0xe3, 0xb3, 0xf4, 0xce, 0xfe, 0xaf, 0xec,
+	0x6e, 0xf6, 0xaa, 0xbb, 0xd7, 0x24, 0x32, 0x3c, 0x58, 0xe3, 0xa6, 0xfb, 0xc2, 0x32, 0x4f, 0xed,
+	0xab, 0xdf, 0x03, 0x00, 0xe0, 0xe1, 0xa3, 0x2b, 0x77, 0x05, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
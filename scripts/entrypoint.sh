@@ -95,6 +95,9 @@ AWS_VPC_K8S_CNI_VETHPREFIX=${AWS_VPC_K8S_CNI_VETHPREFIX:-"eni"}
 AWS_VPC_ENI_MTU=${AWS_VPC_ENI_MTU:-"9001"}
 AWS_VPC_K8S_PLUGIN_LOG_FILE=${AWS_VPC_K8S_PLUGIN_LOG_FILE:-"/var/log/aws-routed-eni/plugin.log"}
 AWS_VPC_K8S_PLUGIN_LOG_LEVEL=${AWS_VPC_K8S_PLUGIN_LOG_LEVEL:-"Debug"}
+AWS_VPC_K8S_CNI_TEARDOWN_RETRIES=${AWS_VPC_K8S_CNI_TEARDOWN_RETRIES:-"3"}
+ENABLE_TRACING=${ENABLE_TRACING:-"false"}
+TRACING_EXPORTER_ENDPOINT=${TRACING_EXPORTER_ENDPOINT:-"localhost:4317"}
 
 AWS_VPC_K8S_CNI_CONFIGURE_RPFILTER=${AWS_VPC_K8S_CNI_CONFIGURE_RPFILTER:-"true"}
 ENABLE_PREFIX_DELEGATION=${ENABLE_PREFIX_DELEGATION:-"false"}
@@ -150,6 +153,9 @@ sed \
   -e s~__MTU__~"${AWS_VPC_ENI_MTU}"~g \
   -e s~__PLUGINLOGFILE__~"${AWS_VPC_K8S_PLUGIN_LOG_FILE}"~g \
   -e s~__PLUGINLOGLEVEL__~"${AWS_VPC_K8S_PLUGIN_LOG_LEVEL}"~g \
+  -e s~__TEARDOWNRETRIES__~"${AWS_VPC_K8S_CNI_TEARDOWN_RETRIES}"~g \
+  -e s~__ENABLETRACING__~"${ENABLE_TRACING}"~g \
+  -e s~__TRACINGEXPORTERENDPOINT__~"${TRACING_EXPORTER_ENDPOINT}"~g \
   10-aws.conflist > "$HOST_CNI_CONFDIR_PATH/10-aws.conflist"
 
 log_in_json info "Successfully copied CNI plugin binary and config file."